@@ -10,6 +10,11 @@ mkdir -p "$BIN_DIR"
 cp sync.toml "$BIN_DIR"
 cp scan.toml "$BIN_DIR"
 cp restore.toml "$BIN_DIR"
+cp verify.toml "$BIN_DIR"
+cp ttl.toml "$BIN_DIR"
+cp bench.toml "$BIN_DIR"
+cp replay.toml "$BIN_DIR"
+cp keys.toml "$BIN_DIR"
 cp -r filters "$BIN_DIR"
 cp -r scripts/cluster_helper "$BIN_DIR"
 
@@ -18,13 +23,17 @@ dist() {
     export GOOS=$g
     export GOARCH=$a
     export CGO_ENABLED=0
-    go build -v -trimpath -o "$BIN_DIR/redis-shake" "./cmd/redis-shake"
+    BIN="redis-shake"
+    if [ "$1" == "windows" ]; then
+        BIN="redis-shake.exe"
+    fi
+    go build -v -trimpath -o "$BIN_DIR/$BIN" "./cmd/redis-shake"
     unset GOOS
     unset GOARCH
     echo "build success GOOS=$1 GOARCH=$2"
 
     cd "$BIN_DIR"
-    tar -czvf ./redis-shake-"$1"-"$2".tar.gz ./sync.toml ./scan.toml ./restore.toml ./redis-shake ./filters ./cluster_helper
+    tar -czvf ./redis-shake-"$1"-"$2".tar.gz ./sync.toml ./scan.toml ./restore.toml ./verify.toml ./ttl.toml ./bench.toml ./replay.toml ./keys.toml ./"$BIN" ./filters ./cluster_helper
     cd ..
 }
 
@@ -35,6 +44,10 @@ if [ "$1" == "dist" ]; then
             dist "$g" "$a"
         done
     done
+    g="windows"
+    a="amd64"
+    dist "$g" "$a"
+    rm "$BIN_DIR/redis-shake.exe"
 fi
 
 # build the current platform
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/checker"
+	"github.com/alibaba/RedisShake/internal/config"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// cutoverCommand starts the cutover of the sync running with configFile by
+// the control API and prints its progress. The exit code is 0 if it is safe
+// to switch the clients to the target.
+func cutoverCommand(configFile string) int {
+	config.LoadFromFile(configFile)
+	adv := &config.Config.Advanced
+	if adv.MetricsPort == 0 {
+		fmt.Println("metrics_port is not set, the sync does not serve the control API")
+		return 1
+	}
+	host := adv.MetricsHost
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	url := fmt.Sprintf("http://%s/api/v1/cutover", net.JoinHostPort(host, strconv.Itoa(adv.MetricsPort)))
+
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if adv.ControlToken != "" {
+		req.Header.Set("Authorization", "Bearer "+adv.ControlToken)
+	}
+	if _, err := cutoverRequest(req); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	last := ""
+	for {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		status, err := cutoverRequest(req)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		line := fmt.Sprintf("%s checked=%d inconsistent=%d %s", status.State, status.Checked, status.Inconsistent, status.Message)
+		if line != last {
+			fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), line)
+			last = line
+		}
+		switch status.State {
+		case checker.CutoverSafe:
+			return 0
+		case checker.CutoverFailed, checker.CutoverIdle:
+			return 1
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func cutoverRequest(req *http.Request) (*checker.CutoverStatus, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cutover request failed. status=[%s], body=[%s]", resp.Status, body)
+	}
+	status := new(checker.CutoverStatus)
+	if err := json.Unmarshal(body, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -1,45 +1,122 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/checker"
 	"github.com/alibaba/RedisShake/internal/config"
-	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/control"
 	"github.com/alibaba/RedisShake/internal/log"
-	"github.com/alibaba/RedisShake/internal/reader"
-	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/preflight"
+	"github.com/alibaba/RedisShake/internal/rdb"
+	"github.com/alibaba/RedisShake/internal/utils"
 	"github.com/alibaba/RedisShake/internal/writer"
+	"github.com/alibaba/RedisShake/pkg/shake"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
+var (
+	dryRun       = flag.Bool("dry-run", false, "run the whole pipeline without writing to target")
+	dryRunOutput = flag.String("dry-run-output", "", "write the commands to this file in RESP when --dry-run")
+	force        = flag.Bool("force", false, "start even if the pre-flight report has unsupported findings")
+)
+
+// exit is replaced when running as a Windows service, so that the exit code
+// is reported to the service manager.
+var exit = os.Exit
+
+// quit stops the sync, the entries already sent are drained before exit.
+var quit = make(chan os.Signal, 1)
+
 func main() {
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		fmt.Println("Usage: redis-shake <config file> <filter file>")
+	flag.Usage = func() {
+		fmt.Println("Usage: redis-shake [--dry-run] [--dry-run-output file] [--force] <config file> <filter file>")
+		fmt.Println("       redis-shake check <rdb file>")
+		fmt.Println("       redis-shake cutover <config file of the running sync>")
+		fmt.Println("       redis-shake [--force] service install|uninstall|start|stop [config file] [filter file]")
 		fmt.Println("Example: redis-shake config.toml filter.lua")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) >= 1 && args[0] == "service" {
+		os.Exit(serviceCommand(args[1:]))
+	}
+	if len(args) < 1 || len(args) > 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
+	if len(args) == 2 && args[0] == "check" {
+		os.Exit(checkRDB(args[1]))
+	}
+	if len(args) == 2 && args[0] == "cutover" {
+		os.Exit(cutoverCommand(args[1]))
+	}
+
+	if isWindowsService() {
+		runService(args)
+		return
+	}
+	run(args)
+}
+
+// run syncs with the config file and the optional filter file in args, it
+// never returns.
+func run(args []string) {
 	// load filter file
-	if len(os.Args) == 3 {
-		luaFile := os.Args[2]
-		filter.LoadFromFile(luaFile)
+	if len(args) == 2 {
+		luaFile := args[1]
+		shake.LoadFilter(luaFile)
+	}
+
+	// the working dir is changed to dir by config
+	startDir, err := os.Getwd()
+	if err != nil {
+		panic(err.Error())
+	}
+	if *dryRunOutput != "" {
+		path, err := filepath.Abs(*dryRunOutput)
+		if err != nil {
+			panic(err.Error())
+		}
+		*dryRunOutput = path
 	}
 
 	// load config
-	configFile := os.Args[1]
-	config.LoadFromFile(configFile)
+	configFile := args[0]
+	shake.LoadConfig(configFile)
 
-	log.Init()
+	shake.Init()
+	if config.Config.Advanced.Schedule != "" && !isScheduledRun() {
+		exit(runSchedule(startDir))
+	}
+	utils.LockDir()
 	log.Infof("GOOS: %s, GOARCH: %s", runtime.GOOS, runtime.GOARCH)
 	log.Infof("Ncpu: %d, GOMAXPROCS: %d", config.Config.Advanced.Ncpu, runtime.GOMAXPROCS(0))
 	log.Infof("pid: %d", os.Getpid())
 	log.Infof("pprof_port: %d", config.Config.Advanced.PprofPort)
-	if len(os.Args) == 2 {
+	if len(args) == 1 {
 		log.Infof("No lua file specified, will not filter any cmd.")
 	}
+	if *dryRun || config.Config.Target.Type == "sink" {
+		// nothing can be read back from the target
+		if *dryRun {
+			log.Infof("dry run, nothing is written to target")
+		}
+		config.Config.Advanced.CheckInterval = 0
+		config.Config.Advanced.ReadbackEvery = 0
+		config.Config.Advanced.CanaryRatio = 0
+		config.Config.Advanced.CanaryKeys = nil
+		config.Config.Advanced.WaitReplicas = 0
+		config.Config.Advanced.ResumeDedupe = false
+		config.Config.Advanced.CutoverVerifyKeys = 0
+	}
 
 	// start pprof
 	if config.Config.Advanced.PprofPort != 0 {
@@ -53,68 +130,165 @@ func main() {
 
 	// start statistics
 	if config.Config.Advanced.MetricsPort != 0 {
-		statistics.Metrics.Address = config.Config.Source.Address
 		go func() {
-			log.Infof("metrics url: http://localhost:%d", config.Config.Advanced.MetricsPort)
-			mux := http.NewServeMux()
-			mux.HandleFunc("/", statistics.Handler)
-			err := http.ListenAndServe(fmt.Sprintf("localhost:%d", config.Config.Advanced.MetricsPort), mux)
+			address := fmt.Sprintf("%s:%d", config.Config.Advanced.MetricsHost, config.Config.Advanced.MetricsPort)
+			log.Infof("metrics url: http://%s", address)
+			err := http.ListenAndServe(address, shake.Handler())
+			if err != nil {
+				log.PanicError(err)
+			}
+		}()
+	}
+
+	if config.Config.Advanced.ProbePort != 0 {
+		go func() {
+			address := fmt.Sprintf(":%d", config.Config.Advanced.ProbePort)
+			log.Infof("health probe url: http://%s/healthz", address)
+			err := http.ListenAndServe(address, shake.ProbeHandler())
 			if err != nil {
 				log.PanicError(err)
 			}
 		}()
 	}
 
+	// verify compares source and target, nothing is written
+	if config.Config.Type == "verify" {
+		shake.StartStatistics()
+		control.StartSystemdNotify()
+		control.NotifyReady()
+		exit(checker.Verify())
+	}
+
+	// ttl copies the expirations of source keys to the target, values are not written
+	if config.Config.Type == "ttl" {
+		shake.StartStatistics()
+		control.StartSystemdNotify()
+		control.NotifyReady()
+		exit(checker.RepairTTL(*dryRun))
+	}
+
 	// create writer
-	var theWriter writer.Writer
-	target := &config.Config.Target
-	switch config.Config.Target.Type {
-	case "standalone":
-		theWriter = writer.NewRedisWriter(target.Address, target.Username, target.Password, target.IsTLS)
-	case "cluster":
-		theWriter = writer.NewRedisClusterWriter(target.Address, target.Username, target.Password, target.IsTLS)
-	default:
-		log.Panicf("unknown target type: %s", target.Type)
-	}
-
-	// create reader
-	source := &config.Config.Source
-	var theReader reader.Reader
-	if config.Config.Type == "sync" {
-		theReader = reader.NewPSyncReader(source.Address, source.Username, source.Password, source.IsTLS, source.ElastiCachePSync)
-	} else if config.Config.Type == "restore" {
-		theReader = reader.NewRDBReader(source.RDBFilePath)
-	} else if config.Config.Type == "scan" {
-		theReader = reader.NewScanReader(source.Address, source.Username, source.Password, source.IsTLS)
+	var theWriter shake.Writer
+	if *dryRun {
+		theWriter = shake.NewDryRunWriter(*dryRunOutput)
 	} else {
-		log.Panicf("unknown source type: %s", config.Config.Type)
+		theWriter = shake.NewWriter()
+	}
+	if !preflightPassed() {
+		theWriter.Close()
+		exit(control.ExitPreflight)
 	}
-	ch := theReader.StartRead()
 
-	// start sync
-	statistics.Init()
-	id := uint64(0)
-	for e := range ch {
-		statistics.UpdateInQueueEntriesCount(uint64(len(ch)))
-		// calc arguments
-		e.Id = id
-		id++
-		e.CmdName, e.Group, e.Keys = commands.CalcKeys(e.Argv)
-		e.Slots = commands.CalcSlots(e.Keys)
-
-		// filter
-		code := filter.Filter(e)
-		statistics.UpdateEntryId(e.Id)
-		if code == filter.Allow {
-			theWriter.Write(e)
-			statistics.AddAllowEntriesCount()
-		} else if code == filter.Disallow {
-			// do something
-			statistics.AddDisallowEntriesCount()
+	shake.StartStatistics()
+	control.StartSystemdNotify()
+	control.NotifyReady()
+	notifySignals()
+	control.WriteLimit.SetRate(config.Config.Advanced.RateLimitOps)
+	control.ReadLimit.SetRate(config.Config.Advanced.RateLimitReadBytes)
+
+	// wait for the orchestrator to start the sync
+	if config.Config.Advanced.StartPaused {
+		log.Infof("waiting for POST /api/v1/start")
+	wait:
+		for {
+			select {
+			case <-control.Started():
+				break wait
+			case sig := <-quit:
+				if isPauseSignal(sig) {
+					control.TogglePause()
+					continue
+				}
+				if isDiagnosticsSignal(sig) {
+					log.Infof("%s", control.Diagnostics())
+					continue
+				}
+				log.Infof("received signal [%v] before start", sig)
+				theWriter.Close()
+				exit(0)
+			}
+		}
+	}
+	control.Start()
+
+	// flush only once started, nothing is deleted while waiting for the orchestrator.
+	// a scheduled run is a child process, each run flushes before its full sync
+	if config.Config.Advanced.FlushTarget {
+		if *dryRun {
+			log.Infof("dry run, flush_target skipped")
+		} else if config.Config.Target.Type == "sink" {
+			log.Infof("target is a sink, flush_target skipped")
 		} else {
-			log.Panicf("error when run lua filter. entry: %s", e.ToString())
+			writer.FlushTarget()
+		}
+	}
+
+	// canary: migrate and verify a part of the keys before the full run
+	if checker.CanaryEnabled() && !checker.RunCanary(theWriter) {
+		theWriter.Close()
+		exit(control.ExitCanaryFailed)
+	}
+
+	theReader := shake.NewReader()
+
+	// start sync
+	stop := make(chan struct{})
+	go func() {
+		for sig := range quit {
+			if isPauseSignal(sig) {
+				control.TogglePause()
+				continue
+			}
+			if isDiagnosticsSignal(sig) {
+				log.Infof("%s", control.Diagnostics())
+				continue
+			}
+			log.Infof("received signal [%v]", sig)
+			close(stop)
+			return
 		}
+	}()
+	exitCode := shake.Run(theReader, theWriter, stop)
+	writeRunMetrics()
+	exit(exitCode)
+}
+
+// preflightPassed logs the pre-flight report of sync, scan and restore. It
+// fails if the report has unsupported findings, unless --force or --dry-run
+// is set. Warnings are only logged.
+func preflightPassed() bool {
+	if t := config.Config.Type; t != "sync" && t != "scan" && t != "restore" {
+		return true
+	}
+	if config.Config.Target.Type == "sink" {
+		log.Infof("target is a sink, pre-flight report skipped")
+		return true
+	}
+	report := preflight.Check()
+	for _, line := range strings.Split(strings.TrimSpace(report.String()), "\n") {
+		log.Infof("%s", line)
+	}
+	if !report.Blocking() || *dryRun {
+		return true
+	}
+	if *force {
+		log.Warnf("pre-flight report has unsupported findings, started because of --force")
+		return true
+	}
+	log.Warnf("pre-flight report has unsupported findings, check them and run with --force to start anyway")
+	return false
+}
+
+// checkRDB parses the rdb file and prints the report, the exit code is 1 if
+// anomalies are found.
+func checkRDB(path string) int {
+	config.Config.Advanced.LogFile = os.DevNull
+	config.Config.Advanced.LogLevel = "warn"
+	log.Init()
+	report := rdb.Check(path)
+	fmt.Print(report)
+	if len(report.Anomalies) > 0 {
+		return 1
 	}
-	theWriter.Close()
-	log.Infof("finished.")
+	return 0
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runMetricsEnv is set for the child process of a scheduled run, it is the
+// file the child writes its metrics to before exit.
+const runMetricsEnv = "REDIS_SHAKE_RUN_METRICS"
+
+const runsDir = "runs"
+
+type runReport struct {
+	Start          time.Time       `json:"start"`
+	End            time.Time       `json:"end"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	ExitCode       int             `json:"exit_code"`
+	Succeeded      bool            `json:"succeeded"`
+	Metrics        json.RawMessage `json:"metrics,omitempty"`
+}
+
+func isScheduledRun() bool {
+	return os.Getenv(runMetricsEnv) != ""
+}
+
+// runSchedule starts redis-shake with the same arguments in startDir on
+// every time matching schedule, one run at a time. A run that takes longer
+// than the interval skips the missed times. It returns when stopped by a
+// signal.
+func runSchedule(startDir string) int {
+	cron, err := utils.ParseCron(config.Config.Advanced.Schedule)
+	if err != nil {
+		log.Panicf("invalid schedule. error=[%v]", err)
+	}
+	if err := os.MkdirAll(runsDir, os.ModePerm); err != nil {
+		log.PanicError(err)
+	}
+	notifySignals()
+	for {
+		next := cron.Next(time.Now())
+		if next.IsZero() {
+			log.Panicf("schedule never matches. schedule=[%s]", config.Config.Advanced.Schedule)
+		}
+		log.Infof("next scheduled run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case sig := <-quit:
+			timer.Stop()
+			if isPauseSignal(sig) || isDiagnosticsSignal(sig) {
+				continue
+			}
+			log.Infof("received signal [%v], scheduler exits", sig)
+			return 0
+		}
+		if stopped := runScheduled(startDir); stopped {
+			return 0
+		}
+	}
+}
+
+// runScheduled runs once in a child process and saves its report, signals
+// are forwarded to the child. It reports whether a signal stopped it.
+func runScheduled(startDir string) (stopped bool) {
+	report := runReport{Start: time.Now()}
+	name := report.Start.Format("20060102-150405")
+	metricsPath, err := filepath.Abs(filepath.Join(runsDir, name+".metrics"))
+	if err != nil {
+		log.PanicError(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.PanicError(err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Dir = startDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), runMetricsEnv+"="+metricsPath)
+	log.Infof("scheduled run started. name=[%s]", name)
+	if err := cmd.Start(); err != nil {
+		log.PanicError(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// a non-zero exit code is in the report
+		_ = cmd.Wait()
+		close(done)
+	}()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case sig := <-quit:
+			if !isPauseSignal(sig) && !isDiagnosticsSignal(sig) {
+				log.Infof("received signal [%v], waiting for the scheduled run to stop", sig)
+				stopped = true
+			}
+			if err := cmd.Process.Signal(sig); err != nil {
+				_ = cmd.Process.Kill()
+			}
+		}
+	}
+
+	report.End = time.Now()
+	report.ElapsedSeconds = report.End.Sub(report.Start).Seconds()
+	report.ExitCode = cmd.ProcessState.ExitCode()
+	switch report.ExitCode {
+	case 0, control.ExitCaughtUp, control.ExitStopAt, control.ExitStopAtOffset:
+		report.Succeeded = true
+	}
+	if metrics, err := ioutil.ReadFile(metricsPath); err == nil {
+		report.Metrics = metrics
+		_ = os.Remove(metricsPath)
+	}
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(runsDir, name+".json"), buf, 0644); err != nil {
+		log.PanicError(err)
+	}
+	if report.Succeeded {
+		log.Infof("scheduled run finished. name=[%s], elapsed=[%.2f]s, exit_code=[%d]", name, report.ElapsedSeconds, report.ExitCode)
+	} else {
+		log.Warnf("scheduled run failed. name=[%s], elapsed=[%.2f]s, exit_code=[%d]", name, report.ElapsedSeconds, report.ExitCode)
+	}
+	removeOldRuns(config.Config.Advanced.ScheduleKeepRuns)
+	return stopped
+}
+
+// removeOldRuns keeps the last keep run reports.
+func removeOldRuns(keep int) {
+	files, err := ioutil.ReadDir(runsDir)
+	if err != nil {
+		log.PanicError(err)
+	}
+	var names []string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(runsDir, names[0])); err != nil {
+			log.Warnf("remove old run report failed. name=[%s], error=[%v]", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+// writeRunMetrics saves the metrics for the scheduler in a scheduled run.
+func writeRunMetrics() {
+	path := os.Getenv(runMetricsEnv)
+	if path == "" {
+		return
+	}
+	buf, err := json.Marshal(statistics.Snapshot())
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		log.Warnf("write run metrics failed. path=[%s], error=[%v]", path, err)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+func serviceCommand([]string) int {
+	fmt.Println("service is only supported on Windows, use systemd or another supervisor instead")
+	return 1
+}
+
+func isWindowsService() bool {
+	return false
+}
+
+func runService([]string) {}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "redis-shake"
+
+// serviceCommand handles "redis-shake service install|uninstall|start|stop".
+// The service runs "redis-shake [--force] <config file> [filter file]" with
+// absolute paths, in the directory of the config file.
+func serviceCommand(args []string) int {
+	if len(args) < 1 {
+		flag.Usage()
+		return 1
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+	case "uninstall":
+		err = controlService(func(s *mgr.Service) error { return s.Delete() })
+	case "start":
+		err = controlService(func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		err = controlService(func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	default:
+		flag.Usage()
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("service %s failed: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("service %s successful\n", args[0])
+	return 0
+}
+
+func installService(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: redis-shake service install <config file> [filter file]")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	for i := range args {
+		if args[i], err = filepath.Abs(args[i]); err != nil {
+			return err
+		}
+	}
+	if *force {
+		// the service starts even if the pre-flight report has unsupported findings
+		args = append([]string{"--force"}, args...)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "RedisShake",
+		Description: "Redis data migration, config file: " + args[0],
+		StartType:   mgr.StartManual,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	return s.Close()
+}
+
+func controlService(f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return f(s)
+}
+
+func isWindowsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		panic(err.Error())
+	}
+	return isService
+}
+
+// runService runs the sync under the service manager. Stop and shutdown
+// requests drain the sent entries like SIGTERM, then the exit code of the
+// sync is reported.
+func runService(args []string) {
+	// the working dir of services is the system dir, dir in config is
+	// relative to the config file instead
+	if err := os.Chdir(filepath.Dir(args[0])); err != nil {
+		panic(err.Error())
+	}
+	codes := make(chan int, 1)
+	exit = func(code int) {
+		codes <- code
+		select {} // the service returns and the process exits
+	}
+	s := &shakeService{args: args, codes: codes}
+	if err := svc.Run(serviceName, s); err != nil {
+		panic(err.Error())
+	}
+	os.Exit(s.code)
+}
+
+type shakeService struct {
+	args  []string
+	codes chan int
+	code  int
+}
+
+func (s *shakeService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go run(s.args)
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-requests:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(time.Minute / time.Millisecond)}
+				select {
+				case quit <- syscall.SIGTERM:
+				default:
+				}
+			}
+		case s.code = <-s.codes:
+			if s.code != 0 {
+				return true, uint32(s.code) // service specific exit code
+			}
+			return false, 0
+		}
+	}
+}
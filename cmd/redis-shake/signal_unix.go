@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals stops on SIGINT and SIGTERM. SIGUSR2 pauses or resumes both
+// reading and writing, SIGUSR1 logs the diagnostics.
+func notifySignals() {
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+}
+
+func isPauseSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+func isDiagnosticsSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
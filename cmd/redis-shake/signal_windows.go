@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySignals stops on Ctrl+C, and on closing the console, logoff and
+// shutdown, which are delivered as SIGTERM. There are no pause and
+// diagnostics signals, use the /pause and /api/v1/diagnostics endpoints
+// instead.
+func notifySignals() {
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+}
+
+func isPauseSignal(os.Signal) bool {
+	return false
+}
+
+func isDiagnosticsSignal(os.Signal) bool {
+	return false
+}
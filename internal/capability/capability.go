@@ -0,0 +1,200 @@
+package capability
+
+import (
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Profile is what the target supports. Redis-protocol stores such as
+// Kvrocks, Pika and Tendis implement a subset of redis: RESTORE may be
+// missing, there may be only one db, and some commands or data types are not
+// implemented.
+type Profile struct {
+	Server    string // redis, kvrocks, pika, tendis or the server name in HELLO
+	Select    bool   // more than one db
+	noRestore int32  // 1 if RESTORE of redis DUMP payloads is not possible
+	commands  map[string]bool
+
+	MaxMemory       uint64 // 0 if unlimited or CONFIG GET is not allowed
+	MaxMemoryPolicy string // empty if CONFIG GET is not allowed
+	ClusterEnabled  bool
+}
+
+// Target is the profile of the target, everything is supported until Probe.
+var Target = &Profile{Server: "redis", Select: true}
+
+// CanRestore reports whether values can be sent by RESTORE.
+func (p *Profile) CanRestore() bool {
+	return atomic.LoadInt32(&p.noRestore) == 0
+}
+
+// DisableRestore is called when the target rejected RESTORE during the sync,
+// the following values are rewritten into commands.
+func (p *Profile) DisableRestore() {
+	atomic.StoreInt32(&p.noRestore, 1)
+}
+
+// Supports reports whether the target implements the command, subcommands
+// such as XGROUP-CREATE are checked by their container.
+func (p *Profile) Supports(cmdName string) bool {
+	if p.commands == nil {
+		return true
+	}
+	if i := strings.Index(cmdName, "-"); i > 0 {
+		cmdName = cmdName[:i]
+	}
+	return p.commands[cmdName]
+}
+
+// Probe builds the profile of the target by HELLO, INFO and COMMAND INFO. A
+// command that can not be listed by COMMAND INFO is probed by sending it
+// without arguments, which fails with an unknown command error if the target
+// does not implement it.
+func Probe(address string, username string, password string, isTls bool) {
+	c := client.NewRedisClient(address, username, password, isTls)
+	defer c.Close()
+	p := &Profile{Server: "redis", Select: true}
+
+	if reply, err := c.Do("HELLO"); err == nil {
+		if fields, ok := reply.([]interface{}); ok && len(fields) >= 2 {
+			if server, ok := fields[1].(string); ok {
+				p.Server = server
+			}
+		}
+	}
+	if info, err := client.String(c.Do("INFO", "server")); err == nil {
+		switch {
+		case strings.Contains(info, "kvrocks_version:"):
+			p.Server = "kvrocks"
+		case strings.Contains(info, "pika_version:"):
+			p.Server = "pika"
+		case strings.Contains(strings.ToLower(info), "tendis"):
+			p.Server = "tendis"
+		}
+	}
+
+	names := commands.Names()
+	if reply, err := c.Do(append([]string{"COMMAND", "INFO"}, names...)...); err == nil {
+		if infos, ok := reply.([]interface{}); ok && len(infos) == len(names) {
+			p.commands = make(map[string]bool, len(names))
+			for i, info := range infos {
+				if _, ok := info.([]interface{}); ok {
+					p.commands[names[i]] = true
+				}
+			}
+		}
+	}
+	if p.commands == nil {
+		log.Infof("target does not support COMMAND INFO, only RESTORE and SELECT are probed")
+	}
+	if !p.Supports("RESTORE") || unknownCommand(c, "RESTORE") {
+		p.DisableRestore()
+	}
+	if _, err := c.Do("SELECT", "1"); err != nil {
+		p.Select = false
+	} else if _, err := c.Do("SELECT", "0"); err != nil {
+		log.Panicf("select db 0 of target failed. error=[%v]", err)
+	}
+	probeLimits(c, p)
+
+	Target = p
+	unsupported := 0
+	if p.commands != nil {
+		unsupported = len(names) - len(p.commands)
+	}
+	log.Infof("target capabilities probed. server=[%s], restore=[%v], select=[%v], unsupported_commands=[%d]",
+		p.Server, p.CanRestore(), p.Select, unsupported)
+}
+
+// ProbeLimits only discovers the limits of the target, it is used when
+// probe_capabilities is off.
+func ProbeLimits(address string, username string, password string, isTls bool) {
+	c := client.NewRedisClient(address, username, password, isTls)
+	defer c.Close()
+	p := &Profile{Server: Target.Server, Select: Target.Select, noRestore: Target.noRestore, commands: Target.commands}
+	probeLimits(c, p)
+	Target = p
+}
+
+// probeLimits reads proto-max-bulk-len, client-query-buffer-limit and
+// maxmemory by CONFIG GET, which is often renamed or disabled on managed
+// redis, the defaults are kept then. The limits set in the config file are
+// not overridden.
+func probeLimits(c *client.Redis, p *Profile) {
+	adv := &config.Config.Advanced
+	if v, ok := configGet(c, "proto-max-bulk-len"); ok && v > 0 && config.DiscoverLimits.ProtoMaxBulkLen {
+		adv.TargetRedisProtoMaxBulkLen = v
+	}
+	if v, ok := configGet(c, "client-query-buffer-limit"); ok && v > 0 && config.DiscoverLimits.QuerybufLen {
+		adv.TargetRedisClientMaxQuerybufLen = v
+	}
+	// strings are split into chunks no larger than a bulk
+	if adv.RewriteStringChunkSize > adv.TargetRedisProtoMaxBulkLen {
+		adv.RewriteStringChunkSize = adv.TargetRedisProtoMaxBulkLen
+	}
+	if v, ok := configGet(c, "maxmemory"); ok {
+		p.MaxMemory = v
+	}
+	if v, ok := configGetString(c, "maxmemory-policy"); ok {
+		p.MaxMemoryPolicy = v
+	}
+
+	if info, err := client.String(c.Do("INFO", "cluster")); err == nil {
+		p.ClusterEnabled = strings.Contains(info, "cluster_enabled:1")
+	}
+	target := &config.Config.Target
+	if p.ClusterEnabled && target.Type != "cluster" {
+		log.Panicf("target is a redis cluster, set target.type to cluster. address=[%s]", target.Address)
+	}
+	if p.ClusterEnabled {
+		p.Select = false // a cluster has only db 0
+	}
+	log.Infof("target limits probed. proto_max_bulk_len=[%d], client_max_querybuf_len=[%d], rewrite_string_chunk_size=[%d], maxmemory=[%d], maxmemory_policy=[%s], cluster_enabled=[%v]",
+		adv.TargetRedisProtoMaxBulkLen, adv.TargetRedisClientMaxQuerybufLen, adv.RewriteStringChunkSize, p.MaxMemory, p.MaxMemoryPolicy, p.ClusterEnabled)
+}
+
+// KeepsFreq reports whether RESTORE keeps FREQ on the target, that is with an
+// LFU maxmemory-policy, IDLETIME is kept otherwise. ok is false if the policy
+// is unknown.
+func (p *Profile) KeepsFreq() (keeps bool, ok bool) {
+	if p.MaxMemoryPolicy == "" {
+		return false, false
+	}
+	return strings.HasSuffix(p.MaxMemoryPolicy, "-lfu"), true
+}
+
+func configGetString(c *client.Redis, name string) (string, bool) {
+	reply, err := c.Do("CONFIG", "GET", name)
+	if err != nil {
+		log.Infof("CONFIG GET is not allowed on target, the default is used. name=[%s], error=[%v]", name, err)
+		return "", false
+	}
+	fields, ok := reply.([]interface{})
+	if !ok || len(fields) != 2 {
+		return "", false
+	}
+	value, ok := fields[1].(string)
+	return value, ok
+}
+
+func configGet(c *client.Redis, name string) (uint64, bool) {
+	value, ok := configGetString(c, name)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+func unknownCommand(c *client.Redis, name string) bool {
+	_, err := c.Do(name)
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command")
+}
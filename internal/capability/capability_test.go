@@ -0,0 +1,67 @@
+package capability
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"strings"
+	"testing"
+)
+
+// limitsServer answers CONFIG GET with values, or refuses it if values is
+// nil, as on managed redis.
+func limitsServer(t *testing.T, values map[string]string) *clienttest.Server {
+	return clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		switch strings.ToUpper(argv[0]) {
+		case "CONFIG":
+			if values == nil {
+				return clienttest.Error("ERR unknown command 'CONFIG'")
+			}
+			return clienttest.Array(clienttest.Bulk(argv[2]), clienttest.Bulk(values[argv[2]]))
+		case "INFO":
+			return clienttest.Bulk("# Cluster\r\ncluster_enabled:0\r\n")
+		}
+		return clienttest.Error("ERR unknown command")
+	}))
+}
+
+func TestProbeLimits(t *testing.T) {
+	saved, savedDiscover, savedTarget := config.Config, config.DiscoverLimits, Target
+	defer func() { config.Config, config.DiscoverLimits, Target = saved, savedDiscover, savedTarget }()
+	values := map[string]string{
+		"proto-max-bulk-len":        "1048576",
+		"client-query-buffer-limit": "2097152",
+		"maxmemory":                 "100000",
+		"maxmemory-policy":          "allkeys-lfu",
+	}
+	cases := []struct {
+		name      string
+		values    map[string]string
+		discover  bool // the limits are 0 in the config file
+		bulkLen   uint64
+		querybuf  uint64
+		chunkSize uint64
+		policy    string
+	}{
+		{"discovered", values, true, 1048576, 2097152, 1048576, "allkeys-lfu"},
+		{"set in config", values, false, 512 * 1024 * 1024, 1024 * 1024 * 1024, 4 * 1024 * 1024, "allkeys-lfu"},
+		{"config refused", nil, true, 512 * 1024 * 1024, 1024 * 1024 * 1024, 4 * 1024 * 1024, ""},
+	}
+	for _, c := range cases {
+		adv := &config.Config.Advanced
+		adv.TargetRedisProtoMaxBulkLen = 512 * 1024 * 1024
+		adv.TargetRedisClientMaxQuerybufLen = 1024 * 1024 * 1024
+		adv.RewriteStringChunkSize = 4 * 1024 * 1024
+		config.DiscoverLimits.ProtoMaxBulkLen = c.discover
+		config.DiscoverLimits.QuerybufLen = c.discover
+		Target = &Profile{Server: "redis", Select: true}
+
+		ProbeLimits(limitsServer(t, c.values).Addr(), "", "", false)
+		if adv.TargetRedisProtoMaxBulkLen != c.bulkLen || adv.TargetRedisClientMaxQuerybufLen != c.querybuf || adv.RewriteStringChunkSize != c.chunkSize {
+			t.Errorf("%s: proto_max_bulk_len=[%d], client_max_querybuf_len=[%d], rewrite_string_chunk_size=[%d]",
+				c.name, adv.TargetRedisProtoMaxBulkLen, adv.TargetRedisClientMaxQuerybufLen, adv.RewriteStringChunkSize)
+		}
+		if Target.MaxMemoryPolicy != c.policy || !Target.Select || Target.ClusterEnabled {
+			t.Errorf("%s: unexpected profile. %+v", c.name, Target)
+		}
+	}
+}
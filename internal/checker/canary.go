@@ -0,0 +1,180 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/writer"
+	"hash/crc32"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// canaryKey is a key written in the canary phase.
+type canaryKey struct {
+	dbId int
+	key  string
+}
+
+// CanaryEnabled reports whether a canary phase runs before the full run.
+func CanaryEnabled() bool {
+	cfg := &config.Config.Advanced
+	return cfg.CanaryRatio > 0 || len(cfg.CanaryKeys) > 0
+}
+
+// isCanary selects canary_ratio of the keys by hash, so the same keys are
+// selected every run.
+func isCanary(key string) bool {
+	return float64(crc32.ChecksumIEEE([]byte(key))%10000) < config.Config.Advanced.CanaryRatio*10000
+}
+
+// RunCanary migrates the canary keys from source through the filter to w,
+// waits until the target answered them, and verifies them. The full run
+// should only start if it returns true.
+func RunCanary(w writer.Writer) bool {
+	if !capability.Target.CanRestore() {
+		log.Warnf("canary skipped, the target does not support RESTORE. server=[%s]", capability.Target.Server)
+		statistics.UpdateCanaryStatus("skipped")
+		return true
+	}
+	cfg := &config.Config
+	log.Infof("canary started. ratio=[%v], max_keys=[%d], keys=[%d]", cfg.Advanced.CanaryRatio, cfg.Advanced.CanaryMaxKeys, len(cfg.Advanced.CanaryKeys))
+	statistics.UpdateCanaryStatus("migrating")
+
+	source := newEndpoint(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS)
+	var written []canaryKey
+	var answered sync.WaitGroup
+	write := func(dbId int, key string) {
+		e := canaryEntry(source, dbId, key)
+		if e == nil {
+			return
+		}
+		filterMu.Lock()
+		code := filter.Filter(e)
+		filterMu.Unlock()
+		if code != filter.Allow {
+			return
+		}
+		// a write skipped by the writer, such as BUSYKEY, is answered too and
+		// found by the verify
+		answered.Add(1)
+		e.OnReply = answered.Done
+		e.OnDrop = answered.Done
+		w.Write(e)
+		written = append(written, canaryKey{dbId, key})
+		statistics.AddCanaryKeysCount()
+	}
+
+	// keys selected by canary_ratio on every master of the source, the scan
+	// stops at canary_max_keys
+	listed := make(map[string]bool)
+	for _, key := range cfg.Advanced.CanaryKeys {
+		listed[key] = true
+	}
+	nodes := sourceNodes()
+	dbIds := make(map[int]bool)
+	selected := 0
+	for _, address := range nodes.addresses {
+		c := client.NewRedisClient(address, nodes.username, nodes.password, nodes.isTls)
+		for _, dbId := range c.KeyspaceDbs() {
+			dbIds[dbId] = true
+			if cfg.Advanced.CanaryRatio == 0 || selected >= cfg.Advanced.CanaryMaxKeys {
+				continue
+			}
+			if _, err := c.Do("SELECT", strconv.Itoa(dbId)); err != nil {
+				log.PanicError(err)
+			}
+			var cursor uint64
+			for {
+				var batch []string
+				cursor, batch = c.Scan(cursor)
+				for _, key := range batch {
+					if selected < cfg.Advanced.CanaryMaxKeys && !listed[key] && isCanary(key) {
+						write(dbId, key)
+						selected++
+					}
+				}
+				if cursor == 0 || selected >= cfg.Advanced.CanaryMaxKeys {
+					break
+				}
+			}
+		}
+		c.Close()
+	}
+	// keys of canary_keys, in every db of the source
+	for dbId := range dbIds {
+		for _, key := range cfg.Advanced.CanaryKeys {
+			write(dbId, key)
+		}
+	}
+
+	replied := make(chan struct{})
+	go func() {
+		answered.Wait()
+		close(replied)
+	}()
+	select {
+	case <-replied:
+	case <-time.After(time.Duration(cfg.Advanced.CanaryTimeout) * time.Second):
+		statistics.UpdateCanaryStatus("failed")
+		log.Warnf("canary failed, the target did not answer the canary keys in %ds, the full run is not started. keys=[%d]", cfg.Advanced.CanaryTimeout, len(written))
+		return false
+	}
+
+	statistics.UpdateCanaryStatus("verifying")
+	log.Infof("canary keys written, start verifying. keys=[%d]", len(written))
+	method := cfg.Advanced.VerifyMethod
+	if method == "auto" {
+		method = probeMethod()
+	}
+	worker := newVerifyWorker(method)
+	for _, k := range written {
+		if _, ok := worker.verifyKey(k.dbId, k.key); !ok {
+			statistics.AddCanaryInconsistentCount()
+		}
+	}
+	inconsistent := statistics.LoadMetrics().CanaryInconsistentCount
+	if inconsistent > 0 {
+		statistics.UpdateCanaryStatus("failed")
+		log.Warnf("canary failed, the full run is not started. keys=[%d], inconsistent=[%d]", len(written), inconsistent)
+		return false
+	}
+	statistics.UpdateCanaryStatus("passed")
+	log.Infof("canary passed, start the full run. keys=[%d]", len(written))
+	return true
+}
+
+// canaryEntry returns nil if the key does not exist. The node of the key is
+// found by MOVED if the source is a cluster.
+func canaryEntry(source *endpoint, dbId int, key string) *entry.Entry {
+	payload, err := client.String(source.do(dbId, key, "DUMP", key))
+	if err == proto.Nil {
+		return nil
+	}
+	if err != nil {
+		log.PanicError(err)
+	}
+	pttl, err := client.Int64(source.do(dbId, key, "PTTL", key))
+	if err != nil {
+		log.PanicError(err)
+	}
+	if pttl == -2 {
+		return nil // expired after DUMP
+	}
+	if pttl < 0 {
+		pttl = 0
+	}
+	e := entry.NewEntry()
+	e.DbId = dbId
+	e.Argv = []string{"RESTORE", key, strconv.FormatInt(pttl, 10), payload, "REPLACE"}
+	e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+	e.Slots = commands.CalcSlots(e.Keys)
+	return e
+}
@@ -0,0 +1,29 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"strconv"
+	"testing"
+)
+
+func TestIsCanary(t *testing.T) {
+	defer func(ratio float64) { config.Config.Advanced.CanaryRatio = ratio }(config.Config.Advanced.CanaryRatio)
+	config.Config.Advanced.CanaryRatio = 0.1
+	selected := 0
+	for i := 0; i < 10000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if isCanary(key) {
+			selected++
+		}
+		if isCanary(key) != isCanary(key) {
+			t.Fatalf("key %s is not selected the same way every time", key)
+		}
+	}
+	if selected < 800 || selected > 1200 {
+		t.Errorf("canary_ratio 0.1 selected %d of 10000 keys", selected)
+	}
+	config.Config.Advanced.CanaryRatio = 0
+	if isCanary("key:1") {
+		t.Errorf("key selected with canary_ratio 0")
+	}
+}
@@ -0,0 +1,210 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// states of the cutover
+const (
+	CutoverIdle      = "idle"
+	CutoverFreezing  = "freezing"  // pausing the writes of the source
+	CutoverDraining  = "draining"  // waiting for the target to apply the stream
+	CutoverVerifying = "verifying" // comparing the last written keys
+	CutoverSafe      = "safe"      // safe to switch the clients to the target
+	CutoverFailed    = "failed"
+)
+
+// CutoverStatus is served by GET /api/v1/cutover.
+type CutoverStatus struct {
+	State        string `json:"state"`
+	Message      string `json:"message"`
+	SourceOffset int64  `json:"source_offset"` // master_repl_offset of the source once paused
+	Checked      int    `json:"checked"`
+	Inconsistent int    `json:"inconsistent"`
+	PausedUntil  string `json:"paused_until,omitempty"` // RFC3339
+}
+
+var cutover struct {
+	mu     sync.Mutex
+	status CutoverStatus
+}
+
+func init() {
+	cutover.status.State = CutoverIdle
+}
+
+// Cutover returns the status of the last cutover.
+func Cutover() CutoverStatus {
+	cutover.mu.Lock()
+	defer cutover.mu.Unlock()
+	return cutover.status
+}
+
+func updateCutover(update func(s *CutoverStatus)) {
+	cutover.mu.Lock()
+	update(&cutover.status)
+	cutover.mu.Unlock()
+}
+
+// StartCutover freezes the source, waits for the target to catch up and
+// verifies the last written keys in the background. It returns false if a
+// cutover is running.
+func StartCutover() bool {
+	cutover.mu.Lock()
+	defer cutover.mu.Unlock()
+	switch cutover.status.State {
+	case CutoverFreezing, CutoverDraining, CutoverVerifying:
+		return false
+	}
+	cutover.status = CutoverStatus{State: CutoverFreezing}
+	go runCutover()
+	return true
+}
+
+func runCutover() {
+	cfg := &config.Config
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Warnf("cutover failed. reason=[%s]", msg)
+		updateCutover(func(s *CutoverStatus) {
+			s.State = CutoverFailed
+			s.Message = msg
+		})
+	}
+	if cfg.Type != "sync" {
+		fail("cutover is only supported in sync mode")
+		return
+	}
+	if _, ok := control.AOFLag(); !ok {
+		fail("the full sync is not finished")
+		return
+	}
+
+	// freeze
+	log.Infof("cutover started, pausing the writes of source. pause=[%ds]", cfg.Advanced.CutoverPauseSeconds)
+	source := client.NewRedisClient(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS)
+	defer source.Close()
+	pause := strconv.Itoa(cfg.Advanced.CutoverPauseSeconds * 1000)
+	if _, err := source.Do("CLIENT", "PAUSE", pause, "WRITE"); err != nil {
+		// before redis 6.2 all clients are paused, the replication goes on
+		if _, err := source.Do("CLIENT", "PAUSE", pause); err != nil {
+			fail("CLIENT PAUSE failed: %v", err)
+			return
+		}
+	}
+	pausedUntil := time.Now().Add(time.Duration(cfg.Advanced.CutoverPauseSeconds) * time.Second)
+	// the writes of source resume if the cutover fails from here
+	freezeFail := fail
+	fail = func(format string, args ...interface{}) {
+		if _, err := source.Do("CLIENT", "UNPAUSE"); err != nil {
+			log.Warnf("CLIENT UNPAUSE failed, the source is paused until [%s]. error=[%v]", pausedUntil.Format(time.RFC3339), err)
+		}
+		freezeFail(format, args...)
+	}
+	offset, err := replOffset(source)
+	if err != nil {
+		fail("read master_repl_offset of source failed: %v", err)
+		return
+	}
+	updateCutover(func(s *CutoverStatus) {
+		s.State = CutoverDraining
+		s.SourceOffset = offset
+		s.PausedUntil = pausedUntil.Format(time.RFC3339)
+	})
+
+	// drain, the applied offset covers the entries written to the target and
+	// the entries dropped by the filter, entries still between the reader and
+	// the writers do not count
+	deadline := time.Now().Add(time.Duration(cfg.Advanced.CutoverDrainTimeout) * time.Second)
+	for {
+		applied := statistics.GetAOFAppliedOffset()
+		if applied >= uint64(offset) {
+			break
+		}
+		if time.Now().After(deadline) {
+			fail("target did not catch up in %ds. source_offset=[%d], applied_offset=[%d]", cfg.Advanced.CutoverDrainTimeout, offset, applied)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Infof("cutover drained. source_offset=[%d]", offset)
+
+	// verify
+	updateCutover(func(s *CutoverStatus) { s.State = CutoverVerifying })
+	keys := lastKeys(cfg.Advanced.CutoverVerifyKeys)
+	sourceEndpoint := newEndpoint(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS)
+	targetEndpoint := newTargetEndpoint()
+	inconsistent := 0
+	for i, key := range keys {
+		ok, err := checkKey(sourceEndpoint, targetEndpoint, key)
+		if err != nil {
+			fail("compare key failed. key=[%s], error=[%v]", key.sourceKey, err)
+			return
+		}
+		if !ok {
+			inconsistent++
+		}
+		updateCutover(func(s *CutoverStatus) {
+			s.Checked = i + 1
+			s.Inconsistent = inconsistent
+		})
+	}
+	if inconsistent > 0 {
+		fail("%d of the last %d written keys are inconsistent, see the log", inconsistent, len(keys))
+		return
+	}
+	msg := fmt.Sprintf("safe to switch, the writes of source are paused until %s", pausedUntil.Format(time.RFC3339))
+	log.Infof("cutover %s. checked=[%d]", msg, len(keys))
+	updateCutover(func(s *CutoverStatus) {
+		s.State = CutoverSafe
+		s.Message = msg
+	})
+}
+
+var replOffsetRegexp = regexp.MustCompile(`(?m)^master_repl_offset:(\d+)`)
+
+func replOffset(c *client.Redis) (int64, error) {
+	info, err := client.String(c.Do("INFO", "replication"))
+	if err != nil {
+		return 0, err
+	}
+	match := replOffsetRegexp.FindStringSubmatch(info)
+	if match == nil {
+		return 0, fmt.Errorf("no master_repl_offset in INFO replication")
+	}
+	return strconv.ParseInt(match[1], 10, 64)
+}
+
+// CutoverHandler serves GET /api/v1/cutover for the status and POST to start
+// the cutover.
+func CutoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		control.Authorized(func(w http.ResponseWriter, _ *http.Request) {
+			if !StartCutover() {
+				http.Error(w, "cutover is running", http.StatusConflict)
+				return
+			}
+			writeCutover(w)
+		})(w, r)
+		return
+	}
+	writeCutover(w)
+}
+
+func writeCutover(w http.ResponseWriter) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Cutover()); err != nil {
+		log.Warnf("write cutover response failed. err=[%v]", err)
+	}
+}
@@ -0,0 +1,63 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"testing"
+)
+
+func TestRunCutover(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	defer statistics.ResetAOFAppliedOffset()
+	defer statistics.SetRDBFileSize(0)
+
+	source := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		if strings.EqualFold(argv[0], "info") {
+			return clienttest.Bulk("# Replication\r\nrole:master\r\nmaster_repl_offset:1000\r\n")
+		}
+		return clienttest.OK
+	}))
+	config.Config.Type = "sync"
+	config.Config.Source.Address = source.Addr()
+	config.Config.Advanced.CutoverPauseSeconds = 10
+	config.Config.Advanced.CutoverVerifyKeys = 0
+	// the full sync is finished
+	statistics.SetRDBFileSize(1)
+	statistics.UpdateRDBSentSize(1)
+
+	unpaused := func() bool {
+		for _, cmd := range source.Commands() {
+			if len(cmd) > 1 && strings.EqualFold(cmd[1], "unpause") {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the target is behind the paused source, the source is unpaused
+	statistics.ResetAOFAppliedOffset()
+	statistics.UpdateAOFAppliedOffset(500)
+	config.Config.Advanced.CutoverDrainTimeout = 0
+	runCutover()
+	if s := Cutover(); s.State != CutoverFailed || s.SourceOffset != 1000 || !strings.Contains(s.Message, "did not catch up") {
+		t.Errorf("cutover should fail while draining. status=%+v", s)
+	}
+	if !unpaused() {
+		t.Errorf("the source should be unpaused when the cutover fails. cmds=%v", source.Commands())
+	}
+
+	// the applied offset covers the offset of the paused source
+	statistics.UpdateAOFAppliedOffset(1000)
+	before := len(source.Commands())
+	runCutover()
+	if s := Cutover(); s.State != CutoverSafe || s.PausedUntil == "" {
+		t.Errorf("cutover should be safe. status=%+v", s)
+	}
+	cmds := source.Commands()[before:]
+	if len(cmds) != 2 || !strings.EqualFold(cmds[0][1], "pause") || cmds[0][3] != "WRITE" {
+		t.Errorf("the writes of source should be paused and stay paused. cmds=%v", cmds)
+	}
+}
@@ -0,0 +1,157 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dedupeFile              = "resume_dedupe.bloom"
+	dedupeFalsePositiveRate = 0.01
+	dedupeSaveInterval      = 10 * time.Second
+)
+
+// dedupe remembers the values restored on the target in a bloom filter saved
+// in dir, so that a full sync restarted without flushing the target skips
+// them.
+var dedupe struct {
+	enabled bool
+	mu      sync.Mutex
+	bloom   *utils.Bloom
+	dirty   bool
+	target  *endpoint // only used by SkipMigrated
+}
+
+// StartDedupe loads the filter saved by the previous run if resume_dedupe is
+// set.
+func StartDedupe() {
+	cfg := &config.Config
+	if !cfg.Advanced.ResumeDedupe {
+		return
+	}
+	bloom := utils.NewBloom(uint64(cfg.Advanced.ResumeDedupeKeys), dedupeFalsePositiveRate)
+	if f, err := os.Open(dedupeFile); err == nil {
+		loaded, err := utils.ReadBloom(f)
+		_ = f.Close()
+		if err != nil {
+			log.Warnf("invalid %s, migrated keys are not skipped. err=[%v]", dedupeFile, err)
+		} else if !loaded.SameSize(bloom) {
+			log.Warnf("resume_dedupe_keys changed, migrated keys are not skipped. file=[%s]", dedupeFile)
+		} else {
+			bloom = loaded
+			log.Infof("resume dedupe loaded, values restored by the previous run are skipped. file=[%s]", dedupeFile)
+		}
+	} else if !os.IsNotExist(err) {
+		log.PanicError(err)
+	}
+	dedupe.bloom = bloom
+	dedupe.target = newTargetEndpoint()
+	dedupe.enabled = true
+	go func() {
+		for range time.Tick(dedupeSaveInterval) {
+			SaveDedupe()
+		}
+	}()
+}
+
+// SkipMigrated reports whether e restores a value that the target got from a
+// previous run. A value found in the filter is skipped only if the target has
+// the same value, compared by the DUMP payload without its version and
+// checksum, and a ttl if e has one. A false positive of the filter, or a value
+// changed on the target since, is written again. Values smaller
+// than resume_dedupe_min_bytes are cheaper to write again than to check.
+// Otherwise the value is remembered once the target answered.
+func SkipMigrated(e *entry.Entry) bool {
+	if !dedupe.enabled || !e.IsBase || !strings.EqualFold(e.CmdName, "restore") || len(e.Argv) < 4 ||
+		len(e.Argv[3]) < config.Config.Advanced.ResumeDedupeMinBytes {
+		return false
+	}
+	id := dedupeId(e)
+	dedupe.mu.Lock()
+	maybe := dedupe.bloom.Contains(id)
+	dedupe.mu.Unlock()
+	if maybe {
+		if sameOnTarget(e) {
+			statistics.AddDedupeSkippedCount()
+			return true
+		}
+	}
+	onReply := e.OnReply
+	e.OnReply = func() {
+		dedupe.mu.Lock()
+		dedupe.bloom.Add(id)
+		dedupe.dirty = true
+		dedupe.mu.Unlock()
+		if onReply != nil {
+			onReply()
+		}
+	}
+	return false
+}
+
+func sameOnTarget(e *entry.Entry) bool {
+	key, payload := e.Argv[1], e.Argv[3]
+	if len(payload) < dumpTrailerLen {
+		return false
+	}
+	targetPayload, err := dumpPayload(dedupe.target, e.DbId, key)
+	if err != nil || targetPayload == "" || targetPayload != payload[:len(payload)-dumpTrailerLen] {
+		return false
+	}
+	ttl, err := hasTTL(dedupe.target, e.DbId, key)
+	return err == nil && ttl == (e.Argv[2] != "0")
+}
+
+// dedupeId identifies the value by db, key, payload and the expire time in
+// seconds. The ttl of RESTORE is relative, a value whose expire time is
+// rounded differently is written again.
+func dedupeId(e *entry.Entry) []byte {
+	expireAt := int64(0)
+	if ttl, _ := strconv.ParseInt(e.Argv[2], 10, 64); ttl > 0 {
+		expireAt = (time.Now().UnixMilli() + ttl) / 1000
+	}
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(e.DbId))
+	sb.WriteByte(0)
+	sb.WriteString(e.Argv[1])
+	sb.WriteByte(0)
+	sb.WriteString(e.Argv[3])
+	sb.WriteByte(0)
+	sb.WriteString(strconv.FormatInt(expireAt, 10))
+	return []byte(sb.String())
+}
+
+// SaveDedupe saves the filter to dir if values were added since the last save.
+func SaveDedupe() {
+	if !dedupe.enabled {
+		return
+	}
+	dedupe.mu.Lock()
+	defer dedupe.mu.Unlock()
+	if !dedupe.dirty {
+		return
+	}
+	tmp := dedupeFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.PanicError(err)
+	}
+	if _, err := dedupe.bloom.WriteTo(f); err != nil {
+		log.PanicError(err)
+	}
+	if err := f.Close(); err != nil {
+		log.PanicError(err)
+	}
+	if err := os.Rename(tmp, dedupeFile); err != nil {
+		log.PanicError(err)
+	}
+	dedupe.dirty = false
+}
@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/writer"
+	"strconv"
+	"strings"
+)
+
+// endpoint reads keys from a standalone redis, a cluster or the shards of a
+// sharded target. Connections are created on demand, MOVED replies are
+// followed and remembered by slot.
+type endpoint struct {
+	address  string
+	ring     *writer.ShardRing // shards of a sharded target, nil otherwise
+	username string
+	password string
+	isTls    bool
+
+	clients map[string]*client.Redis
+	dbIds   map[string]int // selected db of each connection
+	movedTo map[int]string // slot -> address
+}
+
+func newEndpoint(address string, username string, password string, isTls bool) *endpoint {
+	return &endpoint{
+		address:  address,
+		username: username,
+		password: password,
+		isTls:    isTls,
+		clients:  make(map[string]*client.Redis),
+		dbIds:    make(map[string]int),
+		movedTo:  make(map[int]string),
+	}
+}
+
+// newTargetEndpoint returns the endpoint of the target in Config.
+func newTargetEndpoint() *endpoint {
+	cfg := &config.Config.Target
+	p := newEndpoint(cfg.Address, cfg.Username, cfg.Password, cfg.IsTLS)
+	if cfg.Type == "sharded" {
+		p.ring = writer.TargetRing()
+	}
+	return p
+}
+
+// do runs the command on the node that serves key in db.
+func (p *endpoint) do(dbId int, key string, args ...string) (interface{}, error) {
+	slot := commands.CalcSlots([]string{key})[0]
+	address, ok := p.movedTo[slot]
+	if !ok {
+		address = p.address
+		if p.ring != nil {
+			_, address = p.ring.Shard(key)
+		}
+	}
+	for redirects := 0; ; redirects++ {
+		reply, err := p.doOn(address, dbId, args...)
+		if redisErr, ok := err.(proto.RedisError); ok && strings.HasPrefix(string(redisErr), "MOVED ") && redirects < 3 {
+			// MOVED <slot> <address>
+			words := strings.Fields(string(redisErr))
+			if len(words) == 3 {
+				address = words[2]
+				p.movedTo[slot] = address
+				continue
+			}
+		}
+		return reply, err
+	}
+}
+
+func (p *endpoint) doOn(address string, dbId int, args ...string) (interface{}, error) {
+	c, ok := p.clients[address]
+	if !ok {
+		var err error
+		c, err = client.DialRedisClient(address, p.username, p.password, p.isTls)
+		if err != nil {
+			return nil, err
+		}
+		p.clients[address] = c
+		p.dbIds[address] = 0
+	}
+	if p.dbIds[address] != dbId {
+		if _, err := c.Do("SELECT", strconv.Itoa(dbId)); err != nil {
+			p.handleError(address, err)
+			return nil, err
+		}
+		p.dbIds[address] = dbId
+	}
+	reply, err := c.Do(args...)
+	p.handleError(address, err)
+	return reply, err
+}
+
+// handleError drops the connection on network errors, it is dialed again
+// by the next command.
+func (p *endpoint) handleError(address string, err error) {
+	if _, isRedisError := err.(proto.RedisError); err != nil && !isRedisError {
+		log.Warnf("checker connection broken. address=[%s], error=[%v]", address, err)
+		p.clients[address].Close()
+		delete(p.clients, address)
+	}
+}
@@ -0,0 +1,287 @@
+package checker
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb/structure"
+	"github.com/alibaba/RedisShake/internal/rdb/types"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// readbackSample is what a sampled SET or RESTORE wrote to target.
+type readbackSample struct {
+	dbId   int
+	key    string
+	cmd    string
+	value  string // value of SET, or payload of RESTORE
+	hasTTL bool
+	stale  bool // the key was written again before the readback
+}
+
+var readback struct {
+	enabled bool
+	count   uint64 // sampleable writes seen
+	mu      sync.Mutex
+	pending map[string]*readbackSample // by db and key
+	ch      chan *readbackSample
+}
+
+// ReadbackEnabled reports whether write-then-readback sampling is enabled,
+// callers skip Readback otherwise.
+func ReadbackEnabled() bool {
+	return readback.enabled
+}
+
+// StartReadback reads back 1 in readback_every SET/RESTORE commands right
+// after the target answered them, and compares the value and ttl existence
+// with what was written. RESTORE is compared by the logical value, the
+// encodings of the target may differ from the source. It catches proxies or targets that silently mangle
+// data, the result is the write_correctness metric.
+func StartReadback() {
+	cfg := &config.Config
+	if cfg.Advanced.ReadbackEvery <= 0 {
+		return
+	}
+	readback.enabled = true
+	readback.pending = make(map[string]*readbackSample)
+	readback.ch = make(chan *readbackSample, 1024)
+	target := newTargetEndpoint()
+	log.Infof("checker readback started. every=[%d]", cfg.Advanced.ReadbackEvery)
+	go func() {
+		for s := range readback.ch {
+			mismatch, err := s.check(target)
+			readback.mu.Lock()
+			stale := s.stale
+			if readback.pending[pendingKey(s.dbId, s.key)] == s {
+				delete(readback.pending, pendingKey(s.dbId, s.key))
+			}
+			readback.mu.Unlock()
+			if err != nil {
+				log.Warnf("checker readback failed. key=[%s], error=[%v]", s.key, err)
+				continue
+			}
+			if stale {
+				continue
+			}
+			if mismatch != "" {
+				log.Warnf("checker readback found mangled write. db=[%d], key=[%s], cmd=[%s], %s", s.dbId, s.key, s.cmd, mismatch)
+			}
+			statistics.UpdateWriteCorrectness(mismatch == "")
+		}
+	}()
+}
+
+func pendingKey(dbId int, key string) string {
+	return strconv.Itoa(dbId) + ":" + key
+}
+
+// Readback is called with every entry written to target, before writing.
+// Pending samples of the keys written by e are not compared anymore.
+func Readback(e *entry.Entry) {
+	readback.mu.Lock()
+	defer readback.mu.Unlock()
+	for _, key := range e.Keys {
+		if s, ok := readback.pending[pendingKey(e.DbId, key)]; ok {
+			s.stale = true
+		}
+	}
+	s := newReadbackSample(e)
+	if s == nil {
+		return
+	}
+	readback.count++
+	if readback.count%uint64(config.Config.Advanced.ReadbackEvery) != 0 {
+		return
+	}
+	readback.pending[pendingKey(s.dbId, s.key)] = s
+	onReply := e.OnReply
+	e.OnReply = func() {
+		select {
+		case readback.ch <- s:
+		default: // never block the writer, the sample is dropped
+			readback.mu.Lock()
+			s.stale = true
+			readback.mu.Unlock()
+		}
+		if onReply != nil {
+			onReply()
+		}
+	}
+}
+
+// newReadbackSample returns nil if the result of e can not be known from e
+// alone.
+func newReadbackSample(e *entry.Entry) *readbackSample {
+	argv := e.Argv
+	switch strings.ToUpper(e.CmdName) {
+	case "SET":
+		if len(argv) < 3 {
+			return nil
+		}
+		s := &readbackSample{dbId: e.DbId, key: argv[1], cmd: "SET", value: argv[2]}
+		for i := 3; i < len(argv); i++ {
+			switch strings.ToUpper(argv[i]) {
+			case "EX", "PX", "EXAT", "PXAT":
+				s.hasTTL = true
+				i++
+			default: // NX, XX, GET and KEEPTTL depend on the state of target
+				return nil
+			}
+		}
+		return s
+	case "RESTORE":
+		if len(argv) < 4 || len(argv[3]) <= dumpTrailerLen {
+			return nil
+		}
+		return &readbackSample{
+			dbId:   e.DbId,
+			key:    argv[1],
+			cmd:    "RESTORE",
+			value:  argv[3],
+			hasTTL: argv[2] != "0",
+		}
+	}
+	return nil
+}
+
+// check returns the difference found, or an empty string.
+func (s *readbackSample) check(target *endpoint) (string, error) {
+	if s.cmd == "RESTORE" {
+		return s.checkRestore(target)
+	}
+	reply, err := target.do(s.dbId, s.key, "GET", s.key)
+	if err == proto.Nil {
+		return "key not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if value := fmt.Sprint(reply); value != s.value {
+		return fmt.Sprintf("value differs. written_len=[%d], read_len=[%d]", len(s.value), len(value)), nil
+	}
+	ttl, err := hasTTL(target, s.dbId, s.key)
+	if err != nil {
+		return "", err
+	}
+	if ttl != s.hasTTL {
+		return fmt.Sprintf("ttl differs. written_ttl=[%v], read_ttl=[%v]", s.hasTTL, ttl), nil
+	}
+	return "", nil
+}
+
+// checkRestore compares the logical value of the payload with the value read
+// from the target.
+func (s *readbackSample) checkRestore(target *endpoint) (string, error) {
+	written, err := payloadValue(s.key, s.value)
+	if err != nil {
+		return "", err
+	}
+	read, err := readValue(target, s.dbId, s.key)
+	if err != nil {
+		return "", err
+	}
+	if read.typ == "none" {
+		return "key not found", nil
+	}
+	if written == nil {
+		// module values, only the existence and the ttl are compared
+		written = &keyValue{typ: read.typ, size: read.size}
+	}
+	written.hasTTL = s.hasTTL
+	if read.typ == "zset" {
+		read.content = zsetMembers(read.content)
+	}
+	if !written.equal(read) {
+		return fmt.Sprintf("value differs. written=[%s], read=[%s]", written, read), nil
+	}
+	return "", nil
+}
+
+// payloadValue returns the logical value of a DUMP payload as readValue reads
+// it, nil for module values. Members of sorted sets are compared without
+// their scores, the scores of the rdb are rounded when parsed.
+func payloadValue(key string, payload string) (v *keyValue, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse DUMP payload failed: %v", r)
+		}
+	}()
+	rd := strings.NewReader(payload[:len(payload)-dumpTrailerLen])
+	o := types.ParseObject(rd, structure.ReadByte(rd), key)
+	switch o.(type) {
+	case *types.StringObject:
+		v = &keyValue{typ: "string"}
+	case *types.ListObject:
+		v = &keyValue{typ: "list"}
+	case *types.SetObject:
+		v = &keyValue{typ: "set"}
+	case *types.HashObject:
+		v = &keyValue{typ: "hash"}
+	case *types.ZsetObject:
+		v = &keyValue{typ: "zset"}
+	case *types.StreamObject:
+		// entries are compared by their number only
+		v = &keyValue{typ: "stream"}
+		for _, cmd := range o.Rewrite() {
+			if strings.EqualFold(cmd[0], "xadd") {
+				v.size++
+			}
+		}
+		return v, nil
+	default:
+		return nil, nil
+	}
+	var content []string
+	for _, cmd := range o.Rewrite() {
+		args := cmd[2:]
+		switch v.typ {
+		case "string":
+			if len(content) == 0 {
+				content = append(content, "")
+			}
+			content[0] += args[0] // SET and APPEND chunks
+		case "hash":
+			content = append(content, args[0]+"\x00"+args[1])
+		case "zset":
+			content = append(content, args[1]) // ZADD score member
+		default:
+			content = append(content, args...)
+		}
+	}
+	switch v.typ {
+	case "string":
+		if len(content) > 0 {
+			v.size = int64(len(content[0]))
+		}
+	default:
+		v.size = int64(len(content))
+	}
+	if (v.typ == "string" && v.size > compareMaxStringLen) || (v.typ != "string" && v.size > compareMaxElements) {
+		return v, nil
+	}
+	if v.typ != "list" {
+		sort.Strings(content)
+	}
+	v.content = content
+	return v, nil
+}
+
+// zsetMembers returns the sorted members of the ZRANGE WITHSCORES reply.
+func zsetMembers(content []string) []string {
+	if content == nil {
+		return nil
+	}
+	members := make([]string, 0, len(content)/2)
+	for i := 0; i < len(content); i += 2 {
+		members = append(members, content[i])
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,65 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/entry"
+	"reflect"
+	"testing"
+)
+
+func TestNewReadbackSample(t *testing.T) {
+	s := newReadbackSample(&entry.Entry{CmdName: "set", Argv: []string{"set", "k", "v", "EX", "10"}})
+	if s == nil || s.key != "k" || s.value != "v" || !s.hasTTL {
+		t.Errorf("SET with EX should be sampled. sample=%+v", s)
+	}
+	if s := newReadbackSample(&entry.Entry{CmdName: "set", Argv: []string{"set", "k", "v", "NX"}}); s != nil {
+		t.Errorf("SET NX depends on the target and should not be sampled")
+	}
+	if s := newReadbackSample(&entry.Entry{CmdName: "rpush", Argv: []string{"rpush", "k", "v"}}); s != nil {
+		t.Errorf("RPUSH should not be sampled")
+	}
+}
+
+// dumpOf returns a DUMP payload of a string (0), list (1), set (2) or hash
+// (4) with a dummy trailer.
+func dumpOf(typeByte byte, strs ...string) string {
+	payload := []byte{typeByte}
+	switch typeByte {
+	case 1, 2:
+		payload = append(payload, byte(len(strs)))
+	case 4:
+		payload = append(payload, byte(len(strs)/2))
+	}
+	for _, s := range strs {
+		payload = append(payload, byte(len(s)))
+		payload = append(payload, s...)
+	}
+	return string(payload) + "\x0a\x00" + "crc64sum"
+}
+
+func TestPayloadValue(t *testing.T) {
+	cases := []struct {
+		payload string
+		want    *keyValue
+	}{
+		{dumpOf(0, "hello"), &keyValue{typ: "string", size: 5, content: []string{"hello"}}},
+		{dumpOf(1, "b", "a", "b"), &keyValue{typ: "list", size: 3, content: []string{"b", "a", "b"}}},
+		{dumpOf(2, "b", "a"), &keyValue{typ: "set", size: 2, content: []string{"a", "b"}}},
+		{dumpOf(4, "f2", "v2", "f1", "v1"), &keyValue{typ: "hash", size: 2, content: []string{"f1\x00v1", "f2\x00v2"}}},
+	}
+	for _, c := range cases {
+		got, err := payloadValue("k", c.payload)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("payload=[%q], value=[%+v], want=[%+v], error=[%v]", c.payload, got, c.want, err)
+		}
+	}
+	if _, err := payloadValue("k", dumpOf(0, "hello")[:3]+"\x0a\x00crc64sum"); err == nil {
+		t.Errorf("truncated payload parsed")
+	}
+}
+
+func TestZsetMembers(t *testing.T) {
+	got := zsetMembers([]string{"b", "1", "a", "2.5"})
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("members=%v", got)
+	}
+}
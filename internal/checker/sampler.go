@@ -0,0 +1,158 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	recentKeysSize = 10000
+	scoreWindow    = 1000 // the score is calculated from the last scoreWindow samples
+	// a sample is inconsistent only if it still differs after retries, the
+	// target may not have applied the latest commands yet
+	sampleRetries    = 3
+	sampleRetryDelay = time.Second
+)
+
+type sampledKey struct {
+	sourceDb  int
+	sourceKey string
+	targetDb  int
+	targetKey string
+}
+
+var sampler struct {
+	enabled bool
+	mu      sync.Mutex
+	recent  [recentKeysSize]sampledKey
+	count   int // total recorded keys
+}
+
+// Enabled reports whether written keys are recorded, for sampling or for the
+// cutover, callers skip Record otherwise.
+func Enabled() bool {
+	return sampler.enabled
+}
+
+// Record remembers the keys written to target, source keys and target keys
+// are different if the filter renamed them.
+func Record(sourceDb int, sourceKeys []string, targetDb int, targetKeys []string) {
+	if len(sourceKeys) != len(targetKeys) {
+		return
+	}
+	sampler.mu.Lock()
+	for i := range sourceKeys {
+		sampler.recent[sampler.count%recentKeysSize] = sampledKey{sourceDb, sourceKeys[i], targetDb, targetKeys[i]}
+		sampler.count++
+	}
+	sampler.mu.Unlock()
+}
+
+// lastKeys returns up to n distinct keys, the most recently written first.
+func lastKeys(n int) []sampledKey {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	seen := make(map[sampledKey]bool)
+	var keys []sampledKey
+	for i := sampler.count - 1; i >= 0 && i >= sampler.count-recentKeysSize && len(keys) < n; i-- {
+		key := sampler.recent[i%recentKeysSize]
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func pick(n int) []sampledKey {
+	sampler.mu.Lock()
+	defer sampler.mu.Unlock()
+	total := sampler.count
+	if total > recentKeysSize {
+		total = recentKeysSize
+	}
+	if total == 0 {
+		return nil
+	}
+	keys := make([]sampledKey, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, sampler.recent[rand.Intn(total)])
+	}
+	return keys
+}
+
+// StartSampling compares recently written keys between source and target
+// every check_interval seconds and updates the rolling consistency score.
+func StartSampling() {
+	cfg := &config.Config
+	// the cutover compares the last written keys
+	if cfg.Type == "sync" && cfg.Advanced.MetricsPort != 0 && cfg.Advanced.CutoverVerifyKeys > 0 {
+		sampler.enabled = true
+	}
+	if cfg.Advanced.CheckInterval <= 0 {
+		return
+	}
+	if cfg.Type == "restore" {
+		log.Warnf("checker sampling is disabled in restore mode, there is no source redis")
+		return
+	}
+	sampler.enabled = true
+	source := newEndpoint(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS)
+	target := newTargetEndpoint()
+	log.Infof("checker sampling started. interval=[%d]s, sample_count=[%d]", cfg.Advanced.CheckInterval, cfg.Advanced.CheckSampleCount)
+
+	go func() {
+		var window [scoreWindow]bool
+		checked := 0
+		consistent := 0
+		for range time.Tick(time.Duration(cfg.Advanced.CheckInterval) * time.Second) {
+			for _, key := range pick(cfg.Advanced.CheckSampleCount) {
+				ok, err := checkKey(source, target, key)
+				if err != nil {
+					log.Warnf("checker sample failed. key=[%s], error=[%v]", key.sourceKey, err)
+					continue
+				}
+				// slide the window
+				if checked >= scoreWindow && window[checked%scoreWindow] {
+					consistent--
+				}
+				window[checked%scoreWindow] = ok
+				if ok {
+					consistent++
+				}
+				checked++
+				total := checked
+				if total > scoreWindow {
+					total = scoreWindow
+				}
+				statistics.UpdateConsistencyScore(float64(consistent)/float64(total), ok)
+			}
+		}
+	}()
+}
+
+func checkKey(source *endpoint, target *endpoint, key sampledKey) (bool, error) {
+	for i := 0; ; i++ {
+		sourceValue, err := readValue(source, key.sourceDb, key.sourceKey)
+		if err != nil {
+			return false, err
+		}
+		targetValue, err := readValue(target, key.targetDb, key.targetKey)
+		if err != nil {
+			return false, err
+		}
+		if sourceValue.equal(targetValue) {
+			return true, nil
+		}
+		if i == sampleRetries {
+			log.Warnf("checker found inconsistent key. source_db=[%d], source_key=[%s], source=[%v], target_db=[%d], target_key=[%s], target=[%v]",
+				key.sourceDb, key.sourceKey, sourceValue, key.targetDb, key.targetKey, targetValue)
+			return false, nil
+		}
+		time.Sleep(sampleRetryDelay)
+	}
+}
@@ -0,0 +1,103 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strconv"
+	"time"
+)
+
+const ttlCursorFile = "ttl_cursor.json"
+
+// RepairTTL copies the expiration of every key of the source to the same key
+// on the target, values are not touched. Keys missing on either side are
+// skipped. With dryRun the differences are only counted and logged.
+func RepairTTL(dryRun bool) int {
+	cfg := &config.Config
+	log.Infof("ttl repair started. workers=[%d], tolerance=[%dms], dry_run=[%v]", cfg.Advanced.VerifyWorkers, cfg.Advanced.TTLTolerance, dryRun)
+	repaired := forEachKey(ttlCursorFile, sourceNodes(), func() func(dbId int, key string) bool {
+		source := newEndpoint(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS)
+		target := newTargetEndpoint()
+		return func(dbId int, key string) bool {
+			statistics.AddTTLCheckedCount()
+			return repairTTL(source, target, dbId, key, dryRun)
+		}
+	})
+	m := statistics.LoadMetrics()
+	log.Infof("ttl repair finished. checked=[%d], repaired=[%d], missing_on_target=[%d]",
+		m.TTLCheckedCount, repaired, m.TTLMissingCount)
+	return 0
+}
+
+// repairTTL returns true if the ttl of the key is repaired, or would be with
+// dryRun.
+func repairTTL(source *endpoint, target *endpoint, dbId int, key string, dryRun bool) bool {
+	targetDb, targetKey, ok := targetKeyOf(dbId, key)
+	if !ok {
+		return false
+	}
+	now := time.Now().UnixMilli()
+	sourceTTL, err := pttl(source, dbId, key)
+	if err != nil {
+		log.Warnf("ttl repair read source failed. db=[%d], key=[%s], error=[%v]", dbId, key, err)
+		return false
+	}
+	targetTTL, err := pttl(target, targetDb, targetKey)
+	if err != nil {
+		log.Warnf("ttl repair read target failed. db=[%d], key=[%s], error=[%v]", targetDb, targetKey, err)
+		return false
+	}
+	if sourceTTL == -2 {
+		return false // deleted on source after scan
+	}
+	if targetTTL == -2 {
+		statistics.AddTTLMissingCount()
+		return false
+	}
+	// the expiration was capped by max_ttl when the key was written
+	if maxTTL := config.Config.Filter.MaxTTL * 1000; maxTTL > 0 && sourceTTL > maxTTL {
+		if targetTTL >= 0 && targetTTL <= maxTTL {
+			return false
+		}
+		sourceTTL = maxTTL
+	}
+
+	var args []string
+	switch {
+	case sourceTTL == -1 && targetTTL == -1:
+		return false
+	case sourceTTL == -1:
+		args = []string{"PERSIST", targetKey}
+	case targetTTL == -1 || abs(sourceTTL-targetTTL) > int64(config.Config.Advanced.TTLTolerance):
+		args = []string{"PEXPIREAT", targetKey, strconv.FormatInt(now+sourceTTL, 10)}
+	default:
+		return false
+	}
+	log.Debugf("ttl differs. db=[%d], key=[%s], source_pttl=[%d], target_pttl=[%d], repair=%v", targetDb, targetKey, sourceTTL, targetTTL, args)
+	if !dryRun {
+		if _, err := target.do(targetDb, targetKey, args...); err != nil {
+			log.Warnf("ttl repair failed. db=[%d], key=[%s], error=[%v]", targetDb, targetKey, err)
+			return false
+		}
+	}
+	statistics.AddTTLRepairedCount()
+	return true
+}
+
+// pttl returns -2 if the key does not exist and -1 if it has no ttl.
+func pttl(p *endpoint, dbId int, key string) (int64, error) {
+	reply, err := p.do(dbId, key, "PTTL", key)
+	if err != nil {
+		return 0, err
+	}
+	ttl, _ := reply.(int64)
+	return ttl, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,86 @@
+package checker
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ttlServer answers PTTL from ttls, -2 for the missing keys.
+func ttlServer(t *testing.T, ttls map[string]int64) *clienttest.Server {
+	return clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		switch strings.ToUpper(argv[0]) {
+		case "PTTL":
+			if ttl, ok := ttls[argv[1]]; ok {
+				return clienttest.Int(ttl)
+			}
+			return clienttest.Int(-2)
+		case "PEXPIREAT", "PERSIST":
+			return clienttest.Int(1)
+		}
+		return clienttest.OK
+	}))
+}
+
+func TestRepairTTL(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	config.Config.Advanced.TTLTolerance = 1000
+	config.Config.Filter.MaxTTL = 60
+
+	source := ttlServer(t, map[string]int64{
+		"same": 10000, "diff": 10000, "persist": -1, "lost": 10000, "missing": 1000, "none": -1, "capped": 3600000,
+	})
+	target := ttlServer(t, map[string]int64{
+		"same": 9500, "diff": 50000, "persist": 5000, "lost": -1, "none": -1, "capped": 59000,
+	})
+	cases := []struct {
+		key      string
+		repaired bool
+		cmd      string // sent to the target, the timestamp of PEXPIREAT omitted
+	}{
+		{"same", false, ""},
+		{"diff", true, "PEXPIREAT diff"},
+		{"persist", true, "PERSIST persist"},
+		{"lost", true, "PEXPIREAT lost"},
+		{"missing", false, ""},
+		{"none", false, ""},
+		// written with max_ttl, the target keeps the capped ttl
+		{"capped", false, ""},
+	}
+	src := newEndpoint(source.Addr(), "", "", false)
+	tgt := newEndpoint(target.Addr(), "", "", false)
+	for _, dryRun := range []bool{true, false} {
+		for _, c := range cases {
+			before := len(target.Commands())
+			start := time.Now().UnixMilli()
+			repaired := repairTTL(src, tgt, 0, c.key, dryRun)
+			if repaired != c.repaired {
+				t.Errorf("key=[%s], dry_run=[%v], repaired=[%v], want=[%v]", c.key, dryRun, repaired, c.repaired)
+			}
+			var sent []string
+			for _, cmd := range target.Commands()[before:] {
+				if cmd[0] == "PTTL" {
+					continue
+				}
+				sent = append(sent, strings.Join(cmd[:2], " "))
+				if cmd[0] == "PEXPIREAT" {
+					at, _ := strconv.ParseInt(cmd[2], 10, 64)
+					if at < start+10000 || at > time.Now().UnixMilli()+10000 {
+						t.Errorf("key=[%s], PEXPIREAT at=[%d], want the ttl of the source from now", c.key, at)
+					}
+				}
+			}
+			want := c.cmd
+			if dryRun {
+				want = ""
+			}
+			if got := strings.Join(sent, "|"); got != want {
+				t.Errorf("key=[%s], dry_run=[%v], sent=[%s], want=[%s]", c.key, dryRun, got, want)
+			}
+		}
+	}
+}
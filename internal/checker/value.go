@@ -0,0 +1,132 @@
+package checker
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"reflect"
+	"sort"
+)
+
+const (
+	// values bigger than these are compared by type and size only
+	compareMaxElements  = 1024
+	compareMaxStringLen = 1024 * 1024
+)
+
+// keyValue is the part of a key compared between source and target.
+type keyValue struct {
+	typ     string
+	size    int64
+	hasTTL  bool
+	content []string // nil if the value is too big or the type is unknown
+}
+
+func (v *keyValue) String() string {
+	return fmt.Sprintf("type=[%s], size=[%d], has_ttl=[%v]", v.typ, v.size, v.hasTTL)
+}
+
+func (v *keyValue) equal(o *keyValue) bool {
+	if v.typ != o.typ || v.size != o.size || v.hasTTL != o.hasTTL {
+		return false
+	}
+	if v.content == nil || o.content == nil {
+		return true
+	}
+	return reflect.DeepEqual(v.content, o.content)
+}
+
+// sizeCommands returns the size of each type, content commands return the
+// whole value. Unordered types are sorted after reading.
+var sizeCommands = map[string]string{
+	"string": "STRLEN",
+	"list":   "LLEN",
+	"hash":   "HLEN",
+	"set":    "SCARD",
+	"zset":   "ZCARD",
+	"stream": "XLEN",
+}
+
+func contentCommand(typ string, key string) []string {
+	switch typ {
+	case "string":
+		return []string{"GET", key}
+	case "list":
+		return []string{"LRANGE", key, "0", "-1"}
+	case "hash":
+		return []string{"HGETALL", key}
+	case "set":
+		return []string{"SMEMBERS", key}
+	case "zset":
+		return []string{"ZRANGE", key, "0", "-1", "WITHSCORES"}
+	case "stream":
+		return []string{"XRANGE", key, "-", "+"}
+	}
+	return nil
+}
+
+func readValue(p *endpoint, dbId int, key string) (*keyValue, error) {
+	reply, err := p.do(dbId, key, "TYPE", key)
+	if err != nil {
+		return nil, err
+	}
+	v := &keyValue{typ: fmt.Sprint(reply)}
+	if v.typ == "none" {
+		return v, nil
+	}
+
+	reply, err = p.do(dbId, key, "PTTL", key)
+	if err != nil {
+		return nil, err
+	}
+	pttl, _ := reply.(int64)
+	v.hasTTL = pttl >= 0
+
+	sizeCmd, ok := sizeCommands[v.typ]
+	if !ok {
+		return v, nil // module types are compared by type
+	}
+	reply, err = p.do(dbId, key, sizeCmd, key)
+	if err != nil {
+		return nil, err
+	}
+	v.size, _ = reply.(int64)
+	if (v.typ == "string" && v.size > compareMaxStringLen) || (v.typ != "string" && v.size > compareMaxElements) {
+		return v, nil
+	}
+
+	reply, err = p.do(dbId, key, contentCommand(v.typ, key)...)
+	if err == proto.Nil {
+		return v, nil // deleted between commands, size shows the difference
+	}
+	if err != nil {
+		return nil, err
+	}
+	v.content = flatten(reply, []string{})
+	switch v.typ {
+	case "set":
+		sort.Strings(v.content)
+	case "hash":
+		pairs := make([]string, 0, len(v.content)/2)
+		for i := 0; i+1 < len(v.content); i += 2 {
+			pairs = append(pairs, v.content[i]+"\x00"+v.content[i+1])
+		}
+		sort.Strings(pairs)
+		v.content = pairs
+	}
+	return v, nil
+}
+
+// flatten converts nested replies such as XRANGE to a list of strings.
+func flatten(reply interface{}, out []string) []string {
+	switch r := reply.(type) {
+	case []interface{}:
+		for _, item := range r {
+			out = flatten(item, out)
+		}
+	case nil:
+		out = append(out, "<nil>")
+	default:
+		out = append(out, fmt.Sprint(r))
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package checker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	// XRANGE reply
+	reply := []interface{}{
+		[]interface{}{"1-1", []interface{}{"f1", "v1"}},
+		[]interface{}{"2-1", []interface{}{"f2", "v2"}},
+	}
+	want := []string{"1-1", "f1", "v1", "2-1", "f2", "v2"}
+	if got := flatten(reply, []string{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("flatten failed. got=%v", got)
+	}
+}
+
+func TestKeyValueEqual(t *testing.T) {
+	a := &keyValue{typ: "set", size: 2, content: []string{"a", "b"}}
+	if !a.equal(&keyValue{typ: "set", size: 2, content: []string{"a", "b"}}) {
+		t.Errorf("same values should be equal")
+	}
+	if a.equal(&keyValue{typ: "set", size: 2, content: []string{"a", "c"}}) {
+		t.Errorf("different members should not be equal")
+	}
+	if a.equal(&keyValue{typ: "set", size: 2, hasTTL: true, content: []string{"a", "b"}}) {
+		t.Errorf("ttl should be compared")
+	}
+	// big values are compared by size
+	if !a.equal(&keyValue{typ: "set", size: 2}) {
+		t.Errorf("values without content should be compared by size")
+	}
+}
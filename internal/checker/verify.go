@@ -0,0 +1,597 @@
+package checker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/writer"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	verifyCursorFile      = "verify_cursor.json"
+	verifyExtraCursorFile = "verify_extra_cursor.json"
+	verifyResultFile      = "verify_result.log"
+	// DUMP payload ends with 2 bytes rdb version and 8 bytes crc64
+	dumpTrailerLen = 10
+)
+
+// verifyPosition is the next position to scan, saved in verify_cursor.json
+// so that an interrupted verification continues from it.
+type verifyPosition struct {
+	Node    int    `json:"node,omitempty"` // index of the node when scanning a cluster
+	DbId    int    `json:"db_id"`
+	Cursor  uint64 `json:"cursor"`
+	Scanned uint64 `json:"scanned,omitempty"` // keys scanned before this position, for the progress
+	Found   uint64 `json:"found,omitempty"`   // keys found before this position, such as inconsistent keys
+}
+
+// scanNodes are the nodes scanned for keys, the source or the masters or the
+// shards of the target.
+type scanNodes struct {
+	addresses []string
+	username  string
+	password  string
+	isTls     bool
+}
+
+// sourceNodes returns the source, or its masters if it is a cluster.
+func sourceNodes() scanNodes {
+	cfg := &config.Config.Source
+	addresses := []string{cfg.Address}
+	c := client.NewRedisClient(cfg.Address, cfg.Username, cfg.Password, cfg.IsTLS)
+	if strings.Contains(c.DoWithStringReply("INFO", "cluster"), "cluster_enabled:1") {
+		addresses = writer.ClusterMasters(cfg.Address, cfg.Username, cfg.Password, cfg.IsTLS)
+		sort.Strings(addresses) // the node index of the saved position must be stable
+		log.Infof("source is a cluster, scanning its masters. masters=%v", addresses)
+	}
+	c.Close()
+	return scanNodes{addresses, cfg.Username, cfg.Password, cfg.IsTLS}
+}
+
+func targetNodes() scanNodes {
+	cfg := &config.Config.Target
+	return scanNodes{writer.TargetAddresses(), cfg.Username, cfg.Password, cfg.IsTLS}
+}
+
+type verifyBatch struct {
+	seq   uint64
+	dbId  int
+	keys  []string
+	found uint64         // keys of the batch the worker reported
+	next  verifyPosition // position after this batch
+}
+
+// Verify compares every key of the source with the target and returns the
+// exit code. Keys are scanned in batches and checked by verify_workers
+// workers, memory is bounded by the number of batches in flight.
+func Verify() int {
+	cfg := &config.Config
+	method := cfg.Advanced.VerifyMethod
+	if method == "auto" {
+		method = probeMethod()
+	}
+	log.Infof("verify started. method=[%s], workers=[%d]", method, cfg.Advanced.VerifyWorkers)
+
+	results, err := os.OpenFile(verifyResultFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.PanicError(err)
+	}
+	defer results.Close()
+	var resultsMu sync.Mutex
+
+	// the inconsistent keys found before an interruption are kept in the
+	// cursor file, so that a resumed verify does not exit 0
+	inconsistent := forEachKey(verifyCursorFile, sourceNodes(), func() func(dbId int, key string) bool {
+		w := newVerifyWorker(method)
+		return func(dbId int, key string) bool {
+			statistics.AddVerifyCheckedCount()
+			reason, ok := w.verifyKey(dbId, key)
+			if ok {
+				return false
+			}
+			resultsMu.Lock()
+			_, err := fmt.Fprintf(results, "db=%d key=%s reason=%s\n", dbId, strconv.Quote(key), reason)
+			resultsMu.Unlock()
+			if err != nil {
+				log.PanicError(err)
+			}
+			statistics.AddVerifyInconsistentCount()
+			return true
+		}
+	})
+
+	if cfg.Advanced.VerifyExtraKeys != "ignore" {
+		log.Infof("verify looking for extra keys on target. verify_extra_keys=[%s]", cfg.Advanced.VerifyExtraKeys)
+		sourceDbs := keyspaceDbs(sourceNodes())
+		inconsistent += forEachKey(verifyExtraCursorFile, targetNodes(), func() func(dbId int, key string) bool {
+			w := newVerifyWorker(method)
+			return func(dbId int, key string) bool {
+				reason, ok := w.checkExtraKey(dbId, key, sourceDbs)
+				if ok {
+					return false
+				}
+				resultsMu.Lock()
+				_, err := fmt.Fprintf(results, "target db=%d key=%s reason=%s\n", dbId, strconv.Quote(key), reason)
+				resultsMu.Unlock()
+				if err != nil {
+					log.PanicError(err)
+				}
+				// deleted extra keys are repaired, not inconsistent
+				if cfg.Advanced.VerifyExtraKeys == "delete" {
+					return false
+				}
+				statistics.AddVerifyInconsistentCount()
+				return true
+			}
+		})
+	}
+
+	m := statistics.LoadMetrics()
+	log.Infof("verify finished. checked=[%d], inconsistent=[%d], extra=[%d], result_file=[%s]",
+		m.VerifyCheckedCount, inconsistent, m.VerifyExtraCount, verifyResultFile)
+	if inconsistent > 0 {
+		return control.ExitInconsistent
+	}
+	return 0
+}
+
+// forEachKey scans every key of nodes from the position saved in cursorFile
+// and passes it to one of verify_workers workers made by newWorker. The
+// position of the checked keys is saved to cursorFile, which is removed when
+// the scan is done. The progress is estimated from the number of keys in
+// INFO keyspace of nodes. It returns the number of keys the workers reported,
+// including those before the saved position.
+func forEachKey(cursorFile string, nodes scanNodes, newWorker func() func(dbId int, key string) bool) uint64 {
+	workers := config.Config.Advanced.VerifyWorkers
+	start := loadPosition(cursorFile)
+	total := keyspaceKeys(nodes)
+	startTime := time.Now()
+	scanned := start.Scanned
+	found := start.Found
+	statistics.UpdateVerifyProgress(scanned, total, -1)
+	batches := make(chan *verifyBatch, workers*2)
+	done := make(chan *verifyBatch, workers*2)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle := newWorker()
+			for batch := range batches {
+				for _, key := range batch.keys {
+					if handle(batch.dbId, key) {
+						batch.found++
+					}
+				}
+				done <- batch
+			}
+		}()
+	}
+	go func() {
+		seq := uint64(0)
+		for pos := start; pos.Node < len(nodes.addresses); pos = (verifyPosition{Node: pos.Node + 1}) {
+			scanBatches(nodes, pos, &seq, batches)
+		}
+		close(batches)
+		wg.Wait()
+		close(done)
+	}()
+
+	// save the position of the last batch that all batches before it are checked
+	finished := make(map[uint64]*verifyBatch)
+	nextSeq := uint64(0)
+	for batch := range done {
+		finished[batch.seq] = batch
+		for finished[nextSeq] != nil {
+			scanned += uint64(len(finished[nextSeq].keys))
+			found += finished[nextSeq].found
+			pos := finished[nextSeq].next
+			pos.Scanned = scanned
+			pos.Found = found
+			savePosition(cursorFile, pos)
+			delete(finished, nextSeq)
+			nextSeq++
+		}
+		statistics.UpdateVerifyProgress(scanned, total, eta(scanned-start.Scanned, total, scanned, time.Since(startTime)))
+	}
+	statistics.UpdateVerifyProgress(scanned, total, 0)
+	_ = os.Remove(cursorFile)
+	return found
+}
+
+// eta estimates the time to scan the remaining keys at the speed of this run,
+// -1 means unknown. The total is an estimate, keys may be added meanwhile.
+func eta(scannedThisRun uint64, total uint64, scanned uint64, elapsed time.Duration) time.Duration {
+	if scannedThisRun == 0 {
+		return -1
+	}
+	if scanned >= total {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(scannedThisRun) * float64(total-scanned))
+}
+
+// scanBatches scans the node of start from its position, seq is the seq of
+// the next batch.
+func scanBatches(nodes scanNodes, start verifyPosition, seq *uint64, batches chan<- *verifyBatch) {
+	c := client.NewRedisClient(nodes.addresses[start.Node], nodes.username, nodes.password, nodes.isTls)
+	defer c.Close()
+	dbIds := c.KeyspaceDbs()
+	for i, dbId := range dbIds {
+		if dbId < start.DbId {
+			continue
+		}
+		var cursor uint64
+		if dbId == start.DbId {
+			cursor = start.Cursor
+		}
+		if _, err := c.Do("SELECT", strconv.Itoa(dbId)); err != nil {
+			log.PanicError(err)
+		}
+		for {
+			var keys []string
+			cursor, keys = c.Scan(cursor)
+			statistics.UpdateScanPosition(dbId, cursor)
+			batch := &verifyBatch{seq: *seq, dbId: dbId, keys: keys, next: verifyPosition{Node: start.Node, DbId: dbId, Cursor: cursor}}
+			if cursor == 0 {
+				if i+1 < len(dbIds) {
+					batch.next = verifyPosition{Node: start.Node, DbId: dbIds[i+1]}
+				} else {
+					batch.next = verifyPosition{Node: start.Node + 1}
+				}
+			}
+			batches <- batch
+			*seq++
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+}
+
+var (
+	keyspaceKeysRegexp = regexp.MustCompile(`(?m)^db\d+:keys=(\d+)`)
+)
+
+// keyspaceDbs returns the dbs that have keys on any of nodes, in ascending
+// order.
+func keyspaceDbs(nodes scanNodes) []int {
+	seen := make(map[int]bool)
+	var dbIds []int
+	for _, address := range nodes.addresses {
+		c := client.NewRedisClient(address, nodes.username, nodes.password, nodes.isTls)
+		for _, dbId := range c.KeyspaceDbs() {
+			if !seen[dbId] {
+				seen[dbId] = true
+				dbIds = append(dbIds, dbId)
+			}
+		}
+		c.Close()
+	}
+	sort.Ints(dbIds)
+	return dbIds
+}
+
+// keyspaceKeys returns the number of keys of all dbs of nodes.
+func keyspaceKeys(nodes scanNodes) uint64 {
+	var total uint64
+	for _, address := range nodes.addresses {
+		c := client.NewRedisClient(address, nodes.username, nodes.password, nodes.isTls)
+		info := c.DoWithStringReply("INFO", "keyspace")
+		c.Close()
+		for _, match := range keyspaceKeysRegexp.FindAllStringSubmatch(info, -1) {
+			keys, err := strconv.ParseUint(match[1], 10, 64)
+			if err != nil {
+				log.PanicError(err)
+			}
+			total += keys
+		}
+	}
+	return total
+}
+
+func loadPosition(cursorFile string) verifyPosition {
+	var pos verifyPosition
+	buf, err := ioutil.ReadFile(cursorFile)
+	if os.IsNotExist(err) {
+		return pos
+	}
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err := json.Unmarshal(buf, &pos); err != nil {
+		log.Panicf("invalid %s, remove it to start from the beginning. err=[%v]", cursorFile, err)
+	}
+	log.Infof("resumed from %s. node=[%d], db=[%d], cursor=[%d], scanned=[%d], found=[%d]", cursorFile, pos.Node, pos.DbId, pos.Cursor, pos.Scanned, pos.Found)
+	return pos
+}
+
+func savePosition(cursorFile string, pos verifyPosition) {
+	buf, err := json.Marshal(pos)
+	if err != nil {
+		log.PanicError(err)
+	}
+	tmp := cursorFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		log.PanicError(err)
+	}
+	if err := os.Rename(tmp, cursorFile); err != nil {
+		log.PanicError(err)
+	}
+}
+
+// probeMethod uses DEBUG DIGEST-VALUE if both sides support it. DEBUG is
+// disabled by default since redis 7.0 and on most cloud services.
+func probeMethod() string {
+	cfg := &config.Config
+	for _, side := range []struct {
+		address, username, password string
+		isTls                       bool
+	}{
+		{cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS},
+		{cfg.Target.Address, cfg.Target.Username, cfg.Target.Password, cfg.Target.IsTLS},
+	} {
+		c := client.NewRedisClient(side.address, side.username, side.password, side.isTls)
+		_, err := c.Do("DEBUG", "DIGEST-VALUE", "redis-shake-verify-probe")
+		c.Close()
+		if err != nil {
+			log.Infof("DEBUG DIGEST-VALUE is not available, verify by DUMP. address=[%s], err=[%v]", side.address, err)
+			return "dump"
+		}
+	}
+	return "digest"
+}
+
+// filterMu serializes the filter between workers, the lua state is not thread safe
+var filterMu sync.Mutex
+
+type verifyWorker struct {
+	method string
+	source *endpoint
+	target *endpoint
+}
+
+func newVerifyWorker(method string) *verifyWorker {
+	cfg := &config.Config
+	return &verifyWorker{
+		method: method,
+		source: newEndpoint(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS),
+		target: newTargetEndpoint(),
+	}
+}
+
+// verifyKey returns false and the reason if the key is different on target.
+// The key is mapped by the filter the same way as it was synced.
+func (w *verifyWorker) verifyKey(dbId int, key string) (string, bool) {
+	targetDb, targetKey, ok := targetKeyOf(dbId, key)
+	if !ok {
+		return "", true
+	}
+
+	for i := 0; ; i++ {
+		reason, err := w.compare(dbId, key, targetDb, targetKey)
+		if err != nil {
+			reason = "error: " + err.Error()
+		}
+		if reason == "" {
+			return "", true
+		}
+		if i == sampleRetries {
+			log.Warnf("verify found inconsistent key. db=[%d], key=[%s], target_db=[%d], target_key=[%s], reason=[%s]",
+				dbId, key, targetDb, targetKey, reason)
+			return reason, false
+		}
+		time.Sleep(sampleRetryDelay)
+	}
+}
+
+// checkExtraKey returns false and the reason if the key of the target comes
+// from a source key that does not exist, and deletes it if verify_extra_keys
+// is delete. Keys the filter would not write, such as keys of other tenants,
+// are ignored. sourceDbs are the dbs that have keys on the source.
+func (w *verifyWorker) checkExtraKey(targetDb int, targetKey string, sourceDbs []int) (string, bool) {
+	sourceKeys, ok := filter.SourceKeysOf(targetDb, targetKey, sourceDbs)
+	if !ok {
+		return "", true
+	}
+	// the key is extra only if every source key it may come from is missing
+	// in every db it may be synced from, such as all dbs db_map maps to
+	// targetDb, and a source key with the same name as a renamed one
+	type sourceRef struct {
+		db  int
+		key string
+	}
+	var candidates []sourceRef
+	written := false
+	for _, sk := range sourceKeys {
+		for _, db := range sk.Dbs {
+			if tdb, tkey, ok := targetKeyOf(db, sk.Key); ok && tdb == targetDb && tkey == targetKey {
+				candidates = append(candidates, sourceRef{db, sk.Key})
+				written = true
+			}
+		}
+		if len(sk.Dbs) == 0 {
+			// no db of the source has keys, the filter is asked about the key only
+			if _, tkey, ok := targetKeyOf(targetDb, sk.Key); ok && tkey == targetKey {
+				written = true
+			}
+		}
+	}
+	if !written {
+		return "", true
+	}
+	for i := 0; ; i++ {
+		sourceExists := false
+		for _, c := range candidates {
+			found, err := exists(w.source, c.db, c.key)
+			if err != nil {
+				return "error: " + err.Error(), false
+			}
+			if found {
+				sourceExists = true
+				break
+			}
+		}
+		targetExists, err := exists(w.target, targetDb, targetKey)
+		if err != nil {
+			return "error: " + err.Error(), false
+		}
+		if sourceExists || !targetExists {
+			return "", true
+		}
+		if i == sampleRetries {
+			break
+		}
+		time.Sleep(sampleRetryDelay)
+	}
+	statistics.AddVerifyExtraCount()
+	if config.Config.Advanced.VerifyExtraKeys == "delete" {
+		if _, err := w.target.do(targetDb, targetKey, "DEL", targetKey); err != nil {
+			return "extra on target, delete failed: " + err.Error(), false
+		}
+		log.Infof("verify deleted extra key on target. db=[%d], key=[%s]", targetDb, targetKey)
+		return "extra on target, deleted", false
+	}
+	log.Warnf("verify found extra key on target. db=[%d], key=[%s], source_keys=%v", targetDb, targetKey, candidates)
+	return "extra on target", false
+}
+
+func exists(p *endpoint, dbId int, key string) (bool, error) {
+	reply, err := p.do(dbId, key, "EXISTS", key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+// targetKeyOf maps a key of the source by the filter the same way as it was
+// synced, ok is false if the filter drops it.
+func targetKeyOf(dbId int, key string) (targetDb int, targetKey string, ok bool) {
+	e := entry.NewEntry()
+	e.DbId = dbId
+	e.Argv = []string{"RESTORE", key, "0", ""} // how a key of the source looks like to the filter
+	e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+	e.Slots = commands.CalcSlots(e.Keys)
+	filterMu.Lock()
+	code := filter.Filter(e)
+	filterMu.Unlock()
+	if code != filter.Allow {
+		return 0, "", false
+	}
+	return e.DbId, e.Argv[1], true
+}
+
+// compare returns an empty reason if the key is the same on both sides.
+func (w *verifyWorker) compare(sourceDb int, sourceKey string, targetDb int, targetKey string) (string, error) {
+	var sourceSum, targetSum string
+	var err error
+	switch w.method {
+	case "digest":
+		if sourceSum, err = digestValue(w.source, sourceDb, sourceKey); err != nil {
+			return "", err
+		}
+		if targetSum, err = digestValue(w.target, targetDb, targetKey); err != nil {
+			return "", err
+		}
+	case "dump":
+		if sourceSum, err = dumpPayload(w.source, sourceDb, sourceKey); err != nil {
+			return "", err
+		}
+		if targetSum, err = dumpPayload(w.target, targetDb, targetKey); err != nil {
+			return "", err
+		}
+	}
+	if sourceSum == "" && targetSum == "" {
+		return "", nil // deleted on both sides
+	}
+	if sourceSum == "" {
+		return "", nil // deleted on source after scan
+	}
+	if targetSum == "" {
+		return "missing on target", nil
+	}
+
+	sourceTTL, err := hasTTL(w.source, sourceDb, sourceKey)
+	if err != nil {
+		return "", err
+	}
+	targetTTL, err := hasTTL(w.target, targetDb, targetKey)
+	if err != nil {
+		return "", err
+	}
+	if sourceSum == targetSum && sourceTTL == targetTTL {
+		return "", nil
+	}
+
+	sourceValue, err := readValue(w.source, sourceDb, sourceKey)
+	if err != nil {
+		return "", err
+	}
+	targetValue, err := readValue(w.target, targetDb, targetKey)
+	if err != nil {
+		return "", err
+	}
+	if sourceTTL != targetTTL {
+		return fmt.Sprintf("ttl differs. source=[%v], target=[%v]", sourceValue, targetValue), nil
+	}
+	// DUMP payloads differ when the encodings differ, compare the values again
+	if w.method == "dump" && sourceValue.content != nil && sourceValue.equal(targetValue) {
+		return "", nil
+	}
+	return fmt.Sprintf("value differs. source=[%v], target=[%v]", sourceValue, targetValue), nil
+}
+
+func hasTTL(p *endpoint, dbId int, key string) (bool, error) {
+	ttl, err := pttl(p, dbId, key)
+	return ttl >= 0, err
+}
+
+// digestValue returns an empty string if the key does not exist.
+func digestValue(p *endpoint, dbId int, key string) (string, error) {
+	reply, err := p.do(dbId, key, "DEBUG", "DIGEST-VALUE", key)
+	if err != nil {
+		return "", err
+	}
+	digests := flatten(reply, []string{})
+	if len(digests) != 1 {
+		return "", fmt.Errorf("unexpected DEBUG DIGEST-VALUE reply: %v", reply)
+	}
+	if strings.Trim(digests[0], "0") == "" {
+		return "", nil
+	}
+	return digests[0], nil
+}
+
+// dumpPayload returns the DUMP payload without rdb version and checksum, or an
+// empty string if the key does not exist.
+func dumpPayload(p *endpoint, dbId int, key string) (string, error) {
+	reply, err := p.do(dbId, key, "DUMP", key)
+	if err == proto.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprint(reply)
+	if len(payload) < dumpTrailerLen {
+		return "", fmt.Errorf("DUMP payload too short. key=[%s]", key)
+	}
+	return payload[:len(payload)-dumpTrailerLen], nil
+}
@@ -0,0 +1,163 @@
+package checker
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stringServer serves string keys, DUMP returns the payload of a key and GET
+// its value.
+func stringServer(t *testing.T, payloads map[string]string, values map[string]string) *clienttest.Server {
+	return clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		key := ""
+		if len(argv) > 1 {
+			key = argv[1]
+		}
+		value, ok := values[key]
+		switch strings.ToUpper(argv[0]) {
+		case "SELECT":
+			return clienttest.OK
+		case "DUMP":
+			if !ok {
+				return clienttest.Nil
+			}
+			return clienttest.Bulk(payloads[key] + "\x09\x00" + strings.Repeat("\x00", 8))
+		case "PTTL":
+			return clienttest.Int(-1)
+		case "TYPE":
+			if !ok {
+				return "+none\r\n"
+			}
+			return "+string\r\n"
+		case "STRLEN":
+			return clienttest.Int(int64(len(value)))
+		case "GET":
+			return clienttest.Bulk(value)
+		}
+		return clienttest.Error("ERR unknown command")
+	}))
+}
+
+func TestVerifyCompare(t *testing.T) {
+	source := stringServer(t,
+		map[string]string{"same": "p1", "int": "int:7", "diff": "p2", "gone": "p3"},
+		map[string]string{"same": "v", "int": "7", "diff": "a", "gone": "v"})
+	// int is stored with another encoding on the target, its DUMP differs
+	target := stringServer(t,
+		map[string]string{"same": "p1", "int": "raw:7", "diff": "p4"},
+		map[string]string{"same": "v", "int": "7", "diff": "b"})
+	w := &verifyWorker{
+		method: "dump",
+		source: newEndpoint(source.Addr(), "", "", false),
+		target: newEndpoint(target.Addr(), "", "", false),
+	}
+	for key, want := range map[string]string{
+		"same":    "",
+		"int":     "",
+		"diff":    "value differs",
+		"gone":    "missing on target",
+		"missing": "",
+	} {
+		reason, err := w.compare(0, key, 0, key)
+		if err != nil || !strings.HasPrefix(reason, want) || (want == "" && reason != "") {
+			t.Errorf("key=[%s], reason=[%s], error=[%v], want=[%s]", key, reason, err, want)
+		}
+	}
+}
+
+// scanServer has the keys a, b and c in db 0, SCAN returns a then b and c
+// from cursor 5.
+func scanServer(t *testing.T) *clienttest.Server {
+	return clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		switch strings.ToUpper(argv[0]) {
+		case "INFO":
+			return clienttest.Bulk("# Keyspace\r\ndb0:keys=3,expires=0,avg_ttl=0\r\n")
+		case "SELECT":
+			return clienttest.OK
+		case "SCAN":
+			if argv[1] != "5" {
+				return clienttest.Array(clienttest.Bulk("5"), clienttest.Array(clienttest.Bulk("a")))
+			}
+			return clienttest.Array(clienttest.Bulk("0"), clienttest.Array(clienttest.Bulk("b"), clienttest.Bulk("c")))
+		}
+		return clienttest.Error("ERR unknown command")
+	}))
+}
+
+// TestForEachKeyResume checks that the scan continues from the saved cursor
+// and that the keys found before the interruption are counted.
+func TestForEachKeyResume(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.VerifyWorkers = 2
+
+	node := scanServer(t)
+	cursorFile := filepath.Join(t.TempDir(), verifyCursorFile)
+	buf, _ := json.Marshal(verifyPosition{DbId: 0, Cursor: 5, Scanned: 1, Found: 1})
+	if err := ioutil.WriteFile(cursorFile, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var checked []string
+	found := forEachKey(cursorFile, scanNodes{addresses: []string{node.Addr()}}, func() func(dbId int, key string) bool {
+		return func(dbId int, key string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			checked = append(checked, key)
+			return key == "c"
+		}
+	})
+	if found != 2 {
+		t.Errorf("found=[%d], want the key found before the interruption and c", found)
+	}
+	if len(checked) != 2 || strings.Contains(strings.Join(checked, ""), "a") {
+		t.Errorf("checked=%v, want b and c only", checked)
+	}
+	if _, err := os.Stat(cursorFile); !os.IsNotExist(err) {
+		t.Errorf("cursor file not removed after the scan. error=[%v]", err)
+	}
+}
+
+func TestVerifyProgress(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.VerifyWorkers = 1
+	savedMetrics := *statistics.Metrics
+	defer func() { *statistics.Metrics = savedMetrics }()
+
+	cursorFile := filepath.Join(t.TempDir(), verifyCursorFile)
+	forEachKey(cursorFile, scanNodes{addresses: []string{scanServer(t).Addr()}}, func() func(dbId int, key string) bool {
+		return func(dbId int, key string) bool { return false }
+	})
+	m := statistics.LoadMetrics()
+	if m.VerifyScannedKeys != 3 || m.VerifyTotalKeys != 3 || m.VerifyPercent != 100 || m.VerifyETASeconds != 0 {
+		t.Errorf("scanned=[%d], total=[%d], percent=[%v], eta=[%d], want every key of INFO keyspace scanned",
+			m.VerifyScannedKeys, m.VerifyTotalKeys, m.VerifyPercent, m.VerifyETASeconds)
+	}
+
+	cases := []struct {
+		scannedThisRun, total, scanned uint64
+		elapsed, eta                   time.Duration
+	}{
+		{0, 100, 0, time.Second, -1},
+		// resumed at 40, 10 keys in 1s, 50 keys left
+		{10, 100, 50, time.Second, 5 * time.Second},
+		// keys were added since INFO keyspace
+		{120, 100, 120, time.Second, 0},
+	}
+	for _, c := range cases {
+		if got := eta(c.scannedThisRun, c.total, c.scanned, c.elapsed); got != c.eta {
+			t.Errorf("eta(%d, %d, %d, %v)=[%v], want=[%v]", c.scannedThisRun, c.total, c.scanned, c.elapsed, got, c.eta)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package clienttest runs a fake redis server for the tests of the packages
+// talking to redis. It speaks RESP2, each command is answered by a Handler.
+package clienttest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alibaba/RedisShake/internal/client/proto"
+)
+
+// Handler returns the raw reply of argv, such as OK or Error("ERR ...").
+type Handler func(argv []string) string
+
+// Replies
+const (
+	OK   = "+OK\r\n"
+	Nil  = "$-1\r\n"
+	Pong = "+PONG\r\n"
+	// Hangup closes the connection instead of replying.
+	Hangup = "hangup"
+)
+
+func Error(msg string) string {
+	return "-" + msg + "\r\n"
+}
+
+func Int(n int64) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+func Bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func Array(items ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(items), strings.Join(items, ""))
+}
+
+// Server is a fake redis server listening on a random port of localhost.
+type Server struct {
+	ln      net.Listener
+	handler Handler
+	mu      sync.Mutex
+	cmds    [][]string
+}
+
+// NewServer starts a server answering with handler, it is closed when the
+// test ends. The handshake of the clients, HELLO, CLIENT SETNAME and PING,
+// is answered by the handler too, see Handshake.
+func NewServer(t testing.TB, handler Handler) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	s := &Server{ln: ln, handler: handler}
+	go s.accept()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Commands returns the commands received, the handshake excluded.
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.cmds...)
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := proto.NewReader(bufio.NewReader(conn))
+	for {
+		reply, err := rd.ReadReply()
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		argv := make([]string, len(items))
+		for i, item := range items {
+			argv[i], _ = item.(string)
+		}
+		if !isHandshake(argv) {
+			s.mu.Lock()
+			s.cmds = append(s.cmds, argv)
+			s.mu.Unlock()
+		}
+		out := s.handler(argv)
+		if out == Hangup {
+			return
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func isHandshake(argv []string) bool {
+	if len(argv) == 0 {
+		return false
+	}
+	switch strings.ToLower(argv[0]) {
+	case "hello", "ping", "auth":
+		return true
+	case "client":
+		return len(argv) > 1 && strings.EqualFold(argv[1], "setname")
+	}
+	return false
+}
+
+// Handshake answers the handshake of a client without a password, HELLO
+// is refused as by redis < 6 and the rest is passed to next.
+func Handshake(next Handler) Handler {
+	return func(argv []string) string {
+		switch strings.ToLower(argv[0]) {
+		case "hello":
+			return Error("ERR unknown command 'hello'")
+		case "ping":
+			return Pong
+		case "client":
+			if len(argv) > 1 && strings.EqualFold(argv[1], "setname") {
+				return OK
+			}
+		}
+		return next(argv)
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"errors"
+	"github.com/alibaba/RedisShake/internal/log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Faults are injected into a connection to exercise the retry and reconnect
+// logic in staging. Rates are the probabilities per read or write on the
+// connection, from 0 to 1.
+type Faults struct {
+	ErrorRate      float64 // a read fails as if the connection was reset
+	LatencyRate    float64 // a read is delayed by Latency
+	Latency        time.Duration
+	DisconnectRate float64 // the connection is closed before a write
+}
+
+func (f *Faults) Enabled() bool {
+	return f.ErrorRate > 0 || f.LatencyRate > 0 || f.DisconnectRate > 0
+}
+
+var ErrInjected = errors.New("injected fault")
+
+type faultConn struct {
+	net.Conn
+	faults Faults
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if rand.Float64() < c.faults.LatencyRate {
+		log.Warnf("fault injection: read delayed. address=[%s], latency=[%v]", c.RemoteAddr(), c.faults.Latency)
+		time.Sleep(c.faults.Latency)
+	}
+	if rand.Float64() < c.faults.ErrorRate {
+		log.Warnf("fault injection: read error. address=[%s]", c.RemoteAddr())
+		_ = c.Conn.Close()
+		return 0, ErrInjected
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	if rand.Float64() < c.faults.DisconnectRate {
+		log.Warnf("fault injection: disconnected. address=[%s]", c.RemoteAddr())
+		_ = c.Conn.Close()
+		return 0, ErrInjected
+	}
+	return c.Conn.Write(b)
+}
+
+// InjectFaults makes the following reads and writes fail or slow down randomly.
+func (r *Redis) InjectFaults(faults Faults) {
+	if !faults.Enabled() {
+		return
+	}
+	log.Warnf("fault injection enabled. address=[%s], error_rate=[%v], latency_rate=[%v], latency=[%v], disconnect_rate=[%v]",
+		r.conn.RemoteAddr(), faults.ErrorRate, faults.LatencyRate, faults.Latency, faults.DisconnectRate)
+	r.conn.Conn = &faultConn{Conn: r.conn.Conn, faults: faults}
+}
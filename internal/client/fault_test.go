@@ -0,0 +1,37 @@
+package client
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"testing"
+	"time"
+)
+
+func TestInjectFaults(t *testing.T) {
+	server := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		return clienttest.OK
+	}))
+	cases := []struct {
+		name   string
+		faults Faults
+		err    error
+	}{
+		{"read error", Faults{ErrorRate: 1}, ErrInjected},
+		{"disconnect", Faults{DisconnectRate: 1}, ErrInjected},
+		{"latency", Faults{LatencyRate: 1, Latency: 50 * time.Millisecond}, nil},
+		{"none", Faults{}, nil},
+	}
+	for _, c := range cases {
+		r := NewRedisClient(server.Addr(), "", "", false)
+		r.InjectFaults(c.faults)
+		start := time.Now()
+		reply, err := r.Do("SET", "k", "v")
+		elapsed := time.Since(start)
+		r.Close()
+		if err != c.err || (err == nil && reply != "OK") {
+			t.Errorf("%s: reply=[%v], error=[%v], want error=[%v]", c.name, reply, err, c.err)
+		}
+		if elapsed < c.faults.Latency {
+			t.Errorf("%s: reply not delayed. elapsed=[%v], latency=[%v]", c.name, elapsed, c.faults.Latency)
+		}
+	}
+}
@@ -3,14 +3,38 @@ package client
 import (
 	"bufio"
 	"crypto/tls"
+	"fmt"
 	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/log"
 	"net"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// timeoutConn refreshes the read deadline before every read, so a silent peer
+// is detected after timeout. Zero timeout means no deadline.
+type timeoutConn struct {
+	net.Conn
+	timeout int64 // nanoseconds, accessed atomically
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if timeout := atomic.LoadInt64(&c.timeout); timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(time.Duration(timeout))); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
 type Redis struct {
+	conn        *timeoutConn
 	reader      *bufio.Reader
 	writer      *bufio.Writer
 	protoReader *proto.Reader
@@ -18,49 +42,144 @@ type Redis struct {
 }
 
 func NewRedisClient(address string, username string, password string, isTls bool) *Redis {
-	r := new(Redis)
-	var conn net.Conn
-	var dialer net.Dialer
-	var err error
-	dialer.Timeout = 3 * time.Second
-	if isTls {
-		conn, err = tls.DialWithDialer(&dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		conn, err = dialer.Dial("tcp", address)
-	}
+	r, err := DialRedisClient(address, username, password, isTls)
 	if err != nil {
 		log.PanicError(err)
 	}
+	return r
+}
 
-	r.reader = bufio.NewReader(conn)
-	r.writer = bufio.NewWriter(conn)
-	r.protoReader = proto.NewReader(r.reader)
-	r.protoWriter = proto.NewWriter(r.writer)
+// DialRedisClient is the same as NewRedisClient, but returns an error instead
+// of panic, used by the reconnect loops. The connection is set up by HELLO
+// with AUTH and SETNAME, or by AUTH, CLIENT SETNAME and PING on servers
+// without HELLO.
+func DialRedisClient(address string, username string, password string, isTls bool) (*Redis, error) {
+	r, err := dial(address, isTls)
+	if err != nil {
+		return nil, err
+	}
+	name := clientName(address)
+	err = r.hello(username, password, name)
+	if err == nil {
+		log.Infof("hello successful. address=[%s], name=[%s]", address, name)
+		return r, nil
+	}
+	if redisErr, ok := err.(proto.RedisError); ok && strings.HasPrefix(string(redisErr), "WRONGPASS") {
+		r.Close()
+		return nil, fmt.Errorf("auth failed. address=[%s], error=[%v]", address, err)
+	}
+	if _, ok := err.(proto.RedisError); !ok {
+		// some proxies close the connection on unknown commands
+		r.Close()
+		if r, err = dial(address, isTls); err != nil {
+			return nil, err
+		}
+	}
+	log.Debugf("HELLO is not supported, fall back to AUTH. address=[%s], error=[%v]", address, err)
 
 	// auth
 	if password != "" {
-		var reply string
+		var reply interface{}
 		if username != "" {
-			reply = r.DoWithStringReply("auth", username, password)
+			reply, err = r.Do("auth", username, password)
 		} else {
-			reply = r.DoWithStringReply("auth", password)
+			reply, err = r.Do("auth", password)
 		}
-		if reply != "OK" {
-			log.Panicf("auth failed with reply: %s", reply)
+		if err != nil || reply != "OK" {
+			r.Close()
+			return nil, fmt.Errorf("auth failed. address=[%s], reply=[%v], error=[%v]", address, reply, err)
 		}
 		log.Infof("auth successful. address=[%s]", address)
 	} else {
 		log.Infof("no password. address=[%s]", address)
 	}
 
+	// proxies and old servers may not know CLIENT SETNAME
+	if _, err = r.Do("client", "setname", name); err != nil {
+		log.Debugf("CLIENT SETNAME failed. address=[%s], error=[%v]", address, err)
+		if _, ok := err.(proto.RedisError); !ok {
+			r.Close()
+			return nil, err
+		}
+	}
+
 	// ping to test connection
-	reply := r.DoWithStringReply("ping")
+	reply, err := r.Do("ping")
+	if err != nil || reply != "PONG" {
+		r.Close()
+		return nil, fmt.Errorf("ping failed. address=[%s], reply=[%v], error=[%v]", address, reply, err)
+	}
 
-	if reply != "PONG" {
-		panic("ping failed with reply: " + reply)
+	return r, nil
+}
+
+// hello sends HELLO 2, which keeps the RESP2 replies, with AUTH if there is a
+// password.
+func (r *Redis) hello(username string, password string, name string) error {
+	args := []string{"hello", "2"}
+	if password != "" {
+		if username == "" {
+			username = "default"
+		}
+		args = append(args, "auth", username, password)
 	}
+	args = append(args, "setname", name)
+	_, err := r.Do(args...)
+	return err
+}
 
-	return r
+// clientName is set by HELLO or CLIENT SETNAME so that the connections of
+// redis-shake are found in CLIENT LIST of both ends, such as
+// redis-shake-<task_id>-source. The task id is the pid if task_id is empty.
+// The role is told by the address, all connections to other addresses than
+// the source are to the target.
+func clientName(address string) string {
+	task := config.Config.Advanced.TaskId
+	if task == "" {
+		task = strconv.Itoa(os.Getpid())
+	}
+	role := "target"
+	if address == config.Config.Source.Address {
+		role = "source"
+	}
+	// the name can not contain spaces
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, "redis-shake-"+task+"-"+role)
+}
+
+func dial(address string, isTls bool) (*Redis, error) {
+	r := new(Redis)
+	var conn net.Conn
+	var dialer net.Dialer
+	var err error
+	dialer.Timeout = 3 * time.Second
+	if isTls {
+		conn, err = tls.DialWithDialer(&dialer, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.conn = &timeoutConn{Conn: conn}
+	r.reader = bufio.NewReader(r.conn)
+	r.writer = bufio.NewWriter(r.conn)
+	r.protoReader = proto.NewReader(r.reader)
+	r.protoWriter = proto.NewWriter(r.writer)
+	return r, nil
+}
+
+// Do sends the command and returns the reply, errors are returned instead of panic.
+func (r *Redis) Do(args ...string) (interface{}, error) {
+	if err := r.TrySend(args...); err != nil {
+		return nil, err
+	}
+	return r.Receive()
 }
 
 func (r *Redis) DoWithStringReply(args ...string) string {
@@ -75,29 +194,50 @@ func (r *Redis) DoWithStringReply(args ...string) string {
 }
 
 func (r *Redis) Send(args ...string) {
+	err := r.TrySend(args...)
+	if err != nil {
+		log.PanicError(err)
+	}
+}
+
+// TrySend is the same as Send, but returns the error instead of panic.
+func (r *Redis) TrySend(args ...string) error {
 	argsInterface := make([]interface{}, len(args))
 	for inx, item := range args {
 		argsInterface[inx] = item
 	}
 	err := r.protoWriter.WriteArgs(argsInterface)
 	if err != nil {
-		log.PanicError(err)
+		return err
 	}
-	r.flush()
+	return r.writer.Flush()
 }
 
 func (r *Redis) SendBytes(buf []byte) {
-	_, err := r.writer.Write(buf)
+	err := r.TrySendBytes(buf)
 	if err != nil {
 		log.PanicError(err)
 	}
-	r.flush()
 }
 
-func (r *Redis) flush() {
-	err := r.writer.Flush()
+// TrySendBytes is the same as SendBytes, but returns the error instead of panic.
+func (r *Redis) TrySendBytes(buf []byte) error {
+	_, err := r.writer.Write(buf)
 	if err != nil {
-		log.PanicError(err)
+		return err
+	}
+	return r.writer.Flush()
+}
+
+// SetReadTimeout makes reads fail if nothing is received within timeout.
+func (r *Redis) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&r.conn.timeout, int64(timeout))
+}
+
+// Close closes the connection, errors are ignored.
+func (r *Redis) Close() {
+	if r.conn != nil {
+		_ = r.conn.Close()
 	}
 }
 
@@ -140,3 +280,101 @@ func (r *Redis) Scan(cursor uint64) (newCursor uint64, keys []string) {
 	}
 	return
 }
+
+var keyspaceDbRegexp = regexp.MustCompile(`(?m)^db(\d+):`)
+
+// KeyspaceDbs returns the dbs that have keys, in ascending order.
+func (r *Redis) KeyspaceDbs() []int {
+	info := r.DoWithStringReply("INFO", "keyspace")
+	var dbIds []int
+	for _, match := range keyspaceDbRegexp.FindAllStringSubmatch(info, -1) {
+		dbId, err := strconv.Atoi(match[1])
+		if err != nil {
+			log.PanicError(err)
+		}
+		dbIds = append(dbIds, dbId)
+	}
+	sort.Ints(dbIds)
+	return dbIds
+}
+
+// DumpWithTTL returns the DUMP payload and the PTTL of key, read in one
+// MULTI so that the ttl is the one of the payload. exists is false if the key
+// does not exist, pttl is -1 if it has no ttl.
+func (r *Redis) DumpWithTTL(key string) (payload string, pttl int64, exists bool, err error) {
+	replies, err := r.exec([]string{"DUMP", key}, []string{"PTTL", key})
+	if err != nil {
+		return "", 0, false, err
+	}
+	return dumpReplies(replies)
+}
+
+// DumpWithOffset is DumpWithTTL, and also returns master_repl_offset of
+// INFO replication read in the same MULTI, the replication offset the value
+// is at.
+func (r *Redis) DumpWithOffset(key string) (payload string, pttl int64, exists bool, offset int64, err error) {
+	replies, err := r.exec([]string{"DUMP", key}, []string{"PTTL", key}, []string{"INFO", "replication"})
+	if err != nil {
+		return "", 0, false, 0, err
+	}
+	info, ok := replies[2].(string)
+	if !ok {
+		return "", 0, false, 0, fmt.Errorf("unexpected INFO reply: %v", replies[2])
+	}
+	match := masterReplOffsetRegexp.FindStringSubmatch(info)
+	if match == nil {
+		return "", 0, false, 0, fmt.Errorf("master_repl_offset not found in INFO replication")
+	}
+	if offset, err = strconv.ParseInt(match[1], 10, 64); err != nil {
+		return "", 0, false, 0, err
+	}
+	payload, pttl, exists, err = dumpReplies(replies[:2])
+	return payload, pttl, exists, offset, err
+}
+
+var masterReplOffsetRegexp = regexp.MustCompile(`master_repl_offset:(\d+)`)
+
+// exec runs cmds in one MULTI and returns the replies of EXEC, one per
+// command, an error reply of a command is an error in the replies.
+func (r *Redis) exec(cmds ...[]string) ([]interface{}, error) {
+	for _, argv := range append(append([][]string{{"MULTI"}}, cmds...), []string{"EXEC"}) {
+		if err := r.TrySend(argv...); err != nil {
+			return nil, err
+		}
+	}
+	// +OK of MULTI and +QUEUED of the commands, all replies are read so
+	// that the connection can be used after an error
+	var queueErr error
+	for i := 0; i < len(cmds)+1; i++ {
+		if _, err := r.Receive(); err != nil && queueErr == nil {
+			queueErr = err
+		}
+	}
+	reply, err := r.Receive()
+	if queueErr != nil {
+		return nil, queueErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(cmds) {
+		return nil, fmt.Errorf("unexpected EXEC reply: %v", reply)
+	}
+	return replies, nil
+}
+
+// dumpReplies reads the replies of DUMP and PTTL.
+func dumpReplies(replies []interface{}) (payload string, pttl int64, exists bool, err error) {
+	if err, ok := replies[0].(error); ok {
+		return "", 0, false, err
+	}
+	if replies[0] == nil {
+		return "", 0, false, nil
+	}
+	payload, ok := replies[0].(string)
+	if pttl, ok2 := replies[1].(int64); ok && ok2 {
+		return payload, pttl, true, nil
+	}
+	return "", 0, false, fmt.Errorf("unexpected EXEC reply: %v", replies)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// handshakeServer records the commands of the handshake, helloErr is the
+// reply to HELLO, empty if HELLO is supported.
+func handshakeServer(t *testing.T, helloErr string, password string) (*clienttest.Server, func() []string) {
+	var mu sync.Mutex
+	var cmds []string
+	s := clienttest.NewServer(t, func(argv []string) string {
+		mu.Lock()
+		cmds = append(cmds, strings.ToLower(strings.Join(argv, " ")))
+		mu.Unlock()
+		switch strings.ToLower(argv[0]) {
+		case "hello":
+			if helloErr != "" {
+				return clienttest.Error(helloErr)
+			}
+			return clienttest.Array(clienttest.Bulk("server"), clienttest.Bulk("redis"))
+		case "auth":
+			if argv[len(argv)-1] != password {
+				return clienttest.Error("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			return clienttest.OK
+		case "ping":
+			return clienttest.Pong
+		}
+		return clienttest.OK
+	})
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), cmds...)
+	}
+}
+
+func TestDialHello(t *testing.T) {
+	defer func(task string) { config.Config.Advanced.TaskId = task }(config.Config.Advanced.TaskId)
+	config.Config.Advanced.TaskId = "t1"
+
+	s, cmds := handshakeServer(t, "", "secret")
+	r, err := DialRedisClient(s.Addr(), "", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	want := "hello 2 auth default secret setname redis-shake-t1-target"
+	if got := cmds(); len(got) != 1 || got[0] != want {
+		t.Errorf("the connection should be set up by HELLO only. cmds=%v", got)
+	}
+}
+
+func TestDialFallbackToAuth(t *testing.T) {
+	defer func(task string) { config.Config.Advanced.TaskId = task }(config.Config.Advanced.TaskId)
+	config.Config.Advanced.TaskId = "t1"
+
+	// redis < 6
+	s, cmds := handshakeServer(t, "ERR unknown command 'hello'", "secret")
+	r, err := DialRedisClient(s.Addr(), "user", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	want := []string{
+		"hello 2 auth user secret setname redis-shake-t1-target",
+		"auth user secret",
+		"client setname redis-shake-t1-target",
+		"ping",
+	}
+	if got := cmds(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected handshake. cmds=%v", got)
+	}
+
+	// a wrong password is reported by AUTH
+	s, _ = handshakeServer(t, "ERR unknown command 'hello'", "secret")
+	if _, err := DialRedisClient(s.Addr(), "", "wrong", false); err == nil || !strings.Contains(err.Error(), "auth failed") {
+		t.Errorf("wrong password should fail the auth. err=[%v]", err)
+	}
+}
+
+func TestDialWrongPass(t *testing.T) {
+	s, cmds := handshakeServer(t, "WRONGPASS invalid username-password pair or user is disabled.", "secret")
+	_, err := DialRedisClient(s.Addr(), "", "wrong", false)
+	if err == nil || !strings.Contains(err.Error(), "auth failed") || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("WRONGPASS of HELLO should fail the connection. err=[%v]", err)
+	}
+	// no fall back to AUTH with the same wrong password
+	if got := cmds(); len(got) != 1 {
+		t.Errorf("only HELLO should be sent. cmds=%v", got)
+	}
+}
@@ -5,12 +5,20 @@ import (
 	"github.com/alibaba/RedisShake/internal/log"
 	"github.com/alibaba/RedisShake/internal/utils"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 // CalcKeys https://redis.io/docs/reference/key-specs/
 func CalcKeys(argv []string) (cmaName string, group string, keys []string) {
+	cmaName, group, keys, _ = CalcKeysWithIndexes(argv)
+	return
+}
+
+// CalcKeysWithIndexes is the same as CalcKeys, and also returns the index of
+// every key in argv, so that keys can be rewritten in place.
+func CalcKeysWithIndexes(argv []string) (cmaName string, group string, keys []string, keyIndexes []int) {
 	argc := len(argv)
 	group = "unknown"
 	cmaName = strings.ToUpper(argv[0])
@@ -64,6 +72,7 @@ func CalcKeys(argv []string) (cmaName string, group string, keys []string) {
 			keyStep := spec.findKeysRangeKeyStep
 			for inx := begin; inx <= lastKeyInx && limitCount > 0; inx += keyStep {
 				keys = append(keys, argv[inx])
+				keyIndexes = append(keyIndexes, inx)
 				limitCount -= 1
 			}
 		case "keynum":
@@ -79,6 +88,7 @@ func CalcKeys(argv []string) (cmaName string, group string, keys []string) {
 			step := spec.findKeysKeynumKeyStep
 			for inx := begin + firstKey; keyCount > 0; inx += step {
 				keys = append(keys, argv[inx])
+				keyIndexes = append(keyIndexes, inx)
 				keyCount -= 1
 			}
 		default:
@@ -97,6 +107,12 @@ func CalcSlots(keys []string) []int {
 }
 
 func keyHash(key string) uint16 {
+	return utils.Crc16(HashTag(key)) & 0x3FFF
+}
+
+// HashTag returns the part of key that is hashed to the slot, the content of
+// the first {...} if not empty, or the whole key.
+func HashTag(key string) string {
 	hashtag := ""
 findHashTag:
 	for i, s := range key {
@@ -110,7 +126,25 @@ findHashTag:
 		}
 	}
 	if len(hashtag) > 0 {
-		key = hashtag
+		return hashtag
+	}
+	return key
+}
+
+// Names returns the sorted names of the known commands, subcommands such as
+// XGROUP-CREATE are returned as their container XGROUP.
+func Names() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for name := range redisCommands {
+		if i := strings.Index(name, "-"); i > 0 && containers[name[:i]] {
+			name = name[:i]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
 	}
-	return utils.Crc16(key) & 0x3FFF
+	sort.Strings(names)
+	return names
 }
@@ -42,6 +42,17 @@ func TestCalcKeys(t *testing.T) {
 	}
 }
 
+func TestCalcKeysWithIndexes(t *testing.T) {
+	_, _, keys, indexes := CalcKeysWithIndexes([]string{"MSET", "key1", "value1", "key2", "value2"})
+	if !testEq(keys, []string{"key1", "key2"}) || len(indexes) != 2 || indexes[0] != 1 || indexes[1] != 3 {
+		t.Errorf("CalcKeysWithIndexes(MSET key1 value1 key2 value2) failed. keys=%v, indexes=%v", keys, indexes)
+	}
+	_, _, keys, indexes = CalcKeysWithIndexes([]string{"RESTORE", "key", "0", "value"})
+	if !testEq(keys, []string{"key"}) || len(indexes) != 1 || indexes[0] != 1 {
+		t.Errorf("CalcKeysWithIndexes(RESTORE key 0 value) failed. keys=%v, indexes=%v", keys, indexes)
+	}
+}
+
 func TestKeyHash(t *testing.T) {
 	ret := keyHash("abcde")
 	if ret != 16097 {
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"github.com/pelletier/go-toml/v2"
 	"io/ioutil"
+	"net"
 	"os"
 	"runtime"
+	"sort"
 )
 
 type tomlSource struct {
@@ -17,51 +19,217 @@ type tomlSource struct {
 	Password         string  `toml:"password"`
 	IsTLS            bool    `toml:"tls"`
 	ElastiCachePSync string  `toml:"elasticache_psync"`
+	Flavor           string  `toml:"flavor"`
 
 	// restore mode
 	RDBFilePath string `toml:"rdb_file_path"`
+
+	// keys mode
+	KeyFile string `toml:"key_file"`
+
+	// replay mode
+	ReplayDir   string  `toml:"replay_dir"`
+	ReplaySpeed float64 `toml:"replay_speed"`
+
+	// bench mode
+	BenchCount     uint64         `toml:"bench_count"`
+	BenchTypes     map[string]int `toml:"bench_types"`
+	BenchValueSize int            `toml:"bench_value_size"`
+	BenchElements  int            `toml:"bench_elements"`
+	BenchKeySpace  uint64         `toml:"bench_key_space"`
+	BenchTTLRatio  float64        `toml:"bench_ttl_ratio"`
+	BenchTTL       int            `toml:"bench_ttl"`
 }
 
 type tomlTarget struct {
-	Type     string  `toml:"type"`
-	Version  float32 `toml:"version"`
-	Username string  `toml:"username"`
-	Address  string  `toml:"address"`
-	Password string  `toml:"password"`
-	IsTLS    bool    `toml:"tls"`
+	Type              string  `toml:"type"`
+	Version           float32 `toml:"version"`
+	Username          string  `toml:"username"`
+	Address           string  `toml:"address"`
+	Password          string  `toml:"password"`
+	IsTLS             bool    `toml:"tls"`
+	ProbeCapabilities bool    `toml:"probe_capabilities"`
+	DropUnsupported   bool    `toml:"drop_unsupported"`
+
+	// sharded target, shard name -> address
+	Shards            map[string]string `toml:"shards"`
+	ShardVirtualNodes int               `toml:"shard_virtual_nodes"`
+
+	// sink target, a registered writer to something other than redis
+	Sink        string            `toml:"sink"`
+	SinkOptions map[string]string `toml:"sink_options"`
+}
+
+type tomlFilter struct {
+	AllowKeyPatterns []string          `toml:"allow_key_patterns"`
+	BlockKeyPatterns []string          `toml:"block_key_patterns"`
+	DbMap            map[string]int    `toml:"db_map"`
+	KeyDbMap         map[string]int    `toml:"key_db_map"`
+	RenameKeyPrefix  map[string]string `toml:"rename_key_prefix"`
+	Namespace        string            `toml:"namespace"`
+	SampleRatio      float64           `toml:"sample_ratio"`
+	MaxTTL           int64             `toml:"max_ttl"`
 }
 
 type tomlAdvanced struct {
 	Dir string `toml:"dir"`
 
+	// in the client name of the connections, see CLIENT LIST
+	TaskId string `toml:"task_id"`
+
 	Ncpu int `toml:"ncpu"`
 
-	PprofPort   int `toml:"pprof_port"`
-	MetricsPort int `toml:"metrics_port"`
+	PprofPort   int    `toml:"pprof_port"`
+	MetricsPort int    `toml:"metrics_port"`
+	MetricsHost string `toml:"metrics_host"`
+	ProbePort   int    `toml:"probe_port"` // only /healthz and /readyz, on all interfaces
+
+	// control api
+	ControlToken string `toml:"control_token"`
+	StartPaused  bool   `toml:"start_paused"`
+	RateLimitOps int    `toml:"rate_limit_ops"`
+
+	RateLimitReadBytes int `toml:"rate_limit_read_bytes"`
+
+	// health probes
+	HealthStallTimeout int    `toml:"health_stall_timeout"`
+	ReadyLagBytes      uint64 `toml:"ready_lag_bytes"`
+
+	// continuous sync
+	SyncForever         bool `toml:"sync_forever"`
+	ReconnectMaxBackoff int  `toml:"reconnect_max_backoff"`
+	ReplTimeout         int  `toml:"repl_timeout"`
+	ApplyDelay          int  `toml:"apply_delay"`
+
+	// buffer the source while the target is unreachable
+	TargetOutageMaxSeconds int    `toml:"target_outage_max_seconds"`
+	TargetOutageMaxBytes   uint64 `toml:"target_outage_max_bytes"`
+
+	// dump.rdb of the full sync
+	RDBDir    string `toml:"rdb_dir"`
+	RDBResume bool   `toml:"rdb_resume"`
+
+	// write the rdb of a diskless source to dump.rdb instead of parsing it from the socket
+	DisklessSpillToDisk bool `toml:"diskless_spill_to_disk"`
+
+	// record the replication stream, see replay mode
+	RecordDir string `toml:"record_dir"`
+
+	// stop conditions
+	StopAt         string `toml:"stop_at"`
+	StopAtOffset   int64  `toml:"stop_at_offset"`
+	StopLagBytes   uint64 `toml:"stop_lag_bytes"`
+	StopLagSeconds int    `toml:"stop_lag_seconds"`
+
+	// cutover, see POST /api/v1/cutover
+	CutoverPauseSeconds int `toml:"cutover_pause_seconds"`
+	CutoverDrainTimeout int `toml:"cutover_drain_timeout"`
+	CutoverVerifyKeys   int `toml:"cutover_verify_keys"`
+
+	// scheduled runs
+	Schedule         string `toml:"schedule"`
+	ScheduleKeepRuns int    `toml:"schedule_keep_runs"`
 
 	// log
 	LogFile     string `toml:"log_file"`
 	LogLevel    string `toml:"log_level"`
 	LogInterval int    `toml:"log_interval"`
 
+	// scan mode
+	ScanKeyspaceNotify bool `toml:"scan_keyspace_notify"`
+
+	// sampling consistency checker
+	CheckInterval    int `toml:"check_interval"`
+	CheckSampleCount int `toml:"check_sample_count"`
+	ReadbackEvery    int `toml:"readback_every"`
+
+	// canary phase
+	CanaryRatio   float64  `toml:"canary_ratio"`
+	CanaryKeys    []string `toml:"canary_keys"`
+	CanaryMaxKeys int      `toml:"canary_max_keys"`
+	CanaryTimeout int      `toml:"canary_timeout"` // seconds for the target to answer the canary keys
+
+	// verify mode
+	VerifyMethod  string `toml:"verify_method"`
+	VerifyWorkers int    `toml:"verify_workers"`
+	// keys on target that do not exist on source: ignore, report or delete
+	VerifyExtraKeys string `toml:"verify_extra_keys"`
+
+	// ttl mode
+	TTLTolerance int `toml:"ttl_tolerance"`
+
+	// flush the target before the full sync
+	FlushTarget        bool   `toml:"flush_target"`
+	FlushTargetConfirm string `toml:"flush_target_confirm"`
+
 	// rdb restore
 	RDBRestoreCommandBehavior string `toml:"rdb_restore_command_behavior"`
+	// error replies of the target other than BUSYKEY are counted and skipped
+	SkipErrorReplies bool `toml:"skip_error_replies"`
+	// IDLETIME and FREQ the target ignores for its maxmemory-policy: warn or drop
+	RestoreIdleFreq string `toml:"restore_idle_freq"`
+
+	// lua scripts of the rdb: load or skip, and the name of SCRIPT on the target
+	RDBLuaScripts string `toml:"rdb_lua_scripts"`
+	ScriptCommand string `toml:"script_command"`
+	// copy the keys of an EVALSHA of an unknown script from the source
+	ScriptCopyKeys bool `toml:"script_copy_keys"`
+
+	// skip the values restored by a previous run
+	ResumeDedupe         bool `toml:"resume_dedupe"`
+	ResumeDedupeKeys     int  `toml:"resume_dedupe_keys"`
+	ResumeDedupeMinBytes int  `toml:"resume_dedupe_min_bytes"`
 
 	// for writer
 	PipelineCountLimit              uint64 `toml:"pipeline_count_limit"`
 	TargetRedisClientMaxQuerybufLen uint64 `toml:"target_redis_client_max_querybuf_len"`
 	TargetRedisProtoMaxBulkLen      uint64 `toml:"target_redis_proto_max_bulk_len"`
+
+	// memory pressure of the target
+	OOMPause                bool    `toml:"oom_pause"`
+	OOMMaxWait              int     `toml:"oom_max_wait"`
+	TargetMemoryPauseRatio  float64 `toml:"target_memory_pause_ratio"`
+	TargetMemoryResumeRatio float64 `toml:"target_memory_resume_ratio"`
+
+	// durability checkpoints
+	WaitReplicas     int `toml:"wait_replicas"`
+	WaitTimeout      int `toml:"wait_timeout"`
+	WaitEveryEntries int `toml:"wait_every_entries"`
+
+	// fault injection
+	FaultErrorRate      float64 `toml:"fault_error_rate"`
+	FaultLatencyRate    float64 `toml:"fault_latency_rate"`
+	FaultLatency        int     `toml:"fault_latency"`
+	FaultDisconnectRate float64 `toml:"fault_disconnect_rate"`
+
+	// for rewrite
+	RewriteStringChunkSize uint64   `toml:"rewrite_string_chunk_size"`
+	GeoKeyPatterns         []string `toml:"geo_key_patterns"`
+	SetRewriteBatchSize    int      `toml:"set_rewrite_batch_size"`
+
+	// pacing of big keys rewritten into several commands
+	BigKeyThreshold   uint64 `toml:"big_key_threshold"`
+	BigKeyChunkDelay  int    `toml:"big_key_chunk_delay"`
+	BigKeyConcurrency int    `toml:"big_key_concurrency"`
 }
 
 type tomlShakeConfig struct {
 	Type     string
 	Source   tomlSource
 	Target   tomlTarget
+	Filter   tomlFilter
 	Advanced tomlAdvanced
 }
 
 var Config tomlShakeConfig
 
+// DiscoverLimits is set for the target limits that are 0 in the config file,
+// they are read from the target by CONFIG GET when it is probed.
+var DiscoverLimits struct {
+	ProtoMaxBulkLen bool
+	QuerybufLen     bool
+}
+
 func init() {
 	Config.Type = "sync"
 
@@ -72,8 +240,20 @@ func init() {
 	Config.Source.Password = ""
 	Config.Source.IsTLS = false
 	Config.Source.ElastiCachePSync = ""
+	Config.Source.Flavor = "redis"
 	// restore
 	Config.Source.RDBFilePath = ""
+	// keys
+	Config.Source.KeyFile = ""
+	// bench
+	Config.Source.ReplayDir = ""
+	Config.Source.ReplaySpeed = 1
+	Config.Source.BenchCount = 1000000
+	Config.Source.BenchValueSize = 64
+	Config.Source.BenchElements = 10
+	Config.Source.BenchKeySpace = 100000
+	Config.Source.BenchTTLRatio = 0
+	Config.Source.BenchTTL = 3600
 
 	// target
 	Config.Target.Type = "standalone"
@@ -82,19 +262,102 @@ func init() {
 	Config.Target.Username = ""
 	Config.Target.Password = ""
 	Config.Target.IsTLS = false
+	Config.Target.ProbeCapabilities = false
+	Config.Target.DropUnsupported = false
+	Config.Target.Shards = map[string]string{}
+	Config.Target.ShardVirtualNodes = 160
+	Config.Target.Sink = ""
+	Config.Target.SinkOptions = map[string]string{}
+
+	// filter
+	Config.Filter.AllowKeyPatterns = []string{}
+	Config.Filter.BlockKeyPatterns = []string{}
+	Config.Filter.DbMap = map[string]int{}
+	Config.Filter.KeyDbMap = map[string]int{}
+	Config.Filter.RenameKeyPrefix = map[string]string{}
+	Config.Filter.Namespace = ""
+	Config.Filter.SampleRatio = 0
+	Config.Filter.MaxTTL = 0
 
 	// advanced
 	Config.Advanced.Dir = "data"
+	Config.Advanced.TaskId = ""
 	Config.Advanced.Ncpu = 4
 	Config.Advanced.PprofPort = 0
 	Config.Advanced.MetricsPort = 0
+	Config.Advanced.MetricsHost = "localhost"
+	Config.Advanced.ProbePort = 0
+	Config.Advanced.ControlToken = ""
+	Config.Advanced.StartPaused = false
+	Config.Advanced.RateLimitOps = 0
+	Config.Advanced.RateLimitReadBytes = 0
+	Config.Advanced.HealthStallTimeout = 60
+	Config.Advanced.ReadyLagBytes = 1000000
+	Config.Advanced.SyncForever = false
+	Config.Advanced.ReconnectMaxBackoff = 60
+	Config.Advanced.ReplTimeout = 60
+	Config.Advanced.ApplyDelay = 0
+	Config.Advanced.TargetOutageMaxSeconds = 0
+	Config.Advanced.TargetOutageMaxBytes = 0
+	Config.Advanced.RDBDir = ""
+	Config.Advanced.RDBResume = false
+	Config.Advanced.DisklessSpillToDisk = false
+	Config.Advanced.RecordDir = ""
+	Config.Advanced.StopAt = ""
+	Config.Advanced.StopAtOffset = 0
+	Config.Advanced.StopLagBytes = 0
+	Config.Advanced.StopLagSeconds = 0
+	Config.Advanced.CutoverPauseSeconds = 300
+	Config.Advanced.CutoverDrainTimeout = 60
+	Config.Advanced.CutoverVerifyKeys = 1000
+	Config.Advanced.Schedule = ""
+	Config.Advanced.ScheduleKeepRuns = 10
 	Config.Advanced.LogFile = "redis-shake.log"
 	Config.Advanced.LogLevel = "info"
 	Config.Advanced.LogInterval = 5
+	Config.Advanced.ScanKeyspaceNotify = false
+	Config.Advanced.CheckInterval = 0
+	Config.Advanced.CheckSampleCount = 10
+	Config.Advanced.ReadbackEvery = 0
+	Config.Advanced.CanaryRatio = 0
+	Config.Advanced.CanaryKeys = []string{}
+	Config.Advanced.CanaryMaxKeys = 1000
+	Config.Advanced.CanaryTimeout = 60
+	Config.Advanced.VerifyMethod = "auto"
+	Config.Advanced.VerifyWorkers = 4
+	Config.Advanced.VerifyExtraKeys = "ignore"
+	Config.Advanced.TTLTolerance = 1000
+	Config.Advanced.FlushTarget = false
+	Config.Advanced.FlushTargetConfirm = ""
 	Config.Advanced.RDBRestoreCommandBehavior = "rewrite"
+	Config.Advanced.SkipErrorReplies = false
+	Config.Advanced.RestoreIdleFreq = "warn"
+	Config.Advanced.RDBLuaScripts = "load"
+	Config.Advanced.ScriptCommand = "script"
+	Config.Advanced.ScriptCopyKeys = false
+	Config.Advanced.ResumeDedupe = false
+	Config.Advanced.ResumeDedupeKeys = 10000000
+	Config.Advanced.ResumeDedupeMinBytes = 1024
 	Config.Advanced.PipelineCountLimit = 1024
 	Config.Advanced.TargetRedisClientMaxQuerybufLen = 1024 * 1000 * 1000
 	Config.Advanced.TargetRedisProtoMaxBulkLen = 512 * 1000 * 1000
+	Config.Advanced.OOMPause = false
+	Config.Advanced.OOMMaxWait = 0
+	Config.Advanced.TargetMemoryPauseRatio = 0
+	Config.Advanced.TargetMemoryResumeRatio = 0
+	Config.Advanced.WaitReplicas = 0
+	Config.Advanced.WaitTimeout = 1000
+	Config.Advanced.WaitEveryEntries = 10000
+	Config.Advanced.FaultErrorRate = 0
+	Config.Advanced.FaultLatencyRate = 0
+	Config.Advanced.FaultLatency = 1000
+	Config.Advanced.FaultDisconnectRate = 0
+	Config.Advanced.RewriteStringChunkSize = 64 * 1000 * 1000
+	Config.Advanced.GeoKeyPatterns = []string{}
+	Config.Advanced.SetRewriteBatchSize = 512
+	Config.Advanced.BigKeyThreshold = 16 * 1024 * 1024
+	Config.Advanced.BigKeyChunkDelay = 0
+	Config.Advanced.BigKeyConcurrency = 1
 }
 
 func LoadFromFile(filename string) {
@@ -141,7 +404,184 @@ func LoadFromFile(filename string) {
 		panic("target redis version must be greater than 2.8")
 	}
 
-	if Config.Type != "sync" && Config.Type != "restore" && Config.Type != "scan" {
-		panic("type must be sync/restore/scan")
+	if Config.Type != "sync" && Config.Type != "restore" && Config.Type != "scan" && Config.Type != "verify" && Config.Type != "ttl" && Config.Type != "bench" && Config.Type != "replay" && Config.Type != "keys" {
+		panic("type must be sync/restore/scan/verify/ttl/bench/replay/keys")
+	}
+	if Config.Source.Flavor != "redis" && Config.Source.Flavor != "keydb" && Config.Source.Flavor != "dragonfly" {
+		panic("source flavor must be redis/keydb/dragonfly")
+	}
+	if Config.Target.Type != "standalone" && Config.Target.Type != "cluster" && Config.Target.Type != "sharded" && Config.Target.Type != "sink" {
+		panic("target type must be standalone/cluster/sharded/sink")
+	}
+	if Config.Target.Type == "sink" && Config.Target.Sink == "" {
+		panic("a sink target needs sink, the name of a registered sink such as jsonl")
+	}
+	if Config.Target.Type == "sharded" {
+		if len(Config.Target.Shards) == 0 || Config.Target.ShardVirtualNodes <= 0 {
+			panic("a sharded target needs shards and shard_virtual_nodes greater than 0")
+		}
+		// the target is probed and inspected by the first shard
+		if Config.Target.Address == "" {
+			names := make([]string, 0, len(Config.Target.Shards))
+			for name := range Config.Target.Shards {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			Config.Target.Address = Config.Target.Shards[names[0]]
+		}
+	}
+	if Config.Type == "keys" && Config.Source.KeyFile == "" {
+		panic("key_file must be set in keys mode")
+	}
+	if Config.Type == "replay" && (Config.Source.ReplayDir == "" || Config.Source.ReplaySpeed < 0) {
+		panic("replay_dir must be set and replay_speed must not be negative")
+	}
+	if len(Config.Source.BenchTypes) == 0 {
+		Config.Source.BenchTypes = map[string]int{"string": 1}
+	}
+	if Config.Type == "bench" && (Config.Source.BenchKeySpace == 0 || Config.Source.BenchElements <= 0) {
+		panic("bench_key_space and bench_elements must be greater than 0")
+	}
+	if Config.Advanced.VerifyMethod != "auto" && Config.Advanced.VerifyMethod != "digest" && Config.Advanced.VerifyMethod != "dump" {
+		panic("verify_method must be auto/digest/dump")
+	}
+	if Config.Advanced.RestoreIdleFreq != "warn" && Config.Advanced.RestoreIdleFreq != "drop" {
+		panic("restore_idle_freq must be warn/drop")
+	}
+	if Config.Advanced.RDBLuaScripts != "load" && Config.Advanced.RDBLuaScripts != "skip" {
+		panic("rdb_lua_scripts must be load/skip")
+	}
+	if Config.Advanced.ScriptCommand == "" {
+		panic("script_command must not be empty")
+	}
+	if Config.Advanced.VerifyExtraKeys != "ignore" && Config.Advanced.VerifyExtraKeys != "report" && Config.Advanced.VerifyExtraKeys != "delete" {
+		panic("verify_extra_keys must be ignore/report/delete")
+	}
+	for _, rate := range []float64{Config.Advanced.FaultErrorRate, Config.Advanced.FaultLatencyRate, Config.Advanced.FaultDisconnectRate} {
+		if rate < 0 || rate > 1 {
+			panic("fault_error_rate, fault_latency_rate and fault_disconnect_rate must be between 0 and 1")
+		}
+	}
+	if Config.Advanced.MetricsPort != 0 && Config.Advanced.ControlToken == "" && !isLoopback(Config.Advanced.MetricsHost) {
+		panic(fmt.Sprintf("metrics_host [%s] is reachable from other hosts, set control_token to protect the control API", Config.Advanced.MetricsHost))
+	}
+	if Config.Advanced.StartPaused && Config.Advanced.MetricsPort == 0 {
+		panic("start_paused needs metrics_port to serve /api/v1/start")
+	}
+	if Config.Advanced.RateLimitOps < 0 {
+		panic("rate_limit_ops must not be negative")
+	}
+	if Config.Advanced.RateLimitReadBytes < 0 {
+		panic("rate_limit_read_bytes must not be negative")
+	}
+	if Config.Advanced.TargetOutageMaxSeconds < 0 {
+		panic("target_outage_max_seconds must not be negative")
+	}
+	if Config.Advanced.CanaryRatio < 0 || Config.Advanced.CanaryRatio > 1 {
+		panic("canary_ratio must be between 0 and 1")
+	}
+	if Config.Advanced.CanaryMaxKeys <= 0 || Config.Advanced.CanaryTimeout <= 0 {
+		panic("canary_max_keys and canary_timeout must be positive")
+	}
+	if (Config.Advanced.CanaryRatio > 0 || len(Config.Advanced.CanaryKeys) > 0) && Config.Type != "sync" && Config.Type != "scan" {
+		panic("canary is only supported in sync and scan mode")
+	}
+	if (Config.Advanced.CanaryRatio > 0 || len(Config.Advanced.CanaryKeys) > 0) && Config.Advanced.RDBRestoreCommandBehavior == "panic" {
+		panic("canary keys are written again by the full run, rdb_restore_command_behavior can not be panic")
+	}
+	if Config.Advanced.FlushTarget {
+		if Config.Type != "sync" && Config.Type != "restore" && Config.Type != "scan" {
+			panic("flush_target is only supported in sync, restore and scan mode")
+		}
+		if Config.Advanced.FlushTargetConfirm != Config.Target.Address {
+			panic("flush_target deletes all data on the target, set flush_target_confirm to the target address to confirm")
+		}
+		if Config.Filter.Namespace != "" {
+			panic("flush_target can not be used with filter.namespace, FLUSHALL would delete the keys of the other tenants of the target")
+		}
+	}
+	if Config.Advanced.Schedule != "" {
+		if Config.Type != "sync" && Config.Type != "scan" && Config.Type != "restore" {
+			panic("schedule is only supported in sync, scan and restore mode")
+		}
+		if !Config.Advanced.FlushTarget {
+			panic("each scheduled run is a fresh full sync, which does not delete the keys deleted on the source since the last run, set flush_target")
+		}
+		if Config.Type == "sync" && Config.Advanced.StopLagSeconds <= 0 {
+			panic("scheduled sync runs must end, set stop_lag_seconds")
+		}
+		if Config.Advanced.SyncForever || Config.Advanced.ScanKeyspaceNotify || Config.Advanced.StartPaused {
+			panic("schedule can not be used with sync_forever, scan_keyspace_notify or start_paused")
+		}
+		if Config.Advanced.ScheduleKeepRuns <= 0 {
+			panic("schedule_keep_runs must be greater than 0")
+		}
+	}
+	if Config.Advanced.TargetRedisProtoMaxBulkLen == 0 {
+		Config.Advanced.TargetRedisProtoMaxBulkLen = 512 * 1000 * 1000
+		DiscoverLimits.ProtoMaxBulkLen = true
+	}
+	if Config.Advanced.TargetRedisClientMaxQuerybufLen == 0 {
+		Config.Advanced.TargetRedisClientMaxQuerybufLen = 1024 * 1000 * 1000
+		DiscoverLimits.QuerybufLen = true
+	}
+	if Config.Advanced.VerifyWorkers <= 0 {
+		panic("verify_workers must be greater than 0")
+	}
+	if Config.Advanced.CutoverPauseSeconds <= 0 || Config.Advanced.CutoverDrainTimeout <= 0 || Config.Advanced.CutoverVerifyKeys < 0 {
+		panic("cutover_pause_seconds and cutover_drain_timeout must be greater than 0, cutover_verify_keys must not be negative")
+	}
+	if Config.Advanced.TTLTolerance < 0 {
+		panic("ttl_tolerance must not be negative")
+	}
+	if Config.Advanced.RDBResume && !Config.Advanced.FlushTarget {
+		panic("rdb_resume sends dump.rdb and the commands after it again, which the last run may have applied already, set flush_target to flush the target before")
+	}
+	if Config.Advanced.ResumeDedupe {
+		if Config.Type != "sync" && Config.Type != "restore" {
+			panic("resume_dedupe can only be used in sync or restore mode")
+		}
+		if Config.Advanced.ResumeDedupeKeys <= 0 {
+			panic("resume_dedupe_keys must be greater than 0")
+		}
+	}
+	if Config.Advanced.BigKeyChunkDelay < 0 {
+		panic("big_key_chunk_delay must not be negative")
+	}
+	if Config.Advanced.BigKeyConcurrency <= 0 {
+		panic("big_key_concurrency must be greater than 0")
+	}
+	if len(Config.Filter.KeyDbMap) != 0 && Config.Target.Type == "cluster" {
+		panic("key_db_map can not be used with a cluster target, which has only db 0")
+	}
+	for pattern, dbId := range Config.Filter.KeyDbMap {
+		if dbId < 0 {
+			panic(fmt.Sprintf("invalid db in key_db_map. pattern=[%s], db=[%d]", pattern, dbId))
+		}
+	}
+	if Config.Filter.MaxTTL < 0 {
+		panic("filter.max_ttl must not be negative")
+	}
+	if Config.Filter.SampleRatio < 0 || Config.Filter.SampleRatio > 1 {
+		panic("sample_ratio must be between 0 and 1")
+	}
+	if Config.Advanced.TargetMemoryPauseRatio < 0 || Config.Advanced.TargetMemoryPauseRatio > 1 {
+		panic("target_memory_pause_ratio must be between 0 and 1")
+	}
+	if Config.Advanced.TargetMemoryResumeRatio == 0 {
+		Config.Advanced.TargetMemoryResumeRatio = Config.Advanced.TargetMemoryPauseRatio - 0.05
+	}
+	if Config.Advanced.TargetMemoryPauseRatio > 0 && Config.Advanced.TargetMemoryResumeRatio > Config.Advanced.TargetMemoryPauseRatio {
+		panic("target_memory_resume_ratio must not be greater than target_memory_pause_ratio")
+	}
+}
+
+// isLoopback reports whether host only listens on the loopback interface, an
+// empty host listens on all interfaces.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
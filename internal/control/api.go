@@ -0,0 +1,195 @@
+package control
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// The control API on metrics_port lets an orchestrator manage a fleet of
+// redis-shake instances:
+//
+//	GET  /api/v1/status
+//	GET  /api/v1/diagnostics                 the same as SIGUSR1, needs the token
+//	POST /api/v1/start                       start the sync when start_paused
+//	POST /api/v1/stop                        drain the sent commands and exit
+//	POST /api/v1/pause?side=read|write|all
+//	POST /api/v1/resume?side=read|write|all
+//	POST /api/v1/rate_limit?ops=<n>          0 means unlimited
+//	POST /api/v1/read_limit?bytes=<n>        0 means unlimited
+//	POST /api/v1/log_level?level=debug|info|warn
+//	GET  /api/v1/cutover                     see checker.CutoverHandler
+//	POST /api/v1/cutover                     freeze the source, drain and verify
+//
+// POST requests and diagnostics, which has goroutine stacks and recent
+// warnings with keys, need "Authorization: Bearer <control_token>" if
+// control_token is set.
+
+var (
+	startMu sync.Mutex
+	started = make(chan struct{})
+)
+
+// Started is closed when the sync starts, by Start or POST /api/v1/start.
+func Started() <-chan struct{} {
+	return started
+}
+
+// Start starts the sync, it returns false if it is already started.
+func Start() bool {
+	startMu.Lock()
+	defer startMu.Unlock()
+	if isStarted() {
+		return false
+	}
+	close(started)
+	return true
+}
+
+func isStarted() bool {
+	select {
+	case <-started:
+		return true
+	default:
+		return false
+	}
+}
+
+// RegisterAPI adds the control API to mux.
+func RegisterAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/status", statusHandler)
+	mux.HandleFunc("/api/v1/diagnostics", TokenRequired(DiagnosticsHandler))
+	mux.HandleFunc("/api/v1/start", Authorized(startHandler))
+	mux.HandleFunc("/api/v1/stop", Authorized(stopHandler))
+	mux.HandleFunc("/api/v1/pause", Authorized(PauseHandler))
+	mux.HandleFunc("/api/v1/resume", Authorized(ResumeHandler))
+	mux.HandleFunc("/api/v1/rate_limit", Authorized(rateLimitHandler))
+	mux.HandleFunc("/api/v1/read_limit", Authorized(readLimitHandler))
+	mux.HandleFunc("/api/v1/log_level", Authorized(logLevelHandler))
+}
+
+// Authorized checks the method and control_token of mutating requests.
+func Authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		TokenRequired(handler)(w, r)
+	}
+}
+
+// TokenRequired checks the control_token of requests of any method.
+func TokenRequired(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkToken(w, r) {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func checkToken(w http.ResponseWriter, r *http.Request) bool {
+	token := config.Config.Advanced.ControlToken
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+type status struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Started     bool   `json:"started"`
+	ReadPaused  bool   `json:"read_paused"`
+	WritePaused bool   `json:"write_paused"`
+	RateLimit   int    `json:"rate_limit_ops"`
+	ReadLimit   int    `json:"rate_limit_read_bytes"`
+	LogLevel    string `json:"log_level"`
+	Healthy     string `json:"healthy"` // empty if healthy, otherwise the reason
+	Ready       string `json:"ready"`   // empty if ready, otherwise the reason
+
+	Metrics interface{} `json:"metrics"`
+}
+
+func statusHandler(w http.ResponseWriter, _ *http.Request) {
+	cfg := &config.Config
+	source := cfg.Source.Address
+	if cfg.Type == "restore" {
+		source = cfg.Source.RDBFilePath
+	}
+	writeJSON(w, &status{
+		Type:        cfg.Type,
+		Source:      source,
+		Target:      cfg.Target.Address,
+		Started:     isStarted(),
+		ReadPaused:  ReadPause.IsPaused(),
+		WritePaused: WritePause.IsPaused(),
+		RateLimit:   WriteLimit.Rate(),
+		ReadLimit:   ReadLimit.Rate(),
+		LogLevel:    log.Level(),
+		Healthy:     stalled(),
+		Ready:       notReady(),
+		Metrics:     statistics.Snapshot(),
+	})
+}
+
+func startHandler(w http.ResponseWriter, _ *http.Request) {
+	if !Start() {
+		http.Error(w, "already started", http.StatusConflict)
+		return
+	}
+	log.Infof("control api: start")
+	writeJSON(w, map[string]bool{"started": true})
+}
+
+func stopHandler(w http.ResponseWriter, _ *http.Request) {
+	log.Infof("control api: stop, waiting for the target to answer sent commands")
+	RequestStop()
+	writeJSON(w, map[string]bool{"stopping": true})
+}
+
+func rateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	ops, err := strconv.Atoi(r.URL.Query().Get("ops"))
+	if err != nil || ops < 0 {
+		http.Error(w, "ops must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	WriteLimit.SetRate(ops)
+	log.Infof("control api: rate limit changed. ops=[%d]", ops)
+	writeJSON(w, map[string]int{"rate_limit_ops": ops})
+}
+
+func readLimitHandler(w http.ResponseWriter, r *http.Request) {
+	bytes, err := strconv.Atoi(r.URL.Query().Get("bytes"))
+	if err != nil || bytes < 0 {
+		http.Error(w, "bytes must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	ReadLimit.SetRate(bytes)
+	log.Infof("control api: read limit changed. bytes=[%d]", bytes)
+	writeJSON(w, map[string]int{"rate_limit_read_bytes": bytes})
+}
+
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	if err := log.SetLevel(level); err != nil {
+		http.Error(w, "level must be debug, info or warn", http.StatusBadRequest)
+		return
+	}
+	log.Warnf("control api: log level changed. level=[%s]", level)
+	writeJSON(w, map[string]string{"log_level": level})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("write control api response failed. err=[%v]", err)
+	}
+}
@@ -0,0 +1,90 @@
+package control
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPauseAuthorized(t *testing.T) {
+	defer func(token string) { config.Config.Advanced.ControlToken = token }(config.Config.Advanced.ControlToken)
+	config.Config.Advanced.ControlToken = "secret"
+	defer ReadPause.Resume()
+	mux := http.NewServeMux()
+	RegisterAPI(mux)
+
+	cases := []struct {
+		method string
+		token  string
+		code   int
+	}{
+		{http.MethodGet, "secret", http.StatusMethodNotAllowed},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "wrong", http.StatusUnauthorized},
+		{http.MethodPost, "secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/api/v1/pause?side=read", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Errorf("method=[%s], token=[%s], code=[%d], want=[%d]", c.method, c.token, rec.Code, c.code)
+		}
+		if paused := ReadPause.IsPaused(); paused != (c.code == http.StatusOK) {
+			t.Errorf("method=[%s], token=[%s], read_paused=[%v]", c.method, c.token, paused)
+		}
+	}
+}
+
+func TestDiagnosticsTokenRequired(t *testing.T) {
+	defer func(token string) { config.Config.Advanced.ControlToken = token }(config.Config.Advanced.ControlToken)
+	config.Config.Advanced.ControlToken = "secret"
+	mux := http.NewServeMux()
+	RegisterAPI(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/diagnostics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("diagnostics served without the token. code=[%d]", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/diagnostics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("diagnostics not served with the token. code=[%d]", rec.Code)
+	}
+}
+
+func TestLogLevelAPI(t *testing.T) {
+	defer func() { _ = log.SetLevel(config.Config.Advanced.LogLevel) }()
+	_ = log.SetLevel("info")
+	mux := http.NewServeMux()
+	RegisterAPI(mux)
+	post := func(level string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/log_level?level="+level, nil))
+		return rec.Code
+	}
+
+	if code := post("debug"); code != http.StatusOK || log.Level() != "debug" {
+		t.Errorf("code=[%d], level=[%s], want debug", code, log.Level())
+	}
+	if code := post("trace"); code != http.StatusBadRequest || log.Level() != "debug" {
+		t.Errorf("unknown level accepted. code=[%d], level=[%s]", code, log.Level())
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	var s struct {
+		LogLevel string `json:"log_level"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil || s.LogLevel != "debug" {
+		t.Errorf("status log_level=[%s], error=[%v], want debug", s.LogLevel, err)
+	}
+}
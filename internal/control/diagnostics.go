@@ -0,0 +1,90 @@
+package control
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// queue is the channel from the reader to the main loop, set by RegisterQueue
+var queue atomic.Value // chan *entry.Entry
+
+func RegisterQueue(ch chan *entry.Entry) {
+	queue.Store(ch)
+}
+
+// Diagnostics returns a snapshot for debugging hangs: phase, offsets, queue
+// depths, counters by command, recent warnings and goroutine stacks.
+func Diagnostics() string {
+	m := statistics.LoadMetrics()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "diagnostics at %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "type: %s, phase: %s\n", config.Config.Type, m.Msg)
+	fmt.Fprintf(&sb, "started: %v, read_paused: %v, write_paused: %v, rate_limit_ops: %d, since_progress: %v\n",
+		isStarted(), ReadPause.IsPaused(), WritePause.IsPaused(), WriteLimit.Rate(), SinceProgress().Truncate(time.Millisecond))
+	fmt.Fprintf(&sb, "source_connected: %v, rdb_file_size: %d, rdb_received_size: %d, rdb_send_size: %d\n",
+		m.SourceConnected, m.RdbFileSize, m.RdbReceivedSize, m.RdbSendSize)
+	fmt.Fprintf(&sb, "aof_received_offset: %d, aof_applied_offset: %d, durable_offset: %d\n",
+		m.AofReceivedOffset, statistics.GetAOFAppliedOffset(), m.DurableOffset)
+	if ch, ok := queue.Load().(chan *entry.Entry); ok {
+		fmt.Fprintf(&sb, "reader_channel: %d/%d, ", len(ch), cap(ch))
+	}
+	fmt.Fprintf(&sb, "unanswered_bytes: %d\n", m.UnansweredBytesCount)
+	fmt.Fprintf(&sb, "entry_id: %d, allow_entries: %d, disallow_entries: %d\n", m.EntryId, m.AllowEntriesCount, m.DisallowEntriesCount)
+
+	counts := statistics.GetCommandCounts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return counts[names[i]] > counts[names[j]] })
+	sb.WriteString("commands:")
+	for _, name := range names {
+		fmt.Fprintf(&sb, " %s=%d", name, counts[name])
+	}
+	sb.WriteString("\n")
+
+	drops := statistics.GetDropCounts()
+	reasons := make([]string, 0, len(drops))
+	for reason := range drops {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	sb.WriteString("drops:")
+	for _, reason := range reasons {
+		fmt.Fprintf(&sb, " %s=%d", reason, drops[reason])
+	}
+	sb.WriteString("\n")
+
+	warnings := log.RecentWarnings()
+	fmt.Fprintf(&sb, "recent warnings: %d\n", len(warnings))
+	for _, warning := range warnings {
+		fmt.Fprintf(&sb, "  %s\n", warning)
+	}
+
+	fmt.Fprintf(&sb, "goroutines: %d\n", runtime.NumGoroutine())
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			sb.Write(buf[:n])
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	return sb.String()
+}
+
+// DiagnosticsHandler serves /api/v1/diagnostics, the same as SIGUSR1.
+func DiagnosticsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "text/plain")
+	_, _ = fmt.Fprint(w, Diagnostics())
+}
@@ -0,0 +1,42 @@
+package control
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	defer func(old interface{}) {
+		if ch, ok := old.(chan *entry.Entry); ok {
+			RegisterQueue(ch)
+		}
+	}(queue.Load())
+	ch := make(chan *entry.Entry, 10)
+	ch <- entry.NewEntry()
+	ch <- entry.NewEntry()
+	RegisterQueue(ch)
+	// the counters are not reset between runs of the test
+	commands := statistics.GetCommandCounts()["diagnostics-test-command"] + 1
+	drops := statistics.GetDropCounts()["diagnostics_test_reason"] + 1
+	statistics.AddCommandCount("diagnostics-test-command")
+	statistics.AddDropCount("diagnostics_test_reason")
+	log.Warnf("diagnostics test warning")
+
+	dump := Diagnostics()
+	for _, want := range []string{
+		"reader_channel: 2/10",
+		fmt.Sprintf(" diagnostics-test-command=%d", commands),
+		fmt.Sprintf(" diagnostics_test_reason=%d", drops),
+		"WRN diagnostics test warning",
+		"goroutine ",
+		"TestDiagnostics",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("diagnostics do not contain [%s]:\n%s", want, dump)
+		}
+	}
+}
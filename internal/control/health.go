@@ -0,0 +1,71 @@
+package control
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net/http"
+	"time"
+)
+
+// HealthzHandler serves /healthz, the liveness probe. It only fails when the
+// pipeline is stalled for health_stall_timeout seconds, which a restart may
+// fix. A slow or disconnected source is not a reason to restart.
+func HealthzHandler(w http.ResponseWriter, _ *http.Request) {
+	if reason := stalled(); reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// ReadyzHandler serves /readyz, the readiness probe. It fails while the source
+// or the target is disconnected, the pipeline is stalled or writing is paused,
+// and in sync mode until the rdb is sent and the lag is below ready_lag_bytes.
+func ReadyzHandler(w http.ResponseWriter, _ *http.Request) {
+	if reason := notReady(); reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+func stalled() string {
+	timeout := time.Duration(config.Config.Advanced.HealthStallTimeout) * time.Second
+	if since := SinceProgress(); since > timeout {
+		return fmt.Sprintf("pipeline stalled for %v", since.Truncate(time.Second))
+	}
+	return ""
+}
+
+func notReady() string {
+	cfg := &config.Config
+	if !isStarted() {
+		return "not started"
+	}
+	if (cfg.Type == "sync" || cfg.Type == "scan" || cfg.Type == "keys") && !statistics.LoadMetrics().SourceConnected {
+		return "source disconnected"
+	}
+	if outage := statistics.LongestTargetOutage(); outage > 0 {
+		return fmt.Sprintf("target unreachable for %v", outage.Truncate(time.Second))
+	}
+	if reason := stalled(); reason != "" {
+		return reason
+	}
+	if OOMPaused() {
+		return "writing paused, target is under memory pressure"
+	}
+	if WritePause.IsPaused() {
+		return "writing paused"
+	}
+	if cfg.Type == "sync" {
+		lag, ok := AOFLag()
+		if !ok {
+			return "full sync in progress"
+		}
+		if lag > cfg.Advanced.ReadyLagBytes {
+			return fmt.Sprintf("lag %d bytes exceeds ready_lag_bytes", lag)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package control
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthProbes(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	savedMetrics := *statistics.Metrics
+	defer func() { *statistics.Metrics = savedMetrics }()
+	defer statistics.ResetAOFAppliedOffset()
+	defer func(old chan struct{}) { started = old }(started)
+	started = make(chan struct{})
+	config.Config.Type = "sync"
+	config.Config.Advanced.HealthStallTimeout = 60
+	config.Config.Advanced.ReadyLagBytes = 100
+
+	probe := func(handler http.HandlerFunc) (int, string) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+	ready := func(want string) {
+		t.Helper()
+		code, body := probe(ReadyzHandler)
+		if want == "ok" && (code != http.StatusOK || body != "ok") ||
+			want != "ok" && (code != http.StatusServiceUnavailable || !strings.HasPrefix(body, want)) {
+			t.Errorf("readyz code=[%d], body=[%s], want=[%s]", code, body, want)
+		}
+	}
+
+	ready("not started")
+	Start()
+	statistics.SetSourceConnected(false)
+	ready("source disconnected")
+	statistics.SetSourceConnected(true)
+	statistics.SetRDBFileSize(100)
+	statistics.UpdateRDBSentSize(50)
+	ready("full sync in progress")
+	statistics.UpdateRDBSentSize(100)
+	atomic.StoreUint64(&statistics.Metrics.AofReceivedOffset, 1000)
+	statistics.ResetAOFAppliedOffset()
+	statistics.UpdateAOFAppliedOffset(800)
+	ready("lag 200 bytes exceeds ready_lag_bytes")
+	statistics.UpdateAOFAppliedOffset(950)
+	ready("ok")
+
+	WritePause.Pause()
+	ready("writing paused")
+	WritePause.Resume()
+	statistics.StartTargetOutage("target:6379")
+	ready("target unreachable")
+	statistics.EndTargetOutage("target:6379")
+	ready("ok")
+
+	// a slow source does not restart the process, a stalled pipeline does
+	if code, _ := probe(HealthzHandler); code != http.StatusOK {
+		t.Errorf("healthz code=[%d], want 200", code)
+	}
+	SinceProgress()
+	atomic.StoreInt64(&progress.last, time.Now().Add(-time.Hour).UnixNano())
+	defer atomic.StoreInt64(&progress.last, time.Now().UnixNano())
+	if code, body := probe(HealthzHandler); code != http.StatusServiceUnavailable || !strings.HasPrefix(body, "pipeline stalled") {
+		t.Errorf("healthz code=[%d], body=[%s], want 503 when stalled", code, body)
+	}
+	ready("pipeline stalled")
+}
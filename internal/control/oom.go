@@ -0,0 +1,76 @@
+package control
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"sync"
+	"time"
+)
+
+// oom tracks the nodes of the target under memory pressure, writing is
+// paused while there is any.
+var oom struct {
+	mu     sync.Mutex
+	nodes  map[string]bool
+	since  time.Time
+	paused bool // WritePause was paused by oom, not by hand
+}
+
+// OOMPause pauses writing because node is out of memory or above
+// target_memory_pause_ratio.
+func OOMPause(node string, reason string) {
+	oom.mu.Lock()
+	defer oom.mu.Unlock()
+	if oom.nodes[node] {
+		return
+	}
+	if oom.nodes == nil {
+		oom.nodes = make(map[string]bool)
+	}
+	if len(oom.nodes) == 0 {
+		oom.since = time.Now()
+		oom.paused = !WritePause.IsPaused()
+		WritePause.Pause()
+		statistics.SetOOMPaused(true)
+	}
+	oom.nodes[node] = true
+	log.Warnf("target is under memory pressure, writing paused until memory is available. node=[%s], reason=[%s]", node, reason)
+}
+
+// OOMResume resumes writing once every node recovered, unless writing was
+// paused by hand before.
+func OOMResume(node string) {
+	oom.mu.Lock()
+	defer oom.mu.Unlock()
+	if !oom.nodes[node] {
+		return
+	}
+	delete(oom.nodes, node)
+	log.Infof("target memory is available again. node=[%s], paused=[%v]", node, time.Since(oom.since).Truncate(time.Second))
+	if len(oom.nodes) > 0 {
+		return
+	}
+	statistics.SetOOMPaused(false)
+	if oom.paused {
+		WritePause.Resume()
+	}
+}
+
+// OOMPaused reports whether writing is paused by memory pressure.
+func OOMPaused() bool {
+	oom.mu.Lock()
+	defer oom.mu.Unlock()
+	return len(oom.nodes) > 0
+}
+
+// CheckOOMWait exits if writing is paused by memory pressure for more than
+// oom_max_wait seconds.
+func CheckOOMWait() {
+	maxWait := time.Duration(config.Config.Advanced.OOMMaxWait) * time.Second
+	oom.mu.Lock()
+	defer oom.mu.Unlock()
+	if maxWait > 0 && len(oom.nodes) > 0 && time.Since(oom.since) > maxWait {
+		log.Panicf("target is out of memory for more than oom_max_wait seconds. oom_max_wait=[%d]", config.Config.Advanced.OOMMaxWait)
+	}
+}
@@ -0,0 +1,29 @@
+package control
+
+import "testing"
+
+func TestOOMPause(t *testing.T) {
+	defer WritePause.Resume()
+
+	OOMPause("a", "OOM")
+	OOMPause("b", "OOM")
+	if !OOMPaused() || !WritePause.IsPaused() {
+		t.Fatal("writing not paused by OOM")
+	}
+	OOMResume("a")
+	if !OOMPaused() || !WritePause.IsPaused() {
+		t.Error("writing resumed while a node is still out of memory")
+	}
+	OOMResume("b")
+	if OOMPaused() || WritePause.IsPaused() {
+		t.Error("writing not resumed once every node recovered")
+	}
+
+	// a pause by hand is kept
+	WritePause.Pause()
+	OOMPause("a", "OOM")
+	OOMResume("a")
+	if OOMPaused() || !WritePause.IsPaused() {
+		t.Error("the pause by hand should be kept after OOM")
+	}
+}
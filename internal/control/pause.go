@@ -0,0 +1,122 @@
+package control
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/log"
+	"net/http"
+	"sync"
+)
+
+// Pauser blocks one side of the sync until resumed.
+type Pauser struct {
+	name    string
+	mu      sync.Mutex
+	resumed chan struct{} // nil when not paused, closed on resume
+}
+
+var (
+	// ReadPause stops reading from the source. In sync mode the source keeps
+	// the stream in its output buffer, so a long pause may exceed
+	// client-output-buffer-limit of the source.
+	ReadPause = &Pauser{name: "read"}
+	// WritePause stops writing to the target. In sync mode the stream from
+	// the source is still saved to aof files.
+	WritePause = &Pauser{name: "write"}
+)
+
+func (p *Pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		return
+	}
+	p.resumed = make(chan struct{})
+	log.Infof("%s paused", p.name)
+}
+
+func (p *Pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		return
+	}
+	close(p.resumed)
+	p.resumed = nil
+	log.Infof("%s resumed", p.name)
+}
+
+func (p *Pauser) IsPaused() bool {
+	return p.Resumed() != nil
+}
+
+// Resumed returns a channel closed on resume, or nil when not paused. A nil
+// channel blocks forever in select, so callers can select on it directly.
+func (p *Pauser) Resumed() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		return nil
+	}
+	return p.resumed
+}
+
+// Wait blocks while paused.
+func (p *Pauser) Wait() {
+	if ch := p.Resumed(); ch != nil {
+		<-ch
+	}
+}
+
+// TogglePause pauses both sides if anything is running, resumes them otherwise.
+func TogglePause() {
+	if ReadPause.IsPaused() && WritePause.IsPaused() {
+		ReadPause.Resume()
+		WritePause.Resume()
+	} else {
+		ReadPause.Pause()
+		WritePause.Pause()
+	}
+}
+
+func pausersOf(side string) []*Pauser {
+	switch side {
+	case "read":
+		return []*Pauser{ReadPause}
+	case "write":
+		return []*Pauser{WritePause}
+	case "", "all":
+		return []*Pauser{ReadPause, WritePause}
+	}
+	return nil
+}
+
+// PauseHandler serves /pause?side=read|write|all, all by default. It is
+// registered with Authorized, like the other mutating requests.
+func PauseHandler(w http.ResponseWriter, r *http.Request) {
+	handlePause(w, r, (*Pauser).Pause)
+}
+
+// ResumeHandler serves /resume?side=read|write|all, all by default. It is
+// registered with Authorized.
+func ResumeHandler(w http.ResponseWriter, r *http.Request) {
+	handlePause(w, r, (*Pauser).Resume)
+}
+
+func handlePause(w http.ResponseWriter, r *http.Request, action func(*Pauser)) {
+	pausers := pausersOf(r.URL.Query().Get("side"))
+	if pausers == nil {
+		http.Error(w, "side must be read/write/all", http.StatusBadRequest)
+		return
+	}
+	for _, p := range pausers {
+		action(p)
+	}
+	w.Header().Add("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]bool{
+		"read_paused":  ReadPause.IsPaused(),
+		"write_paused": WritePause.IsPaused(),
+	})
+	if err != nil {
+		log.Warnf("write pause response failed. err=[%v]", err)
+	}
+}
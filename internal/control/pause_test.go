@@ -0,0 +1,48 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauserWait(t *testing.T) {
+	p := &Pauser{name: "test"}
+	p.Wait() // not paused, returns at once
+
+	p.Pause()
+	p.Pause() // pausing twice keeps the same pause
+	resumed := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(resumed)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Resume()
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("Wait blocked after resume")
+	}
+	if p.IsPaused() || p.Resumed() != nil {
+		t.Error("still paused after resume")
+	}
+}
+
+func TestTogglePause(t *testing.T) {
+	defer ReadPause.Resume()
+	defer WritePause.Resume()
+
+	WritePause.Pause() // one side paused, toggling pauses both
+	TogglePause()
+	if !ReadPause.IsPaused() || !WritePause.IsPaused() {
+		t.Fatalf("toggle did not pause both sides. read=[%v], write=[%v]", ReadPause.IsPaused(), WritePause.IsPaused())
+	}
+	TogglePause()
+	if ReadPause.IsPaused() || WritePause.IsPaused() {
+		t.Errorf("toggle did not resume both sides. read=[%v], write=[%v]", ReadPause.IsPaused(), WritePause.IsPaused())
+	}
+}
@@ -0,0 +1,42 @@
+package control
+
+import (
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var progress struct {
+	once sync.Once
+	last int64 // unix nano, accessed atomically
+}
+
+// SinceProgress returns how long the pipeline has had pending entries or
+// unanswered commands without making progress. It is 0 while idle, paused or
+// waiting for the target to come back, a large value means the pipeline is
+// stalled.
+func SinceProgress() time.Duration {
+	progress.once.Do(func() {
+		atomic.StoreInt64(&progress.last, time.Now().UnixNano())
+		go watchProgress()
+	})
+	return time.Since(time.Unix(0, atomic.LoadInt64(&progress.last)))
+}
+
+func watchProgress() {
+	var lastEntryId, lastApplied, lastUnanswered uint64
+	for range time.Tick(time.Second) {
+		m := statistics.Metrics
+		entryId := atomic.LoadUint64(&m.EntryId)
+		applied := statistics.GetAOFAppliedOffset()
+		unanswered := atomic.LoadUint64(&m.UnansweredBytesCount)
+		idle := atomic.LoadUint64(&m.InQueueEntriesCount) == 0 && unanswered == 0
+		moved := entryId != lastEntryId || applied != lastApplied || unanswered != lastUnanswered
+		outage := statistics.LongestTargetOutage() > 0
+		if idle || moved || outage || WritePause.IsPaused() {
+			atomic.StoreInt64(&progress.last, time.Now().UnixNano())
+		}
+		lastEntryId, lastApplied, lastUnanswered = entryId, applied, unanswered
+	}
+}
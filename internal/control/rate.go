@@ -0,0 +1,60 @@
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces the writes evenly, the rate can be changed at runtime.
+type rateLimiter struct {
+	mu   sync.Mutex
+	ops  int // per second, 0 means unlimited
+	next time.Time
+}
+
+var (
+	// WriteLimit limits the entries written to the target per second, see
+	// rate_limit_ops.
+	WriteLimit = new(rateLimiter)
+	// ReadLimit limits the bytes read from the source per second, see
+	// rate_limit_read_bytes.
+	ReadLimit = new(rateLimiter)
+)
+
+func (l *rateLimiter) SetRate(ops int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops = ops
+	l.next = time.Time{}
+}
+
+func (l *rateLimiter) Rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ops
+}
+
+// Wait blocks until the next write is allowed.
+func (l *rateLimiter) Wait() {
+	l.WaitN(1)
+}
+
+// WaitN blocks until n more are allowed.
+func (l *rateLimiter) WaitN(n int) {
+	l.mu.Lock()
+	if l.ops <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.ops))
+	l.mu.Unlock()
+	// short sleeps are inaccurate, sleep once the writes are 1ms ahead
+	if wait > time.Millisecond {
+		time.Sleep(wait)
+	}
+}
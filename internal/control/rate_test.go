@@ -0,0 +1,33 @@
+package control
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := new(rateLimiter)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		l.Wait() // unlimited
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited writes waited. elapsed=[%v]", elapsed)
+	}
+
+	l.SetRate(100) // 10ms per write
+	start = time.Now()
+	for i := 0; i < 6; i++ {
+		l.Wait()
+	}
+	// the first write is at once, the next 5 are spaced by 10ms
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("writes not spaced. rate=[%d], elapsed=[%v]", l.Rate(), elapsed)
+	}
+	l.WaitN(50)
+	start = time.Now()
+	l.Wait() // waits for the 50 before it
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("WaitN did not reserve its share. elapsed=[%v]", elapsed)
+	}
+}
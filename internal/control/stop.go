@@ -0,0 +1,104 @@
+package control
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"time"
+)
+
+// exit codes of the stop conditions, so that scripts can tell them apart
+// from a normal finish (0) and a panic (2)
+const (
+	ExitCaughtUp     = 10
+	ExitStopAt       = 11
+	ExitStopAtOffset = 12
+	ExitWaitTimeout  = 13 // the last WAIT before exit timed out, see wait_replicas
+	ExitInconsistent = 14 // verify found inconsistent keys
+	ExitCanaryFailed = 15 // canary keys are inconsistent, the full run is not started
+	ExitPreflight    = 16 // the pre-flight report has unsupported findings and --force is not set
+)
+
+// stopCh receives the exit code when the sync should stop
+var stopCh = make(chan int, 1)
+
+// RequestStop stops the sync like SIGTERM, with exit code 0.
+func RequestStop() {
+	select {
+	case stopCh <- 0:
+	default:
+	}
+}
+
+// WatchStopConditions checks the time and lag conditions every second and
+// sends the exit code when one is met.
+func WatchStopConditions() <-chan int {
+	ch := stopCh
+	cfg := &config.Config.Advanced
+	var stopAt time.Time
+	if cfg.StopAt != "" {
+		var err error
+		stopAt, err = time.Parse(time.RFC3339, cfg.StopAt)
+		if err != nil {
+			log.Panicf("invalid stop_at, it should be RFC3339 like 2006-01-02T15:04:05+08:00. stop_at=[%s]", cfg.StopAt)
+		}
+		log.Infof("sync will stop at [%v]", stopAt)
+	}
+	if stopAt.IsZero() && cfg.StopLagSeconds <= 0 {
+		return ch
+	}
+	if cfg.StopLagSeconds > 0 {
+		log.Infof("sync will stop when lag <= [%d] bytes for [%d] seconds", cfg.StopLagBytes, cfg.StopLagSeconds)
+	}
+
+	go func() {
+		var caughtUpSince time.Time
+		for now := range time.Tick(time.Second) {
+			if !stopAt.IsZero() && !now.Before(stopAt) {
+				log.Infof("stop_at reached. stop_at=[%v]", stopAt)
+				ch <- ExitStopAt
+				return
+			}
+			if cfg.StopLagSeconds <= 0 {
+				continue
+			}
+			lag, ok := AOFLag()
+			if !ok || lag > cfg.StopLagBytes {
+				caughtUpSince = time.Time{}
+				continue
+			}
+			if caughtUpSince.IsZero() {
+				caughtUpSince = now
+			}
+			if now.Sub(caughtUpSince) >= time.Duration(cfg.StopLagSeconds)*time.Second {
+				log.Infof("target caught up with source. lag=[%d]bytes, duration=[%v]", lag, now.Sub(caughtUpSince))
+				ch <- ExitCaughtUp
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// AOFLag returns the bytes received but not applied yet, ok is false before
+// the rdb is sent or when the source is not a replication stream.
+func AOFLag() (lag uint64, ok bool) {
+	m := statistics.LoadMetrics()
+	if config.Config.Type != "sync" || m.RdbFileSize == 0 || m.RdbSendSize < m.RdbFileSize {
+		return 0, false
+	}
+	received := m.AofReceivedOffset
+	applied := statistics.GetAOFAppliedOffset()
+	if applied >= received {
+		return 0, true
+	}
+	return received - applied, true
+}
+
+// ReachedStopOffset reports whether the entry is after stop_at_offset and
+// should not be written.
+func ReachedStopOffset(e *entry.Entry) bool {
+	stopOffset := config.Config.Advanced.StopAtOffset
+	return stopOffset > 0 && config.Config.Type == "sync" && !e.IsBase && e.Offset > stopOffset
+}
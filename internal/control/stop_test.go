@@ -0,0 +1,61 @@
+package control
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"testing"
+)
+
+func TestAOFLag(t *testing.T) {
+	defer func(typ string) { config.Config.Type = typ }(config.Config.Type)
+	saved := *statistics.Metrics
+	defer func() { *statistics.Metrics = saved }()
+	defer statistics.ResetAOFAppliedOffset()
+	config.Config.Type = "sync"
+
+	statistics.Metrics.RdbFileSize = 100
+	statistics.Metrics.RdbSendSize = 50
+	if _, ok := AOFLag(); ok {
+		t.Error("lag reported while the rdb is being sent")
+	}
+	statistics.Metrics.RdbSendSize = 100
+	statistics.Metrics.AofReceivedOffset = 1000
+	statistics.ResetAOFAppliedOffset()
+	statistics.UpdateAOFAppliedOffset(400)
+	if lag, ok := AOFLag(); !ok || lag != 600 {
+		t.Errorf("unexpected lag. lag=[%d], ok=[%v]", lag, ok)
+	}
+	statistics.UpdateAOFAppliedOffset(1200) // applied an offset received after the metrics were read
+	if lag, ok := AOFLag(); !ok || lag != 0 {
+		t.Errorf("applied ahead of received is no lag. lag=[%d], ok=[%v]", lag, ok)
+	}
+	config.Config.Type = "scan"
+	if _, ok := AOFLag(); ok {
+		t.Error("lag reported outside of sync mode")
+	}
+}
+
+func TestReachedStopOffset(t *testing.T) {
+	defer func(typ string, offset int64) {
+		config.Config.Type, config.Config.Advanced.StopAtOffset = typ, offset
+	}(config.Config.Type, config.Config.Advanced.StopAtOffset)
+	config.Config.Type = "sync"
+	config.Config.Advanced.StopAtOffset = 100
+
+	cases := []struct {
+		isBase bool
+		offset int64
+		want   bool
+	}{
+		{false, 100, false},
+		{false, 101, true},
+		{true, 101, false}, // entries of the rdb are always written
+	}
+	for _, c := range cases {
+		e := &entry.Entry{IsBase: c.isBase, Offset: c.offset}
+		if got := ReachedStopOffset(e); got != c.want {
+			t.Errorf("unexpected result. is_base=[%v], offset=[%d], got=[%v]", c.isBase, c.offset, got)
+		}
+	}
+}
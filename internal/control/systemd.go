@@ -0,0 +1,80 @@
+package control
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemd notifications, see sd_notify(3). They are sent only when started by
+// systemd with Type=notify, NOTIFY_SOCKET is not set otherwise.
+
+// StartSystemdNotify updates STATUS with the progress message, and pings the
+// watchdog while the pipeline is not stalled, so that systemd restarts a hung
+// redis-shake after WatchdogSec.
+func StartSystemdNotify() {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	var watchdog time.Duration
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil {
+		if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	interval := 5 * time.Second
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+	log.Infof("systemd notify enabled. watchdog=[%v]", watchdog)
+	go func() {
+		for range time.Tick(interval) {
+			since := SinceProgress()
+			msg := statistics.LoadMetrics().Msg
+			if msg == "" {
+				msg = "starting"
+			}
+			state := fmt.Sprintf("STATUS=%s, last_progress=[%s] ago", msg, since.Truncate(time.Second))
+			if watchdog > 0 && since < watchdog {
+				state += "\nWATCHDOG=1"
+			} else if watchdog > 0 {
+				log.Warnf("pipeline stalled, systemd watchdog is not pinged. last_progress=[%v] ago", since)
+			}
+			notifySystemd(state)
+		}
+	}()
+}
+
+// NotifyReady tells systemd that the sync started.
+func NotifyReady() {
+	notifySystemd("READY=1\nSTATUS=started")
+}
+
+// NotifyStopping tells systemd that the sent entries are being drained.
+func NotifyStopping() {
+	notifySystemd("STOPPING=1\nSTATUS=stopping")
+}
+
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // abstract socket
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		log.Warnf("systemd notify failed. socket=[%s], error=[%v]", socket, err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Warnf("systemd notify failed. socket=[%s], error=[%v]", socket, err)
+	}
+}
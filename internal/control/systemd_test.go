@@ -0,0 +1,53 @@
+//go:build !windows
+// +build !windows
+
+package control
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "200000")
+	t.Setenv("WATCHDOG_PID", "")
+	receive := func() string {
+		buf := make([]byte, 1024)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("no notification received. error=[%v]", err)
+		}
+		return string(buf[:n])
+	}
+
+	NotifyReady()
+	if state := receive(); state != "READY=1\nSTATUS=started" {
+		t.Errorf("state=%q, want READY", state)
+	}
+	// the pipeline has not stalled, the watchdog is pinged every 100ms. The
+	// progress may be up to a second old when other tests started it.
+	SinceProgress()
+	atomic.StoreInt64(&progress.last, time.Now().UnixNano())
+	StartSystemdNotify()
+	if state := receive(); !strings.HasPrefix(state, "STATUS=") || !strings.HasSuffix(state, "\nWATCHDOG=1") {
+		t.Errorf("state=%q, want STATUS and WATCHDOG", state)
+	}
+	NotifyStopping()
+	for state := receive(); state != "STOPPING=1\nSTATUS=stopping"; state = receive() {
+		if !strings.HasSuffix(state, "\nWATCHDOG=1") {
+			t.Fatalf("state=%q, want STOPPING", state)
+		}
+	}
+}
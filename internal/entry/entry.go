@@ -5,18 +5,24 @@ import "fmt"
 type Entry struct {
 	Id          uint64
 	IsBase      bool //  whether the command is decoded from dump.rdb file
+	IsProtected bool // value is a HyperLogLog or bitmap and must be written byte for byte
+	IsFlush     bool // FLUSHALL of the target before a new full sync, written without filtering
 	DbId        int
 	Argv        []string
 	TimestampMs uint64
 
-	CmdName string
-	Group   string
-	Keys    []string
-	Slots   []int
+	CmdName    string
+	Group      string
+	Keys       []string
+	KeyIndexes []int // index of each key in Argv
+	Slots      []int
 
 	// for statistics
 	Offset      int64
 	EncodedSize uint64 // the size of the entry after encode
+
+	OnReply func() // called by the writer after the target answered without error, may be nil
+	OnDrop  func() // called when the entry is not applied, such as dropped by the filter or skipped on an error reply, may be nil
 }
 
 func NewEntry() *Entry {
@@ -24,6 +30,17 @@ func NewEntry() *Entry {
 	return e
 }
 
+// Clone returns a copy of e that can be changed without changing e, such as
+// by writers the entry is written to at the same time.
+func (e *Entry) Clone() *Entry {
+	c := *e
+	c.Argv = append([]string(nil), e.Argv...)
+	c.Keys = append([]string(nil), e.Keys...)
+	c.KeyIndexes = append([]int(nil), e.KeyIndexes...)
+	c.Slots = append([]int(nil), e.Slots...)
+	return &c
+}
+
 func (e *Entry) ToString() string {
 	return fmt.Sprintf("%v", e.Argv)
 }
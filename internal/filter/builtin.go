@@ -0,0 +1,361 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtinMiddlewares returns the middlewares configured in the [filter] section.
+func builtinMiddlewares() []link {
+	var middlewares []link
+	cfg := &config.Config.Filter
+	if len(cfg.AllowKeyPatterns) != 0 || len(cfg.BlockKeyPatterns) != 0 {
+		middlewares = append(middlewares, link{name: "key_patterns", m: keyPatternFilter(cfg.AllowKeyPatterns, cfg.BlockKeyPatterns)})
+	}
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		middlewares = append(middlewares, link{name: "sample_ratio", m: keySampler(cfg.SampleRatio)})
+	}
+	if cfg.MaxTTL > 0 {
+		middlewares = append(middlewares, link{name: "max_ttl", m: ttlCapper(cfg.MaxTTL)})
+	}
+	if len(cfg.DbMap) != 0 {
+		middlewares = append(middlewares, link{name: "db_map", m: dbMapper(cfg.DbMap)})
+	}
+	if len(cfg.KeyDbMap) != 0 {
+		middlewares = append(middlewares, link{name: "key_db_map", split: keyDbRouter(cfg.KeyDbMap)})
+	}
+	if len(cfg.RenameKeyPrefix) != 0 {
+		middlewares = append(middlewares, link{name: "rename_key_prefix", m: keyPrefixRenamer(cfg.RenameKeyPrefix)})
+	}
+	if cfg.Namespace != "" {
+		middlewares = append(middlewares, link{name: "namespace", m: namespacePrefixer(cfg.Namespace)})
+	}
+	return middlewares
+}
+
+// keyPatternFilter allows the keys that match allow patterns (when set) and
+// no block pattern. The blocked keys are removed from DEL, UNLINK and MSET,
+// other entries are dropped if any of their keys is blocked. Entries without
+// keys are allowed.
+func keyPatternFilter(allow []string, block []string) Middleware {
+	return func(e *entry.Entry) int {
+		return filterKeys(e, func(key string) bool {
+			return (len(allow) == 0 || utils.MatchAnyPattern(allow, key)) && !utils.MatchAnyPattern(block, key)
+		})
+	}
+}
+
+// splittableKeys are the commands whose keys are written independently, by
+// the number of arguments of each key, so that some of the keys can be
+// removed.
+var splittableKeys = map[string]int{
+	"DEL":    1,
+	"UNLINK": 1,
+	"MSET":   2,
+}
+
+// filterKeys keeps the keys of e that keep returns true for. An entry with
+// kept and removed keys is split if its keys are independent, see
+// splittableKeys, and dropped otherwise. Entries without keys are allowed.
+func filterKeys(e *entry.Entry, keep func(key string) bool) int {
+	if len(e.Keys) == 0 {
+		return Allow
+	}
+	kept := make([]bool, len(e.Keys))
+	count := 0
+	for i, key := range e.Keys {
+		if keep(key) {
+			kept[i] = true
+			count++
+		}
+	}
+	switch count {
+	case len(e.Keys):
+		return Allow
+	case 0:
+		return Disallow
+	}
+	stride, ok := splittableKeys[e.CmdName]
+	if !ok || len(e.KeyIndexes) != len(e.Keys) || e.KeyIndexes[len(e.KeyIndexes)-1]+stride > len(e.Argv) {
+		log.Debugf("command has filtered keys and can not be split, dropped. cmd=[%s], keys=%v", e.CmdName, e.Keys)
+		return Disallow
+	}
+	argv := []string{e.Argv[0]}
+	for i, inx := range e.KeyIndexes {
+		if kept[i] {
+			argv = append(argv, e.Argv[inx:inx+stride]...)
+		}
+	}
+	log.Debugf("command split by the filter. from=%v, to=%v", e.Argv, argv)
+	e.Argv = argv
+	e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(argv)
+	e.Slots = commands.CalcSlots(e.Keys)
+	return Allow
+}
+
+// keySampler allows the keys whose hash falls in ratio, the same keys in the
+// full and incremental phases and every run. Keys with the same hash tag are
+// sampled together. The unsampled keys are removed from DEL, UNLINK and MSET,
+// so that only sampled keys are written. Other entries with sampled and
+// unsampled keys, such as SUNIONSTORE, are dropped: writing them would create
+// unsampled keys, or sampled keys from unsampled ones. Entries without keys
+// are allowed.
+func keySampler(ratio float64) Middleware {
+	threshold := uint32(ratio * 10000)
+	return func(e *entry.Entry) int {
+		return filterKeys(e, func(key string) bool {
+			return crc32.ChecksumIEEE([]byte(commands.HashTag(key)))%10000 < threshold
+		})
+	}
+}
+
+// dbMapper redirects the entries of source db to another target db.
+func dbMapper(dbMap map[string]int) Middleware {
+	mapping := dbMapping(dbMap)
+	return func(e *entry.Entry) int {
+		if to, ok := mapping[e.DbId]; ok {
+			e.DbId = to
+		}
+		return Allow
+	}
+}
+
+func dbMapping(dbMap map[string]int) map[int]int {
+	mapping := make(map[int]int, len(dbMap))
+	for from, to := range dbMap {
+		fromId, err := strconv.Atoi(from)
+		if err != nil {
+			log.Panicf("invalid db id in filter.db_map. db=[%s]", from)
+		}
+		mapping[fromId] = to
+	}
+	return mapping
+}
+
+// keyDbRouter moves the entries whose keys match a pattern to its db,
+// overriding the source db and db_map. The longest matching pattern wins,
+// the keys without a pattern keep the db of the entry. DEL, UNLINK and MSET
+// whose keys go to different dbs are split into one command per db, other
+// commands with keys of different dbs, such as RENAME or EVAL, are dropped.
+func keyDbRouter(keyDbMap map[string]int) splitter {
+	route := keyDbRoute(keyDbMap)
+	return func(e *entry.Entry) ([]*entry.Entry, int) {
+		if len(e.Keys) == 0 {
+			return []*entry.Entry{e}, Allow
+		}
+		dbs := make([]int, len(e.Keys))
+		var order []int // the dbs in the order of their first key
+		seen := make(map[int]bool)
+		for i, key := range e.Keys {
+			dbId, ok := route(key)
+			if !ok {
+				dbId = e.DbId
+			}
+			dbs[i] = dbId
+			if !seen[dbId] {
+				seen[dbId] = true
+				order = append(order, dbId)
+			}
+		}
+		if len(order) == 1 {
+			e.DbId = order[0]
+			return []*entry.Entry{e}, Allow
+		}
+		stride, ok := splittableKeys[e.CmdName]
+		if !ok || len(e.KeyIndexes) != len(e.Keys) || e.KeyIndexes[len(e.KeyIndexes)-1]+stride > len(e.Argv) {
+			log.Warnf("keys of one command are routed to different dbs, dropped. cmd=[%s], keys=%v", e.CmdName, e.Keys)
+			return nil, Disallow
+		}
+		log.Debugf("command split by key_db_map. argv=%v, dbs=%v", e.Argv, order)
+		parts := make([]*entry.Entry, len(order))
+		original, keyIndexes := e.Argv, e.KeyIndexes // e is the first part
+		for i, dbId := range order {
+			argv := []string{original[0]}
+			for j, inx := range keyIndexes {
+				if dbs[j] == dbId {
+					argv = append(argv, original[inx:inx+stride]...)
+				}
+			}
+			part := e
+			if i != 0 {
+				part = new(entry.Entry)
+				*part = *e
+			}
+			part.DbId = dbId
+			part.Argv = argv
+			part.CmdName, part.Group, part.Keys, part.KeyIndexes = commands.CalcKeysWithIndexes(argv)
+			part.Slots = commands.CalcSlots(part.Keys)
+			parts[i] = part
+		}
+		// the hooks of e run once, when the last part is written
+		onReply, onDrop := e.OnReply, e.OnDrop
+		for _, part := range parts {
+			part.OnReply, part.OnDrop = nil, nil
+		}
+		parts[len(parts)-1].OnReply, parts[len(parts)-1].OnDrop = onReply, onDrop
+		return parts, Allow
+	}
+}
+
+// keyDbRoute returns the db of the longest pattern of key_db_map key matches.
+func keyDbRoute(keyDbMap map[string]int) func(key string) (int, bool) {
+	patterns := make([]string, 0, len(keyDbMap))
+	for pattern := range keyDbMap {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	return func(key string) (int, bool) {
+		for _, pattern := range patterns {
+			if utils.MatchAnyPattern([]string{pattern}, key) {
+				return keyDbMap[pattern], true
+			}
+		}
+		return 0, false
+	}
+}
+
+// keyPrefixRenamer replaces the key prefix in place, the longest matched
+// prefix wins. Slots are calculated again for cluster targets.
+func keyPrefixRenamer(prefixes map[string]string) Middleware {
+	return func(e *entry.Entry) int {
+		renamed := false
+		for i, inx := range e.KeyIndexes {
+			key, ok := renameKeyPrefix(prefixes, e.Argv[inx])
+			if !ok {
+				continue
+			}
+			e.Argv[inx] = key
+			e.Keys[i] = key
+			renamed = true
+		}
+		if renamed {
+			e.Slots = commands.CalcSlots(e.Keys)
+		}
+		return Allow
+	}
+}
+
+// renameKeyPrefix replaces the longest prefix of key in prefixes, ok is false
+// if no prefix matches.
+func renameKeyPrefix(prefixes map[string]string, key string) (string, bool) {
+	matched := ""
+	for from := range prefixes {
+		if strings.HasPrefix(key, from) && len(from) > len(matched) {
+			matched = from
+		}
+	}
+	if matched == "" {
+		return key, false
+	}
+	return prefixes[matched] + key[len(matched):], true
+}
+
+// namespacePrefixer prefixes every key with the tenant namespace, so that
+// several sources can be consolidated into one target. FLUSHDB, FLUSHALL and
+// SWAPDB would affect the other tenants and are dropped.
+func namespacePrefixer(namespace string) Middleware {
+	return func(e *entry.Entry) int {
+		switch e.CmdName {
+		case "FLUSHDB", "FLUSHALL", "SWAPDB":
+			log.Warnf("command dropped, it would affect other tenants of the target. cmd=[%s], namespace=[%s]", e.CmdName, namespace)
+			return Disallow
+		}
+		if len(e.KeyIndexes) == 0 {
+			return Allow
+		}
+		for i, inx := range e.KeyIndexes {
+			key := namespace + e.Argv[inx]
+			e.Argv[inx] = key
+			e.Keys[i] = key
+		}
+		e.Slots = commands.CalcSlots(e.Keys)
+		return Allow
+	}
+}
+
+// SourceKey is a key of the source a target key may come from, in one of
+// Dbs of the source.
+type SourceKey struct {
+	Dbs []int
+	Key string
+}
+
+// SourceKeysOf reverses namespace, rename_key_prefix, key_db_map and db_map
+// to find the source keys a target key may come from, and the dbs of
+// sourceDbs each of them may be in. Several keys are candidates if renames
+// collide, such as user:1 renamed to u:1 and a source key named u:1. Several
+// dbs are candidates if db_map maps them to the same db or key_db_map routes
+// the key to dbId whatever its source db. ok is false if the key can not come
+// from the source, such as a key without the namespace or a key key_db_map
+// routes to another db. The result is a candidate only, lua and custom
+// filters are not reversed.
+func SourceKeysOf(dbId int, key string, sourceDbs []int) (keys []SourceKey, ok bool) {
+	cfg := &config.Config.Filter
+	if cfg.Namespace != "" {
+		if !strings.HasPrefix(key, cfg.Namespace) {
+			return nil, false
+		}
+		key = key[len(cfg.Namespace):]
+	}
+	// the key itself and every key renamed to it
+	names := []string{key}
+	for from, to := range cfg.RenameKeyPrefix {
+		if strings.HasPrefix(key, to) {
+			names = append(names, from+key[len(to):])
+		}
+	}
+	sort.Strings(names[1:])
+	for _, name := range names {
+		if renamed, _ := renameKeyPrefix(cfg.RenameKeyPrefix, name); renamed != key {
+			continue
+		}
+		if dbs, ok := sourceDbsOf(dbId, name, sourceDbs); ok {
+			keys = append(keys, SourceKey{Dbs: dbs, Key: name})
+		}
+	}
+	return keys, len(keys) != 0
+}
+
+// sourceDbsOf reverses key_db_map and db_map for a key of the source.
+func sourceDbsOf(dbId int, key string, sourceDbs []int) (dbs []int, ok bool) {
+	cfg := &config.Config.Filter
+	if len(cfg.KeyDbMap) != 0 {
+		if routed, ok := keyDbRoute(cfg.KeyDbMap)(key); ok {
+			if routed != dbId {
+				return nil, false
+			}
+			return sourceDbs, true
+		}
+	}
+	mapping := dbMapping(cfg.DbMap)
+	mapped := func(db int) int {
+		if to, ok := mapping[db]; ok {
+			return to
+		}
+		return db
+	}
+	for _, db := range sourceDbs {
+		if mapped(db) == dbId {
+			dbs = append(dbs, db)
+		}
+	}
+	// the key comes from the source if some db is mapped to dbId, even if
+	// that db has no keys on the source now
+	ok = mapped(dbId) == dbId
+	for from := range mapping {
+		ok = ok || mapped(from) == dbId
+	}
+	return dbs, ok
+}
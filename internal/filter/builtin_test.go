@@ -0,0 +1,291 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newTestEntry(argv ...string) *entry.Entry {
+	e := entry.NewEntry()
+	e.Argv = argv
+	e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(argv)
+	e.Slots = commands.CalcSlots(e.Keys)
+	return e
+}
+
+func TestKeyPatternFilter(t *testing.T) {
+	m := keyPatternFilter([]string{"user:*"}, []string{"user:tmp:*"})
+	if m(newTestEntry("SET", "user:1", "v")) != Allow {
+		t.Errorf("user:1 should be allowed")
+	}
+	if m(newTestEntry("SET", "order:1", "v")) != Disallow {
+		t.Errorf("order:1 should be disallowed")
+	}
+	e := newTestEntry("MSET", "user:1", "v1", "user:tmp:1", "v2", "user:2", "v3")
+	if m(e) != Allow || !reflect.DeepEqual(e.Argv, []string{"MSET", "user:1", "v1", "user:2", "v3"}) ||
+		!reflect.DeepEqual(e.Keys, []string{"user:1", "user:2"}) {
+		t.Errorf("MSET should keep the allowed keys only. argv=%v, keys=%v", e.Argv, e.Keys)
+	}
+	e = newTestEntry("DEL", "order:1", "user:1")
+	if m(e) != Allow || !reflect.DeepEqual(e.Argv, []string{"DEL", "user:1"}) || len(e.Slots) != 1 {
+		t.Errorf("DEL should keep the allowed keys only. argv=%v, slots=%v", e.Argv, e.Slots)
+	}
+	if m(newTestEntry("DEL", "order:1", "user:tmp:1")) != Disallow {
+		t.Errorf("DEL without allowed keys should be disallowed")
+	}
+	if m(newTestEntry("RPOPLPUSH", "user:1", "user:tmp:1")) != Disallow {
+		t.Errorf("RPOPLPUSH can not be split and should be disallowed")
+	}
+	if m(newTestEntry("PING")) != Allow {
+		t.Errorf("entries without keys should be allowed")
+	}
+}
+
+func TestKeySampler(t *testing.T) {
+	m := keySampler(0.05)
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		key := "k" + strconv.Itoa(i)
+		code := m(newTestEntry("SET", key, "v"))
+		if code == Allow {
+			sampled++
+		}
+		if m(newTestEntry("SET", key, "v")) != code {
+			t.Fatalf("sampling is not deterministic. key=[%s]", key)
+		}
+		if m(newTestEntry("SET", "{"+key+"}:other", "v")) != code {
+			t.Fatalf("keys with the same hash tag should be sampled together. key=[%s]", key)
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("about 5%% of the keys should be sampled, got %d", sampled)
+	}
+
+	// keys of multi-key commands are sampled one by one
+	var in, out string
+	for i := 0; in == "" || out == ""; i++ {
+		key := "k" + strconv.Itoa(i)
+		if m(newTestEntry("SET", key, "v")) == Allow {
+			in = key
+		} else {
+			out = key
+		}
+	}
+	e := newTestEntry("MSET", out, "1", in, "2")
+	if m(e) != Allow || !reflect.DeepEqual(e.Argv, []string{"MSET", in, "2"}) {
+		t.Errorf("unsampled key of MSET written. argv=%v", e.Argv)
+	}
+	if m(newTestEntry("SUNIONSTORE", in, out)) != Disallow {
+		t.Errorf("SUNIONSTORE of a sampled key from an unsampled one should be dropped")
+	}
+	if m(newTestEntry("PING")) != Allow {
+		t.Errorf("entries without keys should be allowed")
+	}
+}
+
+func TestKeyPrefixRenamer(t *testing.T) {
+	m := keyPrefixRenamer(map[string]string{"a:": "x:", "a:b:": "y:"})
+	e := newTestEntry("MSET", "a:1", "a:1", "a:b:2", "v", "c:3", "v")
+	m(e)
+	want := []string{"MSET", "x:1", "a:1", "y:2", "v", "c:3", "v"}
+	for i := range want {
+		if e.Argv[i] != want[i] {
+			t.Fatalf("keyPrefixRenamer failed. argv=%v", e.Argv)
+		}
+	}
+	if e.Keys[0] != "x:1" || e.Slots[0] != commands.CalcSlots([]string{"x:1"})[0] {
+		t.Errorf("keys or slots are not updated. keys=%v, slots=%v", e.Keys, e.Slots)
+	}
+}
+
+func TestDbMapper(t *testing.T) {
+	m := dbMapper(map[string]int{"0": 1})
+	e := newTestEntry("SET", "k", "v")
+	m(e)
+	if e.DbId != 1 {
+		t.Errorf("db 0 should be mapped to 1, got %d", e.DbId)
+	}
+	e.DbId = 2
+	m(e)
+	if e.DbId != 2 {
+		t.Errorf("db 2 should not be mapped, got %d", e.DbId)
+	}
+}
+
+func TestKeyDbRouter(t *testing.T) {
+	m := keyDbRouter(map[string]int{"session:*": 1, "session:admin:*": 2})
+	cases := map[string]int{"session:1": 1, "session:admin:1": 2, "user:1": 5}
+	for key, want := range cases {
+		e := newTestEntry("SET", key, "v")
+		e.DbId = 5
+		m(e)
+		if e.DbId != want {
+			t.Errorf("key %s should be routed to db %d, got %d", key, want, e.DbId)
+		}
+	}
+}
+
+func TestKeyDbRouterCrossDb(t *testing.T) {
+	m := keyDbRouter(map[string]int{"session:*": 1})
+
+	e := newTestEntry("MSET", "session:1", "a", "user:1", "b", "session:2", "c")
+	replied := 0
+	e.OnReply = func() { replied++ }
+	parts, code := m(e)
+	if code != Allow || len(parts) != 2 || parts[0] != e {
+		t.Fatalf("MSET should be split in 2 parts, e first. code=[%d], parts=[%d]", code, len(parts))
+	}
+	if want := []string{"MSET", "session:1", "a", "session:2", "c"}; parts[0].DbId != 1 || !reflect.DeepEqual(parts[0].Argv, want) ||
+		!reflect.DeepEqual(parts[0].Keys, []string{"session:1", "session:2"}) {
+		t.Errorf("unexpected first part. db=[%d], argv=%v, keys=%v", parts[0].DbId, parts[0].Argv, parts[0].Keys)
+	}
+	if want := []string{"MSET", "user:1", "b"}; parts[1].DbId != 0 || !reflect.DeepEqual(parts[1].Argv, want) || len(parts[1].Slots) != 1 {
+		t.Errorf("unexpected second part. db=[%d], argv=%v, slots=%v", parts[1].DbId, parts[1].Argv, parts[1].Slots)
+	}
+	if parts[0].OnReply != nil || parts[1].OnReply == nil {
+		t.Fatalf("the hook should run once, after the last part")
+	}
+	parts[1].OnReply()
+	if replied != 1 {
+		t.Errorf("hook of the entry not called. replied=[%d]", replied)
+	}
+
+	parts, code = m(newTestEntry("DEL", "user:1", "session:1"))
+	if code != Allow || len(parts) != 2 || !reflect.DeepEqual(parts[0].Argv, []string{"DEL", "user:1"}) || parts[1].DbId != 1 {
+		t.Errorf("DEL should be split by db. code=[%d], parts=%v", code, parts)
+	}
+
+	for _, argv := range [][]string{
+		{"RENAME", "user:1", "session:1"},
+		{"EVAL", "script", "2", "user:1", "session:1"},
+		{"SUNIONSTORE", "session:1", "user:1"},
+	} {
+		if parts, code := m(newTestEntry(argv...)); code != Disallow || parts != nil {
+			t.Errorf("command with keys of different dbs should be dropped. argv=%v, code=[%d]", argv, code)
+		}
+	}
+	if parts, code := m(newTestEntry("RENAME", "session:1", "session:2")); code != Allow || len(parts) != 1 || parts[0].DbId != 1 {
+		t.Errorf("keys of the same db should be routed together. code=[%d], parts=%v", code, parts)
+	}
+}
+
+func TestFilterEntriesSplit(t *testing.T) {
+	defer func(old []link) { chain = old }(chain)
+	chain = []link{
+		{name: "key_db_map", split: keyDbRouter(map[string]int{"session:*": 1})},
+		{name: "namespace", m: namespacePrefixer("t1:")},
+	}
+	entries, code, _ := FilterEntries(newTestEntry("DEL", "session:1", "user:1"))
+	if code != Allow || len(entries) != 2 {
+		t.Fatalf("DEL should be split. code=[%d], entries=[%d]", code, len(entries))
+	}
+	// the chain after the split runs on every part
+	if entries[0].Argv[1] != "t1:session:1" || entries[1].Argv[1] != "t1:user:1" {
+		t.Errorf("parts not prefixed. first=%v, second=%v", entries[0].Argv, entries[1].Argv)
+	}
+	if code, reason := FilterWithReason(newTestEntry("RENAME", "session:1", "user:1")); code != Disallow || reason != "key_db_map" {
+		t.Errorf("cross db RENAME should be dropped by key_db_map. code=[%d], reason=[%s]", code, reason)
+	}
+}
+
+func TestNamespacePrefixer(t *testing.T) {
+	m := namespacePrefixer("t1:")
+	e := newTestEntry("EVAL", "return 1", "2", "a", "b", "c")
+	if m(e) != Allow || e.Argv[3] != "t1:a" || e.Argv[4] != "t1:b" || e.Argv[5] != "c" {
+		t.Fatalf("namespacePrefixer failed. argv=%v", e.Argv)
+	}
+	if e.Slots[0] != commands.CalcSlots([]string{"t1:a"})[0] {
+		t.Errorf("slots are not updated. slots=%v", e.Slots)
+	}
+	if m(newTestEntry("FLUSHDB")) != Disallow {
+		t.Errorf("FLUSHDB should be dropped")
+	}
+}
+
+func TestSourceKeysOf(t *testing.T) {
+	saved := config.Config.Filter
+	defer func() { config.Config.Filter = saved }()
+	config.Config.Filter.Namespace = "t1:"
+	config.Config.Filter.RenameKeyPrefix = map[string]string{"old:": "new:"}
+	config.Config.Filter.DbMap = map[string]int{"3": 0, "4": 0, "0": 5}
+	config.Config.Filter.KeyDbMap = map[string]int{"session:*": 7}
+	sourceDbs := []int{0, 1, 3, 4}
+
+	keys, ok := SourceKeysOf(0, "t1:new:1", sourceDbs)
+	if want := []SourceKey{{Dbs: []int{3, 4}, Key: "new:1"}, {Dbs: []int{3, 4}, Key: "old:1"}}; !ok || !reflect.DeepEqual(keys, want) {
+		t.Errorf("SourceKeysOf failed. keys=%v, ok=[%v]", keys, ok)
+	}
+	if keys, ok := SourceKeysOf(1, "t1:a", sourceDbs); !ok || len(keys) != 1 || !reflect.DeepEqual(keys[0].Dbs, []int{1}) {
+		t.Errorf("db without db_map failed. keys=%v, ok=[%v]", keys, ok)
+	}
+	if keys, ok := SourceKeysOf(2, "t1:a", sourceDbs); !ok || len(keys) != 1 || len(keys[0].Dbs) != 0 {
+		t.Errorf("db without keys on the source failed. keys=%v, ok=[%v]", keys, ok)
+	}
+	if keys, ok := SourceKeysOf(7, "t1:session:1", sourceDbs); !ok || len(keys) != 1 || !reflect.DeepEqual(keys[0].Dbs, sourceDbs) {
+		t.Errorf("key_db_map should route from every db. keys=%v, ok=[%v]", keys, ok)
+	}
+	if _, ok := SourceKeysOf(3, "t1:session:1", sourceDbs); ok {
+		t.Errorf("keys key_db_map routes to another db should not come from the source")
+	}
+	if _, ok := SourceKeysOf(0, "t2:new:1", sourceDbs); ok {
+		t.Errorf("keys without the namespace should not come from the source")
+	}
+}
+
+func TestSourceKeysOfCollision(t *testing.T) {
+	saved := config.Config.Filter
+	defer func() { config.Config.Filter = saved }()
+	config.Config.Filter.Namespace = ""
+	config.Config.Filter.DbMap = nil
+	config.Config.Filter.KeyDbMap = nil
+	config.Config.Filter.RenameKeyPrefix = map[string]string{"user:": "u:", "a:": "b:", "b:": "c:"}
+	sourceDbs := []int{0}
+
+	// u:1 is user:1 renamed, or a source key already called u:1
+	keys, ok := SourceKeysOf(0, "u:1", sourceDbs)
+	want := []SourceKey{{Dbs: []int{0}, Key: "u:1"}, {Dbs: []int{0}, Key: "user:1"}}
+	if !ok || !reflect.DeepEqual(keys, want) {
+		t.Errorf("the unchanged name is a source key too. keys=%v, ok=[%v]", keys, ok)
+	}
+	// b:1 of the source is renamed to c:1, only a:1 becomes b:1
+	keys, ok = SourceKeysOf(0, "b:1", sourceDbs)
+	if want := []SourceKey{{Dbs: []int{0}, Key: "a:1"}}; !ok || !reflect.DeepEqual(keys, want) {
+		t.Errorf("a renamed name is not a source key of itself. keys=%v, ok=[%v]", keys, ok)
+	}
+}
+
+func TestFilterWithReason(t *testing.T) {
+	defer func(old []link) { chain = old }(chain)
+	chain = []link{{name: "key_patterns", m: keyPatternFilter([]string{"user:*"}, nil)}}
+	if code, reason := FilterWithReason(newTestEntry("SET", "user:1", "v")); code != Allow || reason != "" {
+		t.Errorf("user:1 should be allowed. code=[%d], reason=[%s]", code, reason)
+	}
+	if code, reason := FilterWithReason(newTestEntry("SET", "order:1", "v")); code != Disallow || reason != "key_patterns" {
+		t.Errorf("order:1 should be dropped by key_patterns. code=[%d], reason=[%s]", code, reason)
+	}
+}
+
+func TestFilterProtectedValue(t *testing.T) {
+	defer func(old []link) { chain = old }(chain)
+	chain = []link{
+		{name: "rename", m: keyPrefixRenamer(map[string]string{"hll": "new:hll"})},
+		{name: "custom", m: func(e *entry.Entry) int {
+			// a filter rewriting the value into a longer command
+			e.Argv = []string{"EVAL", "script", "1", e.Argv[1], e.Argv[3]}
+			e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+			return Allow
+		}},
+	}
+	e := newTestEntry("RESTORE", "hll", "0", "payload")
+	e.IsProtected = true
+	if code, _ := FilterWithReason(e); code != Allow {
+		t.Fatalf("protected entry dropped. code=[%d]", code)
+	}
+	if want := []string{"RESTORE", "new:hll", "0", "payload"}; !reflect.DeepEqual(e.Argv, want) || e.Keys[0] != "new:hll" {
+		t.Errorf("protected value not restored with the renamed key. argv=%v, keys=%v", e.Argv, e.Keys)
+	}
+}
@@ -0,0 +1,280 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandSince is the version in which each write command was added, only
+// commands newer than 2.8 (the oldest supported target) are listed.
+// see the "since" field in scripts/commands/*.json
+var commandSince = map[string]float32{
+	"PFADD":                 2.8,
+	"PFMERGE":               2.8,
+	"ZREMRANGEBYLEX":        2.8,
+	"BITFIELD":              3.2,
+	"GEOADD":                3.2,
+	"GEORADIUS":             3.2,
+	"GEORADIUSBYMEMBER":     3.2,
+	"SWAPDB":                4.0,
+	"UNLINK":                4.0,
+	"BZPOPMAX":              5.0,
+	"BZPOPMIN":              5.0,
+	"XACK":                  5.0,
+	"XADD":                  5.0,
+	"XCLAIM":                5.0,
+	"XDEL":                  5.0,
+	"XGROUP-CREATE":         5.0,
+	"XGROUP-DELCONSUMER":    5.0,
+	"XGROUP-DESTROY":        5.0,
+	"XGROUP-SETID":          5.0,
+	"XREADGROUP":            5.0,
+	"XSETID":                5.0,
+	"XTRIM":                 5.0,
+	"ZPOPMAX":               5.0,
+	"ZPOPMIN":               5.0,
+	"BLMOVE":                6.2,
+	"COPY":                  6.2,
+	"GEOSEARCHSTORE":        6.2,
+	"GETDEL":                6.2,
+	"GETEX":                 6.2,
+	"LMOVE":                 6.2,
+	"XAUTOCLAIM":            6.2,
+	"XGROUP-CREATECONSUMER": 6.2,
+	"ZDIFFSTORE":            6.2,
+	"ZRANGESTORE":           6.2,
+	"BLMPOP":                7.0,
+	"BZMPOP":                7.0,
+	"FCALL":                 7.0,
+	"FUNCTION-DELETE":       7.0,
+	"FUNCTION-FLUSH":        7.0,
+	"FUNCTION-LOAD":         7.0,
+	"FUNCTION-RESTORE":      7.0,
+	"LMPOP":                 7.0,
+	"ZMPOP":                 7.0,
+}
+
+// setKeepTTLSince is the version of the SET KEEPTTL option, GET, EXAT and
+// PXAT options are 6.2.
+const (
+	setKeepTTLSince  = 6.0
+	setGetSince      = 6.2
+	setExpireAtSince = 6.2
+)
+
+// copyScript emulates COPY source destination [REPLACE] with DUMP and RESTORE.
+// DB option can not be emulated because scripts are bound to one db.
+const copyScript = `local v = redis.call('dump', KEYS[1])
+if not v then return 0 end
+if ARGV[1] == '1' then redis.call('del', KEYS[2]) elseif redis.call('exists', KEYS[2]) == 1 then return 0 end
+local ttl = redis.call('pttl', KEYS[1])
+if ttl < 0 then ttl = 0 end
+redis.call('restore', KEYS[2], ttl, v)
+return 1`
+
+// setExpireAtScript emulates SET key value [NX|XX] EXAT|PXAT, ARGV[1] is the
+// unix time in milliseconds.
+const setExpireAtScript = `local ret = redis.call('set', KEYS[1], unpack(ARGV, 2))
+if ret then redis.call('pexpireat', KEYS[1], ARGV[1]) end
+return ret`
+
+// setKeepTTLScript emulates SET key value [NX|XX] KEEPTTL.
+const setKeepTTLScript = `local ttl = redis.call('pttl', KEYS[1])
+local ret = redis.call('set', KEYS[1], unpack(ARGV))
+if ret and ttl > 0 then redis.call('pexpire', KEYS[1], ttl) end
+return ret`
+
+type converter func(argv []string) ([]string, bool)
+
+// converters translate commands that the target does not support into
+// commands with the same effect. Replies are dropped by the writer, so only
+// the effect on the data matters.
+var converters = map[string]converter{
+	"GETDEL": func(argv []string) ([]string, bool) {
+		// GET + DEL in MULTI, the GET reply is not used so DEL is enough
+		return []string{"del", argv[1]}, true
+	},
+	"UNLINK": func(argv []string) ([]string, bool) {
+		return append([]string{"del"}, argv[1:]...), true
+	},
+	"LMOVE": func(argv []string) ([]string, bool) {
+		if len(argv) == 5 && strings.EqualFold(argv[3], "right") && strings.EqualFold(argv[4], "left") {
+			return []string{"rpoplpush", argv[1], argv[2]}, true
+		}
+		return nil, false
+	},
+	"GETEX": func(argv []string) ([]string, bool) {
+		// the GET reply is not used, only the expiration matters
+		if len(argv) == 3 && strings.EqualFold(argv[2], "persist") {
+			return []string{"persist", argv[1]}, true
+		}
+		if len(argv) != 4 {
+			return nil, false // no option, nothing is written
+		}
+		n, err := strconv.ParseInt(argv[3], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		switch strings.ToUpper(argv[2]) {
+		case "EX":
+			return []string{"pexpire", argv[1], strconv.FormatInt(n*1000, 10)}, true
+		case "PX":
+			return []string{"pexpire", argv[1], argv[3]}, true
+		case "EXAT":
+			return []string{"pexpireat", argv[1], strconv.FormatInt(n*1000, 10)}, true
+		case "PXAT":
+			return []string{"pexpireat", argv[1], argv[3]}, true
+		}
+		return nil, false
+	},
+	"COPY": func(argv []string) ([]string, bool) {
+		replace := "0"
+		for _, arg := range argv[3:] {
+			if strings.EqualFold(arg, "replace") {
+				replace = "1"
+			} else {
+				return nil, false // DB option
+			}
+		}
+		return []string{"eval", copyScript, "2", argv[1], argv[2], replace}, true
+	},
+}
+
+// convertSet removes options of SET unknown to the target. It returns false
+// if argv does not need to change.
+func convertSet(argv []string, targetVersion float32) ([]string, bool) {
+	keepTTL := false
+	expireAt := ""
+	args := make([]string, 0, len(argv))
+	for i := 0; i < len(argv); i++ {
+		arg := argv[i]
+		switch {
+		case i >= 3 && strings.EqualFold(arg, "keepttl") && targetVersion < setKeepTTLSince:
+			keepTTL = true
+		case i >= 3 && strings.EqualFold(arg, "get") && targetVersion < setGetSince:
+			// reply is not used
+		case i >= 3 && i+1 < len(argv) && (strings.EqualFold(arg, "exat") || strings.EqualFold(arg, "pxat")) && targetVersion < setExpireAtSince:
+			n, err := strconv.ParseInt(argv[i+1], 10, 64)
+			if err != nil {
+				return argv, false // invalid, the target answers the error
+			}
+			if strings.EqualFold(arg, "exat") {
+				n *= 1000
+			}
+			expireAt = strconv.FormatInt(n, 10)
+			i++
+		default:
+			args = append(args, arg)
+		}
+	}
+	if len(args) == len(argv) {
+		return argv, false
+	}
+	if keepTTL {
+		return append([]string{"eval", setKeepTTLScript, "1", args[1]}, args[2:]...), true
+	}
+	if expireAt != "" {
+		return append([]string{"eval", setExpireAtScript, "1", args[1], expireAt}, args[2:]...), true
+	}
+	return args, true
+}
+
+// compatConverter translates commands the target does not support when the
+// target is older than the source, and drops the others with a warning.
+func compatConverter(targetVersion float32) Middleware {
+	return func(e *entry.Entry) int {
+		var argv []string
+		converted := false
+		if e.CmdName == "SET" {
+			argv, converted = convertSet(e.Argv, targetVersion)
+		} else if since, ok := commandSince[e.CmdName]; ok && since > targetVersion {
+			if convert, ok := converters[e.CmdName]; ok {
+				argv, converted = convert(e.Argv)
+			}
+			if !converted {
+				log.Warnf("command is not supported by target, dropped. target_version=[%v], since=[%v], argv=%v",
+					targetVersion, since, e.Argv)
+				return Disallow
+			}
+		}
+		if converted {
+			log.Debugf("command converted for target. from=%v, to=%v", e.Argv, argv)
+			e.Argv = argv
+			e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+			e.Slots = commands.CalcSlots(e.Keys)
+		}
+		return Allow
+	}
+}
+
+// capabilityConverter converts the commands the probed target does not
+// implement. The entries that still can not be written, and the entries of
+// other dbs than 0 if the target has only one db, stop the sync unless
+// drop_unsupported is set. The target is probed after Init, so the profile is
+// read for every entry.
+func capabilityConverter(e *entry.Entry) int {
+	target := capability.Target
+	if !target.Select && e.DbId != 0 {
+		if !config.Config.Target.DropUnsupported {
+			log.Panicf("target has only one db, use db_map to move db %d to db 0 or set drop_unsupported to drop its entries. argv=%v", e.DbId, e.Argv)
+		}
+		log.Warnf("target has only one db, entry dropped. db=[%d], argv=%v", e.DbId, e.Argv)
+		return Disallow
+	}
+	if target.Supports(e.CmdName) {
+		return Allow
+	}
+	if convert, ok := converters[e.CmdName]; ok {
+		if argv, converted := convert(e.Argv); converted {
+			cmdName, group, keys, keyIndexes := commands.CalcKeysWithIndexes(argv)
+			if target.Supports(cmdName) {
+				log.Debugf("command converted for target. from=%v, to=%v", e.Argv, argv)
+				e.Argv, e.CmdName, e.Group, e.Keys, e.KeyIndexes = argv, cmdName, group, keys, keyIndexes
+				e.Slots = commands.CalcSlots(e.Keys)
+				return Allow
+			}
+		}
+	}
+	if !config.Config.Target.DropUnsupported {
+		log.Panicf("command is not supported by target, set drop_unsupported to drop it. server=[%s], argv=%v", target.Server, e.Argv)
+	}
+	log.Warnf("command is not supported by target, dropped. server=[%s], argv=%v", target.Server, e.Argv)
+	return Disallow
+}
+
+// NewerCommands returns the write commands added after targetVersion until
+// sourceVersion, split by whether they are converted for the target, in some
+// or all of their forms, or dropped.
+func NewerCommands(sourceVersion float32, targetVersion float32) (converted []string, dropped []string) {
+	for name, since := range commandSince {
+		if since <= targetVersion || since > sourceVersion {
+			continue
+		}
+		if _, ok := converters[name]; ok {
+			converted = append(converted, name)
+		} else {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(converted)
+	sort.Strings(dropped)
+	return converted, dropped
+}
+
+// compatMiddlewares returns the converter when the target is older than the
+// source, and the converter for the probed capabilities of the target.
+func compatMiddlewares() []link {
+	middlewares := []link{{name: "capability", m: capabilityConverter}}
+	if config.Config.Target.Version >= config.Config.Source.Version {
+		return middlewares
+	}
+	log.Infof("target is older than source, unsupported commands will be converted or dropped. source_version=[%v], target_version=[%v]",
+		config.Config.Source.Version, config.Config.Target.Version)
+	return append([]link{{name: "target_version", m: compatConverter(config.Config.Target.Version)}}, middlewares...)
+}
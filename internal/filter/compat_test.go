@@ -0,0 +1,98 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestCompatConverter(t *testing.T) {
+	m := compatConverter(5.0)
+
+	e := newTestEntry("GETDEL", "k")
+	if m(e) != Allow || strings.Join(e.Argv, " ") != "del k" || e.Keys[0] != "k" {
+		t.Errorf("GETDEL conversion failed. argv=%v", e.Argv)
+	}
+
+	e = newTestEntry("SET", "k", "v", "XX", "KEEPTTL", "GET")
+	if m(e) != Allow || e.CmdName != "EVAL" || len(e.Keys) != 1 || e.Keys[0] != "k" ||
+		strings.Join(e.Argv[2:], " ") != "1 k v XX" {
+		t.Errorf("SET KEEPTTL conversion failed. argv=%v", e.Argv)
+	}
+
+	e = newTestEntry("SET", "k", "v", "EX", "10")
+	if m(e) != Allow || len(e.Argv) != 5 {
+		t.Errorf("SET without new options should not change. argv=%v", e.Argv)
+	}
+
+	e = newTestEntry("SET", "k", "v", "NX", "EXAT", "1700000000")
+	if m(e) != Allow || e.CmdName != "EVAL" || e.Keys[0] != "k" ||
+		strings.Join(e.Argv[2:], " ") != "1 k 1700000000000 v NX" {
+		t.Errorf("SET EXAT conversion failed. argv=%v", e.Argv)
+	}
+	e = newTestEntry("SET", "k", "v", "PXAT", "1700000000123")
+	if m(e) != Allow || strings.Join(e.Argv[2:], " ") != "1 k 1700000000123 v" {
+		t.Errorf("SET PXAT conversion failed. argv=%v", e.Argv)
+	}
+
+	getex := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"GETEX", "k", "EX", "10"}, "pexpire k 10000"},
+		{[]string{"GETEX", "k", "px", "1500"}, "pexpire k 1500"},
+		{[]string{"GETEX", "k", "EXAT", "1700000000"}, "pexpireat k 1700000000000"},
+		{[]string{"GETEX", "k", "PXAT", "1700000000123"}, "pexpireat k 1700000000123"},
+		{[]string{"GETEX", "k", "PERSIST"}, "persist k"},
+	}
+	for _, c := range getex {
+		e = newTestEntry(c.argv...)
+		if m(e) != Allow || strings.Join(e.Argv, " ") != c.want || e.Keys[0] != "k" {
+			t.Errorf("GETEX conversion failed. from=%v, to=%v, want=[%s]", c.argv, e.Argv, c.want)
+		}
+	}
+	if m(newTestEntry("GETEX", "k")) != Disallow {
+		t.Errorf("GETEX without option writes nothing and should be dropped")
+	}
+
+	e = newTestEntry("COPY", "a", "b", "REPLACE")
+	if m(e) != Allow || e.CmdName != "EVAL" || strings.Join(e.Keys, " ") != "a b" || e.Argv[5] != "1" {
+		t.Errorf("COPY conversion failed. argv=%v", e.Argv)
+	}
+
+	if m(newTestEntry("COPY", "a", "b", "DB", "1")) != Disallow {
+		t.Errorf("COPY with DB option should be dropped")
+	}
+	if m(newTestEntry("ZMPOP", "1", "z", "MIN")) != Disallow {
+		t.Errorf("ZMPOP should be dropped")
+	}
+	if m(newTestEntry("XADD", "s", "*", "f", "v")) != Allow {
+		t.Errorf("XADD is supported by 5.0")
+	}
+}
+
+func TestCapabilityConverterDropUnsupported(t *testing.T) {
+	defer func(p *capability.Profile) { capability.Target = p }(capability.Target)
+	capability.Target = &capability.Profile{Server: "kvrocks"} // only one db
+
+	e := newTestEntry("SET", "k", "v")
+	if capabilityConverter(e) != Allow {
+		t.Error("entry of db 0 dropped")
+	}
+	e.DbId = 1
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("entry of db 1 dropped without drop_unsupported")
+			}
+		}()
+		capabilityConverter(e)
+	}()
+
+	config.Config.Target.DropUnsupported = true
+	defer func() { config.Config.Target.DropUnsupported = false }()
+	if capabilityConverter(e) != Disallow {
+		t.Error("entry of db 1 not dropped with drop_unsupported")
+	}
+}
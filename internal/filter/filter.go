@@ -1,8 +1,10 @@
 package filter
 
 import (
+	"github.com/alibaba/RedisShake/internal/commands"
 	"github.com/alibaba/RedisShake/internal/entry"
-	lua "github.com/yuin/gopher-lua"
+	"github.com/alibaba/RedisShake/internal/log"
+	"strings"
 )
 
 const (
@@ -11,45 +13,138 @@ const (
 	Error    = 2
 )
 
-var luaInstance *lua.LState
+// Middleware inspects or transforms an entry and returns Allow, Disallow or Error.
+// Entries from rdb, scan and the incremental stream all go through the same chain,
+// so the key list in Keys/KeyIndexes must be calculated before.
+type Middleware func(e *entry.Entry) int
 
-func LoadFromFile(luaFile string) {
-	luaInstance = lua.NewState()
-	err := luaInstance.DoFile(luaFile)
-	if err != nil {
-		panic(err)
-	}
+// splitter is a middleware that may split an entry into several entries,
+// written one after the other. It returns the entries, e first, and Allow,
+// or nil and the code of e.
+type splitter func(e *entry.Entry) ([]*entry.Entry, int)
+
+// link is a middleware of the chain, name is the reason of the entries it
+// drops. Links that may split entries set split instead of m.
+type link struct {
+	name  string
+	m     Middleware
+	split splitter
 }
 
-func Filter(e *entry.Entry) int {
-	if luaInstance == nil {
-		return Allow
+var chain []link
+
+// custom middlewares added by Use
+var custom []Middleware
+
+// Use adds a middleware after the lua filter, it must be called before Init.
+func Use(m Middleware) {
+	custom = append(custom, m)
+}
+
+// Init builds the middleware chain from config. The lua filter and the
+// custom middlewares see the source keys and db, the renaming runs after them
+// and the command conversion for older targets runs last.
+func Init() {
+	chain = nil
+	if luaInstance != nil {
+		chain = append(chain, link{name: "lua", m: luaFilter})
 	}
-	keys := luaInstance.NewTable()
-	for _, key := range e.Keys {
-		keys.Append(lua.LString(key))
+	for _, m := range custom {
+		chain = append(chain, link{name: "custom", m: m})
 	}
+	chain = append(chain, builtinMiddlewares()...)
+	chain = append(chain, compatMiddlewares()...)
+	log.Infof("filter middleware chain initialized. count=[%d]", len(chain))
+}
+
+func Filter(e *entry.Entry) int {
+	code, _ := FilterWithReason(e)
+	return code
+}
+
+// FilterWithReason also returns the name of the middleware that did not
+// allow e, such as lua, key_patterns or capability. The entries split from e
+// are not returned, see FilterEntries.
+func FilterWithReason(e *entry.Entry) (int, string) {
+	_, code, reason := FilterEntries(e)
+	return code, reason
+}
 
-	slots := luaInstance.NewTable()
-	for _, slot := range e.Slots {
-		slots.Append(lua.LNumber(slot))
+// FilterEntries runs the chain like FilterWithReason and returns the entries
+// to write: e, followed by the entries split from it, such as the parts of a
+// DEL whose keys key_db_map routes to different dbs. The parts go through
+// the rest of the chain one by one, e is dropped with its parts if one of
+// them is not allowed.
+func FilterEntries(e *entry.Entry) ([]*entry.Entry, int, string) {
+	var protectedArgv []string
+	var protectedKeyIndexes []int
+	if e.IsProtected {
+		protectedArgv = append(protectedArgv, e.Argv...)
+		protectedKeyIndexes = append(protectedKeyIndexes, e.KeyIndexes...)
 	}
 
-	f := luaInstance.GetGlobal("filter")
-	luaInstance.Push(f)
-	luaInstance.Push(lua.LNumber(e.Id))          // id
-	luaInstance.Push(lua.LBool(e.IsBase))        // is_base
-	luaInstance.Push(lua.LString(e.Group))       // group
-	luaInstance.Push(lua.LString(e.CmdName))     // cmd name
-	luaInstance.Push(keys)                       // keys
-	luaInstance.Push(slots)                      // slots
-	luaInstance.Push(lua.LNumber(e.DbId))        // dbid
-	luaInstance.Push(lua.LNumber(e.TimestampMs)) // timestamp_ms
+	entries := []*entry.Entry{e}
+	code := Allow
+	reason := ""
+chain:
+	for _, l := range chain {
+		var next []*entry.Entry
+		for _, part := range entries {
+			var parts []*entry.Entry
+			if l.split != nil {
+				parts, code = l.split(part)
+			} else {
+				parts, code = []*entry.Entry{part}, l.m(part)
+			}
+			if code != Allow {
+				reason = l.name
+				entries = nil
+				break chain
+			}
+			next = append(next, parts...)
+		}
+		entries = next
+	}
 
-	luaInstance.Call(8, 2)
+	// HyperLogLog and bitmap values must not be transformed by filters, keys may
+	// be renamed and the expiration of RESTORE changed, such as by max_ttl
+	if e.IsProtected && len(e.Argv) > 2 && len(protectedArgv) > 2 &&
+		strings.EqualFold(e.Argv[0], "restore") && strings.EqualFold(protectedArgv[0], "restore") {
+		protectedArgv[2] = e.Argv[2]
+	}
+	if e.IsProtected && !equalValues(protectedArgv, protectedKeyIndexes, e.Argv, e.KeyIndexes) {
+		log.Warnf("filter tried to modify a protected value, modification ignored. key=%v", e.Keys)
+		// the renamed keys are kept if the keys are still in the same order,
+		// the indexes of the original argv may differ from the new ones
+		if len(e.KeyIndexes) == len(protectedKeyIndexes) {
+			for i, inx := range protectedKeyIndexes {
+				protectedArgv[inx] = e.Argv[e.KeyIndexes[i]]
+			}
+		}
+		e.Argv = protectedArgv
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		e.Slots = commands.CalcSlots(e.Keys)
+	}
+	return entries, code, reason
+}
 
-	code := int(luaInstance.Get(1).(lua.LNumber))
-	e.DbId = int(luaInstance.Get(2).(lua.LNumber))
-	luaInstance.Pop(2)
-	return code
+// equalValues compares argv a and b except keys, the keys must be at the
+// same indexes.
+func equalValues(a []string, aKeyIndexes []int, b []string, bKeyIndexes []int) bool {
+	if len(a) != len(b) || len(aKeyIndexes) != len(bKeyIndexes) {
+		return false
+	}
+	isKey := make(map[int]bool, len(aKeyIndexes))
+	for i, inx := range aKeyIndexes {
+		if bKeyIndexes[i] != inx {
+			return false
+		}
+		isKey[inx] = true
+	}
+	for i := range a {
+		if !isKey[i] && a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
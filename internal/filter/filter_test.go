@@ -0,0 +1,55 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"strings"
+	"testing"
+)
+
+// TestInitSameForRDBAndStream checks that a key gets the same db, name and
+// expiration whether it comes from the rdb or from the incremental stream.
+func TestInitSameForRDBAndStream(t *testing.T) {
+	defer func(old []link) { chain = old }(chain)
+	saved := config.Config.Filter
+	defer func() { config.Config.Filter = saved }()
+	config.Config.Filter.AllowKeyPatterns = []string{"user:*"}
+	config.Config.Filter.DbMap = map[string]int{"0": 2}
+	config.Config.Filter.RenameKeyPrefix = map[string]string{"user:": "u:"}
+	config.Config.Filter.Namespace = "t1:"
+	config.Config.Filter.MaxTTL = 60
+	Init()
+
+	// as the readers produce them, keys are calculated by the sync loop
+	run := func(isBase bool, argv ...string) (e *entry.Entry, code int, reason string) {
+		e = entry.NewEntry()
+		e.IsBase = isBase
+		e.Argv = argv
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		e.Slots = commands.CalcSlots(e.Keys)
+		code, reason = FilterWithReason(e)
+		return e, code, reason
+	}
+
+	cases := []struct {
+		isBase bool
+		argv   []string
+		want   string
+	}{
+		{true, []string{"RESTORE", "user:1", "3600000", "payload"}, "RESTORE t1:u:1 60000 payload"},
+		{true, []string{"PEXPIREAT", "user:2", "1"}, "PEXPIREAT t1:u:2 1"}, // rewritten big key, already expired
+		{false, []string{"SET", "user:1", "v", "PX", "3600000"}, "SET t1:u:1 v PX 60000"},
+		{false, []string{"MSET", "user:1", "a", "order:1", "b"}, "MSET t1:u:1 a"},
+		{false, []string{"DEL", "order:1", "user:2"}, "DEL t1:u:2"},
+	}
+	for _, c := range cases {
+		e, code, _ := run(c.isBase, c.argv...)
+		if got := strings.Join(e.Argv, " "); code != Allow || e.DbId != 2 || got != c.want {
+			t.Errorf("unexpected entry. from=%v, code=[%d], db=[%d], got=[%s], want=[%s]", c.argv, code, e.DbId, got, c.want)
+		}
+	}
+	if _, code, reason := run(false, "SET", "order:1", "v"); code != Disallow || reason != "key_patterns" {
+		t.Errorf("order:1 should be dropped by key_patterns. code=[%d], reason=[%s]", code, reason)
+	}
+}
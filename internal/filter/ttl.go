@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"github.com/alibaba/RedisShake/internal/entry"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expireArgs are the commands that set the expiration by one argument at a
+// fixed index, in seconds or milliseconds, relative or as a unix time.
+var expireArgs = map[string]struct {
+	index    int
+	ms       bool
+	absolute bool
+}{
+	"EXPIRE":    {2, false, false},
+	"PEXPIRE":   {2, true, false},
+	"EXPIREAT":  {2, false, true},
+	"PEXPIREAT": {2, true, true},
+	"SETEX":     {2, false, false},
+	"PSETEX":    {2, true, false},
+}
+
+// ttlCapper limits the expiration an entry sets to maxTTL seconds from now.
+// The rdb, restored by RESTORE or rewritten with PEXPIREAT, and the
+// incremental stream are capped alike. Keys without expiration are not
+// changed.
+func ttlCapper(maxTTL int64) Middleware {
+	return func(e *entry.Entry) int {
+		now := time.Now().UnixMilli()
+		capArg := func(inx int, ms bool, absolute bool) {
+			n, err := strconv.ParseInt(e.Argv[inx], 10, 64)
+			if err != nil {
+				return // the target answers the error
+			}
+			limit := maxTTL
+			if ms {
+				limit *= 1000
+			}
+			if absolute && ms {
+				limit += now
+			} else if absolute {
+				limit += now / 1000
+			}
+			if n > limit {
+				e.Argv[inx] = strconv.FormatInt(limit, 10)
+			}
+		}
+		if arg, ok := expireArgs[e.CmdName]; ok && len(e.Argv) > arg.index {
+			capArg(arg.index, arg.ms, arg.absolute)
+			return Allow
+		}
+		switch e.CmdName {
+		case "RESTORE":
+			// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] ..., 0 means no expiration
+			if len(e.Argv) < 4 || e.Argv[2] == "0" {
+				return Allow
+			}
+			absolute := false
+			for _, arg := range e.Argv[4:] {
+				absolute = absolute || strings.EqualFold(arg, "absttl")
+			}
+			capArg(2, true, absolute)
+		case "SET", "GETEX":
+			first := 3 // SET key value [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp] ...
+			if e.CmdName == "GETEX" {
+				first = 2
+			}
+			for i := first; i+1 < len(e.Argv); i++ {
+				switch strings.ToUpper(e.Argv[i]) {
+				case "EX":
+					capArg(i+1, false, false)
+				case "PX":
+					capArg(i+1, true, false)
+				case "EXAT":
+					capArg(i+1, false, true)
+				case "PXAT":
+					capArg(i+1, true, true)
+				default:
+					continue
+				}
+				i++
+			}
+		}
+		return Allow
+	}
+}
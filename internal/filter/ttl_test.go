@@ -0,0 +1,66 @@
+package filter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLCapper(t *testing.T) {
+	m := ttlCapper(60)
+	nowMs := time.Now().UnixMilli()
+	far := strconv.FormatInt(nowMs+3600*1000, 10)
+
+	cases := []struct {
+		argv  []string
+		index int   // of the expiration in argv
+		want  int64 // 0 means unchanged
+		abs   bool  // want is relative to now, in the unit of the argument
+	}{
+		{[]string{"EXPIRE", "k", "3600"}, 2, 60, false},
+		{[]string{"EXPIRE", "k", "10"}, 2, 0, false},
+		{[]string{"PSETEX", "k", "3600000", "v"}, 2, 60000, false},
+		{[]string{"PEXPIREAT", "k", far}, 2, 60000, true},
+		{[]string{"EXPIREAT", "k", strconv.FormatInt(nowMs/1000+3600, 10)}, 2, 60, true},
+		{[]string{"SET", "k", "v", "NX", "EX", "3600"}, 5, 60, false},
+		{[]string{"SET", "k", "ex", "PX", "1000"}, 4, 0, false}, // value "ex" is not an option
+		{[]string{"GETEX", "k", "PXAT", far}, 3, 60000, true},
+		{[]string{"RESTORE", "k", "3600000", "payload"}, 2, 60000, false},
+		{[]string{"RESTORE", "k", far, "payload", "ABSTTL"}, 2, 60000, true},
+		{[]string{"RESTORE", "k", "0", "payload"}, 2, 0, false},
+	}
+	for _, c := range cases {
+		before := c.argv[c.index]
+		e := newTestEntry(append([]string(nil), c.argv...)...)
+		if m(e) != Allow {
+			t.Fatalf("entry dropped. argv=%v", c.argv)
+		}
+		got, _ := strconv.ParseInt(e.Argv[c.index], 10, 64)
+		switch {
+		case c.want == 0 && e.Argv[c.index] != before:
+			t.Errorf("expiration changed. argv=%v, got=%v", c.argv, e.Argv)
+		case c.want != 0 && !c.abs && got != c.want:
+			t.Errorf("expiration not capped. argv=%v, got=%v", c.argv, e.Argv)
+		case c.want != 0 && c.abs:
+			// the unix time is capped to now + max_ttl, a second of slack for the clock
+			base, slack := nowMs, int64(1000)
+			if c.want == 60 {
+				base, slack = nowMs/1000, 1
+			}
+			if got < base+c.want || got > base+c.want+slack {
+				t.Errorf("unix time not capped. argv=%v, got=%v", c.argv, e.Argv)
+			}
+		}
+	}
+}
+
+func TestTTLCapperProtected(t *testing.T) {
+	defer func(old []link) { chain = old }(chain)
+	chain = []link{{name: "max_ttl", m: ttlCapper(60)}}
+	e := newTestEntry("RESTORE", "hll", "3600000", "payload")
+	e.IsProtected = true
+	FilterWithReason(e)
+	if e.Argv[2] != "60000" || e.Argv[3] != "payload" {
+		t.Errorf("expiration of a protected value not capped. argv=%v", e.Argv)
+	}
+}
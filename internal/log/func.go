@@ -20,6 +20,7 @@ func Infof(format string, args ...interface{}) {
 }
 
 func Warnf(format string, args ...interface{}) {
+	addRecent("WRN", format, args...)
 	logger.Warn().Msgf(format, args...)
 }
 
@@ -29,6 +30,7 @@ func Panicf(format string, args ...interface{}) {
 	stack = strings.ReplaceAll(stack, "\n", "  [")
 	logger.Info().Msg(stack)
 
+	addRecent("PNC", format, args...)
 	logger.Panic().Msgf(format, args...)
 }
 
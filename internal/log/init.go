@@ -12,15 +12,8 @@ var logger zerolog.Logger
 func Init() {
 
 	// log level
-	switch config.Config.Advanced.LogLevel {
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	default:
-		panic(fmt.Sprintf("unknown log level: %s", config.Config.Advanced.LogLevel))
+	if err := SetLevel(config.Config.Advanced.LogLevel); err != nil {
+		panic(err.Error())
 	}
 
 	// log file
@@ -32,3 +25,23 @@ func Init() {
 	multi := zerolog.MultiLevelWriter(consoleWriter, fileWriter)
 	logger = zerolog.New(multi).With().Timestamp().Logger()
 }
+
+// SetLevel changes the log level, level is debug, info or warn.
+func SetLevel(level string) error {
+	switch level {
+	case "debug":
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	case "info":
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	case "warn":
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	default:
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	return nil
+}
+
+// Level returns the current log level.
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
@@ -0,0 +1,39 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const recentWarningsSize = 20
+
+// recent keeps the last warnings and panics for the diagnostic dump.
+var recent struct {
+	mu       sync.Mutex
+	messages [recentWarningsSize]string
+	count    int
+}
+
+func addRecent(level string, format string, args ...interface{}) {
+	msg := fmt.Sprintf("%s %s %s", time.Now().Format("2006-01-02 15:04:05"), level, fmt.Sprintf(format, args...))
+	recent.mu.Lock()
+	recent.messages[recent.count%recentWarningsSize] = msg
+	recent.count++
+	recent.mu.Unlock()
+}
+
+// RecentWarnings returns the last warnings and panics, the oldest first.
+func RecentWarnings() []string {
+	recent.mu.Lock()
+	defer recent.mu.Unlock()
+	n := recent.count
+	if n > recentWarningsSize {
+		n = recentWarningsSize
+	}
+	messages := make([]string, 0, n)
+	for i := recent.count - n; i < recent.count; i++ {
+		messages = append(messages, recent.messages[i%recentWarningsSize])
+	}
+	return messages
+}
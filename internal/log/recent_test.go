@@ -0,0 +1,22 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecentWarnings(t *testing.T) {
+	for i := 0; i < recentWarningsSize+5; i++ {
+		Warnf("warning %d", i)
+	}
+	warnings := RecentWarnings()
+	if len(warnings) != recentWarningsSize {
+		t.Fatalf("warnings=[%d], want the last %d", len(warnings), recentWarningsSize)
+	}
+	for i, warning := range warnings {
+		if want := " WRN warning " + strconv.Itoa(i+5); !strings.HasSuffix(warning, want) {
+			t.Errorf("warning=[%s], want the oldest first, ending with [%s]", warning, want)
+		}
+	}
+}
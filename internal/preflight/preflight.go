@@ -0,0 +1,262 @@
+package preflight
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of a finding, only Unsupported needs --force.
+const (
+	Info        = "info"        // handled, such as values rewritten into commands
+	Warning     = "warning"     // data is dropped or may differ
+	Unsupported = "unsupported" // can not be written to the target
+)
+
+type Finding struct {
+	Severity string
+	Message  string
+}
+
+// Report compares the source and the target before the sync starts.
+type Report struct {
+	Source   string
+	Target   string
+	Findings []Finding
+}
+
+func (r *Report) add(severity string, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{severity, fmt.Sprintf(format, args...)})
+}
+
+// Blocking reports whether a finding needs --force to proceed, warnings are
+// only logged.
+func (r *Report) Blocking() bool {
+	for _, f := range r.Findings {
+		if f.Severity == Unsupported {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "pre-flight report\n  source: %s\n  target: %s\n", r.Source, r.Target)
+	if len(r.Findings) == 0 {
+		sb.WriteString("  no incompatibility found\n")
+	}
+	for _, f := range r.Findings {
+		fmt.Fprintf(&sb, "  [%s] %s\n", f.Severity, f.Message)
+	}
+	return sb.String()
+}
+
+// server is what the report needs to know about one side.
+type server struct {
+	name      string  // redis or the server name of the target profile
+	version   string  // redis_version of INFO server, empty if unknown
+	major     float32 // major.minor of version, or the version in the config file
+	modules   []string
+	dbIds     []int
+	usedBytes uint64
+}
+
+// Check connects to the source and the target and compares them. The
+// capabilities of the target are read from capability.Target, so Check runs
+// after the writer is created. For restore, only the config file describes
+// the source.
+func Check() *Report {
+	cfg := &config.Config
+	r := &Report{}
+
+	source := &server{name: "redis", major: cfg.Source.Version}
+	if cfg.Type == "sync" || cfg.Type == "scan" {
+		source = inspect(cfg.Source.Address, cfg.Source.Username, cfg.Source.Password, cfg.Source.IsTLS, "redis", cfg.Source.Version)
+		r.Source = fmt.Sprintf("%s, redis_version=%s, modules=%v, dbs=%v, used_memory=%d",
+			cfg.Source.Address, source.version, source.modules, source.dbIds, source.usedBytes)
+		checkVersion(r, "source", cfg.Source.Version, source)
+	} else {
+		r.Source = fmt.Sprintf("%s, version=%v (config)", cfg.Source.RDBFilePath, cfg.Source.Version)
+	}
+	target := inspect(cfg.Target.Address, cfg.Target.Username, cfg.Target.Password, cfg.Target.IsTLS, capability.Target.Server, cfg.Target.Version)
+	r.Target = fmt.Sprintf("%s, server=%s, redis_version=%s, modules=%v, maxmemory=%d",
+		cfg.Target.Address, target.name, target.version, target.modules, capability.Target.MaxMemory)
+	checkVersion(r, "target", cfg.Target.Version, target)
+
+	checkCommands(r, source, target)
+	checkCapabilities(r, source)
+	checkModules(r, source, target)
+	checkMemory(r, source)
+	return r
+}
+
+func checkVersion(r *Report, side string, configured float32, s *server) {
+	if s.version == "" || s.name != "redis" {
+		return
+	}
+	if configured != s.major {
+		r.add(Warning, "%s.version is %v but the %s is redis %s, commands are converted by %s.version",
+			side, configured, side, s.version, side)
+	}
+}
+
+func checkCommands(r *Report, source *server, target *server) {
+	if target.major >= source.major {
+		return
+	}
+	r.add(Info, "target is older than source, source=[%v], target=[%v]", source.major, target.major)
+	converted, dropped := filter.NewerCommands(source.major, target.major)
+	if len(converted) > 0 {
+		r.add(Info, "commands converted for the target where possible, dropped otherwise: %s", strings.Join(converted, " "))
+	}
+	if len(dropped) > 0 {
+		r.add(Warning, "commands dropped, the target does not support them: %s", strings.Join(dropped, " "))
+	}
+	if source.major >= 5.0 && target.major < 5.0 {
+		r.add(Unsupported, "streams can not be written to redis older than 5.0, stream keys are dropped")
+	}
+	if source.major >= 7.0 && target.major < 7.0 {
+		r.add(Warning, "functions can not be written to redis older than 7.0, FUNCTION LOAD and FCALL are dropped")
+	}
+	if capability.Target.CanRestore() {
+		r.add(Info, "DUMP payloads of a newer rdb version are rejected by the target, values are rewritten into commands")
+	}
+}
+
+// checkCapabilities reports what the probed profile of the target lacks, see
+// probe_capabilities.
+func checkCapabilities(r *Report, source *server) {
+	target := capability.Target
+	if !target.CanRestore() {
+		r.add(Info, "target can not RESTORE, values are rewritten into commands")
+	}
+	if !target.Select {
+		var dropped []int
+		for _, dbId := range source.dbIds {
+			if targetDb, ok := config.Config.Filter.DbMap[strconv.Itoa(dbId)]; ok {
+				dbId = targetDb
+			}
+			if dbId != 0 {
+				dropped = append(dropped, dbId)
+			}
+		}
+		if len(dropped) > 0 && config.Config.Target.DropUnsupported {
+			r.add(Warning, "target has only one db, entries of target dbs %v are dropped, use db_map to move them to db 0", dropped)
+		} else if len(dropped) > 0 {
+			r.add(Unsupported, "target has only one db, entries of target dbs %v stop the sync, use db_map to move them to db 0 or set drop_unsupported", dropped)
+		}
+	}
+	if target.Server == "redis" {
+		return // commands of newer versions are checked by version
+	}
+	var unsupported []string
+	for _, name := range commands.Names() {
+		if !target.Supports(name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 && config.Config.Target.DropUnsupported {
+		r.add(Warning, "target does not implement these commands, they are converted where possible and dropped otherwise: %s",
+			strings.Join(unsupported, " "))
+	} else if len(unsupported) > 0 {
+		r.add(Warning, "target does not implement these commands, they are converted where possible and stop the sync otherwise, unless drop_unsupported is set: %s",
+			strings.Join(unsupported, " "))
+	}
+}
+
+func checkModules(r *Report, source *server, target *server) {
+	if len(source.modules) == 0 {
+		return
+	}
+	if !capability.Target.CanRestore() {
+		r.add(Unsupported, "module values can not be rewritten into commands, they are dropped. modules=%v", source.modules)
+		return
+	}
+	loaded := make(map[string]bool)
+	for _, name := range target.modules {
+		loaded[name] = true
+	}
+	for _, name := range source.modules {
+		if !loaded[name] {
+			r.add(Unsupported, "module %s is not loaded on the target, its values can not be restored", name)
+		}
+	}
+}
+
+func checkMemory(r *Report, source *server) {
+	maxMemory := capability.Target.MaxMemory
+	if maxMemory > 0 && source.usedBytes > maxMemory {
+		r.add(Warning, "used_memory of source is larger than maxmemory of target, source=[%d], target=[%d]", source.usedBytes, maxMemory)
+	}
+}
+
+var (
+	versionRegexp    = regexp.MustCompile(`(?m)^redis_version:(\d+)\.(\d+)\S*`)
+	usedMemoryRegexp = regexp.MustCompile(`(?m)^used_memory:(\d+)`)
+	keyspaceRegexp   = regexp.MustCompile(`(?m)^db(\d+):`)
+)
+
+// inspect reads INFO and MODULE LIST, which may be disabled, the fields are
+// left empty then and the version is the configured one.
+func inspect(address string, username string, password string, isTls bool, name string, configured float32) *server {
+	c := client.NewRedisClient(address, username, password, isTls)
+	defer c.Close()
+	s := &server{name: name, major: configured}
+	if info, err := client.String(c.Do("INFO", "server")); err == nil {
+		if match := versionRegexp.FindStringSubmatch(info); match != nil {
+			s.version = strings.TrimPrefix(match[0], "redis_version:")
+			// other servers report the redis version they are compatible with
+			if name == "redis" {
+				major, _ := strconv.ParseFloat(match[1]+"."+match[2], 32)
+				s.major = float32(major)
+			}
+		}
+	}
+	if info, err := client.String(c.Do("INFO", "memory")); err == nil {
+		if match := usedMemoryRegexp.FindStringSubmatch(info); match != nil {
+			s.usedBytes, _ = strconv.ParseUint(match[1], 10, 64)
+		}
+	}
+	if info, err := client.String(c.Do("INFO", "keyspace")); err == nil {
+		for _, match := range keyspaceRegexp.FindAllStringSubmatch(info, -1) {
+			dbId, _ := strconv.Atoi(match[1])
+			s.dbIds = append(s.dbIds, dbId)
+		}
+		sort.Ints(s.dbIds)
+	}
+	if reply, err := c.Do("MODULE", "LIST"); err == nil {
+		s.modules = moduleNames(reply)
+	}
+	return s
+}
+
+// moduleNames reads the name field of every module in the MODULE LIST reply,
+// an array of field-value arrays or maps.
+func moduleNames(reply interface{}) []string {
+	modules, _ := reply.([]interface{})
+	var names []string
+	for _, module := range modules {
+		switch fields := module.(type) {
+		case []interface{}:
+			for i := 0; i+1 < len(fields); i += 2 {
+				if key, _ := fields[i].(string); key == "name" {
+					name, _ := fields[i+1].(string)
+					names = append(names, name)
+				}
+			}
+		case map[interface{}]interface{}:
+			name, _ := fields["name"].(string)
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,36 @@
+package preflight
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModuleNames(t *testing.T) {
+	resp2 := []interface{}{
+		[]interface{}{"name", "search", "ver", int64(20603)},
+		[]interface{}{"name", "ReJSON", "ver", int64(20007)},
+	}
+	if names := moduleNames(resp2); !reflect.DeepEqual(names, []string{"ReJSON", "search"}) {
+		t.Errorf("unexpected module names. names=%v", names)
+	}
+	resp3 := []interface{}{map[interface{}]interface{}{"name": "bf", "ver": int64(20406)}}
+	if names := moduleNames(resp3); !reflect.DeepEqual(names, []string{"bf"}) {
+		t.Errorf("unexpected module names. names=%v", names)
+	}
+	if names := moduleNames(nil); len(names) != 0 {
+		t.Errorf("no module expected. names=%v", names)
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	r := &Report{}
+	r.add(Info, "values are rewritten into commands")
+	r.add(Warning, "commands dropped")
+	if r.Blocking() {
+		t.Error("warnings block the sync")
+	}
+	r.add(Unsupported, "module values are dropped")
+	if !r.Blocking() {
+		t.Error("unsupported findings do not block the sync")
+	}
+}
@@ -0,0 +1,183 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb/types"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checksumReader calculates the crc64 of the bytes read and counts them.
+type checksumReader struct {
+	rd     io.Reader
+	crc    uint64
+	offset int64
+}
+
+func newChecksumReader(rd io.Reader) *checksumReader {
+	return &checksumReader{rd: rd}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.crc = utils.UpdateCRC64(r.crc, p[:n])
+	r.offset += int64(n)
+	return n, err
+}
+
+// verifyChecksum reads the 8 bytes checksum after EOF. Zero checksum means
+// the rdb is saved with rdbchecksum no. A missing or wrong checksum fails the
+// load, the keys sent may be corrupted, and is only reported by Check.
+func (ld *Loader) verifyChecksum(rd io.Reader) {
+	var expected uint64
+	if err := binary.Read(rd, binary.LittleEndian, &expected); err != nil {
+		ld.corrupted("checksum missing after EOF. err=[%v]", err)
+		return
+	}
+	if expected == 0 {
+		log.Infof("RDB checksum is disabled")
+		if ld.report != nil {
+			ld.report.Checksum = "disabled"
+		}
+		return
+	}
+	if expected != ld.rd.crc {
+		ld.corrupted("checksum mismatch. expected=[%x], calculated=[%x]", expected, ld.rd.crc)
+		return
+	}
+	if ld.report != nil {
+		ld.report.Checksum = "ok"
+	}
+}
+
+// corrupted is reported by Check as an anomaly, the load fails otherwise.
+func (ld *Loader) corrupted(format string, args ...interface{}) {
+	if ld.report != nil {
+		ld.anomaly(format, args...)
+		return
+	}
+	log.Panicf("RDB corrupted: %s, file_path=[%s]", fmt.Sprintf(format, args...), ld.filPath)
+}
+
+// anomaly is reported by Check and logged as warning otherwise.
+func (ld *Loader) anomaly(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Warnf("RDB anomaly: %s", msg)
+	if ld.report != nil {
+		ld.report.Anomalies = append(ld.report.Anomalies, msg)
+	}
+}
+
+type CheckReport struct {
+	Version     int
+	Aux         map[string]string
+	Keys        map[string]uint64 // by type
+	DbKeys      map[int]uint64
+	ExpiredKeys uint64
+	Checksum    string // ok, disabled or empty if not checked
+	Anomalies   []string
+}
+
+func (r *CheckReport) addKey(dbId int, key string, o types.RedisObject, expireAt int64) {
+	typ := objectTypeName(o)
+	r.Keys[typ]++
+	r.DbKeys[dbId]++
+	if expireAt != 0 && expireAt < time.Now().UnixMilli() {
+		r.ExpiredKeys++
+	}
+	if so, ok := o.(*types.SetObject); ok && so.Duplicates() > 0 {
+		r.Anomalies = append(r.Anomalies, fmt.Sprintf("set contains duplicate members. db=[%d], key=[%s], duplicates=[%d]", dbId, key, so.Duplicates()))
+	}
+}
+
+func (r *CheckReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "rdb version: %d\n", r.Version)
+	auxKeys := make([]string, 0, len(r.Aux))
+	for key := range r.Aux {
+		auxKeys = append(auxKeys, key)
+	}
+	sort.Strings(auxKeys)
+	for _, key := range auxKeys {
+		fmt.Fprintf(&sb, "aux %s: %s\n", key, r.Aux[key])
+	}
+	typeNames := make([]string, 0, len(r.Keys))
+	for typ := range r.Keys {
+		typeNames = append(typeNames, typ)
+	}
+	sort.Strings(typeNames)
+	for _, typ := range typeNames {
+		fmt.Fprintf(&sb, "keys %s: %d\n", typ, r.Keys[typ])
+	}
+	dbIds := make([]int, 0, len(r.DbKeys))
+	for dbId := range r.DbKeys {
+		dbIds = append(dbIds, dbId)
+	}
+	sort.Ints(dbIds)
+	for _, dbId := range dbIds {
+		fmt.Fprintf(&sb, "db%d keys: %d\n", dbId, r.DbKeys[dbId])
+	}
+	fmt.Fprintf(&sb, "expired keys: %d\n", r.ExpiredKeys)
+	checksum := r.Checksum
+	if checksum == "" {
+		checksum = "not checked"
+	}
+	fmt.Fprintf(&sb, "checksum: %s\n", checksum)
+	fmt.Fprintf(&sb, "anomalies: %d\n", len(r.Anomalies))
+	for _, anomaly := range r.Anomalies {
+		fmt.Fprintf(&sb, "  %s\n", anomaly)
+	}
+	return sb.String()
+}
+
+func objectTypeName(o types.RedisObject) string {
+	switch o.(type) {
+	case *types.StringObject:
+		return types.StringType
+	case *types.ListObject:
+		return types.ListType
+	case *types.SetObject:
+		return types.SetType
+	case *types.ZsetObject:
+		return types.ZSetType
+	case *types.HashObject:
+		return types.HashType
+	case *types.StreamObject:
+		return "stream"
+	case *types.ModuleObject:
+		return "module"
+	}
+	return fmt.Sprintf("%T", o)
+}
+
+// Check parses the whole rdb file without sending anything and reports the
+// keys by type and the anomalies found. A parse error is reported as an
+// anomaly with the offset it happened at.
+func Check(path string) (report *CheckReport) {
+	report = &CheckReport{
+		Aux:    make(map[string]string),
+		Keys:   make(map[string]uint64),
+		DbKeys: make(map[int]uint64),
+	}
+	ld := NewLoader(path, nil)
+	ld.report = report
+	for {
+		_, err := ld.Next()
+		if err == io.EOF {
+			return report
+		}
+		if err != nil {
+			offset := int64(0)
+			if ld.rd != nil {
+				offset = ld.rd.offset
+			}
+			report.Anomalies = append(report.Anomalies, fmt.Sprintf("parse failed at offset %d: %v", offset, err))
+			return report
+		}
+	}
+}
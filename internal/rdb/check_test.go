@@ -0,0 +1,79 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	path := writeTestRDB(t)
+	report := Check(path)
+	if report.Keys["string"] != 1 || report.Keys["set"] != 1 || report.DbKeys[0] != 2 {
+		t.Errorf("unexpected key counts. keys=%v, db_keys=%v", report.Keys, report.DbKeys)
+	}
+	if report.Checksum != "disabled" || len(report.Anomalies) != 0 {
+		t.Errorf("unexpected report: %s", report)
+	}
+
+	// write the real checksum
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := buf[:len(buf)-8]
+	binary.LittleEndian.PutUint64(buf[len(buf)-8:], utils.CalcCRC64(body))
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report = Check(path); report.Checksum != "ok" {
+		t.Errorf("checksum should be ok: %s", report)
+	}
+
+	// corrupt one byte of the value
+	buf[len(buf)-20] ^= 0xff
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report = Check(path); len(report.Anomalies) == 0 {
+		t.Errorf("corruption should be reported: %s", report)
+	}
+
+	// truncated
+	if err := ioutil.WriteFile(path, buf[:len(buf)-12], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report = Check(path); len(report.Anomalies) == 0 {
+		t.Errorf("truncation should be reported: %s", report)
+	}
+}
+
+func TestLoadChecksumMismatch(t *testing.T) {
+	path := writeTestRDB(t)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint64(buf[len(buf)-8:], utils.CalcCRC64(buf[:len(buf)-8])+1)
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the load of sync and restore fails, check only reports it
+	ld := NewLoader(path, nil)
+	for {
+		_, err = ld.Next()
+		if err != nil {
+			break
+		}
+	}
+	if err == io.EOF || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("load of a corrupted rdb should fail. err=[%v]", err)
+	}
+	if report := Check(path); len(report.Anomalies) != 1 || !strings.Contains(report.Anomalies[0], "checksum mismatch") {
+		t.Errorf("check should report the mismatch: %s", report)
+	}
+}
@@ -0,0 +1,673 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtures are generated by scripts/gen_rdb_fixtures.py, the committed ones
+// by its --encode mode. The golden files catch changes of the output, the
+// values are checked by TestRDBFixtureValues.
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// loadEntries parses the rdb file and returns the entries in readable form.
+// When rewrite is true, every value goes through the rewrite path.
+func loadEntries(t *testing.T, path string, rewrite bool) []string {
+	maxBulkLen := config.Config.Advanced.TargetRedisProtoMaxBulkLen
+	defer func() { config.Config.Advanced.TargetRedisProtoMaxBulkLen = maxBulkLen }()
+	if rewrite {
+		config.Config.Advanced.TargetRedisProtoMaxBulkLen = 0
+	}
+
+	ch := make(chan *entry.Entry, 1024)
+	go func() {
+		NewLoader(path, ch).ParseRDB()
+		close(ch)
+	}()
+
+	var lines []string
+	for e := range ch {
+		lines = append(lines, formatEntry(e))
+	}
+	// hash fields are not ordered
+	sort.Strings(lines)
+	return lines
+}
+
+func formatEntry(e *entry.Entry) string {
+	argv := make([]string, len(e.Argv))
+	for i, arg := range e.Argv {
+		argv[i] = strconv.Quote(arg)
+	}
+	// relative ttl of RESTORE depends on the current time
+	if strings.EqualFold(e.Argv[0], "restore") && e.Argv[2] != "0" {
+		argv[2] = "<ttl>"
+	}
+	return fmt.Sprintf("db=%d %s", e.DbId, strings.Join(argv, " "))
+}
+
+func TestRDBFixtures(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no rdb fixtures, generate them with scripts/gen_rdb_fixtures.py")
+	}
+	for _, file := range files {
+		if report := Check(file); report.Checksum != "ok" || len(report.Anomalies) != 0 {
+			t.Errorf("check of %s failed. checksum=[%s], anomalies=%v", file, report.Checksum, report.Anomalies)
+		}
+		for _, mode := range []string{"restore", "rewrite"} {
+			lines := loadEntries(t, file, mode == "rewrite")
+			got := strings.Join(lines, "\n") + "\n"
+			golden := strings.TrimSuffix(file, ".rdb") + "." + mode + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden file failed, run with -update to create it. err=[%v]", err)
+			}
+			if got != string(want) {
+				t.Errorf("%s output of %s does not match %s", mode, file, golden)
+			}
+		}
+	}
+}
+
+// dumpedKey is a key of a rdb file in the form of the commands rewriting it.
+type dumpedKey struct {
+	cmds     [][]string
+	expireAt string
+}
+
+// values returns the arguments after the key of all commands, in order.
+func (k *dumpedKey) values() []string {
+	var values []string
+	for _, cmd := range k.cmds {
+		values = append(values, cmd[2:]...)
+	}
+	return values
+}
+
+// pairs returns the field-value pairs of a hash, or the member-score pairs
+// of a zset when scoreFirst is set.
+func (k *dumpedKey) pairs(scoreFirst bool) map[string]string {
+	values := k.values()
+	pairs := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		if scoreFirst {
+			pairs[values[i+1]] = values[i]
+		} else {
+			pairs[values[i]] = values[i+1]
+		}
+	}
+	return pairs
+}
+
+// loadDump rewrites every key of the rdb file into commands and groups them
+// by "<db> <key>".
+func loadDump(t *testing.T, path string) map[string]*dumpedKey {
+	maxBulkLen := config.Config.Advanced.TargetRedisProtoMaxBulkLen
+	defer func() { config.Config.Advanced.TargetRedisProtoMaxBulkLen = maxBulkLen }()
+	config.Config.Advanced.TargetRedisProtoMaxBulkLen = 0
+
+	ch := make(chan *entry.Entry, 1024)
+	go func() {
+		NewLoader(path, ch).ParseRDB()
+		close(ch)
+	}()
+	keys := make(map[string]*dumpedKey)
+	for e := range ch {
+		name := fmt.Sprintf("%d %s", e.DbId, e.Argv[1])
+		if strings.EqualFold(e.Argv[0], "xgroup") || strings.EqualFold(e.Argv[0], "xclaim") {
+			name = fmt.Sprintf("%d %s", e.DbId, e.Argv[2])
+		}
+		k, ok := keys[name]
+		if !ok {
+			k = &dumpedKey{}
+			keys[name] = k
+		}
+		if strings.EqualFold(e.Argv[0], "pexpireat") {
+			k.expireAt = e.Argv[2]
+			continue
+		}
+		k.cmds = append(k.cmds, e.Argv)
+	}
+	return keys
+}
+
+// dumpChecker compares the keys of a rdb file with the values written into
+// redis before it was saved. The expected values are written by hand, they
+// never come from the loader.
+type dumpChecker struct {
+	t    *testing.T
+	file string
+	keys map[string]*dumpedKey
+}
+
+func (c *dumpChecker) key(db int, key string) *dumpedKey {
+	k, ok := c.keys[fmt.Sprintf("%d %s", db, key)]
+	if !ok {
+		c.t.Errorf("%s: key missing. db=[%d], key=[%.64s]", c.file, db, key)
+		return &dumpedKey{}
+	}
+	return k
+}
+
+func (c *dumpChecker) list(db int, key string, want ...string) {
+	if got := c.key(db, key).values(); strings.Join(got, ",") != strings.Join(want, ",") {
+		c.t.Errorf("%s: unexpected list. key=[%s], got=%v, want=%v", c.file, key, got, want)
+	}
+}
+
+func (c *dumpChecker) set(db int, key string, want ...string) {
+	got := c.key(db, key).values()
+	sort.Strings(got)
+	sorted := append([]string(nil), want...)
+	sort.Strings(sorted)
+	if strings.Join(got, ",") != strings.Join(sorted, ",") {
+		c.t.Errorf("%s: unexpected set. key=[%s], got=%v, want=%v", c.file, key, got, sorted)
+	}
+}
+
+func (c *dumpChecker) str(db int, key string, want string) {
+	if got := c.key(db, key).values(); len(got) != 1 || got[0] != want {
+		c.t.Errorf("%s: unexpected string. key=[%s], got=%.64q, want=%.64q", c.file, key, got, want)
+	}
+}
+
+func (c *dumpChecker) hash(db int, key string, want map[string]string) {
+	got := c.key(db, key).pairs(false)
+	for field, value := range want {
+		if got[field] != value {
+			c.t.Errorf("%s: unexpected hash field. key=[%s], field=[%s], got=[%.64s], want=[%.64s]", c.file, key, field, got[field], value)
+		}
+	}
+}
+
+func (c *dumpChecker) zset(db int, key string, want map[string]float64) {
+	got := c.key(db, key).pairs(true)
+	for member, score := range want {
+		if s, err := strconv.ParseFloat(got[member], 64); err != nil || s != score {
+			c.t.Errorf("%s: unexpected score. key=[%s], member=[%s], got=[%s], want=[%v]", c.file, key, member, got[member], score)
+		}
+	}
+}
+
+func (c *dumpChecker) size(db int, key string, want int, pairs bool) {
+	got := len(c.key(db, key).values())
+	if pairs {
+		got /= 2
+	}
+	if got != want {
+		c.t.Errorf("%s: unexpected size. key=[%s], got=[%d], want=[%d]", c.file, key, got, want)
+	}
+}
+
+func (c *dumpChecker) expireAt(db int, key string, want string) {
+	if got := c.key(db, key).expireAt; got != want {
+		c.t.Errorf("%s: unexpected expire. key=[%s], got=[%s], want=[%s]", c.file, key, got, want)
+	}
+}
+
+func (c *dumpChecker) count(want int) {
+	if len(c.keys) != want {
+		c.t.Errorf("%s: unexpected number of keys. got=[%d], want=[%d]", c.file, len(c.keys), want)
+	}
+}
+
+// TestRedisDumps loads rdb files saved by redis-server, see testdata/redis.
+func TestRedisDumps(t *testing.T) {
+	cases := map[string]func(c *dumpChecker){
+		"empty_database": func(c *dumpChecker) { c.count(0) },
+		"multiple_databases": func(c *dumpChecker) {
+			c.count(2)
+			c.str(0, "key_in_zeroth_database", "zero")
+			c.str(2, "key_in_second_database", "second")
+		},
+		"integer_keys": func(c *dumpChecker) {
+			c.count(6)
+			c.str(0, "125", "Positive 8 bit integer")
+			c.str(0, "43947", "Positive 16 bit integer")
+			c.str(0, "183358245", "Positive 32 bit integer")
+			c.str(0, "-123", "Negative 8 bit integer")
+			c.str(0, "-29477", "Negative 16 bit integer")
+			c.str(0, "-183358245", "Negative 32 bit integer")
+		},
+		"easily_compressible_string_key": func(c *dumpChecker) {
+			c.str(0, strings.Repeat("a", 200), "Key that redis should compress easily")
+		},
+		"keys_with_expiry": func(c *dumpChecker) {
+			c.str(0, "expires_ms_precision", "2022-12-25 10:11:12.573 UTC")
+			c.expireAt(0, "expires_ms_precision", "1671963072573")
+		},
+		"keys_with_mixed_expiry": func(c *dumpChecker) {
+			c.count(4)
+			for _, key := range []string{"key01", "key04"} {
+				c.str(0, key, "this does expire")
+				if c.key(0, key).expireAt == "" {
+					t.Errorf("expire of %s missing", key)
+				}
+			}
+			for _, key := range []string{"key02", "key03"} {
+				c.str(0, key, "this does not expire")
+				c.expireAt(0, key, "")
+			}
+		},
+		"rdb_version_5_with_checksum": func(c *dumpChecker) {
+			c.str(0, "abcd", "efgh")
+			c.str(0, "foo", "bar")
+			c.str(0, "bar", "baz")
+			c.str(0, "abcdef", "abcdef")
+			c.str(0, "longerstring", "thisisalongerstring.idontknowwhatitmeans")
+		},
+		"linkedlist": func(c *dumpChecker) {
+			c.size(0, "force_linkedlist", 1000, false)
+			members := make(map[string]bool)
+			for _, v := range c.key(0, "force_linkedlist").values() {
+				members[v] = true
+			}
+			for _, v := range []string{"JYY4GIFI0ETHKP4VAJF5333082J4R1UPNPLE329YT0EYPGHSJQ", "TKBXHJOX9Q99ICF4V78XTCA2Y1UYW6ERL35JCIL1O0KSGXS58S"} {
+				if !members[v] {
+					t.Errorf("element of force_linkedlist missing. element=[%s]", v)
+				}
+			}
+		},
+		"ziplist_that_compresses_easily": func(c *dumpChecker) {
+			c.list(0, "ziplist_compresses_easily", "aaaaaa", strings.Repeat("a", 12), strings.Repeat("a", 18),
+				strings.Repeat("a", 24), strings.Repeat("a", 30), strings.Repeat("a", 36))
+		},
+		"ziplist_that_doesnt_compress": func(c *dumpChecker) {
+			c.list(0, "ziplist_doesnt_compress", "aj2410", "cc953a17a8e096e76a44169ad3f9ac87c5f8248a403274416179aa9fbd852344")
+		},
+		"ziplist_with_integers": func(c *dumpChecker) {
+			c.list(0, "ziplist_with_integers", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12",
+				"-2", "13", "25", "-61", "63", "16380", "-16000", "65535", "-65523", "4194304", "9223372036854775807")
+		},
+		"rdb_v7_list_quicklist": func(c *dumpChecker) {
+			c.list(0, "foo", "bar", "baz", "boo")
+		},
+		"intset_16": func(c *dumpChecker) {
+			c.set(0, "intset_16", "32764", "32765", "32766")
+		},
+		"intset_32": func(c *dumpChecker) {
+			c.set(0, "intset_32", "2147418108", "2147418109", "2147418110")
+		},
+		"intset_64": func(c *dumpChecker) {
+			c.set(0, "intset_64", "9223090557583032316", "9223090557583032317", "9223090557583032318")
+		},
+		"regular_set": func(c *dumpChecker) {
+			c.set(0, "regular_set", "alpha", "beta", "gamma", "delta", "phi", "kappa")
+		},
+		"sorted_set_as_ziplist": func(c *dumpChecker) {
+			c.size(0, "sorted_set_as_ziplist", 3, true)
+			c.zset(0, "sorted_set_as_ziplist", map[string]float64{
+				"8b6ba6718a786daefa69438148361901": 1,
+				"cb7a24bb7528f934b841b34c3a73e0c7": 2.37,
+				"523af537946b79c4f8369ed39ba78605": 3.423,
+			})
+		},
+		"regular_sorted_set": func(c *dumpChecker) {
+			c.size(0, "force_sorted_set", 500, true)
+			c.zset(0, "force_sorted_set", map[string]float64{
+				"G72TWVWH0DY782VG0H8VVAR8RNO7BS9QGOHTZFJU67X7L0Z3PR": 3.19,
+				"N8HKPIK4RC4I2CXVV90LQCWODW1DZYD0DA26R8V5QP7UR511M8": 0.76,
+			})
+		},
+		"hash_as_ziplist": func(c *dumpChecker) {
+			c.size(0, "zipmap_compresses_easily", 3, true)
+			c.hash(0, "zipmap_compresses_easily", map[string]string{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"})
+		},
+		"dictionary": func(c *dumpChecker) {
+			c.size(0, "force_dictionary", 1000, true)
+			c.hash(0, "force_dictionary", map[string]string{
+				"ZMU5WEJDG7KU89AOG5LJT6K7HMNB3DEI43M6EYTJ83VRJ6XNXQ": "T63SOS8DQJF0Q0VJEZ0D1IQFCYTIPSBOUIAI9SB0OV57MQR1FI",
+				"UHS5ESW4HLK8XOGTM39IK1SJEUGVV9WOPK6JYA5QBZSJU84491": "6VULTCV52FXJ8MGVSFTZVAGK2JXZMGQ5F8OVJI0X6GEDDR27RZ",
+			})
+		},
+	}
+	files, err := filepath.Glob("testdata/redis/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(cases) {
+		t.Errorf("every dump needs its expected values. dumps=[%d], cases=[%d]", len(files), len(cases))
+	}
+	for _, file := range files {
+		check, ok := cases[strings.TrimSuffix(filepath.Base(file), ".rdb")]
+		if !ok {
+			t.Errorf("no expected values for %s", file)
+			continue
+		}
+		if report := Check(file); len(report.Anomalies) != 0 {
+			t.Errorf("check of %s failed. anomalies=%v", file, report.Anomalies)
+		}
+		c := &dumpChecker{t: t, file: file, keys: loadDump(t, file)}
+		check(c)
+
+		// every key is restored as well
+		restored := make(map[string]bool)
+		for _, line := range loadEntries(t, file, false) {
+			restored[line] = true
+		}
+		if len(restored) != len(c.keys) {
+			t.Errorf("%s: keys restored differ from the keys rewritten. restored=[%d], rewritten=[%d]", file, len(restored), len(c.keys))
+		}
+	}
+}
+
+// TestRDBFixtureValues compares the fixtures of scripts/gen_rdb_fixtures.py
+// with the commands of the script, written here by hand.
+func TestRDBFixtureValues(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".rdb")
+		version := name[strings.Index(name, "-")+1:]
+		atLeast := func(v string) bool {
+			a, _ := strconv.ParseFloat(version, 64)
+			b, _ := strconv.ParseFloat(v, 64)
+			return a >= b
+		}
+		byRedis := strings.HasPrefix(name, "redis-")
+		c := &dumpChecker{t: t, file: file, keys: loadDump(t, file)}
+
+		c.str(0, "string_int", "12345")
+		c.str(0, "string_neg_int", "-2147483649")
+		c.str(0, "string_short", "hello")
+		c.str(0, "string_long", strings.Repeat("x", 100))
+		c.str(0, "string_lzf", strings.Repeat("abcdefgh", 64))
+		c.str(0, "string_expire", "v")
+		c.expireAt(0, "string_expire", "4102444800000")
+		bitmap := make([]byte, 126)
+		bitmap[125] = 0x80 // bit 1000, redis counts the bits from the most significant one
+		c.str(0, "bitmap", string(bitmap))
+
+		c.list(0, "list_small", "a", "1", "b", "2")
+		var elements []string
+		for i := 0; i < 1000; i++ {
+			elements = append(elements, fmt.Sprintf("element_%d", i))
+		}
+		c.list(0, "list_big", elements...)
+		c.list(0, "list_large_element", strings.Repeat("y", 10000))
+
+		c.set(0, "set_int16", "1", "2", "3")
+		c.set(0, "set_int32", "1", "70000")
+		c.set(0, "set_int64", "1", "5000000000", "-5000000000")
+		c.set(0, "set_small", "a", "b", "c")
+		c.size(0, "set_big", 1000, false)
+		c.set(0, "set_big", strings.Split(strings.Replace(strings.Join(elements, ","), "element_", "member_", -1), ",")...)
+
+		c.size(0, "zset_small", 3, true)
+		c.zset(0, "zset_small", map[string]float64{"a": 1, "b": 2.5, "c": -3})
+		c.size(0, "zset_big", 1000, true)
+		c.zset(0, "zset_big", map[string]float64{"member_0": 0, "member_1": 1.5, "member_999": 1498.5})
+
+		c.size(0, "hash_small", 2, true)
+		c.hash(0, "hash_small", map[string]string{"f1": "v1", "f2": "2"})
+		c.size(0, "hash_big", 1000, true)
+		c.hash(0, "hash_big", map[string]string{"field_0": "value_0", "field_999": "value_999"})
+
+		c.str(1, "db1_string", "value")
+
+		if atLeast("3.2") {
+			// scores of the redis GEOADD documentation
+			c.zset(0, "geo", map[string]float64{"Palermo": 3479099956230698, "Catania": 3479447370796909})
+		}
+		// HyperLogLogs and streams are only in the fixtures saved by redis
+		if !byRedis {
+			continue
+		}
+		if hll := c.key(0, "hll").values(); len(hll) != 1 || !strings.HasPrefix(hll[0], "HYLL") {
+			t.Errorf("%s: hll is not a HyperLogLog string. got=%.16q", file, hll)
+		}
+		if atLeast("5.0") {
+			var cmds []string
+			for _, cmd := range c.key(0, "stream").cmds {
+				cmds = append(cmds, strings.ToLower(strings.Join(cmd, " ")))
+			}
+			got := strings.Join(cmds, "\n")
+			for _, want := range []string{
+				"xadd stream 1-1 f1 v1",
+				"xadd stream 2-1 f2 v3",
+				"xsetid stream 2-1",
+				"xgroup create stream group 1-1",
+				"xclaim stream group consumer 0 1-1 ",
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("%s: stream command missing. want=[%s], got=\n%s", file, want, got)
+				}
+			}
+			if strings.Contains(got, "1-2") {
+				t.Errorf("%s: deleted stream entry restored. got=\n%s", file, got)
+			}
+			c.key(0, "stream_empty")
+		}
+	}
+}
+
+// writeTestRDB writes a small rdb file with a string, a set and an expire.
+func writeTestRDB(t *testing.T) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("REDIS0009")
+	writeString := func(s string) {
+		buf.WriteByte(byte(len(s))) // 6 bit length, enough for test
+		buf.WriteString(s)
+	}
+	buf.WriteByte(kFlagAUX)
+	writeString("redis-ver")
+	writeString("6.2.6")
+	buf.WriteByte(kFlagSelect)
+	buf.WriteByte(0)
+	// string with expire
+	buf.WriteByte(kFlagExpireMs)
+	_ = binary.Write(buf, binary.LittleEndian, uint64(4102444800000))
+	buf.WriteByte(0) // RDB_TYPE_STRING
+	writeString("key")
+	writeString("value")
+	// set
+	buf.WriteByte(2) // RDB_TYPE_SET
+	writeString("set")
+	buf.WriteByte(2)
+	writeString("a")
+	writeString("b")
+	buf.WriteByte(kEOF)
+	buf.Write(make([]byte, 8)) // checksum is not verified
+
+	path := filepath.Join(t.TempDir(), "test.rdb")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoaderNext(t *testing.T) {
+	path := writeTestRDB(t)
+	ld := NewLoader(path, nil)
+	var lines []string
+	for {
+		e, err := ld.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, formatEntry(e))
+	}
+	sort.Strings(lines)
+	if want := loadEntries(t, path, false); strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Next differs from ParseRDB. next=%v, parse=%v", lines, want)
+	}
+	if _, err := ld.Next(); err != io.EOF {
+		t.Errorf("io.EOF expected after the last entry. err=[%v]", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := NewStreamLoader(bytes.NewReader(data), nil)
+	var streamed []string
+	for {
+		e, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed = append(streamed, formatEntry(e))
+	}
+	sort.Strings(streamed)
+	if strings.Join(streamed, "\n") != strings.Join(lines, "\n") {
+		t.Errorf("stream differs from file. stream=%v, file=%v", streamed, lines)
+	}
+	// the checksum is not counted
+	if size := uint64(len(data) - 8); statistics.Metrics.RdbFileSize != size || statistics.Metrics.RdbSendSize != size {
+		t.Errorf("size of the streamed rdb not set at its end. rdb_file_size=[%d], rdb_send_size=[%d], want=[%d]",
+			statistics.Metrics.RdbFileSize, statistics.Metrics.RdbSendSize, size)
+	}
+
+	if _, err := NewLoader(filepath.Join(t.TempDir(), "missing.rdb"), nil).Next(); err == nil || err == io.EOF {
+		t.Errorf("error expected for a missing file. err=[%v]", err)
+	}
+}
+
+func TestLoaderRestoreAndRewrite(t *testing.T) {
+	path := writeTestRDB(t)
+
+	restore := loadEntries(t, path, false)
+	if len(restore) != 2 || !strings.HasPrefix(restore[0], `db=0 "restore" "key" <ttl>`) ||
+		!strings.HasPrefix(restore[1], `db=0 "restore" "set" "0"`) {
+		t.Errorf("unexpected restore output: %v", restore)
+	}
+
+	rewrite := loadEntries(t, path, true)
+	want := []string{
+		`db=0 "PEXPIREAT" "key" "4102444800000"`,
+		`db=0 "sadd" "set" "a" "b"`,
+		`db=0 "set" "key" "value"`,
+	}
+	if strings.Join(rewrite, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected rewrite output: %v", rewrite)
+	}
+}
+
+// TestLoaderRewriteExpireOldTarget checks that a target without PEXPIREAT,
+// before 2.6, gets the ttl left at parse time.
+func TestLoaderRewriteExpireOldTarget(t *testing.T) {
+	saved := config.Config.Target.Version
+	defer func() { config.Config.Target.Version = saved }()
+	config.Config.Target.Version = 2.4
+
+	lines := loadEntries(t, writeTestRDB(t), true)
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `db=0 "PEXPIRE" "key" "`) {
+		t.Fatalf("unexpected rewrite output: %v", lines)
+	}
+	ttl, err := strconv.ParseInt(strings.Trim(strings.Fields(lines[0])[3], `"`), 10, 64)
+	if want := int64(4102444800000) - time.Now().UnixMilli(); err != nil || ttl < want || ttl > want+time.Minute.Milliseconds() {
+		t.Errorf("ttl=[%d], want about %d", ttl, want)
+	}
+}
+
+func TestLoaderBigKeyPacing(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.TargetRedisProtoMaxBulkLen = 0 // rewrite every key
+	config.Config.Advanced.SetRewriteBatchSize = 1        // the set is sent in 2 chunks
+	config.Config.Advanced.BigKeyChunkDelay = 50
+	path := writeTestRDB(t)
+
+	cases := []struct {
+		threshold uint64
+		paced     bool
+	}{
+		{0, true},
+		{1 << 20, false}, // the set is below the threshold
+	}
+	for _, c := range cases {
+		config.Config.Advanced.BigKeyThreshold = c.threshold
+		ch := make(chan *entry.Entry, 16)
+		go func() {
+			NewLoader(path, ch).ParseRDB()
+			close(ch)
+		}()
+		var lines []string
+		var sent []time.Time
+		for e := range ch {
+			lines = append(lines, formatEntry(e))
+			sent = append(sent, time.Now())
+		}
+		want := []string{
+			`db=0 "set" "key" "value"`,
+			`db=0 "PEXPIREAT" "key" "4102444800000"`,
+			`db=0 "sadd" "set" "a"`,
+			`db=0 "sadd" "set" "b"`,
+		}
+		if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("unexpected output. threshold=[%d], lines=%v", c.threshold, lines)
+		}
+		gap := sent[3].Sub(sent[2])
+		if delay := 50 * time.Millisecond; (gap >= delay) != c.paced {
+			t.Errorf("unexpected gap between the chunks of the set. threshold=[%d], gap=[%v]", c.threshold, gap)
+		}
+	}
+}
+
+func TestIdleFreqArgs(t *testing.T) {
+	savedAdvanced, savedVersion, savedPolicy := config.Config.Advanced, config.Config.Target.Version, capability.Target.MaxMemoryPolicy
+	defer func() {
+		config.Config.Advanced, config.Config.Target.Version, capability.Target.MaxMemoryPolicy = savedAdvanced, savedVersion, savedPolicy
+	}()
+	config.Config.Target.Version = 7.0
+
+	cases := []struct {
+		policy   string
+		behavior string
+		idle     int64
+		freq     int64
+		want     string
+		lost     uint64
+	}{
+		{"", "drop", 10, 0, "idletime 10", 0}, // policy unknown, kept
+		{"allkeys-lru", "drop", 10, 0, "idletime 10", 0},
+		{"noeviction", "drop", 0, 5, "", 1},
+		{"noeviction", "warn", 0, 5, "freq 5", 1},
+		{"volatile-lfu", "drop", 0, 5, "freq 5", 0},
+		{"allkeys-lfu", "drop", 10, 0, "", 1},
+	}
+	for _, c := range cases {
+		capability.Target.MaxMemoryPolicy = c.policy
+		config.Config.Advanced.RestoreIdleFreq = c.behavior
+		before := statistics.Metrics.IdleFreqLostCount
+		ld := &Loader{idle: c.idle, freq: c.freq}
+		if got := strings.Join(ld.idleFreqArgs(), " "); got != c.want {
+			t.Errorf("unexpected args. policy=[%s], behavior=[%s], got=[%s], want=[%s]", c.policy, c.behavior, got, c.want)
+		}
+		if lost := statistics.Metrics.IdleFreqLostCount - before; lost != c.lost {
+			t.Errorf("unexpected lost count. policy=[%s], got=[%d], want=[%d]", c.policy, lost, c.lost)
+		}
+	}
+}
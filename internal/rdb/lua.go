@@ -0,0 +1,28 @@
+package rdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+)
+
+// loadScript sends a lua aux field as SCRIPT LOAD, or drops it with
+// rdb_lua_scripts = "skip" for targets that reject SCRIPT. The writer sends
+// SCRIPT as script_command.
+func (ld *Loader) loadScript(body string) {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+	if config.Config.Advanced.RDBLuaScripts == "skip" {
+		statistics.AddDropCount("lua_script")
+		log.Infof("RDB lua script skipped. sha1=[%s]", sha)
+		return
+	}
+	e := entry.NewEntry()
+	e.Argv = []string{"script", "load", body}
+	e.IsBase = true
+	ld.emit(e)
+	log.Infof("RDB lua script. sha1=[%s], size=[%d]", sha, len(body))
+}
@@ -0,0 +1,66 @@
+package rdb
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"time"
+)
+
+// sendRewritten sends the commands of a rewritten key. A key is big when its
+// value in the rdb takes at least big_key_threshold bytes. Each chunk of a big key
+// runs as one command on the target, the chunks are spaced by
+// big_key_chunk_delay so that the commands of other clients run between them.
+// With big_key_concurrency > 1, up to that many big keys are sent in the
+// background while the following keys are parsed. Next is not paced. The
+// progress of a big key is tracked until the target answered all chunks, or
+// they were dropped.
+func (ld *Loader) sendRewritten(key string, entries []*entry.Entry, big bool) {
+	cfg := &config.Config.Advanced
+	var progress *statistics.BigKeyProgress
+	if big && ld.ch != nil {
+		progress = statistics.StartBigKey(key, ld.nowDBId, len(entries))
+		for _, e := range entries {
+			e.OnReply = progress.AddApplied
+			e.OnDrop = progress.AddDropped
+		}
+	}
+	push := func(e *entry.Entry) {
+		ld.emit(e)
+		if progress != nil {
+			progress.AddSent()
+		}
+	}
+	delay := time.Duration(cfg.BigKeyChunkDelay) * time.Millisecond
+	if !big || delay <= 0 || ld.ch == nil {
+		for _, e := range entries {
+			push(e)
+		}
+		return
+	}
+	send := func() {
+		start := time.Now()
+		for i, e := range entries {
+			if i > 0 {
+				time.Sleep(delay)
+			}
+			push(e)
+		}
+		log.Debugf("big key sent. key=[%s], commands=[%d], elapsed=[%v]", key, len(entries), time.Since(start))
+	}
+	if cfg.BigKeyConcurrency <= 1 {
+		send()
+		return
+	}
+	if ld.bigKeySema == nil {
+		ld.bigKeySema = make(chan struct{}, cfg.BigKeyConcurrency)
+	}
+	ld.bigKeySema <- struct{}{}
+	ld.bigKeys.Add(1)
+	go func() {
+		defer ld.bigKeys.Done()
+		send()
+		<-ld.bigKeySema
+	}()
+}
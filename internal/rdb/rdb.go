@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/capability"
 	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/entry"
 	"github.com/alibaba/RedisShake/internal/log"
@@ -14,6 +16,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -31,21 +34,40 @@ const (
 	kEOF           = 0xff // End of the RDB file.
 )
 
+// Loader parses an rdb file into entries, pushed into a channel by ParseRDB
+// or pulled one by one by Next.
 type Loader struct {
 	replStreamDbId int // https://github.com/alibaba/RedisShake/pull/430#issuecomment-1099014464
 
 	nowDBId  int
-	expireMs int64
+	expireAt int64 // absolute unix time in milliseconds, 0 means no expire
 	idle     int64
 	freq     int64
 
-	filPath string
-	fp      *os.File
+	filPath   string
+	fp        *os.File
+	stream    io.Reader // read instead of the file if not nil
+	bufReader *bufio.Reader
+	version   int
 
 	ch         chan *entry.Entry
+	pending    []*entry.Entry // entries not returned by Next yet, when ch is nil
+	done       bool           // Next reached the end or failed
 	dumpBuffer bytes.Buffer
+
+	rd     *checksumReader
+	report *CheckReport // only set by Check
+
+	memberExpires int // KeyDB subkey expires, dropped
+
+	idleFreqWarned bool // the target does not keep IDLETIME or FREQ, warned once
+
+	bigKeys    sync.WaitGroup
+	bigKeySema chan struct{} // limits the big keys sent at the same time
 }
 
+// NewLoader creates a loader that sends the entries to ch by ParseRDB. With
+// a nil ch, the entries are read by Next instead.
 func NewLoader(filPath string, ch chan *entry.Entry) *Loader {
 	ld := new(Loader)
 	ld.ch = ch
@@ -53,23 +75,101 @@ func NewLoader(filPath string, ch chan *entry.Entry) *Loader {
 	return ld
 }
 
+// NewStreamLoader creates a loader that reads the rdb from rd instead of a
+// file, such as the rdb of a diskless full sync read from the socket.
+func NewStreamLoader(rd io.Reader, ch chan *entry.Entry) *Loader {
+	ld := NewLoader("(stream)", ch)
+	ld.stream = rd
+	return ld
+}
+
+// ParseRDB sends the entries of the rdb file to ch and returns the
+// repl-stream-db aux field.
 func (ld *Loader) ParseRDB() int {
-	var err error
-	ld.fp, err = os.OpenFile(ld.filPath, os.O_RDONLY, 0666)
-	if err != nil {
-		log.Panicf("open file failed. file_path=[%s], error=[%s]", ld.filPath, err)
-	}
+	ld.open()
 	defer func() {
-		err = ld.fp.Close()
-		if err != nil {
+		if err := ld.close(); err != nil {
 			log.Panicf("close file failed. file_path=[%s], error=[%s]", ld.filPath, err)
 		}
 	}()
-	// bufio分段读取，不会将整个文件加载到内存中
-	rd := bufio.NewReader(ld.fp)
+
+	// read entries
+	ld.parseRDBEntry(ld.rd)
+	// the incremental commands must follow the chunks of big keys
+	ld.bigKeys.Wait()
+	statistics.ClearBigKeys()
+	ld.finish()
+	return ld.replStreamDbId
+}
+
+// Next returns the next entry of the rdb file, or io.EOF after the last one.
+// An invalid file is returned as an error instead of a panic. Big keys are
+// not paced, the caller reads at its own speed. The file is closed at the end
+// or by Close.
+func (ld *Loader) Next() (e *entry.Entry, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ld.Close()
+			err = fmt.Errorf("parse rdb failed. file_path=[%s], error=[%v]", ld.filPath, r)
+		}
+	}()
+	for len(ld.pending) == 0 {
+		if ld.done {
+			return nil, io.EOF
+		}
+		if ld.rd == nil {
+			ld.open()
+		}
+		if !ld.parseRecord(ld.rd) {
+			ld.finish()
+			ld.Close()
+		}
+	}
+	e = ld.pending[0]
+	ld.pending[0] = nil
+	ld.pending = ld.pending[1:]
+	return e, nil
+}
+
+// Close stops Next before the end of the file.
+func (ld *Loader) Close() {
+	ld.done = true
+	_ = ld.close()
+}
+
+// ReplStreamDbId returns the repl-stream-db aux field, read by Next before
+// the first key.
+func (ld *Loader) ReplStreamDbId() int {
+	return ld.replStreamDbId
+}
+
+// emit passes an entry to ch, or keeps it for Next.
+func (ld *Loader) emit(e *entry.Entry) {
+	if ld.ch != nil {
+		ld.ch <- e
+		return
+	}
+	ld.pending = append(ld.pending, e)
+}
+
+// open opens the file and reads the magic string and the rdb version.
+func (ld *Loader) open() {
+	var err error
+	if ld.stream != nil {
+		ld.bufReader = bufio.NewReader(ld.stream)
+	} else {
+		ld.fp, err = os.OpenFile(ld.filPath, os.O_RDONLY, 0666)
+		if err != nil {
+			log.Panicf("open file failed. file_path=[%s], error=[%s]", ld.filPath, err)
+		}
+		// bufio分段读取，不会将整个文件加载到内存中
+		ld.bufReader = bufio.NewReader(ld.fp)
+	}
+	// 校验和覆盖从文件头到 EOF 标识的所有字节
+	ld.rd = newChecksumReader(ld.bufReader)
 	//magic + version 即REDIS + 0006
 	buf := make([]byte, 9)
-	_, err = io.ReadFull(rd, buf)
+	_, err = io.ReadFull(ld.rd, buf)
 	if err != nil {
 		log.PanicError(err)
 	}
@@ -78,27 +178,53 @@ func (ld *Loader) ParseRDB() int {
 		log.Panicf("verify magic string, invalid file format. bytes=[%v]", buf[:5])
 	}
 	// 获取redis版本 0009
-	version, err := strconv.Atoi(string(buf[5:]))
+	ld.version, err = strconv.Atoi(string(buf[5:]))
 	if err != nil {
 		log.PanicError(err)
 	}
-	log.Infof("RDB version: %d", version)
+	log.Infof("RDB version: %d", ld.version)
+	if ld.report != nil {
+		ld.report.Version = ld.version
+	}
+}
 
-	// read entries
-	ld.parseRDBEntry(rd)
+func (ld *Loader) close() error {
+	if ld.fp == nil {
+		return nil
+	}
+	err := ld.fp.Close()
+	ld.fp = nil
+	return err
+}
+
+// finish is called after EOF, it verifies the checksum.
+func (ld *Loader) finish() {
+	// checksum, since rdb version 5
+	if ld.version >= 5 {
+		ld.verifyChecksum(ld.bufReader)
+	}
 
+	if ld.stream != nil {
+		// the size of a diskless rdb is only known at its end
+		statistics.SetRDBFileSize(uint64(ld.rd.offset))
+		statistics.UpdateRDBSentSize(uint64(ld.rd.offset))
+		return
+	}
 	// force update rdb_sent_size for issue: https://github.com/alibaba/RedisShake/issues/485
 	fi, err := os.Stat(ld.filPath)
 	if err != nil {
 		log.Panicf("NewRDBReader: os.Stat error: %s", err.Error())
 	}
-	statistics.Metrics.RdbSendSize = uint64(fi.Size())
-	return ld.replStreamDbId
+	statistics.UpdateRDBSentSize(uint64(fi.Size()))
 }
 
-func (ld *Loader) parseRDBEntry(rd *bufio.Reader) {
+func (ld *Loader) parseRDBEntry(rd io.Reader) {
 	// for stat
 	UpdateRDBSentSize := func() {
+		if ld.stream != nil {
+			statistics.UpdateRDBSentSize(uint64(ld.rd.offset))
+			return
+		}
 		offset, err := ld.fp.Seek(0, io.SeekCurrent)
 		if err != nil {
 			log.PanicError(err)
@@ -108,120 +234,223 @@ func (ld *Loader) parseRDBEntry(rd *bufio.Reader) {
 	defer UpdateRDBSentSize()
 	// read one entry 一秒给tick通道发送一个时间戳
 	tick := time.Tick(time.Second * 1)
-	for true {
-		typeByte := structure.ReadByte(rd)
-		switch typeByte {
-		case kFlagIdle:
-			// 0xF8 LRU redis key的LRU时间戳
-			ld.idle = int64(structure.ReadLength(rd))
-		case kFlagFreq:
-			// 0xF9 LFU LFU频率
-			ld.freq = int64(structure.ReadByte(rd))
-		case kFlagAUX:
-			// redis元属性 0xfa
-			// structure.ReadString的含义因该是按照rdb的字符串编码方式，读取一个字符串
-			key := structure.ReadString(rd)
-			value := structure.ReadString(rd)
-			if key == "repl-stream-db" {
-				var err error
-				ld.replStreamDbId, err = strconv.Atoi(value)
-				if err != nil {
-					log.PanicError(err)
-				}
-				log.Infof("RDB repl-stream-db: %d", ld.replStreamDbId)
-			} else if key == "lua" {
-				// redis 7 ?
-				e := entry.NewEntry()
-				e.Argv = []string{"script", "load", value}
-				e.IsBase = true
-				ld.ch <- e
-				log.Infof("LUA script: [%s]", value)
+	for ld.parseRecord(rd) {
+		select {
+		case <-tick:
+			UpdateRDBSentSize()
+		default:
+		}
+	}
+}
+
+// parseRecord parses one record of the rdb file, it returns false after EOF.
+func (ld *Loader) parseRecord(rd io.Reader) bool {
+	typeByte := structure.ReadByte(rd)
+	switch typeByte {
+	case kFlagIdle:
+		// 0xF8 LRU redis key的LRU时间戳
+		ld.idle = int64(structure.ReadLength(rd))
+	case kFlagFreq:
+		// 0xF9 LFU LFU频率
+		ld.freq = int64(structure.ReadByte(rd))
+	case kFlagAUX:
+		// redis元属性 0xfa
+		// structure.ReadString的含义因该是按照rdb的字符串编码方式，读取一个字符串
+		key := structure.ReadString(rd)
+		value := structure.ReadString(rd)
+		if key == "repl-stream-db" {
+			var err error
+			ld.replStreamDbId, err = strconv.Atoi(value)
+			if err != nil {
+				log.PanicError(err)
+			}
+			log.Infof("RDB repl-stream-db: %d", ld.replStreamDbId)
+		} else if key == "lua" {
+			// scripts loaded on the source by SCRIPT LOAD, saved by redis < 7
+			ld.loadScript(value)
+		} else if key == "mvcc-tstamp" {
+			// KeyDB saves the MVCC timestamp of every key, the target does not need it
+		} else if key == "keydb-subexpire-key" {
+			// KeyDB EXPIREMEMBER, redis has no member expires
+			ld.memberExpires++
+			statistics.AddDropCount("keydb_member_expire")
+		} else if key == "keydb-subexpire-when" {
+		} else {
+			log.Infof("RDB AUX fields. key=[%s], value=[%s]", key, value)
+		}
+		if ld.report != nil {
+			ld.report.Aux[key] = value
+		}
+	case kFlagResizeDB:
+		// 0xFB RESIZEDB  描述 key 数目和设置了过期时间 key 数目
+		dbSize := structure.ReadLength(rd)
+		expireSize := structure.ReadLength(rd)
+		log.Infof("RDB resize db. db_size=[%d], expire_size=[%d]", dbSize, expireSize)
+	case kFlagExpireMs:
+		// 0xFC EXPIRETIMEMS key过期时间，使用毫秒表示。
+		ld.expireAt = int64(structure.ReadUint64(rd))
+	case kFlagExpire:
+		// 0xFD EXPIRETIME  key-过期时间，使用秒表示。
+		ld.expireAt = int64(structure.ReadUint32(rd)) * 1000
+	case kFlagSelect:
+		// 0xFE SELECTDB 选库标识，后面紧跟数据库编号
+		ld.nowDBId = int(structure.ReadLength(rd))
+	case kEOF:
+		// 0xFF EOF rdb文件结束符
+		if ld.memberExpires > 0 {
+			log.Warnf("RDB has KeyDB member expires, they are not synced. count=[%d]", ld.memberExpires)
+		}
+		return false
+	default:
+		// value的类型标识 OBJECT_TYPE 已经在前面被读取到 typeByte 中了
+		// 读取一个key
+		key := structure.ReadString(rd)
+		var value bytes.Buffer
+		// io.TeeReader返回一个Reader，它将从reader(rd)中读取的内容写入writer(&value)。
+		// 通过它执行的所有从reader(rd)中读取的操作都与相应的对writer(&value)的写入操作相匹配。没有内部缓冲——写入操作必须在读取操作完成之前完成。 写入时遇到的任何错误都将报告为读错误。
+		anotherReader := io.TeeReader(rd, &value)
+		o := types.ParseObject(anotherReader, typeByte, key)
+		if ld.report != nil {
+			ld.report.addKey(ld.nowDBId, key, o, ld.expireAt)
+		}
+		// HyperLogLog 和 bitmap 必须按字节原样写入
+		isProtected := false
+		if so, ok := o.(*types.StringObject); ok {
+			isProtected = so.IsProtected()
+		}
+		// 本次value的值大于 512mb, HyperLogLog 不会走 rewrite
+		rewrite := uint64(value.Len()) > config.Config.Advanced.TargetRedisProtoMaxBulkLen && !isHyperLogLog(o)
+		if !capability.Target.CanRestore() {
+			rewrite = true
+		}
+		var cmds []types.RedisCmd
+		// geo 类型的 zset 可以改写为 GEOADD
+		if zo, ok := o.(*types.ZsetObject); ok && utils.MatchAnyPattern(config.Config.Advanced.GeoKeyPatterns, key) {
+			if geoCmds, ok := zo.RewriteGeo(); ok {
+				cmds = geoCmds
+				rewrite = true
 			} else {
-				log.Infof("RDB AUX fields. key=[%s], value=[%s]", key, value)
+				log.Warnf("zset matches geo_key_patterns but scores are not geohash, skip geo rewrite. key=[%s]", key)
 			}
-		case kFlagResizeDB:
-			// 0xFB RESIZEDB  描述 key 数目和设置了过期时间 key 数目
-			dbSize := structure.ReadLength(rd)
-			expireSize := structure.ReadLength(rd)
-			log.Infof("RDB resize db. db_size=[%d], expire_size=[%d]", dbSize, expireSize)
-		case kFlagExpireMs:
-			// 0xFC EXPIRETIMEMS key过期时间，使用毫秒表示。
-			ld.expireMs = int64(structure.ReadUint64(rd)) - time.Now().UnixMilli()
-			if ld.expireMs < 0 {
-				ld.expireMs = 1
+		}
+		if _, ok := o.(*types.ModuleObject); ok && rewrite {
+			log.Warnf("module value can not be rewritten into commands, key dropped. key=[%s]", key)
+			statistics.AddDropCount("module_not_rewritable")
+		} else if rewrite {
+			// 如果值大于512mb，将命令改为对应的redis api, 如string就是set
+			if cmds == nil {
+				cmds = o.Rewrite()
 			}
-		case kFlagExpire:
-			// 0xFD EXPIRETIME  key-过期时间，使用秒表示。
-			ld.expireMs = int64(structure.ReadUint32(rd))*1000 - time.Now().UnixMilli()
-			if ld.expireMs < 0 {
-				ld.expireMs = 1
+			// set 中出现重复元素说明源端数据已损坏，RESTORE 时原样写入，不做检查
+			if so, ok := o.(*types.SetObject); ok && so.Duplicates() > 0 {
+				log.Warnf("set contains duplicate members, duplicates suppressed. key=[%s], duplicates=[%d]", key, so.Duplicates())
+				statistics.AddSetDuplicateMembersCount(uint64(so.Duplicates()))
 			}
-		case kFlagSelect:
-			// 0xFE SELECTDB 选库标识，后面紧跟数据库编号
-			ld.nowDBId = int(structure.ReadLength(rd))
-		case kEOF:
-			// 0xFF EOF rdb文件结束符
-			return
-		default:
-			// value的类型标识 OBJECT_TYPE 已经在前面被读取到 typeByte 中了
-			// 读取一个key
-			key := structure.ReadString(rd)
-			var value bytes.Buffer
-			// io.TeeReader返回一个Reader，它将从reader(rd)中读取的内容写入writer(&value)。
-			// 通过它执行的所有从reader(rd)中读取的操作都与相应的对writer(&value)的写入操作相匹配。没有内部缓冲——写入操作必须在读取操作完成之前完成。 写入时遇到的任何错误都将报告为读错误。
-			anotherReader := io.TeeReader(rd, &value)
-			o := types.ParseObject(anotherReader, typeByte, key)
-			// 本次value的值大于 512mb
-			if uint64(value.Len()) > config.Config.Advanced.TargetRedisProtoMaxBulkLen {
-				// 如果值大于512mb，将命令改为对应的redis api, 如string就是set
-				cmds := o.Rewrite()
-				for _, cmd := range cmds {
-					e := entry.NewEntry()
-					e.IsBase = true
-					e.DbId = ld.nowDBId
-					e.Argv = cmd
-					ld.ch <- e
-				}
-				if ld.expireMs != 0 {
-					e := entry.NewEntry()
-					e.IsBase = true
-					e.DbId = ld.nowDBId
-					e.Argv = []string{"PEXPIRE", key, strconv.FormatInt(ld.expireMs, 10)}
-					ld.ch <- e
-				}
-			} else {
+			entries := make([]*entry.Entry, 0, len(cmds)+1)
+			for _, cmd := range cmds {
 				e := entry.NewEntry()
 				e.IsBase = true
+				e.IsProtected = isProtected
 				e.DbId = ld.nowDBId
-				// 这里的意思应该是将的渠道的value值转为dump以后的序列化形式，然后通过restore命令加载到redis内存中
-				v := ld.createValueDump(typeByte, value.Bytes())
-				// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
-				e.Argv = []string{"restore", key, strconv.FormatInt(ld.expireMs, 10), v} // 10代表10进制
-				if config.Config.Advanced.RDBRestoreCommandBehavior == "rewrite" {
-					if config.Config.Target.Version < 3.0 {
-						log.Panicf("RDB restore command behavior is rewrite, but target redis version is %f, not support REPLACE modifier", config.Config.Target.Version)
-					}
-					e.Argv = append(e.Argv, "replace")
-				}
-				if ld.idle != 0 && config.Config.Target.Version >= 5.0 {
-					e.Argv = append(e.Argv, "idletime", strconv.FormatInt(ld.idle, 10))
+				e.Argv = cmd
+				entries = append(entries, e)
+			}
+			if ld.expireAt != 0 {
+				e := entry.NewEntry()
+				e.IsBase = true
+				e.DbId = ld.nowDBId
+				// 使用绝对时间，避免解析和写入的耗时导致 ttl 偏移
+				if config.Config.Target.Version >= 2.6 {
+					e.Argv = []string{"PEXPIREAT", key, strconv.FormatInt(ld.expireAt, 10)}
+				} else {
+					e.Argv = []string{"PEXPIRE", key, strconv.FormatInt(ld.relativeExpireMs(), 10)}
 				}
-				if ld.freq != 0 && config.Config.Target.Version >= 5.0 {
-					e.Argv = append(e.Argv, "freq", strconv.FormatInt(ld.freq, 10))
+				entries = append(entries, e)
+			}
+			if ld.idle != 0 || ld.freq != 0 {
+				ld.lostIdleFreq("the key is rewritten into commands")
+			}
+			// 只有 rdb 中的值达到 big_key_threshold 才算大 key，小 key 的多条命令直接发送
+			big := len(cmds) > 1 && uint64(value.Len()) >= config.Config.Advanced.BigKeyThreshold
+			ld.sendRewritten(key, entries, big)
+		} else {
+			e := entry.NewEntry()
+			e.IsBase = true
+			e.IsProtected = isProtected
+			e.DbId = ld.nowDBId
+			// 这里的意思应该是将的渠道的value值转为dump以后的序列化形式，然后通过restore命令加载到redis内存中
+			v := ld.createValueDump(typeByte, value.Bytes())
+			// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+			e.Argv = []string{"restore", key, strconv.FormatInt(ld.relativeExpireMs(), 10), v} // 10代表10进制
+			if config.Config.Advanced.RDBRestoreCommandBehavior == "rewrite" {
+				if config.Config.Target.Version < 3.0 {
+					log.Panicf("RDB restore command behavior is rewrite, but target redis version is %f, not support REPLACE modifier", config.Config.Target.Version)
 				}
-				ld.ch <- e
+				e.Argv = append(e.Argv, "replace")
 			}
-			// 复位
-			ld.expireMs = 0
-			ld.idle = 0
-			ld.freq = 0
+			e.Argv = append(e.Argv, ld.idleFreqArgs()...)
+			ld.emit(e)
 		}
-		select {
-		case <-tick:
-			UpdateRDBSentSize()
-		default:
+		// 复位
+		ld.expireAt = 0
+		ld.idle = 0
+		ld.freq = 0
+	}
+	return true
+}
+
+// relativeExpireMs returns the ttl of current key in milliseconds, 0 means no expire.
+// Keys that have already expired get a ttl of 1ms.
+func (ld *Loader) relativeExpireMs() int64 {
+	if ld.expireAt == 0 {
+		return 0
+	}
+	ttl := ld.expireAt - time.Now().UnixMilli()
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// idleFreqArgs returns the IDLETIME or FREQ of the key for RESTORE. The target
+// keeps FREQ only with an LFU maxmemory-policy and IDLETIME otherwise, the
+// other one is counted as lost, and not sent with restore_idle_freq = "drop".
+func (ld *Loader) idleFreqArgs() []string {
+	if ld.idle == 0 && ld.freq == 0 {
+		return nil
+	}
+	if config.Config.Target.Version < 5.0 {
+		ld.lostIdleFreq("the target does not support IDLETIME and FREQ of RESTORE")
+		return nil
+	}
+	var args []string
+	if ld.idle != 0 {
+		args = []string{"idletime", strconv.FormatInt(ld.idle, 10)}
+	} else {
+		args = []string{"freq", strconv.FormatInt(ld.freq, 10)}
+	}
+	if lfu, ok := capability.Target.KeepsFreq(); ok && lfu != (ld.freq != 0) {
+		ld.lostIdleFreq(fmt.Sprintf("the maxmemory-policy of the target is %s", capability.Target.MaxMemoryPolicy))
+		if config.Config.Advanced.RestoreIdleFreq == "drop" {
+			return nil
 		}
 	}
+	return args
+}
+
+// lostIdleFreq counts a key whose LRU idle time or LFU frequency is not kept
+// on the target, the first one is logged.
+func (ld *Loader) lostIdleFreq(reason string) {
+	statistics.AddIdleFreqLostCount()
+	if !ld.idleFreqWarned {
+		ld.idleFreqWarned = true
+		log.Warnf("LRU/LFU metadata of the keys is not kept on the target, %s. counted in idle_freq_lost_count", reason)
+	}
+}
+
+func isHyperLogLog(o types.RedisObject) bool {
+	so, ok := o.(*types.StringObject)
+	return ok && so.IsHyperLogLog()
 }
 
 // createValueDump创建value的dump字符串 以便restore到redis中
@@ -0,0 +1,28 @@
+package rdb
+
+import (
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb/structure"
+	"github.com/alibaba/RedisShake/internal/rdb/types"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+)
+
+// RewriteDump parses a DUMP payload and returns the commands that create the
+// same value, for targets that can not RESTORE it. Module values can not be
+// rewritten, nil is returned for them.
+func RewriteDump(key string, payload string) []types.RedisCmd {
+	// type, value, 2 bytes rdb version and 8 bytes crc64
+	if len(payload) < 11 {
+		log.Panicf("invalid DUMP payload. key=[%s], length=[%d]", key, len(payload))
+	}
+	rd := strings.NewReader(payload[:len(payload)-10])
+	typeByte := structure.ReadByte(rd)
+	o := types.ParseObject(rd, typeByte, key)
+	if _, ok := o.(*types.ModuleObject); ok {
+		log.Warnf("module value can not be rewritten into commands, key dropped. key=[%s]", key)
+		statistics.AddDropCount("module_not_rewritable")
+		return nil
+	}
+	return o.Rewrite()
+}
@@ -0,0 +1,15 @@
+package rdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteDump(t *testing.T) {
+	// DUMP of a list with "a" and "b": type, length, elements, rdb version and crc64
+	payload := "\x01\x02\x01a\x01b" + "\x09\x00" + strings.Repeat("\x00", 8)
+	cmds := RewriteDump("l", payload)
+	if len(cmds) != 2 || strings.Join(cmds[0], " ") != "rpush l a" || strings.Join(cmds[1], " ") != "rpush l b" {
+		t.Errorf("RewriteDump failed. cmds=%v", cmds)
+	}
+}
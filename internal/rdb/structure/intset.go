@@ -3,11 +3,19 @@ package structure
 import (
 	"bufio"
 	"encoding/binary"
+	"github.com/alibaba/RedisShake/internal/log"
 	"io"
 	"strconv"
 	"strings"
 )
 
+// intset encodings, see redis intset.c
+const (
+	intsetEncInt16 = 2 // INTSET_ENC_INT16
+	intsetEncInt32 = 4 // INTSET_ENC_INT32
+	intsetEncInt64 = 8 // INTSET_ENC_INT64
+)
+
 func ReadIntset(rd io.Reader) []string {
 	rd = bufio.NewReader(strings.NewReader(ReadString(rd)))
 
@@ -19,12 +27,14 @@ func ReadIntset(rd io.Reader) []string {
 		intBytes := ReadBytes(rd, encodingType)
 		var intString string
 		switch encodingType {
-		case 2:
+		case intsetEncInt16:
 			intString = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(intBytes))), 10)
-		case 4:
+		case intsetEncInt32:
 			intString = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))), 10)
-		case 8:
-			intString = strconv.FormatInt(int64(int64(binary.LittleEndian.Uint64(intBytes))), 10)
+		case intsetEncInt64:
+			intString = strconv.FormatInt(int64(binary.LittleEndian.Uint64(intBytes)), 10)
+		default:
+			log.Panicf("unknown intset encoding type. encodingType=[%d]", encodingType)
 		}
 		elements[i] = intString
 	}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"math"
+	"strconv"
+)
+
+// see redis geohash.c and geohash_helper.c
+const (
+	geoStep    = 26 // GEO_STEP_MAX
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+)
+
+// deinterleave64 collects the even bits of interleaved into a 32-bit number.
+func deinterleave64(interleaved uint64) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F,
+		0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+	x := interleaved
+	y := interleaved >> 1
+	x = (x | (x >> s[0])) & b[0]
+	y = (y | (y >> s[0])) & b[0]
+	x = (x | (x >> s[1])) & b[1]
+	y = (y | (y >> s[1])) & b[1]
+	x = (x | (x >> s[2])) & b[2]
+	y = (y | (y >> s[2])) & b[2]
+	x = (x | (x >> s[3])) & b[3]
+	y = (y | (y >> s[3])) & b[3]
+	x = (x | (x >> s[4])) & b[4]
+	y = (y | (y >> s[4])) & b[4]
+	x = (x | (x >> s[5])) & b[5]
+	y = (y | (y >> s[5])) & b[5]
+	return x | (y << 32)
+}
+
+// decodeGeohash returns the longitude and latitude of the center of the
+// geohash cell, which is what redis GEOPOS returns.
+func decodeGeohash(bits uint64) (longitude float64, latitude float64) {
+	hashSep := deinterleave64(bits)
+	ilato := float64(uint32(hashSep))       // latitude
+	ilono := float64(uint32(hashSep >> 32)) // longitude
+	scale := float64(uint64(1) << geoStep)
+
+	latMin := geoLatMin + (ilato/scale)*(geoLatMax-geoLatMin)
+	latMax := geoLatMin + ((ilato+1)/scale)*(geoLatMax-geoLatMin)
+	longMin := geoLongMin + (ilono/scale)*(geoLongMax-geoLongMin)
+	longMax := geoLongMin + ((ilono+1)/scale)*(geoLongMax-geoLongMin)
+
+	longitude = math.Max(geoLongMin, math.Min(geoLongMax, (longMin+longMax)/2))
+	latitude = math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return
+}
+
+// parseGeoScore checks whether the score is a valid 52-bit geohash.
+func parseGeoScore(score string) (uint64, bool) {
+	f, err := strconv.ParseFloat(score, 64)
+	if err != nil || f < 0 || f >= float64(uint64(1)<<(geoStep*2)) || f != math.Trunc(f) {
+		return 0, false
+	}
+	return uint64(f), true
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodeGeohash(t *testing.T) {
+	// GEOADD Sicily 13.361389 38.115556 "Palermo"
+	longitude, latitude := decodeGeohash(3479099956230698)
+	if math.Abs(longitude-13.36138933897018433) > 1e-9 || math.Abs(latitude-38.11555639549629859) > 1e-9 {
+		t.Errorf("decodeGeohash(3479099956230698) = %v, %v", longitude, latitude)
+	}
+	// GEOADD Sicily 15.087269 37.502669 "Catania"
+	longitude, latitude = decodeGeohash(3479447370796909)
+	if math.Abs(longitude-15.08726745843887329) > 1e-9 || math.Abs(latitude-37.50266842333162032) > 1e-9 {
+		t.Errorf("decodeGeohash(3479447370796909) = %v, %v", longitude, latitude)
+	}
+}
+
+func TestParseGeoScore(t *testing.T) {
+	if _, ok := parseGeoScore("3479099956230698.000000"); !ok {
+		t.Errorf("parseGeoScore(3479099956230698.000000) should be ok")
+	}
+	if _, ok := parseGeoScore("1.5"); ok {
+		t.Errorf("parseGeoScore(1.5) should not be ok")
+	}
+	if _, ok := parseGeoScore("-1"); ok {
+		t.Errorf("parseGeoScore(-1) should not be ok")
+	}
+	if _, ok := parseGeoScore("4503599627370496"); ok {
+		t.Errorf("parseGeoScore(1<<52) should not be ok")
+	}
+}
@@ -45,6 +45,7 @@ const (
 	rdbTypeZSetListpack     = 17 // RDB_TYPE_ZSET_LISTPACK
 	rdbTypeListQuicklist2   = 18 // RDB_TYPE_LIST_QUICKLIST_2 https://github.com/redis/redis/pull/9357
 	rdbTypeStreamListpacks2 = 19 // RDB_TYPE_STREAM_LISTPACKS2
+	rdbTypeSetListpack      = 20 // RDB_TYPE_SET_LISTPACK
 
 	moduleTypeNameCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
 
@@ -75,7 +76,7 @@ func ParseObject(rd io.Reader, typeByte byte, key string) RedisObject {
 		o := new(ListObject)
 		o.LoadFromBuffer(rd, key, typeByte)
 		return o
-	case rdbTypeSet, rdbTypeSetIntset: // set
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack: // set
 		o := new(SetObject)
 		o.LoadFromBuffer(rd, key, typeByte)
 		return o
@@ -1,14 +1,17 @@
 package types
 
 import (
+	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/log"
 	"github.com/alibaba/RedisShake/internal/rdb/structure"
 	"io"
 )
 
 type SetObject struct {
-	key      string
-	elements []string
+	key        string
+	elements   []string
+	duplicates int  // duplicate members suppressed by dedup, should be 0
+	deduped    bool // dedup is done once, the RESTORE path keeps the payload as is
 }
 
 func (o *SetObject) LoadFromBuffer(rd io.Reader, key string, typeByte byte) {
@@ -18,6 +21,8 @@ func (o *SetObject) LoadFromBuffer(rd io.Reader, key string, typeByte byte) {
 		o.readSet(rd)
 	case rdbTypeSetIntset:
 		o.elements = structure.ReadIntset(rd)
+	case rdbTypeSetListpack:
+		o.elements = structure.ReadListpack(rd)
 	default:
 		log.Panicf("unknown set type. typeByte=[%d]", typeByte)
 	}
@@ -32,11 +37,49 @@ func (o *SetObject) readSet(rd io.Reader) {
 	}
 }
 
+// dedup removes duplicate members. A healthy RDB never contains them, so
+// any suppressed member is a sign of source corruption.
+func (o *SetObject) dedup() {
+	if o.deduped {
+		return
+	}
+	o.deduped = true
+	seen := make(map[string]struct{}, len(o.elements))
+	elements := o.elements[:0]
+	for _, ele := range o.elements {
+		if _, ok := seen[ele]; ok {
+			o.duplicates++
+			continue
+		}
+		seen[ele] = struct{}{}
+		elements = append(elements, ele)
+	}
+	o.elements = elements
+}
+
+// Duplicates returns the count of duplicate members, they are suppressed when
+// the set is rewritten into commands.
+func (o *SetObject) Duplicates() int {
+	o.dedup()
+	return o.duplicates
+}
+
 func (o *SetObject) Rewrite() []RedisCmd {
-	cmds := make([]RedisCmd, len(o.elements))
-	for inx, ele := range o.elements {
-		cmd := RedisCmd{"sadd", o.key, ele}
-		cmds[inx] = cmd
+	// SADD would ignore them, they are counted by Duplicates
+	o.dedup()
+	batchSize := config.Config.Advanced.SetRewriteBatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	cmds := make([]RedisCmd, 0, (len(o.elements)+batchSize-1)/batchSize)
+	for start := 0; start < len(o.elements); start += batchSize {
+		end := start + batchSize
+		if end > len(o.elements) {
+			end = len(o.elements)
+		}
+		cmd := RedisCmd{"sadd", o.key}
+		cmd = append(cmd, o.elements[start:end]...)
+		cmds = append(cmds, cmd)
 	}
 	return cmds
 }
@@ -0,0 +1,27 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSetDedupOnRewrite(t *testing.T) {
+	// a corrupted set of 3 members: a, b, a
+	o := new(SetObject)
+	o.LoadFromBuffer(bytes.NewReader([]byte{3, 1, 'a', 1, 'b', 1, 'a'}), "s", rdbTypeSet)
+	if len(o.elements) != 3 || o.deduped {
+		t.Fatalf("members deduplicated while loading, the RESTORE path does not need it. elements=%v", o.elements)
+	}
+	cmds := o.Rewrite()
+	if want := []RedisCmd{{"sadd", "s", "a", "b"}}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("duplicates not suppressed by Rewrite. cmds=%v", cmds)
+	}
+	if o.Duplicates() != 1 {
+		t.Errorf("duplicates=[%d], want 1", o.Duplicates())
+	}
+	o.Rewrite()
+	if o.Duplicates() != 1 {
+		t.Errorf("duplicates counted again by a second Rewrite. duplicates=[%d]", o.Duplicates())
+	}
+}
@@ -165,7 +165,7 @@ func (o *StreamObject) readStream(rd io.Reader, masterKey string, typeByte byte)
 		lastid := fmt.Sprintf("%v-%v", lastMs, lastSeq)
 
 		/* Create Group */
-		o.cmds = append(o.cmds, []string{"CREATE", masterKey, groupName, lastid})
+		o.cmds = append(o.cmds, []string{"xgroup", "CREATE", masterKey, groupName, lastid})
 
 		/* Load group offset. */
 		if typeByte == rdbTypeStreamListpacks2 {
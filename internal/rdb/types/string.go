@@ -1,10 +1,15 @@
 package types
 
 import (
+	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/rdb/structure"
 	"io"
+	"strings"
 )
 
+// hllMagic is the header of every HyperLogLog value, see redis hyperloglog.c
+const hllMagic = "HYLL"
+
 type StringObject struct {
 	value string
 	key   string
@@ -16,8 +21,32 @@ func (o *StringObject) LoadFromBuffer(rd io.Reader, key string, _ byte) {
 	o.value = structure.ReadString(rd)
 }
 
+// IsHyperLogLog reports whether the value is a HyperLogLog encoded string.
+func (o *StringObject) IsHyperLogLog() bool {
+	return strings.HasPrefix(o.value, hllMagic)
+}
+
+// IsProtected reports whether the value must be preserved byte for byte.
+// HyperLogLogs are detected by header, large strings are treated as bitmaps
+// because there is no way to tell them apart.
+func (o *StringObject) IsProtected() bool {
+	return o.IsHyperLogLog() || uint64(len(o.value)) > config.Config.Advanced.RewriteStringChunkSize
+}
+
+// Rewrite splits the value into SET + APPEND chunks, so the bytes written to
+// the target are exactly the same as the source.
 func (o *StringObject) Rewrite() []RedisCmd {
-	cmd := RedisCmd{}
-	cmd = append(cmd, "set", o.key, o.value)
-	return []RedisCmd{cmd}
+	chunkSize := int(config.Config.Advanced.RewriteStringChunkSize)
+	if chunkSize <= 0 || len(o.value) <= chunkSize {
+		return []RedisCmd{{"set", o.key, o.value}}
+	}
+	cmds := []RedisCmd{{"set", o.key, o.value[:chunkSize]}}
+	for start := chunkSize; start < len(o.value); start += chunkSize {
+		end := start + chunkSize
+		if end > len(o.value) {
+			end = len(o.value)
+		}
+		cmds = append(cmds, RedisCmd{"append", o.key, o.value[start:end]})
+	}
+	return cmds
 }
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"bytes"
+	"github.com/alibaba/RedisShake/internal/config"
+	"reflect"
+	"testing"
+)
+
+func TestStringRewriteChunks(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	config.Config.Advanced.RewriteStringChunkSize = 4
+
+	o := new(StringObject)
+	o.LoadFromBuffer(bytes.NewReader(append([]byte{10}, "HYLL\x00bits\x80"...)), "k", rdbTypeString)
+	if !o.IsHyperLogLog() || !o.IsProtected() {
+		t.Errorf("HyperLogLog not detected. hll=[%v], protected=[%v]", o.IsHyperLogLog(), o.IsProtected())
+	}
+	want := []RedisCmd{{"set", "k", "HYLL"}, {"append", "k", "\x00bit"}, {"append", "k", "s\x80"}}
+	if cmds := o.Rewrite(); !reflect.DeepEqual(cmds, want) {
+		t.Errorf("cmds=%q, want %q", cmds, want)
+	}
+
+	o = new(StringObject)
+	o.LoadFromBuffer(bytes.NewReader([]byte{3, 'a', 'b', 'c'}), "k", rdbTypeString)
+	if o.IsProtected() {
+		t.Errorf("short string should not be protected")
+	}
+	if cmds := o.Rewrite(); !reflect.DeepEqual(cmds, []RedisCmd{{"set", "k", "abc"}}) {
+		t.Errorf("cmds=%q, want a single set", cmds)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/alibaba/RedisShake/internal/log"
 	"github.com/alibaba/RedisShake/internal/rdb/structure"
 	"io"
+	"strconv"
 )
 
 type ZSetEntry struct {
@@ -87,3 +88,21 @@ func (o *ZsetObject) Rewrite() []RedisCmd {
 	}
 	return cmds
 }
+
+// RewriteGeo rewrites the zset to GEOADD commands with decoded longitude and
+// latitude. It returns false if any score is not a valid geohash.
+func (o *ZsetObject) RewriteGeo() ([]RedisCmd, bool) {
+	cmds := make([]RedisCmd, len(o.elements))
+	for inx, ele := range o.elements {
+		bits, ok := parseGeoScore(ele.Score)
+		if !ok {
+			return nil, false
+		}
+		longitude, latitude := decodeGeohash(bits)
+		cmds[inx] = RedisCmd{"geoadd", o.key,
+			strconv.FormatFloat(longitude, 'f', -1, 64),
+			strconv.FormatFloat(latitude, 'f', -1, 64),
+			ele.Member}
+	}
+	return cmds, true
+}
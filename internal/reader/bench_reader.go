@@ -0,0 +1,118 @@
+package reader
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+)
+
+const benchKeyPrefix = "redis-shake-bench:"
+
+// benchReader generates a synthetic workload, used to benchmark the target
+// and tune pipeline settings before the real migration.
+type benchReader struct {
+	ch    chan *entry.Entry
+	rand  *rand.Rand
+	types []string
+	// cumulative weights of types
+	weights []int
+	total   int
+}
+
+func NewBenchReader() Reader {
+	r := new(benchReader)
+	r.rand = rand.New(rand.NewSource(1)) // the same workload every run
+	cfg := &config.Config.Source
+	for typ := range cfg.BenchTypes {
+		r.types = append(r.types, typ)
+	}
+	sort.Strings(r.types)
+	for _, typ := range r.types {
+		switch typ {
+		case "string", "list", "hash", "set", "zset":
+		default:
+			log.Panicf("unknown type in bench_types, should be string/list/hash/set/zset. type=[%s]", typ)
+		}
+		r.total += cfg.BenchTypes[typ]
+		r.weights = append(r.weights, r.total)
+	}
+	if r.total <= 0 {
+		log.Panicf("bench_types should have positive weights")
+	}
+	log.Infof("benchReader created. count=[%d], types=%v, value_size=[%d], elements=[%d], key_space=[%d], ttl_ratio=[%v]",
+		cfg.BenchCount, cfg.BenchTypes, cfg.BenchValueSize, cfg.BenchElements, cfg.BenchKeySpace, cfg.BenchTTLRatio)
+	return r
+}
+
+func (r *benchReader) StartRead() chan *entry.Entry {
+	r.ch = make(chan *entry.Entry, 1024)
+	go func() {
+		cfg := &config.Config.Source
+		for i := uint64(0); i < cfg.BenchCount; i++ {
+			for _, argv := range r.next() {
+				e := entry.NewEntry()
+				e.Argv = argv
+				r.ch <- e
+			}
+		}
+		log.Infof("benchReader finished. count=[%d]", cfg.BenchCount)
+		close(r.ch)
+	}()
+	return r.ch
+}
+
+// next returns the commands of one generated write, a command to set ttl follows
+// when the key has ttl.
+func (r *benchReader) next() [][]string {
+	cfg := &config.Config.Source
+	n := r.rand.Intn(r.total)
+	typ := r.types[sort.SearchInts(r.weights, n+1)]
+	key := benchKeyPrefix + typ + ":" + strconv.FormatUint(uint64(r.rand.Int63())%cfg.BenchKeySpace, 10)
+
+	var argv []string
+	switch typ {
+	case "string":
+		argv = []string{"SET", key, r.value()}
+	case "list":
+		argv = append([]string{"RPUSH", key}, r.values(cfg.BenchElements)...)
+	case "set":
+		argv = append([]string{"SADD", key}, r.values(cfg.BenchElements)...)
+	case "hash":
+		argv = []string{"HMSET", key}
+		for i := 0; i < cfg.BenchElements; i++ {
+			argv = append(argv, "field:"+strconv.Itoa(r.rand.Intn(cfg.BenchElements*10)), r.value())
+		}
+	case "zset":
+		argv = []string{"ZADD", key}
+		for _, member := range r.values(cfg.BenchElements) {
+			argv = append(argv, strconv.FormatFloat(r.rand.Float64()*1000, 'f', 3, 64), member)
+		}
+	}
+	cmds := [][]string{argv}
+	if r.rand.Float64() < cfg.BenchTTLRatio {
+		cmds = append(cmds, []string{"EXPIRE", key, strconv.Itoa(cfg.BenchTTL)})
+	}
+	return cmds
+}
+
+const benchLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func (r *benchReader) value() string {
+	buf := make([]byte, config.Config.Source.BenchValueSize)
+	for i := range buf {
+		buf[i] = benchLetters[r.rand.Intn(len(benchLetters))]
+	}
+	return string(buf)
+}
+
+func (r *benchReader) values(n int) []string {
+	values := make([]string, n)
+	for i := range values {
+		values[i] = r.value()
+	}
+	return values
+}
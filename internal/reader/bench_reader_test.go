@@ -0,0 +1,64 @@
+package reader
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBenchReader(t *testing.T) {
+	saved := config.Config.Source
+	defer func() { config.Config.Source = saved }()
+	cfg := &config.Config.Source
+	cfg.BenchCount = 200
+	cfg.BenchTypes = map[string]int{"string": 1, "hash": 1, "zset": 0}
+	cfg.BenchValueSize = 8
+	cfg.BenchElements = 2
+	cfg.BenchKeySpace = 10
+	cfg.BenchTTLRatio = 1
+	cfg.BenchTTL = 60
+
+	read := func() [][]string {
+		var cmds [][]string
+		for e := range NewBenchReader().StartRead() {
+			cmds = append(cmds, e.Argv)
+		}
+		return cmds
+	}
+	cmds := read()
+	if len(cmds) != 400 {
+		t.Fatalf("commands=[%d], want a write and an EXPIRE for each of the 200 writes", len(cmds))
+	}
+	counts := map[string]int{}
+	keys := map[string]bool{}
+	for i := 0; i < len(cmds); i += 2 {
+		write, expire := cmds[i], cmds[i+1]
+		counts[write[0]]++
+		keys[write[1]] = true
+		switch write[0] {
+		case "SET":
+			if len(write) != 3 || len(write[2]) != 8 {
+				t.Errorf("unexpected SET. argv=%v", write)
+			}
+		case "HMSET":
+			if len(write) != 6 {
+				t.Errorf("unexpected HMSET, want 2 fields. argv=%v", write)
+			}
+		default:
+			t.Errorf("unexpected command of a type not in bench_types. argv=%v", write)
+		}
+		if !strings.HasPrefix(write[1], benchKeyPrefix) || !reflect.DeepEqual(expire, []string{"EXPIRE", write[1], "60"}) {
+			t.Errorf("unexpected key or EXPIRE. write=%v, expire=%v", write, expire)
+		}
+	}
+	if counts["SET"] < 50 || counts["HMSET"] < 50 {
+		t.Errorf("types not mixed by their weights. counts=%v", counts)
+	}
+	if len(keys) > 20 {
+		t.Errorf("keys=[%d], want at most 10 per type", len(keys))
+	}
+	if !reflect.DeepEqual(read(), cmds) {
+		t.Errorf("the workload should be the same every run")
+	}
+}
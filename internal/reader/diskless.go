@@ -0,0 +1,62 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"io"
+)
+
+// rdbEOFMarkLen is the length of the delimiter of a diskless rdb, which is
+// sent as $EOF:<mark>\r\n<rdb><mark> instead of $<length>\r\n<rdb>.
+const rdbEOFMarkLen = 40
+
+// eofMarkReader reads the rdb of a diskless full sync up to the mark and not
+// beyond, the replication stream follows it on the same connection.
+type eofMarkReader struct {
+	rd       *bufio.Reader
+	mark     []byte
+	received uint64
+	done     bool
+}
+
+func newEOFMarkReader(rd *bufio.Reader, mark []byte) *eofMarkReader {
+	return &eofMarkReader{rd: rd, mark: mark}
+}
+
+func (r *eofMarkReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	// only the buffered bytes are searched, the mark is always ahead so
+	// peeking its length does not block on the replication stream
+	n := r.rd.Buffered()
+	if n < len(r.mark) {
+		n = len(r.mark)
+	}
+	buf, err := r.rd.Peek(n)
+	if err != nil {
+		return 0, err
+	}
+	if i := bytes.Index(buf, r.mark); i == 0 {
+		_, _ = r.rd.Discard(len(r.mark))
+		r.done = true
+		return 0, io.EOF
+	} else if i > 0 {
+		n = i
+	} else {
+		n = len(buf) - len(r.mark) + 1 // the tail may be the start of the mark
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	if limit := control.ReadLimit.Rate(); limit > 0 && n > limit/10+1 {
+		n = limit/10 + 1 // at most 100ms of bytes at a time
+	}
+	n, err = r.rd.Read(p[:n])
+	control.ReadLimit.WaitN(n)
+	r.received += uint64(n)
+	statistics.UpdateRDBReceivedSize(r.received)
+	return n, err
+}
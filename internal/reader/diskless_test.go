@@ -0,0 +1,33 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEOFMarkReader(t *testing.T) {
+	mark := []byte(strings.Repeat("m", rdbEOFMarkLen))
+	// the rdb holds the first bytes of the mark, which must not end it
+	rdb := append([]byte("REDIS0009"), mark[:rdbEOFMarkLen-1]...)
+	rdb = append(rdb, "tail"...)
+	stream := "*1\r\n$4\r\nPING\r\n"
+	input := append(append(append([]byte(nil), rdb...), mark...), stream...)
+
+	for _, size := range []int{48, 64, 4096} { // the mark split over several buffers or not
+		rd := bufio.NewReaderSize(bytes.NewReader(input), size)
+		got, err := ioutil.ReadAll(newEOFMarkReader(rd, mark))
+		if err != nil {
+			t.Fatalf("read rdb failed. buffer=[%d], error=[%v]", size, err)
+		}
+		if !bytes.Equal(got, rdb) {
+			t.Errorf("unexpected rdb. buffer=[%d], got=[%q]", size, got)
+		}
+		rest, _ := ioutil.ReadAll(rd)
+		if string(rest) != stream {
+			t.Errorf("the replication stream after the mark is not left unread. buffer=[%d], rest=[%q]", size, rest)
+		}
+	}
+}
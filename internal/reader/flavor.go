@@ -0,0 +1,60 @@
+package reader
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// unwrapRReplay returns the command wrapped by KeyDB active replication:
+// RREPLAY <uuid> <command in RESP> <db> [mvcc]
+func unwrapRReplay(argv []string) ([]string, int, error) {
+	if len(argv) < 4 {
+		return nil, 0, fmt.Errorf("invalid RREPLAY. argv=%v", argv)
+	}
+	reply, err := proto.NewReader(bufio.NewReader(strings.NewReader(argv[2]))).ReadReply()
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid RREPLAY command. argv=%v, error=[%v]", argv, err)
+	}
+	inner := client.ArrayString(reply, nil)
+	dbId, err := strconv.Atoi(argv[3])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid RREPLAY db. argv=%v", argv)
+	}
+	return inner, dbId, nil
+}
+
+// canFallbackToScan reports whether a refused PSYNC should switch to scan
+// mode: only for KeyDB and Dragonfly sources, before anything is synced.
+func (r *psyncReader) canFallbackToScan() bool {
+	return config.Config.Source.Flavor != "redis" && r.replId == ""
+}
+
+// fallbackToScan reads the source by SCAN and DUMP, when the source refused
+// PSYNC. The type in config stays sync, the fallback is a state of the reader.
+func (r *psyncReader) fallbackToScan(reply string) {
+	log.Warnf("psyncReader PSYNC refused by %s source, fall back to scan mode. address=[%s], reply=[%s]",
+		config.Config.Source.Flavor, r.address, reply)
+	r.clientMu.Lock()
+	r.client.Close()
+	r.clientMu.Unlock()
+	r.forwardScan(NewScanReader(r.address, r.username, r.password, r.isTls))
+}
+
+// forwardScan sends the entries of scan, the reader replacing PSYNC, to the
+// channel of r.
+func (r *psyncReader) forwardScan(scan Reader) {
+	atomic.StoreInt32(&r.scanFallback, 1)
+	statistics.SetScanProgress(true)
+	for e := range scan.StartRead() {
+		r.ch <- e
+	}
+	close(r.ch)
+}
@@ -0,0 +1,59 @@
+package reader
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"reflect"
+	"testing"
+)
+
+func TestUnwrapRReplay(t *testing.T) {
+	argv, dbId, err := unwrapRReplay([]string{"RREPLAY", "uuid", "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n", "3", "42"})
+	if err != nil || dbId != 3 || !reflect.DeepEqual(argv, []string{"SET", "k", "v"}) {
+		t.Errorf("unexpected unwrap. argv=%v, db=[%d], error=[%v]", argv, dbId, err)
+	}
+	for _, invalid := range [][]string{
+		{"RREPLAY", "uuid", "*1\r\n$4\r\nPING\r\n"},      // no db
+		{"RREPLAY", "uuid", "*1\r\n$4\r\nPING\r\n", "x"}, // db is not a number
+		{"RREPLAY", "uuid", "not resp", "0"},             // command is not RESP
+	} {
+		if _, _, err := unwrapRReplay(invalid); err == nil {
+			t.Errorf("invalid RREPLAY accepted. argv=%q", invalid)
+		}
+	}
+}
+
+// entriesReader sends its entries and closes the channel.
+type entriesReader []*entry.Entry
+
+func (r entriesReader) StartRead() chan *entry.Entry {
+	ch := make(chan *entry.Entry, len(r))
+	for _, e := range r {
+		ch <- e
+	}
+	close(ch)
+	return ch
+}
+
+func TestForwardScan(t *testing.T) {
+	defer statistics.SetScanProgress(false)
+	r := &psyncReader{ch: make(chan *entry.Entry, 10)}
+	sent := entriesReader{{Argv: []string{"RESTORE", "a", "0", "payload"}}, {Argv: []string{"RESTORE", "b", "0", "payload"}}}
+	typ := config.Config.Type
+	r.forwardScan(sent)
+
+	var got []*entry.Entry
+	for e := range r.ch {
+		got = append(got, e)
+	}
+	if !reflect.DeepEqual(got, []*entry.Entry(sent)) {
+		t.Errorf("entries of the scan not forwarded. entries=[%d]", len(got))
+	}
+	if config.Config.Type != typ {
+		t.Errorf("fallback changed the type in config. type=[%s]", config.Config.Type)
+	}
+	if r.scanFallback != 1 {
+		t.Errorf("fallback state not kept in the reader")
+	}
+}
@@ -5,3 +5,9 @@ import "github.com/alibaba/RedisShake/internal/entry"
 type Reader interface {
 	StartRead() chan *entry.Entry
 }
+
+// Closer is implemented by the readers that have files to close when
+// redis-shake stops before the end of the source.
+type Closer interface {
+	Close()
+}
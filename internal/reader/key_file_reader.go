@@ -0,0 +1,219 @@
+package reader
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// keyFileReader migrates the keys listed in key_file from the source by DUMP
+// and RESTORE ... REPLACE, to repair the keys found inconsistent by verify.
+type keyFileReader struct {
+	path    string
+	address string
+	client  *client.Redis
+	ch      chan *entry.Entry
+	stats   *statistics.ShardMetrics
+}
+
+// keyFileLine is a key of key_file, written to the target as targetKey.
+type keyFileLine struct {
+	db        int
+	key       string
+	targetKey string
+}
+
+func NewKeyFileReader(path string, address string, username string, password string, isTls bool) Reader {
+	if _, err := os.Stat(path); err != nil {
+		log.Panicf("keyFileReader open key_file failed. path=[%s], error=[%v]", path, err)
+	}
+	r := &keyFileReader{path: path, address: address}
+	r.stats = statistics.RegisterShard(statistics.RoleReader, address)
+	r.client = client.NewRedisClient(address, username, password, isTls)
+	log.Infof("keyFileReader connected to redis successful. address=[%s], key_file=[%s]", address, path)
+	statistics.SetSourceConnected(true)
+	return r
+}
+
+func (r *keyFileReader) StartRead() chan *entry.Entry {
+	r.ch = make(chan *entry.Entry, 1024)
+	go r.read()
+	return r.ch
+}
+
+func (r *keyFileReader) read() {
+	file, err := os.Open(r.path)
+	if err != nil {
+		log.PanicError(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 512*1024*1024)
+	dbId := 0
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line, ok, err := parseKeyFileLine(scanner.Text())
+		if err != nil {
+			log.Panicf("keyFileReader parse key_file failed. path=[%s], line=[%d], error=[%v]", r.path, lineNo, err)
+		}
+		if !ok {
+			continue
+		}
+		control.ReadPause.Wait()
+		if line.db != dbId {
+			if _, err := r.client.Do("SELECT", strconv.Itoa(line.db)); err != nil {
+				log.Panicf("keyFileReader select db failed. db=[%d], error=[%v]", line.db, err)
+			}
+			dbId = line.db
+		}
+		r.migrate(line)
+		statistics.UpdateScanPosition(dbId, 0)
+		statistics.AddKeyFileKeysCount()
+	}
+	if err := scanner.Err(); err != nil {
+		log.Panicf("keyFileReader read key_file failed. path=[%s], line=[%d], error=[%v]", r.path, lineNo, err)
+	}
+	log.Infof("keyFileReader finished. address=[%s], keys=[%d]", r.address, statistics.LoadMetrics().KeyFileKeysCount)
+	close(r.ch)
+}
+
+func (r *keyFileReader) migrate(line keyFileLine) {
+	send := func(argv []string) {
+		r.ch <- &entry.Entry{DbId: line.db, Argv: argv}
+	}
+	payload, pttl, exists, err := r.client.DumpWithTTL(line.key)
+	if err != nil {
+		log.Panicf("keyFileReader DUMP failed. db=[%d], key=[%s], error=[%v]", line.db, line.key, err)
+	}
+	if !exists {
+		// the key is gone on the source, it is deleted on the target too
+		log.Infof("keyFileReader key does not exist on source, deleted on target. db=[%d], key=[%s], target_key=[%s]", line.db, line.key, line.targetKey)
+		send([]string{"DEL", line.targetKey})
+		return
+	}
+	control.ReadLimit.WaitN(len(payload))
+	r.stats.AddReceived(uint64(len(payload)), 0)
+	if pttl < 0 {
+		pttl = 0
+	}
+
+	if !capability.Target.CanRestore() {
+		// the target can not RESTORE, the value is rewritten into commands
+		send([]string{"DEL", line.targetKey})
+		for _, cmd := range rdb.RewriteDump(line.targetKey, payload) {
+			send(cmd)
+		}
+		if pttl > 0 {
+			send([]string{"PEXPIRE", line.targetKey, strconv.FormatInt(pttl, 10)})
+		}
+		return
+	}
+	send([]string{"RESTORE", line.targetKey, strconv.FormatInt(pttl, 10), payload, "REPLACE"})
+}
+
+// parseKeyFileLine parses a line of key_file:
+//
+//	[db=<db>] <key> [<target key>]
+//
+// Keys with spaces or quotes are quoted like Go strings. Lines of
+// verify_result.log (db=0 key="k" reason=...) are accepted too, the rest of
+// the line after key= is not parsed, it holds values of the user. The extra
+// keys of the target in it are skipped. ok is false for empty lines and
+// comments starting with #.
+func parseKeyFileLine(text string) (line keyFileLine, ok bool, err error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "target ") {
+		return line, false, nil
+	}
+	field, text, err := nextKeyFileField(text)
+	if err != nil {
+		return line, false, err
+	}
+	if strings.HasPrefix(field, "db=") {
+		if line.db, err = strconv.Atoi(field[len("db="):]); err != nil || line.db < 0 {
+			return line, false, fmt.Errorf("bad db: %s", field)
+		}
+		if text == "" {
+			return line, false, fmt.Errorf("key is missing")
+		}
+		if field, text, err = nextKeyFileField(text); err != nil {
+			return line, false, err
+		}
+	}
+	if strings.HasPrefix(field, `key="`) {
+		// a line of verify_result.log
+		if line.key, err = unquoteKey(field[len("key="):]); err != nil {
+			return line, false, err
+		}
+		line.targetKey = line.key
+		return line, true, nil
+	}
+	if line.key, err = unquoteKey(field); err != nil {
+		return line, false, err
+	}
+	line.targetKey = line.key
+	if text != "" {
+		if field, text, err = nextKeyFileField(text); err != nil {
+			return line, false, err
+		}
+		if line.targetKey, err = unquoteKey(field); err != nil {
+			return line, false, err
+		}
+	}
+	if text != "" {
+		return line, false, fmt.Errorf("more than a key and a target key, quote keys with spaces")
+	}
+	return line, true, nil
+}
+
+// nextKeyFileField splits the first field of text, quoted fields may hold
+// spaces. rest has no leading spaces.
+func nextKeyFileField(text string) (field string, rest string, err error) {
+	if prefix := quotePrefix(text); prefix >= 0 {
+		quoted, err := strconv.QuotedPrefix(text[prefix:])
+		if err != nil {
+			return "", "", fmt.Errorf("bad quoted key: %v", err)
+		}
+		field = text[:prefix] + quoted
+	} else if i := strings.IndexAny(text, " \t"); i >= 0 {
+		field = text[:i]
+	} else {
+		field = text
+	}
+	return field, strings.TrimLeft(text[len(field):], " \t"), nil
+}
+
+// unquoteKey unquotes a key quoted like a Go string, other keys are returned
+// as they are.
+func unquoteKey(field string) (string, error) {
+	if !strings.HasPrefix(field, `"`) {
+		return field, nil
+	}
+	key, err := strconv.Unquote(field)
+	if err != nil {
+		return "", fmt.Errorf("bad quoted key: %s", field)
+	}
+	return key, nil
+}
+
+// quotePrefix returns where the quoted string of a field starts, -1 if the
+// field is not quoted.
+func quotePrefix(text string) int {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		return 0
+	case strings.HasPrefix(text, `key="`):
+		return len("key=")
+	}
+	return -1
+}
@@ -0,0 +1,112 @@
+package reader
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseKeyFileLine(t *testing.T) {
+	cases := []struct {
+		text string
+		ok   bool
+		err  bool
+		line keyFileLine
+	}{
+		{text: "", ok: false},
+		{text: "  # comment", ok: false},
+		{text: `target db=0 key="extra" reason=extra key`, ok: false},
+		{text: "k", ok: true, line: keyFileLine{db: 0, key: "k", targetKey: "k"}},
+		{text: "db=3 k", ok: true, line: keyFileLine{db: 3, key: "k", targetKey: "k"}},
+		{text: "db=1 k new:k", ok: true, line: keyFileLine{db: 1, key: "k", targetKey: "new:k"}},
+		{text: `"a b" "c \"d\""`, ok: true, line: keyFileLine{db: 0, key: "a b", targetKey: `c "d"`}},
+		{text: `db=2 key="a b" reason=value differs`, ok: true, line: keyFileLine{db: 2, key: "a b", targetKey: "a b"}},
+		// reason holds values of the user, an unbalanced quote in it is valid
+		{text: `db=0 key="k" reason=field "f is missing`, ok: true, line: keyFileLine{db: 0, key: "k", targetKey: "k"}},
+		{text: `key="k" reason="`, ok: true, line: keyFileLine{db: 0, key: "k", targetKey: "k"}},
+		{text: "db=x k", err: true},
+		{text: "db=-1 k", err: true},
+		{text: "db=0", err: true},
+		{text: "a b c", err: true},
+		{text: `"a b`, err: true},
+		{text: `key="a`, err: true},
+	}
+	for _, c := range cases {
+		line, ok, err := parseKeyFileLine(c.text)
+		if (err != nil) != c.err {
+			t.Errorf("text=[%s], error=[%v]", c.text, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if ok != c.ok || (ok && line != c.line) {
+			t.Errorf("text=[%s], ok=[%v], line=[%+v], want ok=[%v], line=[%+v]", c.text, ok, line, c.ok, c.line)
+		}
+	}
+}
+
+func TestKeyFileReader(t *testing.T) {
+	// the source holds k1 in db 0 and k in db 2, k2 is missing
+	values := map[string]string{"0 k1": "payload-k1", "2 k": "payload-k"}
+	var mu sync.Mutex
+	db := "0"
+	key := ""
+	server := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToUpper(argv[0]) {
+		case "SELECT":
+			db = argv[1]
+			return clienttest.OK
+		case "MULTI":
+			return clienttest.OK
+		case "DUMP", "PTTL":
+			key = argv[1]
+			return "+QUEUED\r\n"
+		case "EXEC":
+			payload, ok := values[db+" "+key]
+			if !ok {
+				return clienttest.Array(clienttest.Nil, clienttest.Int(-2))
+			}
+			return clienttest.Array(clienttest.Bulk(payload), clienttest.Int(1500))
+		}
+		return clienttest.Error("ERR unknown command")
+	}))
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(path, []byte("k1\n# comment\nk2\ndb=2 k new:k\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keys := statistics.LoadMetrics().KeyFileKeysCount
+	stats := statistics.RegisterShard(statistics.RoleReader, server.Addr())
+	bytes := stats.Bytes
+
+	var got [][]string
+	var dbs []int
+	for e := range NewKeyFileReader(path, server.Addr(), "", "", false).StartRead() {
+		got = append(got, e.Argv)
+		dbs = append(dbs, e.DbId)
+	}
+	want := [][]string{
+		{"RESTORE", "k1", "1500", "payload-k1", "REPLACE"},
+		{"DEL", "k2"},
+		{"RESTORE", "new:k", "1500", "payload-k", "REPLACE"},
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(dbs, []int{0, 0, 2}) {
+		t.Fatalf("entries=%q, dbs=%v, want entries=%q, dbs=[0 0 2]", got, dbs, want)
+	}
+	if cmds := server.Commands(); len(cmds) != 13 || !reflect.DeepEqual(cmds[8], []string{"SELECT", "2"}) {
+		t.Errorf("commands=%q, want SELECT 2 before the key of db 2", server.Commands())
+	}
+	if n := statistics.LoadMetrics().KeyFileKeysCount - keys; n != 3 {
+		t.Errorf("key_file_keys_count increased by %d, want 3", n)
+	}
+	if n := stats.Bytes - bytes; n != uint64(len("payload-k1")+len("payload-k")) {
+		t.Errorf("received bytes increased by %d, want the size of the payloads", n)
+	}
+}
@@ -3,36 +3,58 @@ package reader
 import (
 	"bufio"
 	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
 	"github.com/alibaba/RedisShake/internal/entry"
 	"github.com/alibaba/RedisShake/internal/log"
 	"github.com/alibaba/RedisShake/internal/rdb"
 	"github.com/alibaba/RedisShake/internal/reader/rotate"
 	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/utils"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type psyncReader struct {
-	client  *client.Redis
-	address string
-	ch      chan *entry.Entry
-	DbId    int
+	client   *client.Redis
+	clientMu sync.Mutex // client is replaced when reconnecting
+	address  string
+	username string
+	password string
+	isTls    bool
+	ch       chan *entry.Entry
+	DbId     int
 
 	rd               *bufio.Reader
+	replId           string
 	receivedOffset   int64
+	fullSyncOffset   int64 // offset of the last full sync, acked until aof is applied
 	elastiCachePSync string
+	replicating      int32  // 1 after the psync handshake, replconf ack is only sent then
+	psyncRefused     string // the error reply to PSYNC when falling back to scan
+	scanFallback     int32  // 1 once the source is read by SCAN, see fallbackToScan
+	disklessMark     []byte // the rdb is parsed from the socket up to this mark, see saveRDB
+	timeline         *offsetTimeline
+	stats            *statistics.ShardMetrics
+	rec              atomic.Value // *recorder of record_dir, closed by Close
 }
 
 func NewPSyncReader(address string, username string, password string, isTls bool, ElastiCachePSync string) Reader {
 	r := new(psyncReader)
 	r.address = address
+	r.username = username
+	r.password = password
+	r.isTls = isTls
 	r.elastiCachePSync = ElastiCachePSync
-	r.client = client.NewRedisClient(address, username, password, isTls)
-	r.rd = r.client.BufioReader()
+	r.stats = statistics.RegisterShard(statistics.RoleReader, address)
+	r.setClient(client.NewRedisClient(address, username, password, isTls))
 	log.Infof("psyncReader connected to redis successful. address=[%s]", address)
 	return r
 }
@@ -41,26 +63,206 @@ func (r *psyncReader) StartRead() chan *entry.Entry {
 	r.ch = make(chan *entry.Entry, 1024)
 
 	go func() {
-		r.clearDir()
+		resumed := r.resumeRDB()
+		r.clearDir(resumed)
 		go r.sendReplconfAck()
-		r.saveRDB()
-		startOffset := r.receivedOffset
-		go r.saveAOF(r.rd)
-		r.sendRDB()
-		time.Sleep(1 * time.Second) // wait for saveAOF create aof file
-		r.sendAOF(startOffset)
+		for {
+			fullResync := make(chan struct{}) // closed by saveAOF when partial resync is refused
+			partial := resumed
+			if resumed {
+				resumed = false
+			} else {
+				r.saveRDBWithRetry()
+			}
+			if r.psyncRefused != "" {
+				r.fallbackToScan(r.psyncRefused)
+				return
+			}
+			startOffset := r.receivedOffset
+			atomic.StoreInt64(&r.fullSyncOffset, startOffset)
+			r.timeline = new(offsetTimeline)
+			var rec *recorder
+			if dir := config.Config.Advanced.RecordDir; dir != "" {
+				rec = newRecorder(dir, rdbFilePath(), r.replId, startOffset)
+				if partial {
+					// the rdb of the last run goes on by a partial resync
+					rec.resync(startOffset, r.replId, time.Now())
+				}
+			}
+			r.rec.Store(rec)
+			if r.disklessMark != nil {
+				// the stream follows the rdb on the socket
+				r.sendRDBStream()
+				go r.saveAOF(r.rd, fullResync, rec)
+			} else {
+				go r.saveAOF(r.rd, fullResync, rec)
+				r.sendRDB()
+			}
+			time.Sleep(1 * time.Second) // wait for saveAOF create aof file
+			r.sendAOF(startOffset, fullResync)
+
+			// sendAOF returns only when the source refused partial resync
+			if !config.Config.Advanced.FlushTarget && config.Config.Filter.Namespace != "" {
+				log.Panicf("psyncReader partial resync refused by source, the target is shared by namespaces and can not be flushed for a new full sync, delete the keys of the namespace on the target and restart. address=[%s], namespace=[%s]", r.address, config.Config.Filter.Namespace)
+			}
+			if !config.Config.Advanced.FlushTarget {
+				log.Panicf("psyncReader partial resync refused by source, a new full sync would keep on the target the keys deleted on the source meanwhile, set flush_target to flush the target before it. address=[%s]", r.address)
+			}
+			log.Warnf("psyncReader start a new full sync, the target is flushed first. address=[%s]", r.address)
+			r.ch <- &entry.Entry{Argv: []string{"FLUSHALL"}, IsFlush: true}
+			r.clearDir(false)
+			statistics.ResetAOFAppliedOffset()
+			r.reconnect()
+		}
 	}()
 
 	return r.ch
 }
 
-func (r *psyncReader) clearDir() {
+// Close closes the recording of record_dir, so that its last point is kept.
+func (r *psyncReader) Close() {
+	if rec, ok := r.rec.Load().(*recorder); ok && rec != nil {
+		rec.close()
+	}
+}
+
+func (r *psyncReader) setClient(c *client.Redis) {
+	r.clientMu.Lock()
+	defer r.clientMu.Unlock()
+	if r.client != nil {
+		r.client.Close()
+	}
+	r.client = c
+	r.rd = c.BufioReader()
+	// the source sends PING or newline at least every repl-ping-replica-period
+	r.client.SetReadTimeout(time.Duration(config.Config.Advanced.ReplTimeout) * time.Second)
+}
+
+// do sends argv to the source and reads the reply under clientMu, sendAck
+// writes to the same connection.
+func (r *psyncReader) do(argv ...string) (interface{}, error) {
+	r.clientMu.Lock()
+	defer r.clientMu.Unlock()
+	return r.client.Do(argv...)
+}
+
+// reconnect dials the source until success.
+func (r *psyncReader) reconnect() {
+	atomic.StoreInt32(&r.replicating, 0)
+	statistics.SetSourceConnected(false)
+	backoff := utils.NewBackoff(time.Duration(config.Config.Advanced.ReconnectMaxBackoff) * time.Second)
+	for {
+		c, err := client.DialRedisClient(r.address, r.username, r.password, r.isTls)
+		if err == nil {
+			r.setClient(c)
+			log.Infof("psyncReader reconnected to redis successful. address=[%s]", r.address)
+			return
+		}
+		log.Warnf("psyncReader reconnect failed. address=[%s], error=[%v]", r.address, err)
+		backoff.Wait()
+	}
+}
+
+// saveRDBWithRetry retries the full sync on errors when sync_forever is enabled.
+func (r *psyncReader) saveRDBWithRetry() {
+	if !config.Config.Advanced.SyncForever {
+		r.saveRDB()
+		return
+	}
+	backoff := utils.NewBackoff(time.Duration(config.Config.Advanced.ReconnectMaxBackoff) * time.Second)
+	for !r.trySaveRDB() {
+		backoff.Wait()
+		r.reconnect()
+	}
+}
+
+func (r *psyncReader) trySaveRDB() (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Warnf("psyncReader save RDB failed, retry later. address=[%s], error=[%v]", r.address, err)
+			ok = false
+		}
+	}()
+	r.saveRDB()
+	return true
+}
+
+// partialResync reconnects to the source and continues the replication from
+// receivedOffset. It returns false if the source requires a full resync.
+func (r *psyncReader) partialResync() bool {
+	backoff := utils.NewBackoff(time.Duration(config.Config.Advanced.ReconnectMaxBackoff) * time.Second)
+	for {
+		r.reconnect()
+		_, _ = r.do("replconf", "listening-port", "10007")
+		argv := []string{"PSYNC", r.replId, strconv.FormatInt(r.receivedOffset+1, 10)}
+		if r.elastiCachePSync != "" {
+			argv[0] = r.elastiCachePSync
+		}
+		log.Infof("send %v", argv)
+		reply, err := r.do(argv...)
+		if err != nil {
+			log.Warnf("psyncReader partial resync failed. address=[%s], error=[%v]", r.address, err)
+			backoff.Wait()
+			continue
+		}
+		replyStr, _ := reply.(string)
+		if strings.HasPrefix(replyStr, "CONTINUE") {
+			// the source may change replid after failover
+			if words := strings.Split(replyStr, " "); len(words) == 2 {
+				r.replId = words[1]
+			}
+			atomic.StoreInt32(&r.replicating, 1)
+			statistics.SetSourceConnected(true)
+			log.Infof("psyncReader partial resync successful. address=[%s], offset=[%d]", r.address, r.receivedOffset)
+			return true
+		}
+		log.Warnf("psyncReader partial resync refused by source. address=[%s], reply=[%v]", r.address, reply)
+		return false
+	}
+}
+
+// resumeRDB continues the full sync of the last run from its dump.rdb if
+// rdb_resume is set and the source still has the stream after it in the
+// backlog. The target was flushed at the start, see flush_target, so the rdb
+// and the stream are sent again from the offset of the rdb. A partially
+// downloaded rdb is not continued, redis always sends an rdb from its start,
+// so the full sync starts again then.
+func (r *psyncReader) resumeRDB() bool {
+	if !config.Config.Advanced.RDBResume {
+		return false
+	}
+	meta, ok := readRDBMeta()
+	if !ok {
+		if _, err := os.Stat(rdbFilePath()); err == nil {
+			log.Warnf("dump.rdb of the last run is a partial download, it can not be resumed and is downloaded again. file=[%s]", rdbFilePath())
+		}
+		return false
+	}
+	log.Infof("resuming the full sync from dump.rdb. repl_id=[%s], offset=[%d]", meta.ReplId, meta.Offset)
+	r.replId = meta.ReplId
+	atomic.StoreInt64(&r.receivedOffset, meta.Offset)
+	if !r.partialResync() {
+		r.reconnect()
+		return false
+	}
+	return true
+}
+
+// clearDir removes the rdb and aof files of the last run, dump.rdb is kept if
+// keepRDB.
+func (r *psyncReader) clearDir(keepRDB bool) {
+	if !keepRDB {
+		removeRDBFile()
+	}
 	files, err := ioutil.ReadDir("./")
 	if err != nil {
 		log.PanicError(err)
 	}
 
 	for _, f := range files {
+		if keepRDB && f.Name() == rdbFilePath() {
+			continue
+		}
 		if strings.HasSuffix(f.Name(), ".rdb") || strings.HasSuffix(f.Name(), ".aof") {
 			err = os.Remove(f.Name())
 			if err != nil {
@@ -103,6 +305,10 @@ func (r *psyncReader) saveRDB() {
 				log.PanicError(err)
 			}
 			reply = strings.TrimSpace(reply)
+			if r.canFallbackToScan() {
+				r.psyncRefused = reply
+				return
+			}
 			log.Panicf("psync error. address=[%s], reply=[%s]", r.address, reply)
 		}
 		if b != '+' {
@@ -116,14 +322,23 @@ func (r *psyncReader) saveRDB() {
 	}
 	reply = strings.TrimSpace(reply)
 	log.Infof("receive [%s]", reply)
-	masterOffset, err := strconv.Atoi(strings.Split(reply, " ")[2])
+	words := strings.Split(reply, " ")
+	if len(words) != 3 {
+		log.Panicf("invalid psync reply. address=[%s], reply=[%s]", r.address, reply)
+	}
+	r.replId = words[1]
+	masterOffset, err := strconv.Atoi(words[2])
 	if err != nil {
 		log.PanicError(err)
 	}
-	r.receivedOffset = int64(masterOffset)
+	atomic.StoreInt64(&r.receivedOffset, int64(masterOffset))
+	// acked while the rdb is received and parsed, not the offset of the last full sync
+	atomic.StoreInt64(&r.fullSyncOffset, int64(masterOffset))
+	atomic.StoreInt32(&r.replicating, 1)
+	statistics.SetSourceConnected(true)
 
 	log.Infof("source db is doing bgsave. address=[%s]", r.address)
-	statistics.Metrics.IsDoingBgsave = true
+	statistics.SetDoingBgsave(true)
 
 	timeStart := time.Now()
 	// format: \n\n\n$<length>\r\n<rdb>
@@ -141,13 +356,18 @@ func (r *psyncReader) saveRDB() {
 		}
 		break
 	}
-	statistics.Metrics.IsDoingBgsave = false
+	statistics.SetDoingBgsave(false)
 	log.Infof("source db bgsave finished. timeUsed=[%.2f]s, address=[%s]", time.Since(timeStart).Seconds(), r.address)
 	lengthStr, err := r.rd.ReadString('\n')
 	if err != nil {
 		log.PanicError(err)
 	}
 	lengthStr = strings.TrimSpace(lengthStr)
+	r.disklessMark = nil
+	if strings.HasPrefix(lengthStr, "EOF:") {
+		r.saveDisklessRDB([]byte(strings.TrimPrefix(lengthStr, "EOF:")))
+		return
+	}
 	length, err := strconv.ParseInt(lengthStr, 10, 64)
 	if err != nil {
 		log.PanicError(err)
@@ -155,13 +375,7 @@ func (r *psyncReader) saveRDB() {
 	log.Infof("received rdb length. length=[%d]", length)
 	statistics.SetRDBFileSize(uint64(length))
 
-	// create rdb file
-	rdbFilePath := "dump.rdb"
-	log.Infof("create dump.rdb file. filename_path=[%s]", rdbFilePath)
-	rdbFileHandle, err := os.OpenFile(rdbFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		log.PanicError(err)
-	}
+	rdbFileHandle := createRDBFile(length)
 
 	// read rdb
 	remainder := length
@@ -172,10 +386,14 @@ func (r *psyncReader) saveRDB() {
 		if remainder < readOnce {
 			readOnce = remainder
 		}
+		if limit := int64(control.ReadLimit.Rate()); limit > 0 && readOnce > limit/10+1 {
+			readOnce = limit/10 + 1 // at most 100ms of bytes at a time, so the link is not used in bursts
+		}
 		n, err := r.rd.Read(buf[:readOnce])
 		if err != nil {
 			log.PanicError(err)
 		}
+		control.ReadLimit.WaitN(n)
 		remainder -= int64(n)
 		statistics.UpdateRDBReceivedSize(uint64(length - remainder))
 		_, err = rdbFileHandle.Write(buf[:n])
@@ -183,26 +401,73 @@ func (r *psyncReader) saveRDB() {
 			log.PanicError(err)
 		}
 	}
-	err = rdbFileHandle.Close()
+	rdbFileHandle.Close(r.replId, r.receivedOffset)
+	log.Infof("save RDB finished. address=[%s], total_bytes=[%d]", r.address, length)
+}
+
+// saveDisklessRDB handles the rdb of a source with repl-diskless-sync, which
+// has no length and ends with mark. It is parsed from the socket by
+// sendRDBStream, or written to dump.rdb if diskless_spill_to_disk or
+// record_dir is set.
+func (r *psyncReader) saveDisklessRDB(mark []byte) {
+	if len(mark) != rdbEOFMarkLen {
+		log.Panicf("invalid diskless rdb mark. address=[%s], mark=[%s]", r.address, mark)
+	}
+	statistics.SetRDBFileSize(0) // unknown until the end of the rdb
+	if !config.Config.Advanced.DisklessSpillToDisk && config.Config.Advanced.RecordDir == "" {
+		log.Infof("source is diskless, the rdb is parsed from the socket. address=[%s]", r.address)
+		r.disklessMark = mark
+		return
+	}
+	rdbFileHandle := createRDBFile(0)
+	n, err := io.Copy(rdbFileHandle, newEOFMarkReader(r.rd, mark))
 	if err != nil {
 		log.PanicError(err)
 	}
-	log.Infof("save RDB finished. address=[%s], total_bytes=[%d]", r.address, length)
+	statistics.SetRDBFileSize(uint64(n))
+	statistics.UpdateRDBReceivedSize(uint64(n))
+	rdbFileHandle.Close(r.replId, r.receivedOffset)
+	log.Infof("save RDB finished. address=[%s], total_bytes=[%d], diskless=[true]", r.address, n)
 }
 
-func (r *psyncReader) saveAOF(rd io.Reader) {
+// saveAOF also writes the stream to rec if it is not nil.
+func (r *psyncReader) saveAOF(rd io.Reader, fullResync chan struct{}, rec *recorder) {
 	log.Infof("start save AOF. address=[%s]", r.address)
 	// create aof file
 	aofWriter := rotate.NewAOFWriter(r.receivedOffset)
-	defer aofWriter.Close()
 	buf := make([]byte, 16*1024) // 16KB is enough for writing file
 	for {
+		control.ReadPause.Wait()
 		n, err := rd.Read(buf)
+		control.ReadLimit.WaitN(n)
 		if err != nil {
-			log.PanicError(err)
+			if !config.Config.Advanced.SyncForever {
+				log.PanicError(err)
+			}
+			log.Warnf("psyncReader read from source failed. address=[%s], error=[%v]", r.address, err)
+			if !r.partialResync() {
+				// close the files before the new full sync removes them, open
+				// files can not be removed on Windows
+				aofWriter.Close()
+				if rec != nil {
+					rec.close()
+				}
+				close(fullResync)
+				return
+			}
+			if rec != nil {
+				rec.resync(atomic.LoadInt64(&r.receivedOffset), r.replId, time.Now())
+			}
+			rd = r.rd
+			continue
 		}
-		r.receivedOffset += int64(n)
-		statistics.UpdateAOFReceivedOffset(uint64(r.receivedOffset))
+		received := atomic.AddInt64(&r.receivedOffset, int64(n))
+		now := time.Now()
+		r.timeline.add(received, now)
+		if rec != nil {
+			rec.write(buf[:n], received, now)
+		}
+		r.stats.AddReceived(uint64(n), received)
 		aofWriter.Write(buf[:n])
 	}
 }
@@ -210,17 +475,52 @@ func (r *psyncReader) saveAOF(rd io.Reader) {
 func (r *psyncReader) sendRDB() {
 	// start parse rdb
 	log.Infof("start send RDB. address=[%s]", r.address)
-	rdbLoader := rdb.NewLoader("dump.rdb", r.ch)
+	rdbLoader := rdb.NewLoader(rdbFilePath(), r.ch)
+	r.DbId = rdbLoader.ParseRDB()
+	log.Infof("send RDB finished. address=[%s], repl-stream-db=[%d]", r.address, r.DbId)
+	// with rdb_resume, it is kept for a restart until the next full sync
+	if !config.Config.Advanced.RDBResume {
+		removeRDBFile()
+	}
+}
+
+// sendRDBStream parses the rdb of a diskless source from the socket, the
+// replication stream is read after it.
+func (r *psyncReader) sendRDBStream() {
+	log.Infof("start send RDB from the socket. address=[%s]", r.address)
+	rdbLoader := rdb.NewStreamLoader(newEOFMarkReader(r.rd, r.disklessMark), r.ch)
 	r.DbId = rdbLoader.ParseRDB()
 	log.Infof("send RDB finished. address=[%s], repl-stream-db=[%d]", r.address, r.DbId)
 }
 
-func (r *psyncReader) sendAOF(offset int64) {
-	aofReader := rotate.NewAOFReader(offset)
+func (r *psyncReader) sendAOF(offset int64, fullResync chan struct{}) {
+	delay := time.Duration(config.Config.Advanced.ApplyDelay) * time.Second
+	if delay > 0 {
+		log.Infof("commands are applied [%v] behind the source", delay)
+	}
+	aofReader := rotate.NewAOFReader(offset, fullResync)
 	defer aofReader.Close()
-	r.client.SetBufioReader(bufio.NewReader(aofReader))
+	bufReader := bufio.NewReader(aofReader)
+	protoReader := proto.NewReader(bufReader)
 	for {
-		argv := client.ArrayString(r.client.Receive())
+		reply, err := protoReader.ReadReply()
+		if err != nil {
+			select {
+			case <-fullResync:
+				// the rest of aof is replaced by the new full sync
+				return
+			default:
+				log.PanicError(err)
+			}
+		}
+		argv := client.ArrayString(reply, nil)
+		dbId := r.DbId
+		if strings.EqualFold(argv[0], "rreplay") {
+			argv, dbId, err = unwrapRReplay(argv)
+			if err != nil {
+				log.PanicError(err)
+			}
+		}
 		// select
 		if strings.EqualFold(argv[0], "select") {
 			DbId, err := strconv.Atoi(argv[1])
@@ -230,18 +530,75 @@ func (r *psyncReader) sendAOF(offset int64) {
 			r.DbId = DbId
 			continue
 		}
+		// the source asks for an ack, answer it instead of writing to target
+		if len(argv) >= 2 && strings.EqualFold(argv[0], "replconf") && strings.EqualFold(argv[1], "getack") {
+			r.sendAck()
+			continue
+		}
 
 		e := entry.NewEntry()
 		e.Argv = argv
-		e.DbId = r.DbId
-		e.Offset = aofReader.Offset()
+		e.DbId = dbId
+		// offset of the end of this command, bytes buffered by bufio are not consumed yet
+		e.Offset = aofReader.Offset() - int64(bufReader.Buffered())
+		receivedAt := r.timeline.timeOf(e.Offset)
+		e.TimestampMs = uint64(receivedAt.UnixNano() / int64(time.Millisecond))
+		if wait := time.Until(receivedAt.Add(delay)); delay > 0 && wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-fullResync:
+				return
+			}
+		}
 		r.ch <- e
 	}
 }
 
+// sendReplconfAck is the keepalive of redis-shake as a replica. REPLCONF ACK
+// is sent every 100ms from the psync handshake on, also while the rdb is
+// received and parsed, so that a source with a small repl-timeout does not
+// drop the connection during a long full sync.
 func (r *psyncReader) sendReplconfAck() {
-	for range time.Tick(time.Millisecond * 100) {
-		// send ack receivedOffset
-		r.client.Send("replconf", "ack", strconv.FormatInt(r.receivedOffset, 10))
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadInt32(&r.scanFallback) == 1 {
+			return // the source is read by SCAN, there is no replication
+		}
+		r.sendAck()
+	}
+}
+
+// sendAck reports the processed offset to the source, that is the offset of
+// the last command answered by the target. Until the aof is applied, the offset
+// of the full sync is reported. With several writers, such as for cluster
+// targets, it is the offset all of them applied, see statistics.AddApplied.
+func (r *psyncReader) sendAck() {
+	if atomic.LoadInt32(&r.replicating) == 0 {
+		return
+	}
+	offset := r.ackOffset()
+	// errors are handled by saveAOF
+	r.clientMu.Lock()
+	var err error
+	if atomic.LoadInt32(&r.replicating) == 1 { // not reconnecting meanwhile
+		err = r.client.TrySend("replconf", "ack", strconv.FormatInt(offset, 10))
+	}
+	r.clientMu.Unlock()
+	if err != nil && !config.Config.Advanced.SyncForever {
+		log.PanicError(err)
+	}
+}
+
+// ackOffset is the offset sent by sendAck, the applied offset, at least the
+// offset of the full sync and at most the received offset.
+func (r *psyncReader) ackOffset() int64 {
+	offset := int64(statistics.GetAOFAppliedOffset())
+	if fullSyncOffset := atomic.LoadInt64(&r.fullSyncOffset); offset < fullSyncOffset {
+		offset = fullSyncOffset
+	}
+	if received := atomic.LoadInt64(&r.receivedOffset); offset > received {
+		offset = received
 	}
+	return offset
 }
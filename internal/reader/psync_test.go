@@ -0,0 +1,25 @@
+package reader
+
+import (
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"testing"
+)
+
+func TestAckOffset(t *testing.T) {
+	defer statistics.ResetAOFAppliedOffset()
+	statistics.ResetAOFAppliedOffset()
+	r := &psyncReader{fullSyncOffset: 1000, receivedOffset: 1500}
+
+	// the rdb is received and parsed, nothing of the aof is applied
+	if offset := r.ackOffset(); offset != 1000 {
+		t.Errorf("the offset of the full sync is acked while parsing the rdb. offset=[%d]", offset)
+	}
+	statistics.UpdateAOFAppliedOffset(1200)
+	if offset := r.ackOffset(); offset != 1200 {
+		t.Errorf("the applied offset is acked. offset=[%d]", offset)
+	}
+	statistics.UpdateAOFAppliedOffset(2000)
+	if offset := r.ackOffset(); offset != 1500 {
+		t.Errorf("an offset not received is acked. offset=[%d]", offset)
+	}
+}
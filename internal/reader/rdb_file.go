@@ -0,0 +1,154 @@
+package reader
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// rdbMeta is saved next to dump.rdb once it is downloaded and verified, a
+// dump.rdb without it is a partial download.
+type rdbMeta struct {
+	ReplId string `json:"repl_id"`
+	Offset int64  `json:"offset"` // offset of the FULLRESYNC reply
+	Size   int64  `json:"size"`
+}
+
+func rdbDir() string {
+	if dir := config.Config.Advanced.RDBDir; dir != "" {
+		return dir
+	}
+	return "."
+}
+
+func rdbFilePath() string {
+	return filepath.Join(rdbDir(), "dump.rdb")
+}
+
+func rdbMetaPath() string {
+	return rdbFilePath() + ".meta"
+}
+
+// readRDBMeta returns the meta of a downloaded dump.rdb, false if there is
+// none or dump.rdb does not match it.
+func readRDBMeta() (*rdbMeta, bool) {
+	data, err := ioutil.ReadFile(rdbMetaPath())
+	if err != nil {
+		return nil, false
+	}
+	meta := new(rdbMeta)
+	if err = json.Unmarshal(data, meta); err != nil {
+		log.Warnf("invalid rdb meta file. file=[%s], error=[%v]", rdbMetaPath(), err)
+		return nil, false
+	}
+	fi, err := os.Stat(rdbFilePath())
+	if err != nil || fi.Size() != meta.Size {
+		return nil, false
+	}
+	return meta, true
+}
+
+// removeRDBFile removes dump.rdb and its meta.
+func removeRDBFile() {
+	for _, path := range []string{rdbMetaPath(), rdbFilePath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.PanicError(err)
+		} else if err == nil {
+			log.Infof("remove file. filename=[%s]", path)
+		}
+	}
+}
+
+// rdbFile is the dump.rdb being downloaded. The checksum is calculated while
+// it is written and verified by Close.
+type rdbFile struct {
+	fp   *os.File
+	size int64
+	head []byte // magic string and version
+	tail []byte // the last 8 bytes, not in crc yet
+	crc  uint64
+}
+
+// createRDBFile checks that rdb_dir has room for length bytes, unless the
+// length is unknown, and creates dump.rdb.
+func createRDBFile(length int64) *rdbFile {
+	dir := rdbDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.PanicError(err)
+	}
+	removeRDBFile()
+	if length > 0 {
+		free, err := utils.FreeDiskSpace(dir)
+		if err != nil {
+			log.Warnf("read free disk space failed. dir=[%s], error=[%v]", dir, err)
+		} else if free < uint64(length) {
+			log.Panicf("not enough disk space for the rdb, set rdb_dir to another disk. dir=[%s], free=[%d], rdb=[%d]", dir, free, length)
+		}
+	}
+	path := rdbFilePath()
+	log.Infof("create dump.rdb file. filename_path=[%s]", path)
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		log.PanicError(err)
+	}
+	return &rdbFile{fp: fp}
+}
+
+func (f *rdbFile) Write(p []byte) (int, error) {
+	n, err := f.fp.Write(p)
+	p = p[:n]
+	f.size += int64(n)
+	if len(f.head) < 9 {
+		need := 9 - len(f.head)
+		if need > len(p) {
+			need = len(p)
+		}
+		f.head = append(f.head, p[:need]...)
+	}
+	// the checksum covers all bytes but itself, the last 8 bytes
+	if len(p) >= 8 {
+		f.crc = utils.UpdateCRC64(f.crc, f.tail)
+		f.crc = utils.UpdateCRC64(f.crc, p[:len(p)-8])
+		f.tail = append(f.tail[:0], p[len(p)-8:]...)
+	} else {
+		f.tail = append(f.tail, p...)
+		if len(f.tail) > 8 {
+			f.crc = utils.UpdateCRC64(f.crc, f.tail[:len(f.tail)-8])
+			f.tail = append(f.tail[:0], f.tail[len(f.tail)-8:]...)
+		}
+	}
+	return n, err
+}
+
+// Close verifies the checksum and saves the meta. A corrupted file is
+// removed.
+func (f *rdbFile) Close(replId string, offset int64) {
+	if err := f.fp.Close(); err != nil {
+		log.PanicError(err)
+	}
+	// checksum, since rdb version 5, 0 means rdbchecksum no
+	version := 0
+	if len(f.head) == 9 {
+		version, _ = strconv.Atoi(string(f.head[5:]))
+	}
+	if version >= 5 && len(f.tail) == 8 {
+		expected := binary.LittleEndian.Uint64(f.tail)
+		if expected != 0 && expected != f.crc {
+			removeRDBFile()
+			log.Panicf("rdb checksum mismatch, the download is corrupted. expected=[%x], calculated=[%x]", expected, f.crc)
+		}
+	}
+	meta, err := json.Marshal(rdbMeta{ReplId: replId, Offset: offset, Size: f.size})
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err = ioutil.WriteFile(rdbMetaPath(), meta, 0644); err != nil {
+		log.PanicError(err)
+	}
+}
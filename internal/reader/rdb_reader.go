@@ -36,8 +36,8 @@ func (r *rdbReader) StartRead() chan *entry.Entry {
 		if err != nil {
 			log.Panicf("NewRDBReader: os.Stat error: %s", err.Error())
 		}
-		statistics.Metrics.RdbFileSize = uint64(fi.Size())
-		statistics.Metrics.RdbReceivedSize = uint64(fi.Size())
+		statistics.SetRDBFileSize(uint64(fi.Size()))
+		statistics.UpdateRDBReceivedSize(uint64(fi.Size()))
 		rdbLoader := rdb.NewLoader(r.path, r.ch)
 		_ = rdbLoader.ParseRDB()
 		log.Infof("send RDB finished. path=[%s]", r.path)
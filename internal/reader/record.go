@@ -0,0 +1,166 @@
+package reader
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/log"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Files of a recording, see replayReader.
+const (
+	recordMetaFile     = "record.json"
+	recordRDBFile      = "dump.rdb"
+	recordStreamFile   = "stream.aof"
+	recordTimelineFile = "stream.timeline"
+)
+
+type recordMeta struct {
+	ReplId         string         `json:"repl_id"`
+	StartOffset    int64          `json:"start_offset"`
+	RecordedAt     string         `json:"recorded_at"`
+	PartialResyncs []recordResync `json:"partial_resyncs,omitempty"`
+}
+
+// recordResync is a partial resync of the recorded stream, the stream goes on
+// at offset after a reconnection.
+type recordResync struct {
+	Offset int64  `json:"offset"`
+	ReplId string `json:"repl_id"`
+	At     string `json:"at"` // RFC3339
+}
+
+// recorder saves a copy of the rdb and the raw replication stream received
+// after it, with the time the bytes were received, so the sync can be replayed
+// later. Each line of the timeline file is "<offset> <unix_ms>", meaning all
+// bytes before offset were received at unix_ms.
+type recorder struct {
+	mu       sync.Mutex // write and resync are called by saveAOF, close on stop
+	dir      string
+	meta     recordMeta
+	stream   *os.File
+	timeline *os.File
+	last     offsetTime // written when the next point starts or on close
+	closed   bool
+}
+
+// newRecorder starts a recording in dir, a previous recording is overwritten.
+// The rdb must have been saved to rdbPath.
+func newRecorder(dir string, rdbPath string, replId string, startOffset int64) *recorder {
+	rec := &recorder{dir: dir}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.PanicError(err)
+	}
+	for _, name := range []string{recordMetaFile, recordRDBFile, recordStreamFile, recordTimelineFile} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			log.PanicError(err)
+		}
+	}
+	copyFile(rdbPath, filepath.Join(dir, recordRDBFile))
+
+	var err error
+	rec.stream, err = os.Create(filepath.Join(dir, recordStreamFile))
+	if err != nil {
+		log.PanicError(err)
+	}
+	rec.timeline, err = os.Create(filepath.Join(dir, recordTimelineFile))
+	if err != nil {
+		log.PanicError(err)
+	}
+	rec.meta = recordMeta{ReplId: replId, StartOffset: startOffset, RecordedAt: time.Now().Format(time.RFC3339)}
+	rec.writeMeta()
+	log.Infof("recording the replication stream. dir=[%s], start_offset=[%d]", dir, startOffset)
+	return rec
+}
+
+func (rec *recorder) writeMeta() {
+	meta, err := json.Marshal(rec.meta)
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(rec.dir, recordMetaFile), meta, 0644); err != nil {
+		log.PanicError(err)
+	}
+}
+
+// write records the bytes received before offset.
+func (rec *recorder) write(p []byte, offset int64, t time.Time) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	if _, err := rec.stream.Write(p); err != nil {
+		log.PanicError(err)
+	}
+	if !rec.last.time.IsZero() && t.Sub(rec.last.time) < timelineResolution {
+		rec.last.offset = offset
+		return
+	}
+	rec.writePoint()
+	rec.last = offsetTime{offset, t}
+}
+
+// resync records that the stream goes on at offset after a partial resync.
+func (rec *recorder) resync(offset int64, replId string, t time.Time) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	rec.meta.PartialResyncs = append(rec.meta.PartialResyncs, recordResync{Offset: offset, ReplId: replId, At: t.Format(time.RFC3339)})
+	rec.writeMeta()
+	log.Infof("recording goes on after a partial resync. dir=[%s], offset=[%d]", rec.dir, offset)
+}
+
+func (rec *recorder) writePoint() {
+	if rec.last.time.IsZero() {
+		return
+	}
+	_, err := fmt.Fprintf(rec.timeline, "%d %d\n", rec.last.offset, rec.last.time.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		log.PanicError(err)
+	}
+}
+
+// close writes the last point of the timeline, it may be called more than
+// once.
+func (rec *recorder) close() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return
+	}
+	rec.closed = true
+	rec.writePoint()
+	if err := rec.stream.Close(); err != nil {
+		log.PanicError(err)
+	}
+	if err := rec.timeline.Close(); err != nil {
+		log.PanicError(err)
+	}
+	log.Infof("recording closed. dir=[%s]", rec.dir)
+}
+
+func copyFile(src string, dst string) {
+	in, err := os.Open(src)
+	if err != nil {
+		log.PanicError(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		log.PanicError(err)
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		log.PanicError(err)
+	}
+	if err = out.Close(); err != nil {
+		log.PanicError(err)
+	}
+}
@@ -0,0 +1,69 @@
+package reader
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	defer func(speed float64) { config.Config.Source.ReplaySpeed = speed }(config.Config.Source.ReplaySpeed)
+	config.Config.Source.ReplaySpeed = 0
+	dir := t.TempDir()
+	rdbPath := filepath.Join(dir, "source.rdb")
+	if err := ioutil.WriteFile(rdbPath, []byte("rdb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recordDir := filepath.Join(dir, "record")
+
+	set := []byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n")
+	sel := []byte("*2\r\n$6\r\nSELECT\r\n$1\r\n2\r\n")
+	del := []byte("*2\r\n$3\r\nDEL\r\n$1\r\na\r\n")
+	start := time.Now().Add(-time.Minute)
+	rec := newRecorder(recordDir, rdbPath, "replid", 100)
+	offset := int64(100)
+	for i, p := range [][]byte{set, sel, del} {
+		offset += int64(len(p))
+		rec.write(p, offset, start.Add(time.Duration(i)*time.Second))
+		if i == 0 {
+			rec.resync(offset, "replid2", start)
+		}
+	}
+	rec.close()
+	rec.close()
+	rec.write(set, offset+int64(len(set)), start.Add(time.Hour)) // ignored after close
+
+	r := NewReplayReader(recordDir).(*replayReader)
+	want := []recordResync{{Offset: 100 + int64(len(set)), ReplId: "replid2", At: start.Format(time.RFC3339)}}
+	if r.meta.StartOffset != 100 || !reflect.DeepEqual(r.meta.PartialResyncs, want) {
+		t.Errorf("meta=[%+v]", r.meta)
+	}
+	// the last point is written by close
+	if !r.last.Equal(start.Add(2 * time.Second).Truncate(time.Millisecond)) {
+		t.Errorf("last point of the timeline lost. last=[%v]", r.last)
+	}
+
+	r.ch = make(chan *entry.Entry, 10)
+	r.replayStream(0)
+	close(r.ch)
+	var got []*entry.Entry
+	for e := range r.ch {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("entries=[%d]", len(got))
+	}
+	if got[0].DbId != 0 || got[0].Argv[0] != "SET" || got[0].Offset != 100+int64(len(set)) {
+		t.Errorf("first entry=[%v], db=[%d], offset=[%d]", got[0].Argv, got[0].DbId, got[0].Offset)
+	}
+	if got[1].DbId != 2 || got[1].Argv[0] != "DEL" || got[1].Offset != offset {
+		t.Errorf("second entry=[%v], db=[%d], offset=[%d]", got[1].Argv, got[1].DbId, got[1].Offset)
+	}
+	if ms := int64(got[1].TimestampMs); ms != start.Add(2*time.Second).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("timestamp of the last entry. ms=[%d]", ms)
+	}
+}
@@ -0,0 +1,166 @@
+package reader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayReader feeds a recording of record_dir back into the pipeline: the rdb
+// first, then the commands of the replication stream with the original timing
+// scaled by replay_speed.
+type replayReader struct {
+	dir      string
+	meta     recordMeta
+	timeline *offsetTimeline
+	last     time.Time // time of the last point of timeline
+	ch       chan *entry.Entry
+}
+
+func NewReplayReader(dir string) Reader {
+	absolutePath, err := filepath.Abs(dir)
+	if err != nil {
+		log.PanicError(err)
+	}
+	r := &replayReader{dir: absolutePath, timeline: new(offsetTimeline)}
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, recordMetaFile))
+	if err != nil {
+		log.Panicf("replayReader read recording failed. dir=[%s], error=[%v]", r.dir, err)
+	}
+	if err = json.Unmarshal(data, &r.meta); err != nil {
+		log.PanicError(err)
+	}
+	r.loadTimeline()
+	log.Infof("replayReader created. dir=[%s], repl_id=[%s], start_offset=[%d], recorded_at=[%s], partial_resyncs=[%d], speed=[%v]",
+		r.dir, r.meta.ReplId, r.meta.StartOffset, r.meta.RecordedAt, len(r.meta.PartialResyncs), config.Config.Source.ReplaySpeed)
+	return r
+}
+
+func (r *replayReader) loadTimeline() {
+	file, err := os.Open(filepath.Join(r.dir, recordTimelineFile))
+	if err != nil {
+		log.PanicError(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var offset, ms int64
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &offset, &ms); err != nil {
+			log.Panicf("replayReader invalid timeline. line=[%s], error=[%v]", scanner.Text(), err)
+		}
+		r.last = time.Unix(0, ms*int64(time.Millisecond))
+		r.timeline.add(offset, r.last)
+	}
+	if err := scanner.Err(); err != nil {
+		log.PanicError(err)
+	}
+}
+
+func (r *replayReader) StartRead() chan *entry.Entry {
+	r.ch = make(chan *entry.Entry, 1024)
+	go func() {
+		rdbPath := filepath.Join(r.dir, recordRDBFile)
+		fi, err := os.Stat(rdbPath)
+		if err != nil {
+			log.PanicError(err)
+		}
+		statistics.SetRDBFileSize(uint64(fi.Size()))
+		statistics.UpdateRDBReceivedSize(uint64(fi.Size()))
+		log.Infof("start replay RDB. path=[%s]", rdbPath)
+		dbId := rdb.NewLoader(rdbPath, r.ch).ParseRDB()
+		log.Infof("replay RDB finished. path=[%s], repl-stream-db=[%d]", rdbPath, dbId)
+		r.replayStream(dbId)
+		close(r.ch)
+	}()
+	return r.ch
+}
+
+// countingReader counts the bytes read, that is the replication offset.
+type countingReader struct {
+	rd     io.Reader
+	offset int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+func (r *replayReader) replayStream(dbId int) {
+	path := filepath.Join(r.dir, recordStreamFile)
+	file, err := os.Open(path)
+	if err != nil {
+		log.PanicError(err)
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		log.PanicError(err)
+	}
+	endOffset := r.meta.StartOffset + fi.Size()
+	statistics.UpdateAOFReceivedOffset(uint64(endOffset))
+	// the last point is lost if the recording was not closed
+	if r.last.IsZero() {
+		r.last = time.Now()
+	}
+	r.timeline.add(endOffset, r.last.Add(timelineResolution))
+	log.Infof("start replay stream. path=[%s]", path)
+
+	speed := config.Config.Source.ReplaySpeed
+	counter := &countingReader{rd: file, offset: r.meta.StartOffset}
+	bufReader := bufio.NewReader(counter)
+	protoReader := proto.NewReader(bufReader)
+	var firstRecorded, start time.Time
+	count := 0
+	for {
+		reply, err := protoReader.ReadReply()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.PanicError(err)
+		}
+		argv := client.ArrayString(reply, nil)
+		if strings.EqualFold(argv[0], "select") {
+			dbId, err = strconv.Atoi(argv[1])
+			if err != nil {
+				log.PanicError(err)
+			}
+			continue
+		}
+		if len(argv) >= 2 && strings.EqualFold(argv[0], "replconf") && strings.EqualFold(argv[1], "getack") {
+			continue
+		}
+
+		e := entry.NewEntry()
+		e.Argv = argv
+		e.DbId = dbId
+		e.Offset = counter.offset - int64(bufReader.Buffered())
+		recordedAt := r.timeline.timeOf(e.Offset)
+		e.TimestampMs = uint64(recordedAt.UnixNano() / int64(time.Millisecond))
+		if speed > 0 {
+			if start.IsZero() {
+				firstRecorded, start = recordedAt, time.Now()
+			}
+			elapsed := time.Duration(float64(recordedAt.Sub(firstRecorded)) / speed)
+			time.Sleep(time.Until(start.Add(elapsed)))
+		}
+		r.ch <- e
+		count++
+	}
+	log.Infof("replay stream finished. path=[%s], commands=[%d]", path, count)
+}
@@ -14,10 +14,14 @@ type AOFReader struct {
 	offset   int64
 	pos      int64
 	filename string
+	done     <-chan struct{}
 }
 
-func NewAOFReader(offset int64) *AOFReader {
+// NewAOFReader opens the aof file starting at offset. Read blocks at the end
+// of the files until new data arrives, or returns io.EOF once done is closed.
+func NewAOFReader(offset int64, done <-chan struct{}) *AOFReader {
 	r := new(AOFReader)
+	r.done = done
 	r.openFile(offset)
 	return r
 }
@@ -49,6 +53,11 @@ func (r *AOFReader) readNextFile(offset int64) {
 func (r *AOFReader) Read(buf []byte) (n int, err error) {
 	n, err = r.file.Read(buf)
 	for err == io.EOF {
+		select {
+		case <-r.done:
+			return 0, io.EOF
+		default:
+		}
 		if r.filename != fmt.Sprintf("%d.aof", r.offset) {
 			r.readNextFile(r.offset)
 		}
@@ -62,5 +62,6 @@ func (w *AOFWriter) Close() {
 	if err != nil {
 		log.PanicError(err)
 	}
+	w.file = nil
 	log.Infof("AOFWriter close file. filename=[%s], filesize=[%d]", w.filename, w.filesize)
 }
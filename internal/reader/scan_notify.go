@@ -0,0 +1,167 @@
+package reader
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/utils"
+	"github.com/alibaba/RedisShake/internal/writer"
+)
+
+const keyeventPrefix = "__keyevent@"
+
+// keyspaceNotifier collects the keys changed on the source from keyspace
+// notifications, on every master if the source is a cluster, notifications
+// are local to a node. Keys are deduplicated, the value is fetched again with
+// DUMP, so only the latest value matters. It is best-effort: a subscription
+// broken by an error is made again, but the notifications meanwhile are lost.
+type keyspaceNotifier struct {
+	username string
+	password string
+	isTls    bool
+	mu       sync.Mutex
+	pending  map[dbKey]struct{}
+	notify   chan struct{} // has one element when pending is not empty
+}
+
+func newKeyspaceNotifier(address string, username string, password string, isTls bool, isCluster bool) *keyspaceNotifier {
+	n := new(keyspaceNotifier)
+	n.username = username
+	n.password = password
+	n.isTls = isTls
+	n.pending = make(map[dbKey]struct{})
+	n.notify = make(chan struct{}, 1)
+
+	nodes := []string{address}
+	if isCluster {
+		nodes = writer.ClusterMasters(address, username, password, isTls)
+		log.Infof("scanReader source is a cluster, subscribing every master. masters=%v", nodes)
+	}
+	// subscribe before scanning, so that no change during the scan is lost
+	for _, node := range nodes {
+		c := client.NewRedisClient(node, username, password, isTls)
+		checkKeyspaceEvents(c, node)
+		if err := subscribeKeyevents(c); err != nil {
+			log.PanicError(err)
+		}
+		go n.receive(node, c)
+	}
+	return n
+}
+
+func checkKeyspaceEvents(c *client.Redis, node string) {
+	reply, err := c.Do("CONFIG", "GET", "notify-keyspace-events")
+	if err != nil {
+		log.Warnf("scanReader can not check notify-keyspace-events, make sure it contains \"KEA\" or \"EA\". address=[%s], err=[%v]", node, err)
+	} else if values := client.ArrayString(reply, nil); len(values) == 2 {
+		flags := values[1]
+		if !strings.Contains(flags, "E") {
+			log.Panicf("scanReader keyevent notifications are disabled on source, set notify-keyspace-events to \"EA\". address=[%s], notify-keyspace-events=[%s]", node, flags)
+		}
+		if !strings.Contains(flags, "A") {
+			log.Warnf("scanReader some events are not notified by source, changes may be missed. address=[%s], notify-keyspace-events=[%s]", node, flags)
+		}
+	}
+}
+
+func subscribeKeyevents(c *client.Redis) error {
+	if err := c.TrySend("PSUBSCRIBE", keyeventPrefix+"*__:*"); err != nil {
+		return err
+	}
+	_, err := c.Receive()
+	return err
+}
+
+// receive collects the keys notified by node, the subscription is made again
+// after an error until it succeeds.
+func (n *keyspaceNotifier) receive(node string, c *client.Redis) {
+	for {
+		err := n.receiveUntilError(node, c)
+		c.Close()
+		log.Warnf("scanReader keyspace notifications disconnected, the changes until resubscribed are lost. address=[%s], error=[%v]", node, err)
+		c = n.resubscribe(node)
+		log.Infof("scanReader resubscribed keyspace notifications. address=[%s]", node)
+	}
+}
+
+func (n *keyspaceNotifier) receiveUntilError(node string, c *client.Redis) error {
+	for {
+		reply, err := c.Receive()
+		if err != nil {
+			return err
+		}
+		// pmessage pattern channel key
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 4 || msg[0] != "pmessage" {
+			continue
+		}
+		channel, _ := msg[2].(string)
+		key, _ := msg[3].(string)
+		db, ok := parseKeyeventDb(channel)
+		if !ok {
+			log.Warnf("scanReader unknown keyevent channel. channel=[%s]", channel)
+			continue
+		}
+		n.add(dbKey{db: db, key: key, node: node})
+	}
+}
+
+func (n *keyspaceNotifier) resubscribe(node string) *client.Redis {
+	backoff := utils.NewBackoff(time.Duration(config.Config.Advanced.ReconnectMaxBackoff) * time.Second)
+	for {
+		c, err := client.DialRedisClient(node, n.username, n.password, n.isTls)
+		if err == nil {
+			if err = subscribeKeyevents(c); err == nil {
+				return c
+			}
+			c.Close()
+		}
+		log.Warnf("scanReader resubscribe keyspace notifications failed. address=[%s], error=[%v]", node, err)
+		backoff.Wait()
+	}
+}
+
+func (n *keyspaceNotifier) add(item dbKey) {
+	n.mu.Lock()
+	n.pending[item] = struct{}{}
+	n.mu.Unlock()
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take blocks until some keys are changed and returns them.
+func (n *keyspaceNotifier) take() []dbKey {
+	<-n.notify
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	keys := make([]dbKey, 0, len(n.pending))
+	for item := range n.pending {
+		keys = append(keys, item)
+	}
+	n.pending = make(map[dbKey]struct{})
+	return keys
+}
+
+// parseKeyeventDb parses the db of channel like __keyevent@0__:set
+func parseKeyeventDb(channel string) (int, bool) {
+	if !strings.HasPrefix(channel, keyeventPrefix) {
+		return 0, false
+	}
+	rest := channel[len(keyeventPrefix):]
+	end := strings.Index(rest, "__:")
+	if end < 0 {
+		return 0, false
+	}
+	db, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0, false
+	}
+	return db, true
+}
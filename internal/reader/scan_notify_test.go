@@ -0,0 +1,41 @@
+package reader
+
+import "testing"
+
+func TestParseKeyeventDb(t *testing.T) {
+	cases := []struct {
+		channel string
+		db      int
+		ok      bool
+	}{
+		{"__keyevent@0__:set", 0, true},
+		{"__keyevent@15__:expired", 15, true},
+		{"__keyspace@0__:key", 0, false},
+		{"__keyevent@x__:set", 0, false},
+		{"__keyevent@1", 0, false},
+	}
+	for _, c := range cases {
+		if db, ok := parseKeyeventDb(c.channel); db != c.db || ok != c.ok {
+			t.Errorf("unexpected db. channel=[%s], db=[%d], ok=[%v]", c.channel, db, ok)
+		}
+	}
+}
+
+func TestKeyspaceNotifierTake(t *testing.T) {
+	n := &keyspaceNotifier{pending: make(map[dbKey]struct{}), notify: make(chan struct{}, 1)}
+	n.add(dbKey{db: 0, key: "a", node: "master1"})
+	n.add(dbKey{db: 0, key: "a", node: "master1"})
+	n.add(dbKey{db: 0, key: "b", node: "master2"})
+	keys := n.take()
+	if len(keys) != 2 {
+		t.Fatalf("a key notified twice is fetched once. keys=%v", keys)
+	}
+	for _, item := range keys {
+		if want := map[string]string{"a": "master1", "b": "master2"}[item.key]; item.node != want {
+			t.Errorf("key fetched from another master. key=[%s], node=[%s]", item.key, item.node)
+		}
+	}
+	if len(n.pending) != 0 {
+		t.Errorf("taken keys are still pending. pending=[%d]", len(n.pending))
+	}
+}
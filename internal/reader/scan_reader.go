@@ -4,10 +4,14 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/alibaba/RedisShake/internal/capability"
 	"github.com/alibaba/RedisShake/internal/client"
 	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
 	"github.com/alibaba/RedisShake/internal/entry"
 	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb"
 	"github.com/alibaba/RedisShake/internal/statistics"
 )
 
@@ -20,10 +24,17 @@ type dbKey struct {
 	db       int
 	key      string
 	isSelect bool
+	notified bool // changed after the full scan, the key is deleted on target if it does not exist
+
+	node string        // the master that notified the key, see keyspaceNotifier
+	dump *client.Redis // the client DUMP was sent on, clientDump if nil
 }
 
 type scanReader struct {
-	address string
+	address  string
+	username string
+	password string
+	isTls    bool
 
 	// client for scan keys
 	clientScan   *client.Redis
@@ -34,16 +45,30 @@ type scanReader struct {
 	clientDump     *client.Redis
 	clientDumpDbid int
 	ch             chan *entry.Entry
+
+	// keys changed during and after the scan
+	notifier *keyspaceNotifier
+
+	stats *statistics.ShardMetrics
 }
 
 func NewScanReader(address string, username string, password string, isTls bool) Reader {
 	r := new(scanReader)
 	r.address = address
+	r.username = username
+	r.password = password
+	r.isTls = isTls
+	r.stats = statistics.RegisterShard(statistics.RoleReader, address)
 	r.clientScan = client.NewRedisClient(address, username, password, isTls)
 	r.clientDump = client.NewRedisClient(address, username, password, isTls)
 	log.Infof("scanReader connected to redis successful. address=[%s]", address)
+	statistics.SetSourceConnected(true)
 
 	r.isCluster = r.IsCluster()
+	if config.Config.Advanced.ScanKeyspaceNotify {
+		r.notifier = newKeyspaceNotifier(address, username, password, isTls, r.isCluster)
+		log.Infof("scanReader subscribed keyspace notifications. address=[%s]", address)
+	}
 	return r
 }
 
@@ -75,31 +100,61 @@ func (r *scanReader) scan() {
 			}
 
 			r.clientDump.Send("SELECT", strconv.Itoa(dbId))
-			r.innerChannel <- &dbKey{dbId, "", true}
+			r.innerChannel <- &dbKey{db: dbId, isSelect: true}
 		}
 
 		var cursor uint64 = 0
 		for {
+			control.ReadPause.Wait()
 			var keys []string
 			cursor, keys = r.clientScan.Scan(cursor)
 			for _, key := range keys {
 				r.clientDump.Send("DUMP", key)
 				r.clientDump.Send("PTTL", key)
-				r.innerChannel <- &dbKey{dbId, key, false}
+				r.innerChannel <- &dbKey{db: dbId, key: key}
 			}
 
 			// stat
-			statistics.Metrics.ScanDbId = dbId
-			statistics.Metrics.ScanCursor = cursor
+			statistics.UpdateScanPosition(dbId, cursor)
 
 			if cursor == 0 {
 				break
 			}
 		}
 	}
+	if r.notifier != nil {
+		r.syncNotifiedKeys()
+	}
 	close(r.innerChannel)
 }
 
+// syncNotifiedKeys fetches the keys changed since the scan started, it never returns.
+func (r *scanReader) syncNotifiedKeys() {
+	log.Infof("scanReader scan finished, start syncing changed keys. address=[%s]", r.address)
+	dbId := -1
+	// keys of a cluster are fetched from the master that notified them
+	dumpClients := map[string]*client.Redis{r.address: r.clientDump}
+	for {
+		keys := r.notifier.take()
+		control.ReadPause.Wait()
+		for _, item := range keys {
+			if !r.isCluster && item.db != dbId {
+				dbId = item.db
+				r.clientDump.Send("SELECT", strconv.Itoa(dbId))
+				r.innerChannel <- &dbKey{db: dbId, isSelect: true}
+			}
+			dump, ok := dumpClients[item.node]
+			if !ok {
+				dump = client.NewRedisClient(item.node, r.username, r.password, r.isTls)
+				dumpClients[item.node] = dump
+			}
+			dump.Send("DUMP", item.key)
+			dump.Send("PTTL", item.key)
+			r.innerChannel <- &dbKey{db: item.db, key: item.key, notified: true, dump: dump}
+		}
+	}
+}
+
 func (r *scanReader) fetch() {
 	var id uint64 = 0
 	for item := range r.innerChannel {
@@ -113,30 +168,59 @@ func (r *scanReader) fetch() {
 				log.Panicf("scanReader select db failed. db=[%d]", item.db)
 			}
 		} else {
+			dump := r.clientDump
+			if item.dump != nil {
+				dump = item.dump
+			}
 			// dump
-			receive, err := client.String(r.clientDump.Receive())
+			receive, err := client.String(dump.Receive())
 			if err != proto.Nil && err != nil { // error!
 				log.PanicIfError(err)
 			}
+			control.ReadLimit.WaitN(len(receive))
+			r.stats.AddReceived(uint64(len(receive)), 0)
 
 			// pttl
-			pttl, pttlErr := client.Int64(r.clientDump.Receive())
+			pttl, pttlErr := client.Int64(dump.Receive())
 			log.PanicIfError(pttlErr)
 			if pttl < 0 {
 				pttl = 0
 			}
 
-			if err == proto.Nil { // key not exist
-				continue
+			send := func(argv []string) {
+				id += 1
+				r.ch <- &entry.Entry{
+					Id:     id,
+					IsBase: false,
+					DbId:   item.db,
+					Argv:   argv,
+				}
 			}
-
-			id += 1
-			argv := []string{"RESTORE", item.key, strconv.FormatInt(pttl, 10), receive}
-			r.ch <- &entry.Entry{
-				Id:     id,
-				IsBase: false,
-				DbId:   item.db,
-				Argv:   argv,
+			if err == proto.Nil { // key not exist
+				if !item.notified {
+					// deleted or expired between SCAN and DUMP
+					statistics.AddDropCount("expired")
+					continue
+				}
+				// deleted or expired after the scan
+				send([]string{"DEL", item.key})
+			} else if !capability.Target.CanRestore() {
+				// the target can not RESTORE, the value is rewritten into commands
+				if item.notified {
+					send([]string{"DEL", item.key})
+				}
+				for _, cmd := range rdb.RewriteDump(item.key, receive) {
+					send(cmd)
+				}
+				if pttl > 0 {
+					send([]string{"PEXPIRE", item.key, strconv.FormatInt(pttl, 10)})
+				}
+			} else {
+				argv := []string{"RESTORE", item.key, strconv.FormatInt(pttl, 10), receive}
+				if item.notified {
+					argv = append(argv, "REPLACE")
+				}
+				send(argv)
 			}
 		}
 	}
@@ -0,0 +1,48 @@
+package reader
+
+import (
+	"sync"
+	"time"
+)
+
+// timelineResolution limits the points recorded, at most one point per 10ms.
+const timelineResolution = 10 * time.Millisecond
+
+type offsetTime struct {
+	offset int64
+	time   time.Time
+}
+
+// offsetTimeline records when the bytes of the replication stream were
+// received, so commands read back from aof files can get their timestamp.
+type offsetTimeline struct {
+	mu     sync.Mutex
+	points []offsetTime
+}
+
+// add records that all bytes before offset were received at t.
+func (l *offsetTimeline) add(offset int64, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := len(l.points); n > 0 && t.Sub(l.points[n-1].time) < timelineResolution {
+		l.points[n-1].offset = offset
+		return
+	}
+	l.points = append(l.points, offsetTime{offset, t})
+}
+
+// timeOf returns the time the byte before offset was received. Offsets are
+// queried in ascending order, so older points are dropped.
+func (l *offsetTimeline) timeOf(offset int64) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := 0
+	for i < len(l.points) && l.points[i].offset < offset {
+		i++
+	}
+	l.points = l.points[i:]
+	if len(l.points) == 0 {
+		return time.Now()
+	}
+	return l.points[0].time
+}
@@ -0,0 +1,27 @@
+package reader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOffsetTimeline(t *testing.T) {
+	var l offsetTimeline
+	start := time.Now().Add(-time.Minute)
+	l.add(100, start)
+	l.add(150, start.Add(time.Millisecond)) // within the resolution, merged into the first point
+	l.add(300, start.Add(time.Second))
+
+	if got := l.timeOf(120); !got.Equal(start) {
+		t.Errorf("offset 120 is in the first point. got=[%v]", got)
+	}
+	if got := l.timeOf(200); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("offset 200 is in the second point. got=[%v]", got)
+	}
+	if len(l.points) != 1 {
+		t.Errorf("points before the queried offset are not dropped. points=[%d]", len(l.points))
+	}
+	if got := l.timeOf(400); time.Since(got) > time.Second {
+		t.Errorf("an offset not received yet is now. got=[%v]", got)
+	}
+}
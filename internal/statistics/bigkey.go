@@ -0,0 +1,109 @@
+package statistics
+
+import (
+	"github.com/alibaba/RedisShake/internal/log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BigKeyProgress is the progress of a key of the rdb rewritten into several
+// commands, so that a big key taking minutes is told apart from a hang.
+type BigKeyProgress struct {
+	Key            string  `json:"key"`
+	DbId           int     `json:"db"`
+	Total          uint64  `json:"total"`   // commands of the key
+	Sent           uint64  `json:"sent"`    // passed to the writer
+	Applied        uint64  `json:"applied"` // answered by the target
+	Dropped        uint64  `json:"dropped"` // dropped by the filter or skipped by the writer
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+
+	start time.Time
+	done  uint64 // applied or dropped
+}
+
+var bigKeys struct {
+	mu     sync.Mutex
+	active map[*BigKeyProgress]struct{}
+}
+
+// StartBigKey tracks a key until all of its commands are applied.
+func StartBigKey(key string, dbId int, total int) *BigKeyProgress {
+	p := &BigKeyProgress{Key: key, DbId: dbId, Total: uint64(total), start: time.Now()}
+	bigKeys.mu.Lock()
+	if bigKeys.active == nil {
+		bigKeys.active = make(map[*BigKeyProgress]struct{})
+	}
+	bigKeys.active[p] = struct{}{}
+	bigKeys.mu.Unlock()
+	return p
+}
+
+func (p *BigKeyProgress) AddSent() {
+	atomic.AddUint64(&p.Sent, 1)
+}
+
+// AddApplied is called by the writer for each answered command of the key.
+func (p *BigKeyProgress) AddApplied() {
+	atomic.AddUint64(&p.Applied, 1)
+	p.addDone()
+}
+
+// AddDropped is called for each command of the key that is not applied, so
+// that a key dropped by the filter is not tracked until the end of the rdb.
+func (p *BigKeyProgress) AddDropped() {
+	atomic.AddUint64(&p.Dropped, 1)
+	p.addDone()
+}
+
+func (p *BigKeyProgress) addDone() {
+	if atomic.AddUint64(&p.done, 1) < p.Total {
+		return
+	}
+	bigKeys.mu.Lock()
+	delete(bigKeys.active, p)
+	bigKeys.mu.Unlock()
+	log.Debugf("big key done. key=[%s], commands=[%d], applied=[%d], elapsed=[%v]",
+		p.Key, p.Total, atomic.LoadUint64(&p.Applied), time.Since(p.start))
+}
+
+// GetBigKeys returns the big keys in progress, the oldest first.
+func GetBigKeys() []BigKeyProgress {
+	bigKeys.mu.Lock()
+	keys := make([]BigKeyProgress, 0, len(bigKeys.active))
+	for p := range bigKeys.active {
+		keys = append(keys, BigKeyProgress{
+			Key:            p.Key,
+			DbId:           p.DbId,
+			Total:          p.Total,
+			Sent:           atomic.LoadUint64(&p.Sent),
+			Applied:        atomic.LoadUint64(&p.Applied),
+			Dropped:        atomic.LoadUint64(&p.Dropped),
+			ElapsedSeconds: time.Since(p.start).Seconds(),
+			start:          p.start,
+		})
+	}
+	bigKeys.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].start.Before(keys[j].start) })
+	return keys
+}
+
+// ClearBigKeys forgets the big keys at the end of the rdb, the commands still
+// waiting for a reply are not counted anymore.
+func ClearBigKeys() {
+	bigKeys.mu.Lock()
+	bigKeys.active = nil
+	bigKeys.mu.Unlock()
+}
+
+// logBigKeys logs the big keys in progress for longer than minElapsed.
+func logBigKeys(minElapsed time.Duration) {
+	for _, p := range GetBigKeys() {
+		if p.ElapsedSeconds < minElapsed.Seconds() {
+			continue
+		}
+		log.Infof("big key in progress. key=[%s], db=[%d], applied=[%d/%d], dropped=[%d], sent=[%d], elapsed=[%.0fs]",
+			p.Key, p.DbId, p.Applied, p.Total, p.Dropped, p.Sent, p.ElapsedSeconds)
+	}
+}
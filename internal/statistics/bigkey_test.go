@@ -0,0 +1,25 @@
+package statistics
+
+import "testing"
+
+func TestBigKeyDone(t *testing.T) {
+	defer ClearBigKeys()
+	ClearBigKeys()
+	applied := StartBigKey("applied", 0, 2)
+	dropped := StartBigKey("dropped", 0, 2)
+
+	applied.AddApplied()
+	dropped.AddDropped()
+	if keys := GetBigKeys(); len(keys) != 2 {
+		t.Fatalf("unexpected big keys in progress. keys=%v", keys)
+	}
+	dropped.AddDropped()
+	keys := GetBigKeys()
+	if len(keys) != 1 || keys[0].Key != "applied" || keys[0].Applied != 1 {
+		t.Fatalf("the dropped key is still tracked. keys=%v", keys)
+	}
+	applied.AddApplied()
+	if keys := GetBigKeys(); len(keys) != 0 {
+		t.Errorf("the applied key is still tracked. keys=%v", keys)
+	}
+}
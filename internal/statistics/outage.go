@@ -0,0 +1,72 @@
+package statistics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// targetOutage is the time a writer can not reach its node of the target,
+// the source is buffered meanwhile.
+type targetOutage struct {
+	since          time.Time
+	receivedOffset uint64 // AofReceivedOffset at the start
+}
+
+var outages struct {
+	mu     sync.Mutex
+	byNode map[string]targetOutage // by address
+}
+
+// StartTargetOutage is called by a writer when its connection to address
+// broke. It does nothing if the outage of address is already started.
+func StartTargetOutage(address string) {
+	outages.mu.Lock()
+	defer outages.mu.Unlock()
+	if outages.byNode == nil {
+		outages.byNode = make(map[string]targetOutage)
+	}
+	if _, ok := outages.byNode[address]; ok {
+		return
+	}
+	outages.byNode[address] = targetOutage{since: time.Now(), receivedOffset: atomic.LoadUint64(&Metrics.AofReceivedOffset)}
+	atomic.AddUint64(&Metrics.TargetOutageCount, 1)
+}
+
+// GetTargetOutage returns how long address is unreachable and the bytes of
+// the replication stream received from the source since then.
+func GetTargetOutage(address string) (elapsed time.Duration, buffered uint64, ok bool) {
+	outages.mu.Lock()
+	o, ok := outages.byNode[address]
+	outages.mu.Unlock()
+	if !ok {
+		return 0, 0, false
+	}
+	if received := atomic.LoadUint64(&Metrics.AofReceivedOffset); received > o.receivedOffset {
+		buffered = received - o.receivedOffset
+	}
+	return time.Since(o.since), buffered, true
+}
+
+// EndTargetOutage is called by a writer when it reconnected to address.
+func EndTargetOutage(address string) (elapsed time.Duration, buffered uint64) {
+	elapsed, buffered, _ = GetTargetOutage(address)
+	outages.mu.Lock()
+	delete(outages.byNode, address)
+	outages.mu.Unlock()
+	return elapsed, buffered
+}
+
+// LongestTargetOutage returns the longest current outage of the nodes of the
+// target, 0 if all of them are reachable.
+func LongestTargetOutage() time.Duration {
+	outages.mu.Lock()
+	defer outages.mu.Unlock()
+	var longest time.Duration
+	for _, o := range outages.byNode {
+		if elapsed := time.Since(o.since); elapsed > longest {
+			longest = elapsed
+		}
+	}
+	return longest
+}
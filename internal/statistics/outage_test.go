@@ -0,0 +1,41 @@
+package statistics
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTargetOutage(t *testing.T) {
+	defer func(offset uint64) { atomic.StoreUint64(&Metrics.AofReceivedOffset, offset) }(atomic.LoadUint64(&Metrics.AofReceivedOffset))
+	count := atomic.LoadUint64(&Metrics.TargetOutageCount)
+	atomic.StoreUint64(&Metrics.AofReceivedOffset, 1000)
+
+	StartTargetOutage("a")
+	time.Sleep(10 * time.Millisecond)
+	StartTargetOutage("b")
+	atomic.StoreUint64(&Metrics.AofReceivedOffset, 1500)
+	// an outage already started keeps its start
+	StartTargetOutage("a")
+	elapsed, buffered, ok := GetTargetOutage("a")
+	if !ok || buffered != 500 || elapsed < 10*time.Millisecond {
+		t.Errorf("elapsed=[%v], buffered=[%d], ok=[%v], want the source received since the start", elapsed, buffered, ok)
+	}
+	if longest := LongestTargetOutage(); longest < elapsed {
+		t.Errorf("longest=[%v], want the outage of a", longest)
+	}
+	if n := atomic.LoadUint64(&Metrics.TargetOutageCount) - count; n != 2 {
+		t.Errorf("target_outage_count increased by %d, want 2", n)
+	}
+
+	if _, buffered := EndTargetOutage("a"); buffered != 500 {
+		t.Errorf("buffered=[%d] at the end of the outage, want 500", buffered)
+	}
+	if _, _, ok := GetTargetOutage("a"); ok {
+		t.Error("outage of a not ended")
+	}
+	EndTargetOutage("b")
+	if longest := LongestTargetOutage(); longest != 0 {
+		t.Errorf("longest=[%v], want 0 when every node is reachable", longest)
+	}
+}
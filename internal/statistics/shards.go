@@ -0,0 +1,234 @@
+package statistics
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/config"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Label names of the per shard metrics, they do not change between versions
+// so that external monitoring can rely on them.
+const (
+	LabelTask  = "task"  // task_id of the config
+	LabelRole  = "role"  // RoleReader or RoleWriter
+	LabelShard = "shard" // address of the node, or the name of a shard of a sharded target
+)
+
+const (
+	RoleReader = "reader"
+	RoleWriter = "writer"
+)
+
+// ShardMetrics are the metrics of one reader or writer of a run, such as
+// each master of a cluster target. Metrics holds their aggregation.
+type ShardMetrics struct {
+	Task  string `json:"task"`
+	Role  string `json:"role"`
+	Shard string `json:"shard"`
+
+	Entries         uint64 `json:"entries"`          // answered by the target, for writers
+	Bytes           uint64 `json:"bytes"`            // answered by the target, or received from the source
+	UnansweredBytes uint64 `json:"unanswered_bytes"` // for writers
+	AppliedOffset   uint64 `json:"applied_offset"`   // for writers
+	ReceivedOffset  uint64 `json:"received_offset"`  // for readers
+
+	inFlight int64 // entries given to the writer and not answered yet
+}
+
+var shards struct {
+	mu      sync.Mutex
+	metrics map[string]*ShardMetrics // by role and shard
+	dropped uint64                   // offset of the last entry not written, see AddDroppedOffset
+}
+
+// RegisterShard returns the metrics of the reader or writer of shard, the
+// same ones if it is registered again, such as after a reconnect.
+func RegisterShard(role string, shard string) *ShardMetrics {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	if shards.metrics == nil {
+		shards.metrics = make(map[string]*ShardMetrics)
+	}
+	id := role + "/" + shard
+	m, ok := shards.metrics[id]
+	if !ok {
+		m = &ShardMetrics{Task: config.Config.Advanced.TaskId, Role: role, Shard: shard}
+		shards.metrics[id] = m
+	}
+	return m
+}
+
+// AddInFlight is called by the writer when it is given an entry, which it
+// answers by AddApplied.
+func (m *ShardMetrics) AddInFlight() {
+	atomic.AddInt64(&m.inFlight, 1)
+}
+
+// AddApplied counts an entry answered by the target.
+func (m *ShardMetrics) AddApplied(bytes uint64, offset int64) {
+	m.AddAppliedEntries(1, bytes, offset)
+}
+
+// AddAppliedEntries counts entries answered at once, such as the entries of
+// a sink made durable by a flush, offset is the one of the last of them.
+func (m *ShardMetrics) AddAppliedEntries(entries uint64, bytes uint64, offset int64) {
+	atomic.AddUint64(&m.Entries, entries)
+	atomic.AddUint64(&m.Bytes, bytes)
+	for {
+		old := atomic.LoadUint64(&m.AppliedOffset)
+		if uint64(offset) <= old || atomic.CompareAndSwapUint64(&m.AppliedOffset, old, uint64(offset)) {
+			break
+		}
+	}
+	atomic.AddInt64(&m.inFlight, -int64(entries))
+	UpdateAOFAppliedOffset(writersAppliedOffset())
+}
+
+// AddDroppedOffset is called for an entry of the replication stream that is
+// not written to the target, such as dropped by the filter, so that the
+// applied offset does not stay behind on a stream of dropped entries. The
+// offset is applied once the writers answered all entries given to them.
+func AddDroppedOffset(offset int64) {
+	for {
+		old := atomic.LoadUint64(&shards.dropped)
+		if uint64(offset) <= old || atomic.CompareAndSwapUint64(&shards.dropped, old, uint64(offset)) {
+			break
+		}
+	}
+	UpdateAOFAppliedOffset(writersAppliedOffset())
+}
+
+// writersAppliedOffset returns the offset all writers applied. A writer with
+// entries in flight applied everything up to its own applied offset only,
+// the entries before it went to other writers may be applied already, those
+// after it may be answered by other writers before it. So the offset is the
+// minimum over the writers with entries in flight, or the maximum if they are
+// all idle, including the offset of the dropped entries.
+func writersAppliedOffset() uint64 {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	var busy, idle uint64
+	busySeen := false
+	for _, m := range shards.metrics {
+		if m.Role != RoleWriter {
+			continue
+		}
+		offset := atomic.LoadUint64(&m.AppliedOffset)
+		if atomic.LoadInt64(&m.inFlight) > 0 {
+			if !busySeen || offset < busy {
+				busy = offset
+			}
+			busySeen = true
+		} else if offset > idle {
+			idle = offset
+		}
+	}
+	if busySeen {
+		return busy
+	}
+	if dropped := atomic.LoadUint64(&shards.dropped); dropped > idle {
+		return dropped
+	}
+	return idle
+}
+
+// SetUnansweredBytes updates the bytes sent to the node and not answered,
+// unanswered_bytes_count of Metrics is their sum over the writers.
+func (m *ShardMetrics) SetUnansweredBytes(bytes uint64) {
+	old := atomic.SwapUint64(&m.UnansweredBytes, bytes)
+	atomic.AddUint64(&Metrics.UnansweredBytesCount, bytes-old)
+}
+
+// AddReceived counts bytes received from the source, offset is the
+// replication offset after them or 0 outside of the replication stream.
+func (m *ShardMetrics) AddReceived(bytes uint64, offset int64) {
+	atomic.AddUint64(&m.Bytes, bytes)
+	if offset > 0 {
+		atomic.StoreUint64(&m.ReceivedOffset, uint64(offset))
+		UpdateAOFReceivedOffset(uint64(offset))
+	}
+}
+
+// GetShards returns the metrics of the readers and writers, by role and
+// shard.
+func GetShards() []ShardMetrics {
+	shards.mu.Lock()
+	list := make([]ShardMetrics, 0, len(shards.metrics))
+	for _, m := range shards.metrics {
+		list = append(list, ShardMetrics{
+			Task:            m.Task,
+			Role:            m.Role,
+			Shard:           m.Shard,
+			Entries:         atomic.LoadUint64(&m.Entries),
+			Bytes:           atomic.LoadUint64(&m.Bytes),
+			UnansweredBytes: atomic.LoadUint64(&m.UnansweredBytes),
+			AppliedOffset:   atomic.LoadUint64(&m.AppliedOffset),
+			ReceivedOffset:  atomic.LoadUint64(&m.ReceivedOffset),
+		})
+	}
+	shards.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Role != list[j].Role {
+			return list[i].Role < list[j].Role
+		}
+		return list[i].Shard < list[j].Shard
+	})
+	return list
+}
+
+// resetShardOffsets forgets the applied offsets before a new full sync.
+func resetShardOffsets() {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	for _, m := range shards.metrics {
+		atomic.StoreUint64(&m.AppliedOffset, 0)
+	}
+	atomic.StoreUint64(&shards.dropped, 0)
+}
+
+// PrometheusHandler serves the aggregated and the per shard metrics in the
+// Prometheus text format.
+func PrometheusHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w)
+}
+
+func writePrometheus(w io.Writer) {
+	task := promLabels(LabelTask, config.Config.Advanced.TaskId)
+	gauge := func(name string, help string, kind string, value uint64) {
+		fmt.Fprintf(w, "# HELP redis_shake_%s %s\n# TYPE redis_shake_%s %s\nredis_shake_%s{%s} %d\n",
+			name, help, name, kind, name, task, value)
+	}
+	gauge("allow_entries_total", "Entries passed by the filter.", "counter", atomic.LoadUint64(&Metrics.AllowEntriesCount))
+	gauge("disallow_entries_total", "Entries dropped by the filter.", "counter", atomic.LoadUint64(&Metrics.DisallowEntriesCount))
+	gauge("aof_received_offset", "Replication offset received from the source.", "gauge", atomic.LoadUint64(&Metrics.AofReceivedOffset))
+	gauge("aof_applied_offset", "Replication offset applied to the target.", "gauge", atomic.LoadUint64(&Metrics.AofAppliedOffset))
+	gauge("unanswered_bytes", "Bytes sent to the target and not answered.", "gauge", atomic.LoadUint64(&Metrics.UnansweredBytesCount))
+	gauge("target_outage_seconds", "Seconds a node of the target is unreachable, 0 if all are reachable.", "gauge", uint64(LongestTargetOutage().Seconds()))
+	gauge("target_outages_total", "Connections to the target broken.", "counter", atomic.LoadUint64(&Metrics.TargetOutageCount))
+
+	list := GetShards()
+	shardGauge := func(name string, help string, kind string, value func(m *ShardMetrics) uint64) {
+		fmt.Fprintf(w, "# HELP redis_shake_shard_%s %s\n# TYPE redis_shake_shard_%s %s\n", name, help, name, kind)
+		for i := range list {
+			m := &list[i]
+			fmt.Fprintf(w, "redis_shake_shard_%s{%s,%s,%s} %d\n", name,
+				promLabels(LabelTask, m.Task), promLabels(LabelRole, m.Role), promLabels(LabelShard, m.Shard), value(m))
+		}
+	}
+	shardGauge("entries_total", "Entries answered by the target.", "counter", func(m *ShardMetrics) uint64 { return m.Entries })
+	shardGauge("bytes_total", "Bytes answered by the target or received from the source.", "counter", func(m *ShardMetrics) uint64 { return m.Bytes })
+	shardGauge("unanswered_bytes", "Bytes sent to the target and not answered.", "gauge", func(m *ShardMetrics) uint64 { return m.UnansweredBytes })
+	shardGauge("applied_offset", "Replication offset applied to the target.", "gauge", func(m *ShardMetrics) uint64 { return m.AppliedOffset })
+	shardGauge("received_offset", "Replication offset received from the source.", "gauge", func(m *ShardMetrics) uint64 { return m.ReceivedOffset })
+}
+
+func promLabels(name string, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
@@ -0,0 +1,48 @@
+package statistics
+
+import "testing"
+
+func TestWritersAppliedOffset(t *testing.T) {
+	defer func(old map[string]*ShardMetrics) { shards.metrics = old }(shards.metrics)
+	defer ResetAOFAppliedOffset()
+	shards.metrics = nil
+	a := RegisterShard(RoleWriter, "a")
+	b := RegisterShard(RoleWriter, "b")
+	RegisterShard(RoleReader, "source").AddReceived(100, 300)
+
+	a.AddInFlight()
+	b.AddInFlight()
+	b.AddApplied(10, 200)
+	if offset := writersAppliedOffset(); offset != 0 {
+		t.Errorf("offset answered by b while a has an earlier entry in flight. offset=[%d]", offset)
+	}
+	a.AddApplied(10, 100)
+	if offset := writersAppliedOffset(); offset != 200 {
+		t.Errorf("both writers are idle. offset=[%d]", offset)
+	}
+	a.AddInFlight()
+	if offset := writersAppliedOffset(); offset != 100 {
+		t.Errorf("a has an entry in flight after its offset. offset=[%d]", offset)
+	}
+}
+
+func TestDroppedOffset(t *testing.T) {
+	defer func(old map[string]*ShardMetrics) { shards.metrics = old }(shards.metrics)
+	defer ResetAOFAppliedOffset()
+	shards.metrics = nil
+	w := RegisterShard(RoleWriter, "a")
+
+	w.AddInFlight()
+	AddDroppedOffset(200)
+	if offset := GetAOFAppliedOffset(); offset != 0 {
+		t.Errorf("dropped offset applied before the entry in flight. offset=[%d]", offset)
+	}
+	w.AddApplied(10, 100)
+	if offset := GetAOFAppliedOffset(); offset != 200 {
+		t.Errorf("dropped offset not applied after the writer is idle. offset=[%d]", offset)
+	}
+	AddDroppedOffset(300)
+	if offset := GetAOFAppliedOffset(); offset != 300 {
+		t.Errorf("dropped offset not applied while the writer is idle. offset=[%d]", offset)
+	}
+}
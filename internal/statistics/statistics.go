@@ -5,31 +5,100 @@ import (
 	"fmt"
 	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/log"
+	"math"
 	"math/bits"
 	"net/http"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type metrics struct {
 	// info
-	Address string `json:"address"`
+	Address         string `json:"address"`
+	SourceConnected bool   `json:"source_connected"`
 
 	// entries
 	EntryId              uint64 `json:"entry_id"`
 	AllowEntriesCount    uint64 `json:"allow_entries_count"`
 	DisallowEntriesCount uint64 `json:"disallow_entries_count"`
 
+	// keys of key_file migrated, see keys mode
+	KeyFileKeysCount uint64 `json:"key_file_keys_count"`
+
 	// rdb
 	IsDoingBgsave   bool   `json:"is_doing_bgsave"`
 	RdbFileSize     uint64 `json:"rdb_file_size"`
 	RdbReceivedSize uint64 `json:"rdb_received_size"`
 	RdbSendSize     uint64 `json:"rdb_send_size"`
 
+	// rdb anomalies
+	SetDuplicateMembersCount uint64 `json:"set_duplicate_members_count"`
+
+	// values rewritten into commands after the target rejected RESTORE
+	RestoreFallbackCount uint64 `json:"restore_fallback_count"`
+
+	// keys whose LRU idle time or LFU frequency is not kept by the target, see restore_idle_freq
+	IdleFreqLostCount uint64 `json:"idle_freq_lost_count"`
+
+	// EVALSHA retried after NOSCRIPT with the script loaded on the target
+	ScriptReloadCount uint64 `json:"script_reload_count"`
+	// EVALSHA of unknown scripts applied by copying their keys from the source
+	ScriptKeysCopiedCount uint64 `json:"script_keys_copied_count"`
+
+	// memory pressure of the target, see oom_pause
+	OOMPaused bool   `json:"oom_paused"`
+	OOMCount  uint64 `json:"oom_count"`
+
+	// values skipped because a previous run restored them, see resume_dedupe
+	DedupeSkippedCount uint64 `json:"dedupe_skipped_count"`
+
+	// connections to the target broken and reconnected, see target_outage_max_seconds
+	TargetOutageCount uint64 `json:"target_outage_count"`
+
 	// aof
 	AofReceivedOffset uint64 `json:"aof_received_offset"`
 	AofAppliedOffset  uint64 `json:"aof_applied_offset"`
 
+	// durability checkpoints, see wait_replicas
+	DurableOffset    uint64 `json:"durable_offset"`
+	WaitTimeoutCount uint64 `json:"wait_timeout_count"`
+
+	// sampling consistency checker
+	SampleCheckedCount      uint64  `json:"sample_checked_count"`
+	SampleInconsistentCount uint64  `json:"sample_inconsistent_count"`
+	ConsistencyScore        float64 `json:"consistency_score"`
+
+	// write-then-readback sampling, see readback_every
+	ReadbackCheckedCount uint64  `json:"readback_checked_count"`
+	ReadbackMangledCount uint64  `json:"readback_mangled_count"`
+	WriteCorrectness     float64 `json:"write_correctness"`
+
+	// canary phase, see canary_ratio
+	CanaryStatus            string `json:"canary_status"` // migrating, verifying, passed or failed
+	CanaryKeysCount         uint64 `json:"canary_keys_count"`
+	CanaryInconsistentCount uint64 `json:"canary_inconsistent_count"`
+
+	// verify
+	VerifyCheckedCount      uint64 `json:"verify_checked_count"`
+	VerifyInconsistentCount uint64 `json:"verify_inconsistent_count"`
+	VerifyExtraCount        uint64 `json:"verify_extra_count"`
+
+	// progress of the scan of verify and ttl repair, including the keys
+	// scanned before a resume. The total is from INFO keyspace.
+	VerifyScannedKeys uint64  `json:"verify_scanned_keys"`
+	VerifyTotalKeys   uint64  `json:"verify_total_keys"`
+	VerifyPercent     float64 `json:"verify_percent"`
+	VerifyETASeconds  int64   `json:"verify_eta_seconds"` // -1 means unknown
+
+	// ttl repair
+	TTLCheckedCount  uint64 `json:"ttl_checked_count"`
+	TTLRepairedCount uint64 `json:"ttl_repaired_count"`
+	TTLMissingCount  uint64 `json:"ttl_missing_count"`
+
 	// for performance debug
 	InQueueEntriesCount  uint64 `json:"in_queue_entries_count"`
 	UnansweredBytesCount uint64 `json:"unanswered_bytes_count"`
@@ -44,9 +113,60 @@ type metrics struct {
 
 var Metrics = &metrics{}
 
+// metricsMu guards the fields of Metrics that are not uint64, the uint64
+// fields are updated and read atomically.
+var metricsMu sync.Mutex
+
+// LoadMetrics returns a copy of Metrics, the uint64 fields are read
+// atomically.
+func LoadMetrics() metrics {
+	var m metrics
+	src := reflect.ValueOf(Metrics).Elem()
+	dst := reflect.ValueOf(&m).Elem()
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for i := 0; i < src.NumField(); i++ {
+		if field := src.Field(i); field.Kind() == reflect.Uint64 {
+			dst.Field(i).SetUint(atomic.LoadUint64(field.Addr().Interface().(*uint64)))
+		} else {
+			dst.Field(i).Set(field)
+		}
+	}
+	return m
+}
+
+// Snapshot returns a copy of the metrics with the drop counts, the metrics of
+// each reader and writer, the big keys in progress, the current outage of the
+// target and the scripts loaded on it, for json.
+func Snapshot() interface{} {
+	return struct {
+		metrics
+		DropCounts          map[string]uint64 `json:"drop_counts"`
+		Shards              []ShardMetrics    `json:"shards,omitempty"`
+		BigKeys             []BigKeyProgress  `json:"big_keys,omitempty"`
+		SinkStats           map[string]uint64 `json:"sink_stats,omitempty"`
+		TargetOutageSeconds float64           `json:"target_outage_seconds"`
+		LoadedScripts       []string          `json:"loaded_scripts,omitempty"`
+	}{LoadMetrics(), GetDropCounts(), GetShards(), GetBigKeys(), getSinkStats(), LongestTargetOutage().Seconds(), GetLoadedScripts()}
+}
+
+// sinkStats returns the stats of the sink when the target is a sink.
+var sinkStats atomic.Value // func() map[string]uint64
+
+func SetSinkStats(stats func() map[string]uint64) {
+	sinkStats.Store(stats)
+}
+
+func getSinkStats() map[string]uint64 {
+	if stats, ok := sinkStats.Load().(func() map[string]uint64); ok {
+		return stats()
+	}
+	return nil
+}
+
 func Handler(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(Metrics)
+	err := json.NewEncoder(w).Encode(Snapshot())
 	if err != nil {
 		log.PanicError(err)
 	}
@@ -59,99 +179,436 @@ func Init() {
 			log.Infof("statistics disabled. seconds=[%d]", seconds)
 		}
 
-		lastAllowEntriesCount := Metrics.AllowEntriesCount
-		lastDisallowEntriesCount := Metrics.DisallowEntriesCount
+		lastAllowEntriesCount := atomic.LoadUint64(&Metrics.AllowEntriesCount)
+		lastDisallowEntriesCount := atomic.LoadUint64(&Metrics.DisallowEntriesCount)
 
 		for range time.Tick(time.Duration(seconds) * time.Second) {
+			m := LoadMetrics()
+			var msg string
 			// scan
-			if config.Config.Type == "scan" {
-				Metrics.Msg = fmt.Sprintf("syncing. dbId=[%d], percent=[%.2f]%%, allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes",
-					Metrics.ScanDbId,
-					float64(bits.Reverse64(Metrics.ScanCursor))/float64(^uint(0))*100,
-					float32(Metrics.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
-					float32(Metrics.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
-					Metrics.EntryId,
-					Metrics.InQueueEntriesCount,
-					Metrics.UnansweredBytesCount)
-				log.Infof(strings.Replace(Metrics.Msg, "%", "%%", -1))
-				lastAllowEntriesCount = Metrics.AllowEntriesCount
-				lastDisallowEntriesCount = Metrics.DisallowEntriesCount
+			if config.Config.Type == "scan" || atomic.LoadInt32(&scanProgress) == 1 {
+				msg = fmt.Sprintf("syncing. dbId=[%d], percent=[%.2f]%%, allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes",
+					m.ScanDbId,
+					float64(bits.Reverse64(m.ScanCursor))/float64(^uint(0))*100,
+					float32(m.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
+					float32(m.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
+					m.EntryId,
+					m.InQueueEntriesCount,
+					m.UnansweredBytesCount)
+				logMsg(msg)
+				lastAllowEntriesCount = m.AllowEntriesCount
+				lastDisallowEntriesCount = m.DisallowEntriesCount
+				continue
+			}
+			// keys
+			if config.Config.Type == "keys" {
+				msg = fmt.Sprintf("syncing keys of key_file. dbId=[%d], keys=[%d], allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes",
+					m.ScanDbId,
+					m.KeyFileKeysCount,
+					float32(m.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
+					float32(m.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
+					m.EntryId,
+					m.InQueueEntriesCount,
+					m.UnansweredBytesCount)
+				logMsg(msg)
+				lastAllowEntriesCount = m.AllowEntriesCount
+				lastDisallowEntriesCount = m.DisallowEntriesCount
+				continue
+			}
+			// bench
+			if config.Config.Type == "bench" {
+				msg = fmt.Sprintf("benchmarking. percent=[%.2f]%%, allowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes",
+					float64(m.AllowEntriesCount)*100/float64(config.Config.Source.BenchCount),
+					float32(m.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
+					m.EntryId,
+					m.InQueueEntriesCount,
+					m.UnansweredBytesCount)
+				logMsg(msg)
+				lastAllowEntriesCount = m.AllowEntriesCount
+				continue
+			}
+			// canary
+			if m.CanaryStatus == "migrating" || m.CanaryStatus == "verifying" {
+				msg = fmt.Sprintf("canary %s. keys=[%d], inconsistent=[%d]",
+					m.CanaryStatus,
+					m.CanaryKeysCount,
+					m.CanaryInconsistentCount)
+				logMsg(msg)
+				continue
+			}
+			// verify
+			if config.Config.Type == "verify" {
+				msg = fmt.Sprintf("verifying. percent=[%.2f]%%, eta=[%ds], dbId=[%d], checked=[%d], inconsistent=[%d], extra=[%d]",
+					m.VerifyPercent,
+					m.VerifyETASeconds,
+					m.ScanDbId,
+					m.VerifyCheckedCount,
+					m.VerifyInconsistentCount,
+					m.VerifyExtraCount)
+				logMsg(msg)
+				continue
+			}
+			// ttl repair
+			if config.Config.Type == "ttl" {
+				msg = fmt.Sprintf("repairing ttl. percent=[%.2f]%%, eta=[%ds], dbId=[%d], checked=[%d], repaired=[%d], missing_on_target=[%d]",
+					m.VerifyPercent,
+					m.VerifyETASeconds,
+					m.ScanDbId,
+					m.TTLCheckedCount,
+					m.TTLRepairedCount,
+					m.TTLMissingCount)
+				logMsg(msg)
 				continue
 			}
 			// sync or restore
-			if Metrics.RdbFileSize == 0 {
-				Metrics.Msg = "source db is doing bgsave"
-			} else if Metrics.RdbSendSize > Metrics.RdbReceivedSize {
-				Metrics.Msg = fmt.Sprintf("receiving rdb. percent=[%.2f]%%, rdbFileSize=[%.3f]G, rdbReceivedSize=[%.3f]G",
-					float64(Metrics.RdbReceivedSize)/float64(Metrics.RdbFileSize)*100,
-					float64(Metrics.RdbFileSize)/1024/1024/1024,
-					float64(Metrics.RdbReceivedSize)/1024/1024/1024)
-			} else if Metrics.RdbFileSize > Metrics.RdbSendSize {
-				Metrics.Msg = fmt.Sprintf("syncing rdb. percent=[%.2f]%%, allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes, rdbFileSize=[%.3f]G, rdbSendSize=[%.3f]G",
-					float64(Metrics.RdbSendSize)*100/float64(Metrics.RdbFileSize),
-					float32(Metrics.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
-					float32(Metrics.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
-					Metrics.EntryId,
-					Metrics.InQueueEntriesCount,
-					Metrics.UnansweredBytesCount,
-					float64(Metrics.RdbFileSize)/1024/1024/1024,
-					float64(Metrics.RdbSendSize)/1024/1024/1024)
+			if m.RdbFileSize == 0 {
+				msg = "source db is doing bgsave"
+			} else if m.RdbSendSize > m.RdbReceivedSize {
+				msg = fmt.Sprintf("receiving rdb. percent=[%.2f]%%, rdbFileSize=[%.3f]G, rdbReceivedSize=[%.3f]G",
+					float64(m.RdbReceivedSize)/float64(m.RdbFileSize)*100,
+					float64(m.RdbFileSize)/1024/1024/1024,
+					float64(m.RdbReceivedSize)/1024/1024/1024)
+			} else if m.RdbFileSize > m.RdbSendSize {
+				msg = fmt.Sprintf("syncing rdb. percent=[%.2f]%%, allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes, rdbFileSize=[%.3f]G, rdbSendSize=[%.3f]G",
+					float64(m.RdbSendSize)*100/float64(m.RdbFileSize),
+					float32(m.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
+					float32(m.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
+					m.EntryId,
+					m.InQueueEntriesCount,
+					m.UnansweredBytesCount,
+					float64(m.RdbFileSize)/1024/1024/1024,
+					float64(m.RdbSendSize)/1024/1024/1024)
 			} else {
-				Metrics.Msg = fmt.Sprintf("syncing aof. allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes, diff=[%d], aofReceivedOffset=[%d], aofAppliedOffset=[%d]",
-					float32(Metrics.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
-					float32(Metrics.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
-					Metrics.EntryId,
-					Metrics.InQueueEntriesCount,
-					Metrics.UnansweredBytesCount,
-					Metrics.AofReceivedOffset-Metrics.AofAppliedOffset,
-					Metrics.AofReceivedOffset,
-					Metrics.AofAppliedOffset)
+				msg = fmt.Sprintf("syncing aof. allowOps=[%.2f], disallowOps=[%.2f], entryId=[%d], InQueueEntriesCount=[%d], unansweredBytesCount=[%d]bytes, diff=[%d], aofReceivedOffset=[%d], aofAppliedOffset=[%d]",
+					float32(m.AllowEntriesCount-lastAllowEntriesCount)/float32(seconds),
+					float32(m.DisallowEntriesCount-lastDisallowEntriesCount)/float32(seconds),
+					m.EntryId,
+					m.InQueueEntriesCount,
+					m.UnansweredBytesCount,
+					m.AofReceivedOffset-m.AofAppliedOffset,
+					m.AofReceivedOffset,
+					m.AofAppliedOffset)
 			}
-			log.Infof(strings.Replace(Metrics.Msg, "%", "%%", -1))
-			lastAllowEntriesCount = Metrics.AllowEntriesCount
-			lastDisallowEntriesCount = Metrics.DisallowEntriesCount
+			logMsg(msg)
+			logBigKeys(time.Duration(seconds) * time.Second)
+			lastAllowEntriesCount = m.AllowEntriesCount
+			lastDisallowEntriesCount = m.DisallowEntriesCount
 		}
 	}()
 }
 
+// scanProgress is 1 if the progress of a scan is logged in another type, such
+// as sync falling back to scan.
+var scanProgress int32
+
+func SetScanProgress(scanning bool) {
+	if scanning {
+		atomic.StoreInt32(&scanProgress, 1)
+	} else {
+		atomic.StoreInt32(&scanProgress, 0)
+	}
+}
+
+// logMsg logs msg, the status served as msg.
+func logMsg(msg string) {
+	metricsMu.Lock()
+	Metrics.Msg = msg
+	metricsMu.Unlock()
+	log.Infof(strings.Replace(msg, "%", "%%", -1))
+}
+
 // entry id
 
 func UpdateEntryId(id uint64) {
-	Metrics.EntryId = id
+	atomic.StoreUint64(&Metrics.EntryId, id)
+}
+
+// commandCounts counts the allowed entries by command, for the diagnostic
+// dump. It is updated for every entry, a name is added once and then counted
+// atomically.
+var commandCounts sync.Map // command name -> *uint64
+
+func AddCommandCount(cmdName string) {
+	count, ok := commandCounts.Load(cmdName)
+	if !ok {
+		count, _ = commandCounts.LoadOrStore(cmdName, new(uint64))
+	}
+	atomic.AddUint64(count.(*uint64), 1)
+}
+
+func GetCommandCounts() map[string]uint64 {
+	counts := make(map[string]uint64)
+	commandCounts.Range(func(name, count interface{}) bool {
+		counts[name.(string)] = atomic.LoadUint64(count.(*uint64))
+		return true
+	})
+	return counts
 }
+
+// dropCounts counts the entries and keys not written to target by reason,
+// such as filter:key_patterns, expired or busykey
+var dropCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func AddDropCount(reason string) {
+	dropCounts.mu.Lock()
+	if dropCounts.counts == nil {
+		dropCounts.counts = make(map[string]uint64)
+	}
+	dropCounts.counts[reason]++
+	dropCounts.mu.Unlock()
+}
+
+func GetDropCounts() map[string]uint64 {
+	dropCounts.mu.Lock()
+	defer dropCounts.mu.Unlock()
+	counts := make(map[string]uint64, len(dropCounts.counts))
+	for reason, count := range dropCounts.counts {
+		counts[reason] = count
+	}
+	return counts
+}
+
 func AddAllowEntriesCount() {
-	Metrics.AllowEntriesCount++
+	atomic.AddUint64(&Metrics.AllowEntriesCount, 1)
 }
 func AddDisallowEntriesCount() {
-	Metrics.DisallowEntriesCount++
+	atomic.AddUint64(&Metrics.DisallowEntriesCount, 1)
 }
 
 // rdb
 
+func SetSourceConnected(connected bool) {
+	metricsMu.Lock()
+	Metrics.SourceConnected = connected
+	metricsMu.Unlock()
+}
+
 func SetRDBFileSize(size uint64) {
-	Metrics.RdbFileSize = size
+	atomic.StoreUint64(&Metrics.RdbFileSize, size)
 }
 func UpdateRDBReceivedSize(size uint64) {
-	Metrics.RdbReceivedSize = size
+	atomic.StoreUint64(&Metrics.RdbReceivedSize, size)
 }
 func UpdateRDBSentSize(offset uint64) {
-	Metrics.RdbSendSize = offset
+	atomic.StoreUint64(&Metrics.RdbSendSize, offset)
+}
+
+func AddSetDuplicateMembersCount(count uint64) {
+	atomic.AddUint64(&Metrics.SetDuplicateMembersCount, count)
+}
+
+func AddRestoreFallbackCount() {
+	atomic.AddUint64(&Metrics.RestoreFallbackCount, 1)
+}
+
+func AddKeyFileKeysCount() {
+	atomic.AddUint64(&Metrics.KeyFileKeysCount, 1)
+}
+
+func AddIdleFreqLostCount() {
+	atomic.AddUint64(&Metrics.IdleFreqLostCount, 1)
+}
+
+func AddScriptReloadCount() {
+	atomic.AddUint64(&Metrics.ScriptReloadCount, 1)
+}
+
+func AddScriptKeysCopiedCount() {
+	atomic.AddUint64(&Metrics.ScriptKeysCopiedCount, 1)
+}
+
+// loadedScripts are the sha1 of the scripts loaded on the target by SCRIPT
+// LOAD, including the lua aux fields of the rdb.
+var loadedScripts struct {
+	mu   sync.Mutex
+	shas map[string]struct{}
+}
+
+func AddLoadedScript(sha string) {
+	loadedScripts.mu.Lock()
+	if loadedScripts.shas == nil {
+		loadedScripts.shas = make(map[string]struct{})
+	}
+	loadedScripts.shas[sha] = struct{}{}
+	loadedScripts.mu.Unlock()
+}
+
+func GetLoadedScripts() []string {
+	loadedScripts.mu.Lock()
+	shas := make([]string, 0, len(loadedScripts.shas))
+	for sha := range loadedScripts.shas {
+		shas = append(shas, sha)
+	}
+	loadedScripts.mu.Unlock()
+	sort.Strings(shas)
+	return shas
+}
+
+func AddDedupeSkippedCount() {
+	atomic.AddUint64(&Metrics.DedupeSkippedCount, 1)
+}
+
+func SetOOMPaused(paused bool) {
+	metricsMu.Lock()
+	Metrics.OOMPaused = paused
+	metricsMu.Unlock()
+}
+
+func AddOOMCount() {
+	atomic.AddUint64(&Metrics.OOMCount, 1)
 }
 
 // aof
 
 func UpdateAOFReceivedOffset(offset uint64) {
-	Metrics.AofReceivedOffset = offset
+	atomic.StoreUint64(&Metrics.AofReceivedOffset, offset)
 }
+
+// UpdateAOFAppliedOffset is called by writers when the target applied all
+// commands up to offset. The applied offset only grows, entries from rdb have
+// offset 0.
 func UpdateAOFAppliedOffset(offset uint64) {
-	Metrics.AofAppliedOffset = offset
+	for {
+		old := atomic.LoadUint64(&Metrics.AofAppliedOffset)
+		if offset <= old || atomic.CompareAndSwapUint64(&Metrics.AofAppliedOffset, old, offset) {
+			return
+		}
+	}
+}
+func GetAOFAppliedOffset() uint64 {
+	return atomic.LoadUint64(&Metrics.AofAppliedOffset)
+}
+func ResetAOFAppliedOffset() {
+	atomic.StoreUint64(&Metrics.AofAppliedOffset, 0)
+	resetShardOffsets()
+}
+
+// durability
+
+// UpdateDurableOffset is called when WAIT is satisfied, the commands until
+// offset are on the replicas of the target.
+func UpdateDurableOffset(offset uint64) {
+	for {
+		old := atomic.LoadUint64(&Metrics.DurableOffset)
+		if offset <= old || atomic.CompareAndSwapUint64(&Metrics.DurableOffset, old, offset) {
+			return
+		}
+	}
+}
+func AddWaitTimeoutCount() {
+	atomic.AddUint64(&Metrics.WaitTimeoutCount, 1)
+}
+func GetWaitTimeoutCount() uint64 {
+	return atomic.LoadUint64(&Metrics.WaitTimeoutCount)
+}
+
+// checker
+
+// UpdateConsistencyScore is called after every sampled key, score is the
+// consistent ratio of the recent samples.
+func UpdateConsistencyScore(score float64, consistent bool) {
+	atomic.AddUint64(&Metrics.SampleCheckedCount, 1)
+	if !consistent {
+		atomic.AddUint64(&Metrics.SampleInconsistentCount, 1)
+	}
+	metricsMu.Lock()
+	Metrics.ConsistencyScore = score
+	metricsMu.Unlock()
+}
+
+// UpdateWriteCorrectness is called after every write read back, correctness
+// is the ratio of writes read back unchanged.
+func UpdateWriteCorrectness(correct bool) {
+	metricsMu.Lock()
+	checked := atomic.AddUint64(&Metrics.ReadbackCheckedCount, 1)
+	mangled := atomic.LoadUint64(&Metrics.ReadbackMangledCount)
+	if !correct {
+		mangled = atomic.AddUint64(&Metrics.ReadbackMangledCount, 1)
+	}
+	Metrics.WriteCorrectness = 1 - float64(mangled)/float64(checked)
+	metricsMu.Unlock()
+}
+
+func UpdateCanaryStatus(status string) {
+	metricsMu.Lock()
+	Metrics.CanaryStatus = status
+	metricsMu.Unlock()
+}
+func AddCanaryKeysCount() {
+	atomic.AddUint64(&Metrics.CanaryKeysCount, 1)
+}
+func AddCanaryInconsistentCount() {
+	atomic.AddUint64(&Metrics.CanaryInconsistentCount, 1)
+}
+
+func AddVerifyCheckedCount() {
+	atomic.AddUint64(&Metrics.VerifyCheckedCount, 1)
+}
+func AddVerifyInconsistentCount() {
+	atomic.AddUint64(&Metrics.VerifyInconsistentCount, 1)
+}
+
+func AddVerifyExtraCount() {
+	atomic.AddUint64(&Metrics.VerifyExtraCount, 1)
+}
+
+// UpdateVerifyProgress is called after every batch of scanned keys, eta < 0
+// means unknown.
+func UpdateVerifyProgress(scanned uint64, total uint64, eta time.Duration) {
+	atomic.StoreUint64(&Metrics.VerifyScannedKeys, scanned)
+	atomic.StoreUint64(&Metrics.VerifyTotalKeys, total)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if total > 0 {
+		Metrics.VerifyPercent = math.Min(float64(scanned)/float64(total)*100, 100)
+	}
+	Metrics.VerifyETASeconds = int64(eta.Seconds())
+	if eta < 0 {
+		Metrics.VerifyETASeconds = -1
+	}
+}
+
+// ttl repair
+
+func AddTTLCheckedCount() {
+	atomic.AddUint64(&Metrics.TTLCheckedCount, 1)
+}
+
+func AddTTLRepairedCount() {
+	atomic.AddUint64(&Metrics.TTLRepairedCount, 1)
+}
+
+func AddTTLMissingCount() {
+	atomic.AddUint64(&Metrics.TTLMissingCount, 1)
 }
 
 // for debug
 
 func UpdateInQueueEntriesCount(count uint64) {
-	Metrics.InQueueEntriesCount = count
+	atomic.StoreUint64(&Metrics.InQueueEntriesCount, count)
+}
+
+// scan position
+
+// UpdateScanPosition is called by the scan of readers and checkers.
+func UpdateScanPosition(dbId int, cursor uint64) {
+	metricsMu.Lock()
+	Metrics.ScanDbId = dbId
+	metricsMu.Unlock()
+	atomic.StoreUint64(&Metrics.ScanCursor, cursor)
+}
+
+func SetDoingBgsave(doing bool) {
+	metricsMu.Lock()
+	Metrics.IsDoingBgsave = doing
+	metricsMu.Unlock()
 }
-func UpdateUnansweredBytesCount(count uint64) {
-	Metrics.UnansweredBytesCount = count
+
+func SetAddress(address string) {
+	metricsMu.Lock()
+	Metrics.Address = address
+	metricsMu.Unlock()
 }
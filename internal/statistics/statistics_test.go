@@ -0,0 +1,32 @@
+package statistics
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLoadMetrics(t *testing.T) {
+	defer func(old metrics) { *Metrics = old }(LoadMetrics())
+	before := LoadMetrics().AllowEntriesCount
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			AddAllowEntriesCount()
+			UpdateScanPosition(1, uint64(i))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			UpdateCanaryStatus("migrating")
+			_ = Snapshot()
+		}
+	}()
+	wg.Wait()
+	m := LoadMetrics()
+	if m.AllowEntriesCount != before+1000 || m.ScanCursor != 999 || m.ScanDbId != 1 || m.CanaryStatus != "migrating" {
+		t.Errorf("metrics not loaded. allow=[%d], cursor=[%d], db=[%d], canary=[%s]", m.AllowEntriesCount, m.ScanCursor, m.ScanDbId, m.CanaryStatus)
+	}
+}
@@ -0,0 +1,26 @@
+package utils
+
+import "time"
+
+// Backoff is an exponential backoff starting from 1 second.
+type Backoff struct {
+	current time.Duration
+	max     time.Duration
+}
+
+func NewBackoff(max time.Duration) *Backoff {
+	return &Backoff{current: time.Second, max: max}
+}
+
+// Wait sleeps for the current interval, then doubles it up to max.
+func (b *Backoff) Wait() {
+	time.Sleep(b.current)
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+}
+
+func (b *Backoff) Reset() {
+	b.current = time.Second
+}
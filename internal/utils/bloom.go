@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+const bloomMagic = "RSBLOOM1"
+
+// Bloom is a bloom filter, it is not safe for concurrent use.
+type Bloom struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// NewBloom sizes the filter for n items at false positive rate p.
+func NewBloom(n uint64, p float64) *Bloom {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return &Bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// locations uses double hashing, h1 + i*h2 for i in [0, k).
+func (b *Bloom) locations(data []byte) []uint64 {
+	h1 := fnv.New64a()
+	_, _ = h1.Write(data)
+	h2 := fnv.New64()
+	_, _ = h2.Write(data)
+	a, c := h1.Sum64(), h2.Sum64()|1
+	locations := make([]uint64, b.k)
+	for i := uint64(0); i < b.k; i++ {
+		locations[i] = (a + i*c) % b.m
+	}
+	return locations
+}
+
+func (b *Bloom) Add(data []byte) {
+	for _, l := range b.locations(data) {
+		b.bits[l/64] |= 1 << (l % 64)
+	}
+}
+
+// Contains reports false if data was never added, true if it probably was.
+func (b *Bloom) Contains(data []byte) bool {
+	for _, l := range b.locations(data) {
+		if b.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SameSize reports whether b and o have the same number of bits and hashes.
+func (b *Bloom) SameSize(o *Bloom) bool {
+	return b.m == o.m && b.k == o.k
+}
+
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(bloomMagic); err != nil {
+		return 0, err
+	}
+	for _, v := range []uint64{b.m, b.k} {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return 0, err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, b.bits); err != nil {
+		return 0, err
+	}
+	return int64(len(bloomMagic) + 16 + 8*len(b.bits)), bw.Flush()
+}
+
+// ReadBloom reads a filter saved by WriteTo.
+func ReadBloom(r io.Reader) (*Bloom, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != bloomMagic {
+		return nil, errors.New("not a bloom filter file")
+	}
+	b := new(Bloom)
+	if err := binary.Read(br, binary.LittleEndian, &b.m); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(br, binary.LittleEndian, &b.k); err != nil {
+		return nil, err
+	}
+	if b.m == 0 || b.k == 0 || b.m > 1<<40 {
+		return nil, errors.New("invalid bloom filter size")
+	}
+	b.bits = make([]uint64, (b.m+63)/64)
+	if err := binary.Read(br, binary.LittleEndian, b.bits); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestBloom(t *testing.T) {
+	b := NewBloom(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		b.Add([]byte("key:" + strconv.Itoa(i)))
+	}
+	for i := 0; i < 10000; i++ {
+		if !b.Contains([]byte("key:" + strconv.Itoa(i))) {
+			t.Fatalf("added item not found. i=[%d]", i)
+		}
+	}
+	falsePositives := 0
+	for i := 10000; i < 20000; i++ {
+		if b.Contains([]byte("key:" + strconv.Itoa(i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Errorf("false positive rate too high. false_positives=[%d]", falsePositives)
+	}
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadBloom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.SameSize(b) || !loaded.Contains([]byte("key:42")) {
+		t.Errorf("loaded filter differs")
+	}
+}
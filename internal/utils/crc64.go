@@ -95,3 +95,11 @@ func CalcCRC64(p []byte) uint64 {
 	}
 	return crc
 }
+
+// UpdateCRC64 continues the crc64 of previous bytes with p.
+func UpdateCRC64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2022, 3, 15, 10, 30, 20, 0, time.UTC) // Tuesday
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2022, 3, 15, 10, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2022, 3, 16, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, 3, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2022, 3, 15, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2022, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, 3, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 4", time.Date(2022, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * *", time.Date(2022, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 1", time.Date(2022, 3, 21, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31/2 * 1", time.Date(2022, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * */2", time.Date(2022, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 2 29 2 *", time.Date(2024, 2, 29, 2, 30, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%s) failed: %v", c.expr, err)
+		}
+		if next := cron.Next(from); !next.Equal(c.next) {
+			t.Errorf("Next(%s) = %v, expected %v", c.expr, next, c.next)
+		}
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%s) should fail", expr)
+		}
+	}
+}
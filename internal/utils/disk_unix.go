@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// FreeDiskSpace returns the bytes available to the process on the file
+// system of dir.
+func FreeDiskSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
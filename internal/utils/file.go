@@ -16,3 +16,20 @@ func DoesFileExist(fileName string) bool {
 	}
 	return true
 }
+
+// LockDir makes sure only one redis-shake uses the working dir, the aof files,
+// checkpoints and logs in it would be corrupted otherwise. The lock is held
+// until the process exits.
+func LockDir() {
+	f, err := os.OpenFile("redis-shake.lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.PanicError(err)
+	}
+	if err = lockFile(f); err != nil {
+		_ = f.Close()
+		log.Panicf("another redis-shake is running in the same dir, change dir in config. err=[%v]", err)
+	}
+	lockedFile = f
+}
+
+var lockedFile *os.File // never closed, the lock is released on exit
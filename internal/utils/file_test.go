@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+// TestLockDir checks that a second redis-shake in the same dir is refused.
+func TestLockDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	LockDir()
+	defer lockedFile.Close()
+	// another process opens the lock file on its own
+	f, err := os.OpenFile("redis-shake.lock", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err == nil {
+		t.Fatal("the dir is locked twice")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("LockDir should panic when the dir is locked")
+			}
+		}()
+		LockDir()
+	}()
+}
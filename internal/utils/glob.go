@@ -0,0 +1,88 @@
+package utils
+
+// MatchPattern reports whether key matches the redis glob-style pattern.
+// Supported: * ? [abc] [^abc] [a-z] and \ to escape, same as redis KEYS.
+func MatchPattern(pattern string, key string) bool {
+	p, k := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; k <= len(key); k++ {
+				if MatchPattern(pattern[p+1:], key[k:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if k >= len(key) {
+				return false
+			}
+			k++
+		case '[':
+			if k >= len(key) {
+				return false
+			}
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for p < len(pattern) && pattern[p] != ']' {
+				if pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == key[k] {
+						match = true
+					}
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if key[k] >= start && key[k] <= end {
+						match = true
+					}
+					p += 2
+				} else if pattern[p] == key[k] {
+					match = true
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			k++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if k >= len(key) || pattern[p] != key[k] {
+				return false
+			}
+			k++
+		}
+		p++
+	}
+	return k == len(key)
+}
+
+// MatchAnyPattern reports whether key matches one of the patterns.
+func MatchAnyPattern(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if MatchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// dryRunWriter serializes the entries like the redis writers but discards
+// them, or writes them to a file in RESP. A summary of what would have been
+// written is logged on close.
+type dryRunWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	buf    *bytes.Buffer
+
+	start    time.Time
+	count    uint64
+	bytes    uint64
+	commands map[string]uint64
+	dbs      map[int]uint64
+	dbId     int
+}
+
+// NewDryRunWriter discards the commands if path is empty.
+func NewDryRunWriter(path string) Writer {
+	w := &dryRunWriter{
+		buf:      new(bytes.Buffer),
+		start:    time.Now(),
+		commands: make(map[string]uint64),
+		dbs:      make(map[int]uint64),
+		dbId:     -1,
+	}
+	if path != "" {
+		var err error
+		w.file, err = os.Create(path)
+		if err != nil {
+			log.PanicError(err)
+		}
+		w.writer = bufio.NewWriter(w.file)
+		log.Infof("dryRunWriter writes commands to file. path=[%s]", path)
+	} else {
+		log.Infof("dryRunWriter discards commands")
+	}
+	return w
+}
+
+func (w *dryRunWriter) Write(e *entry.Entry) {
+	w.buf.Reset()
+	if w.dbId != e.DbId {
+		client.EncodeArgv([]string{"SELECT", strconv.Itoa(e.DbId)}, w.buf)
+		w.dbId = e.DbId
+	}
+	client.EncodeArgv(e.Argv, w.buf)
+	e.EncodedSize = uint64(w.buf.Len())
+	if w.writer != nil {
+		if _, err := w.writer.Write(w.buf.Bytes()); err != nil {
+			log.PanicError(err)
+		}
+	}
+	w.count++
+	w.bytes += e.EncodedSize
+	w.commands[e.CmdName]++
+	w.dbs[e.DbId]++
+	if e.OnDrop != nil {
+		e.OnDrop()
+	}
+	// the source is acked as if the target applied it
+	statistics.UpdateAOFAppliedOffset(uint64(e.Offset))
+}
+
+func (w *dryRunWriter) Close() {
+	if w.writer != nil {
+		if err := w.writer.Flush(); err != nil {
+			log.PanicError(err)
+		}
+		if err := w.file.Close(); err != nil {
+			log.PanicError(err)
+		}
+	}
+	elapsed := time.Since(w.start)
+	log.Infof("dry run summary. commands=[%d], bytes=[%d], elapsed=[%v], commands_per_second=[%.2f], bytes_per_second=[%.2f]",
+		w.count, w.bytes, elapsed, float64(w.count)/elapsed.Seconds(), float64(w.bytes)/elapsed.Seconds())
+
+	names := make([]string, 0, len(w.commands))
+	for name := range w.commands {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return w.commands[names[i]] > w.commands[names[j]] })
+	for _, name := range names {
+		log.Infof("dry run command. name=[%s], count=[%d]", name, w.commands[name])
+	}
+	dbIds := make([]int, 0, len(w.dbs))
+	for dbId := range w.dbs {
+		dbIds = append(dbIds, dbId)
+	}
+	sort.Ints(dbIds)
+	for _, dbId := range dbIds {
+		log.Infof("dry run db. db=[%d], count=[%d]", dbId, w.dbs[dbId])
+	}
+}
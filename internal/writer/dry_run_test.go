@@ -0,0 +1,40 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/entry"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRunWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dry_run.aof")
+	w := NewDryRunWriter(path)
+	dropped := 0
+	for _, e := range []*entry.Entry{
+		{DbId: 0, Argv: []string{"SET", "k", "v"}, CmdName: "SET"},
+		{DbId: 0, Argv: []string{"DEL", "k"}, CmdName: "DEL"},
+		{DbId: 1, Argv: []string{"SET", "k", "v"}, CmdName: "SET"},
+	} {
+		e.OnDrop = func() { dropped++ }
+		w.Write(e)
+	}
+	w.Close()
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n" +
+		"*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n" +
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	if string(buf) != want {
+		t.Errorf("file=%q, want=%q", buf, want)
+	}
+	// nothing reaches a target, the entries are not replied
+	if dropped != 3 {
+		t.Errorf("dropped=[%d], want 3", dropped)
+	}
+}
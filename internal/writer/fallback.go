@@ -0,0 +1,85 @@
+package writer
+
+import (
+	"errors"
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+)
+
+// restoreRejected reports whether the error reply of RESTORE means the target
+// can not restore the payload, and whether it rejects every RESTORE: the
+// command is unknown, renamed or not permitted, or the payload version is
+// newer than the target. Bad data format is usually an encoding the target
+// does not know, only for some types.
+func restoreRejected(e *entry.Entry, err error) (rejected bool, always bool) {
+	if !strings.EqualFold(e.CmdName, "restore") || len(e.Argv) < 4 {
+		return false, false
+	}
+	msg := err.Error()
+	switch {
+	case strings.HasPrefix(msg, "ERR unknown command"),
+		strings.HasPrefix(msg, "NOPERM "),
+		msg == "ERR DUMP payload version or checksum are wrong":
+		return true, true
+	case msg == "ERR Bad data format":
+		return true, false
+	}
+	return false, false
+}
+
+// rewriteRestore parses the payload of the rejected RESTORE and sends the
+// commands creating the same value, see runFallbacks. The commands would
+// merge into an existing key, or fail with WRONGTYPE, so like RESTORE, the key
+// is deleted first with REPLACE, and without it an existing key is handled as
+// BUSYKEY by rdb_restore_command_behavior. The BUSYKEY error is returned if
+// the key is skipped.
+func (w *redisWriter) rewriteRestore(e *entry.Entry, err error, always bool) error {
+	if always && capability.Target.CanRestore() {
+		capability.Target.DisableRestore()
+		log.Warnf("redisWriter target rejected RESTORE, values are rewritten into commands from now on. address=[%s], error=[%v]", w.address, err)
+	}
+	key, ttl, payload := e.Argv[1], e.Argv[2], e.Argv[3]
+	replace, absTTL := false, false
+	for _, arg := range e.Argv[4:] {
+		switch strings.ToLower(arg) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		}
+	}
+
+	do := func(argv ...string) {
+		if _, err := w.do(argv...); err != nil {
+			log.Panicf("redisWriter rewrite of rejected RESTORE failed. error=[%v], argv=%v", err, argv)
+		}
+	}
+	if replace || config.Config.Advanced.RDBRestoreCommandBehavior == "rewrite" {
+		do("del", key)
+	} else {
+		reply, err := w.do("exists", key)
+		if err != nil {
+			log.Panicf("redisWriter EXISTS before the rewrite of rejected RESTORE failed. error=[%v], key=[%s]", err, key)
+		}
+		if n, _ := reply.(int64); n > 0 {
+			busyKey(e)
+			return errors.New(busyKeyReply)
+		}
+	}
+	for _, cmd := range rdb.RewriteDump(key, payload) {
+		do(cmd...)
+	}
+	if absTTL {
+		do("pexpireat", key, ttl)
+	} else if ttl != "0" {
+		do("pexpire", key, ttl)
+	}
+	statistics.AddRestoreFallbackCount()
+	log.Debugf("redisWriter RESTORE rejected, value rewritten. key=[%s], error=[%v]", key, err)
+	return nil
+}
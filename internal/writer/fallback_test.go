@@ -0,0 +1,89 @@
+package writer
+
+import (
+	"errors"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// typedTarget is a target that knows the type of its keys only.
+func typedTarget(types map[string]string) clienttest.Handler {
+	var mu sync.Mutex
+	return clienttest.Handshake(func(argv []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		key := argv[1]
+		switch strings.ToLower(argv[0]) {
+		case "exists":
+			if _, ok := types[key]; ok {
+				return clienttest.Int(1)
+			}
+			return clienttest.Int(0)
+		case "del":
+			delete(types, key)
+			return clienttest.Int(1)
+		case "rpush":
+			if typ, ok := types[key]; ok && typ != "list" {
+				return clienttest.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+			types[key] = "list"
+			return clienttest.Int(1)
+		}
+		return clienttest.Int(1)
+	})
+}
+
+func TestRewriteRestoreExistingKey(t *testing.T) {
+	defer func(b string) { config.Config.Advanced.RDBRestoreCommandBehavior = b }(config.Config.Advanced.RDBRestoreCommandBehavior)
+	// DUMP of a list with "a" and "b"
+	payload := "\x01\x02\x01a\x01b" + "\x09\x00" + strings.Repeat("\x00", 8)
+	rejected := errors.New("ERR Bad data format")
+	cases := []struct {
+		behavior string
+		typ      string // of the key on the target
+		want     string // commands sent
+		skipped  bool
+	}{
+		{"rewrite", "list", "del l|rpush l a|rpush l b", false},
+		{"rewrite", "string", "del l|rpush l a|rpush l b", false},
+		{"skip", "list", "exists l", true},
+		{"skip", "string", "exists l", true},
+		{"skip", "", "exists l|rpush l a|rpush l b", false},
+	}
+	for _, c := range cases {
+		config.Config.Advanced.RDBRestoreCommandBehavior = c.behavior
+		types := map[string]string{}
+		if c.typ != "" {
+			types["l"] = c.typ
+		}
+		server := clienttest.NewServer(t, typedTarget(types))
+		w := &redisWriter{address: server.Addr(), client: client.NewRedisClient(server.Addr(), "", "", false)}
+		argv := []string{"restore", "l", "0", payload}
+		if c.behavior == "rewrite" {
+			argv = append(argv, "replace")
+		}
+		busy := statistics.GetDropCounts()["busykey"]
+		err := w.rewriteRestore(&entry.Entry{CmdName: "restore", Argv: argv}, rejected, false)
+		w.client.Close()
+
+		var sent []string
+		for _, cmd := range server.Commands() {
+			sent = append(sent, strings.Join(cmd, " "))
+		}
+		if got := strings.Join(sent, "|"); got != c.want {
+			t.Errorf("behavior=[%s], type=[%s], sent=[%s], want=[%s]", c.behavior, c.typ, got, c.want)
+		}
+		if skipped := statistics.GetDropCounts()["busykey"] == busy+1; skipped != c.skipped || (err != nil) != c.skipped {
+			t.Errorf("behavior=[%s], type=[%s], skipped=[%v], err=[%v]", c.behavior, c.typ, skipped, err)
+		}
+		if c.typ != "" && !c.skipped && types["l"] != "list" {
+			t.Errorf("behavior=[%s], type=[%s], the key is not replaced by the list", c.behavior, c.typ)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
+	"time"
+)
+
+// targetFaults returns the faults injected into the connections to target.
+func targetFaults() client.Faults {
+	cfg := &config.Config.Advanced
+	return client.Faults{
+		ErrorRate:      cfg.FaultErrorRate,
+		LatencyRate:    cfg.FaultLatencyRate,
+		Latency:        time.Duration(cfg.FaultLatency) * time.Millisecond,
+		DisconnectRate: cfg.FaultDisconnectRate,
+	}
+}
@@ -0,0 +1,75 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/log"
+	"strings"
+)
+
+// FlushTarget runs FLUSHALL on the target, on every master if it is a
+// cluster or on every shard, before the full sync. It refuses to flush a node that is the
+// source itself, compared by address and by run_id. Each scheduled run is a
+// new process and flushes again. A new full sync after a refused partial
+// resync flushes by a FLUSHALL entry instead, in order with the commands
+// already written, see entry.IsFlush.
+func FlushTarget() {
+	source := &config.Config.Source
+	target := &config.Config.Target
+
+	addresses := TargetAddresses()
+
+	sourceRunId := ""
+	if config.Config.Type == "sync" || config.Config.Type == "scan" {
+		c := client.NewRedisClient(source.Address, source.Username, source.Password, source.IsTLS)
+		sourceRunId = infoField(c.DoWithStringReply("INFO", "server"), "run_id")
+		c.Close()
+	}
+
+	clients := make([]*client.Redis, 0, len(addresses))
+	for _, address := range addresses {
+		if (config.Config.Type == "sync" || config.Config.Type == "scan") && address == source.Address {
+			log.Panicf("flush_target refused, the target is the source. address=[%s]", address)
+		}
+		c := client.NewRedisClient(address, target.Username, target.Password, target.IsTLS)
+		runId := infoField(c.DoWithStringReply("INFO", "server"), "run_id")
+		if sourceRunId != "" && runId == sourceRunId {
+			log.Panicf("flush_target refused, the target is the source. address=[%s], run_id=[%s]", address, runId)
+		}
+		clients = append(clients, c)
+	}
+	for i, c := range clients {
+		log.Warnf("flushing target. address=[%s]", addresses[i])
+		if reply := c.DoWithStringReply("FLUSHALL"); reply != "OK" {
+			log.Panicf("FLUSHALL failed. address=[%s], reply=[%s]", addresses[i], reply)
+		}
+		c.Close()
+	}
+	log.Infof("target flushed. addresses=%v", addresses)
+}
+
+// ClusterMasters returns the addresses of the masters that are not failed.
+func ClusterMasters(address string, username string, password string, isTls bool) []string {
+	c := client.NewRedisClient(address, username, password, isTls)
+	defer c.Close()
+	reply := strings.TrimSpace(c.DoWithStringReply("cluster", "nodes"))
+	var masters []string
+	for _, line := range strings.Split(reply, "\n") {
+		words := strings.Split(strings.TrimSpace(line), " ")
+		if len(words) < 3 || !strings.Contains(words[2], "master") || strings.Contains(words[2], "fail") {
+			continue
+		}
+		masters = append(masters, nodeAddress(words[1]))
+	}
+	return masters
+}
+
+// infoField returns the value of field in an INFO reply.
+func infoField(info string, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, field+":") {
+			return strings.TrimSpace(strings.TrimPrefix(line, field+":"))
+		}
+	}
+	return ""
+}
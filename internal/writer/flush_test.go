@@ -0,0 +1,71 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"strings"
+	"testing"
+)
+
+// runIdServer answers INFO server with runId and FLUSHALL with OK.
+func runIdServer(t *testing.T, runId string) *clienttest.Server {
+	return clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		if strings.EqualFold(argv[0], "info") {
+			return clienttest.Bulk("# Server\r\nredis_version:7.0.0\r\nrun_id:" + runId + "\r\n")
+		}
+		return clienttest.OK
+	}))
+}
+
+func flushed(s *clienttest.Server) bool {
+	for _, cmd := range s.Commands() {
+		if strings.EqualFold(cmd[0], "flushall") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFlushTarget(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	config.Config.Type = "sync"
+	config.Config.Target.Type = "standalone"
+
+	source := runIdServer(t, "source-run-id")
+	target := runIdServer(t, "target-run-id")
+	config.Config.Source.Address = source.Addr()
+	config.Config.Target.Address = target.Addr()
+	FlushTarget()
+	if !flushed(target) || flushed(source) {
+		t.Errorf("only the target should be flushed. target=%v, source=%v", target.Commands(), source.Commands())
+	}
+
+	// the same node behind another address, such as a proxy
+	same := runIdServer(t, "source-run-id")
+	config.Config.Target.Address = same.Addr()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("flush of the source should be refused")
+			}
+		}()
+		FlushTarget()
+	}()
+	if flushed(same) || flushed(source) {
+		t.Errorf("the source is flushed")
+	}
+
+	config.Config.Target.Address = source.Addr()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("flush of the source address should be refused")
+			}
+		}()
+		FlushTarget()
+	}()
+	if flushed(source) {
+		t.Errorf("the source is flushed")
+	}
+}
@@ -0,0 +1,105 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"os"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterSink("jsonl", newJSONLSink)
+}
+
+// jsonlSink writes one json object per entry to a file, an example of a
+// sink and a simple way to feed the commands to other tools:
+//
+//	{"db":0,"cmd":"SET","argv":["set","k","v"],"keys":["k"],"offset":1234}
+//
+// Arguments that are not valid UTF-8 are written base64 encoded in
+// argv_base64 instead of argv. Entries of the rdb have "base":true. Options:
+// path, the file to append to.
+type jsonlSink struct {
+	file    *os.File
+	writer  *bufio.Writer
+	entries uint64
+	bytes   uint64
+	binary  uint64 // entries written as argv_base64
+}
+
+type jsonlLine struct {
+	DbId       int      `json:"db"`
+	CmdName    string   `json:"cmd"`
+	Argv       []string `json:"argv,omitempty"`
+	ArgvBase64 []string `json:"argv_base64,omitempty"`
+	Keys       []string `json:"keys,omitempty"`
+	Base       bool     `json:"base,omitempty"`
+	Offset     int64    `json:"offset,omitempty"`
+}
+
+func newJSONLSink(options map[string]string) (Sink, error) {
+	path := options["path"]
+	if path == "" {
+		return nil, fmt.Errorf("the jsonl sink needs path in sink_options")
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *jsonlSink) Write(e *entry.Entry) error {
+	line := jsonlLine{DbId: e.DbId, CmdName: e.CmdName, Keys: e.Keys, Base: e.IsBase, Offset: e.Offset}
+	if isUTF8(e.Argv) && isUTF8(e.Keys) {
+		line.Argv = e.Argv
+	} else {
+		line.ArgvBase64 = make([]string, len(e.Argv))
+		for i, arg := range e.Argv {
+			line.ArgvBase64[i] = base64.StdEncoding.EncodeToString([]byte(arg))
+		}
+		line.Keys = nil
+		s.binary++
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err = s.writer.Write(data); err != nil {
+		return err
+	}
+	s.entries++
+	s.bytes += uint64(len(data))
+	return nil
+}
+
+func isUTF8(args []string) bool {
+	for _, arg := range args {
+		if !utf8.ValidString(arg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *jsonlSink) Flush() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *jsonlSink) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *jsonlSink) Stats() map[string]uint64 {
+	return map[string]uint64{"entries": s.entries, "bytes": s.bytes, "binary_entries": s.binary}
+}
@@ -0,0 +1,37 @@
+package writer
+
+import (
+	"encoding/json"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	w := NewSinkWriter("jsonl", map[string]string{"path": path})
+	w.Write(&entry.Entry{DbId: 1, CmdName: "SET", Argv: []string{"set", "k", "v"}, Keys: []string{"k"}, Offset: 10})
+	w.Write(&entry.Entry{CmdName: "SET", Argv: []string{"set", "k", "\xff"}, Keys: []string{"k"}, IsBase: true})
+	w.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+	if lines[0] != `{"db":1,"cmd":"SET","argv":["set","k","v"],"keys":["k"],"offset":10}` {
+		t.Errorf("unexpected line: %s", lines[0])
+	}
+	var line jsonlLine
+	if err = json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Argv != nil || strings.Join(line.ArgvBase64, " ") != "c2V0 aw== /w==" || !line.Base {
+		t.Errorf("unexpected binary line: %s", lines[1])
+	}
+}
@@ -0,0 +1,78 @@
+package writer
+
+import (
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/client/proto"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const memoryCheckInterval = 5 * time.Second
+
+func isOOM(err error) bool {
+	_, isRedisError := err.(proto.RedisError)
+	return isRedisError && strings.HasPrefix(err.Error(), "OOM ")
+}
+
+// retryOOM pauses writing after the target replied OOM and sends e again
+// until it is applied, see runFallbacks.
+func (w *redisWriter) retryOOM(e *entry.Entry, err error) {
+	control.OOMPause(w.address, err.Error())
+	statistics.AddOOMCount()
+	for {
+		time.Sleep(time.Second)
+		control.CheckOOMWait()
+		_, err = w.do(e.Argv...)
+		if err == nil {
+			break
+		}
+		if !isOOM(err) {
+			log.Panicf("redisWriter retry after OOM failed. error=[%v], argv=%v", err, e.Argv)
+		}
+	}
+	control.OOMResume(w.address)
+}
+
+// watchMemory pauses writing while used_memory of the target is above
+// target_memory_pause_ratio of maxmemory, and resumes it below
+// target_memory_resume_ratio.
+func watchMemory(address string, username string, password string, isTls bool) {
+	cfg := &config.Config.Advanced
+	var c *client.Redis
+	for range time.Tick(memoryCheckInterval) {
+		control.CheckOOMWait()
+		var err error
+		if c == nil {
+			if c, err = client.DialRedisClient(address, username, password, isTls); err != nil {
+				log.Warnf("memory watcher connect to target failed. address=[%s], error=[%v]", address, err)
+				c = nil
+				continue
+			}
+		}
+		info, err := client.String(c.Do("INFO", "memory"))
+		if err != nil {
+			log.Warnf("memory watcher INFO memory failed. address=[%s], error=[%v]", address, err)
+			c.Close()
+			c = nil
+			continue
+		}
+		used, _ := strconv.ParseUint(infoField(info, "used_memory"), 10, 64)
+		max, _ := strconv.ParseUint(infoField(info, "maxmemory"), 10, 64)
+		if max == 0 {
+			continue
+		}
+		ratio := float64(used) / float64(max)
+		if ratio >= cfg.TargetMemoryPauseRatio {
+			control.OOMPause(address, fmt.Sprintf("used_memory %d is %.2f of maxmemory %d", used, ratio, max))
+		} else if ratio <= cfg.TargetMemoryResumeRatio {
+			control.OOMResume(address)
+		}
+	}
+}
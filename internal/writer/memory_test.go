@@ -0,0 +1,54 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRetryOOM checks that a command refused by OOM is sent again before the
+// later commands on its key, and that writing is resumed then.
+func TestRetryOOM(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.OOMPause = true
+
+	var mu sync.Mutex
+	refused := false
+	server := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if argv[2] == "v1" && !refused {
+			refused = true
+			return clienttest.Error("OOM command not allowed when used memory > 'maxmemory'.")
+		}
+		return clienttest.OK
+	}))
+	ooms := atomic.LoadUint64(&statistics.Metrics.OOMCount)
+	w := NewRedisWriter(server.Addr(), "", "", false)
+	for _, argv := range [][]string{{"SET", "k1", "v1"}, {"SET", "k2", "v"}, {"SET", "k1", "v2"}} {
+		e := entry.NewEntry()
+		e.Argv = argv
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		w.Write(e)
+	}
+	w.Close()
+
+	var sent []string
+	for _, cmd := range server.Commands() {
+		sent = append(sent, strings.Join(cmd, " "))
+	}
+	if got, want := strings.Join(sent, "|"), "SET k1 v1|SET k2 v|SET k1 v1|SET k1 v2"; got != want {
+		t.Errorf("sent=[%s], want=[%s]", got, want)
+	}
+	if atomic.LoadUint64(&statistics.Metrics.OOMCount) != ooms+1 || control.OOMPaused() {
+		t.Errorf("oom_count=[%d], oom_paused=[%v], want one OOM and writing resumed", statistics.Metrics.OOMCount, control.OOMPaused())
+	}
+}
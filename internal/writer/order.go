@@ -0,0 +1,196 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Some error replies are handled by the writer, such as a RESTORE the target
+// rejects, which is rewritten into commands, OOM, retried until the target has
+// memory again, or NOSCRIPT, after which the script is loaded again. The reply is read after the
+// commands pipelined behind the failed one were executed, two things keep
+// the order of commands anyway: a command is not sent while an earlier
+// command on one of its keys may still need a fallback, see keyBarrier, and
+// the fallback runs on the connection of the writer once the replies of the
+// commands sent before it are read, see runFallbacks.
+
+// mayFallback reports whether the error reply of e may be handled by a
+// fallback. With oom_pause, every command but SELECT may be retried after
+// OOM.
+func mayFallback(e *entry.Entry) bool {
+	if config.Config.Advanced.OOMPause {
+		return !strings.EqualFold(e.CmdName, "select")
+	}
+	name := strings.ToLower(e.CmdName)
+	return name == "restore" || name == "evalsha" || name == "evalsha_ro"
+}
+
+// keyBarrier holds back the commands on the keys of the sent commands that
+// may need a fallback, until they are answered. Commands without keys wait
+// for all of them, and all commands wait for those without keys.
+type keyBarrier struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	keys    map[string]int // db and key of the unresolved commands
+	count   int            // unresolved commands
+	keyless int            // unresolved commands without keys
+}
+
+func barrierKey(dbId int, key string) string {
+	return strconv.Itoa(dbId) + " " + key
+}
+
+// enter blocks until e does not touch the keys of an unresolved command, and
+// records e if it may need a fallback.
+func (b *keyBarrier) enter(e *entry.Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mu)
+		b.keys = make(map[string]int)
+	}
+	for b.blocked(e) {
+		b.cond.Wait()
+	}
+	if !mayFallback(e) {
+		return
+	}
+	b.count++
+	if len(e.Keys) == 0 {
+		b.keyless++
+	}
+	for _, key := range e.Keys {
+		b.keys[barrierKey(e.DbId, key)]++
+	}
+}
+
+func (b *keyBarrier) blocked(e *entry.Entry) bool {
+	if b.keyless > 0 || (len(e.Keys) == 0 && b.count > 0) {
+		return true
+	}
+	for _, key := range e.Keys {
+		if b.keys[barrierKey(e.DbId, key)] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// leave is called once e is answered and its fallback, if any, is done.
+func (b *keyBarrier) leave(e *entry.Entry) {
+	if !mayFallback(e) {
+		return
+	}
+	b.mu.Lock()
+	b.count--
+	if len(e.Keys) == 0 {
+		b.keyless--
+	}
+	for _, key := range e.Keys {
+		k := barrierKey(e.DbId, key)
+		if b.keys[k]--; b.keys[k] == 0 {
+			delete(b.keys, k)
+		}
+	}
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// answered is an entry whose reply was read while draining the pipeline.
+type answered struct {
+	e        *entry.Entry
+	reply    interface{}
+	err      error
+	fallback func() error // returns the error e is finished with, such as BUSYKEY of a skipped key
+}
+
+// runFallbacks is called by flushInterval when the error reply of e needs
+// fallback. It stops sending, reads the replies of the entries sent after e,
+// replay first, runs the fallbacks in order on the connection of the writer
+// and finishes the entries in order. It returns the entries to read the
+// replies of, which are not empty only if the connection broke.
+func (w *redisWriter) runFallbacks(e *entry.Entry, fallback func() error, replay []*entry.Entry) []*entry.Entry {
+	w.clientMu.Lock()
+	defer w.clientMu.Unlock()
+	sent := replay
+drain:
+	for {
+		select {
+		case next, ok := <-w.chWaitReply:
+			if !ok {
+				break drain
+			}
+			sent = append(sent, next)
+		default:
+			break drain
+		}
+	}
+
+	db := w.replyDb // the db of the connection once the replies are read
+	done := []answered{{e: e, fallback: fallback}}
+	for i, next := range sent {
+		reply, err := w.client.Receive()
+		if connectionBroken(err) && reconnectEnabled() {
+			log.Warnf("redisWriter connection broken. address=[%s], error=[%v]", w.address, err)
+			// the fallbacks did not run yet, the failed entries are sent
+			// again, with the select commands between them
+			var pending []*entry.Entry
+			for _, a := range done {
+				if a.fallback != nil || strings.EqualFold(a.e.CmdName, "select") {
+					pending = append(pending, a.e)
+				} else {
+					w.finish(a.e, a.reply, a.err)
+				}
+			}
+			return w.reconnectLocked(append(pending, sent[i:]...))
+		}
+		if strings.EqualFold(next.CmdName, "select") && err == nil {
+			db = next.DbId
+		}
+		done = append(done, answered{e: next, reply: reply, err: err, fallback: w.checkReply(next, reply, err)})
+	}
+
+	// nothing is in flight, the connection is used synchronously
+	connDb := db
+	for i := range done {
+		a := &done[i]
+		if a.fallback == nil {
+			continue
+		}
+		if a.e.DbId != connDb {
+			w.selectDb(a.e.DbId)
+			connDb = a.e.DbId
+		}
+		a.reply, a.err = nil, a.fallback()
+	}
+	if connDb != db {
+		w.selectDb(db)
+	}
+	for _, a := range done {
+		w.finish(a.e, a.reply, a.err)
+	}
+	return nil
+}
+
+// selectDb switches the connection to dbId while nothing is in flight.
+func (w *redisWriter) selectDb(dbId int) {
+	if _, err := w.do("select", strconv.Itoa(dbId)); err != nil {
+		log.Panicf("redisWriter select db failed. error=[%v], db=[%d]", err, dbId)
+	}
+}
+
+// do sends argv while nothing is in flight and returns the error reply, if
+// any. The fallback may be partly applied if the connection breaks, so the
+// sync stops then.
+func (w *redisWriter) do(argv ...string) (interface{}, error) {
+	reply, err := w.client.Do(argv...)
+	if connectionBroken(err) {
+		log.Panicf("redisWriter connection broken during a fallback, it may be partly applied, a restart starts a new full sync. address=[%s], error=[%v], argv=%v",
+			w.address, err, argv)
+	}
+	return reply, err
+}
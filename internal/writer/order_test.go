@@ -0,0 +1,68 @@
+package writer
+
+import (
+	"errors"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"testing"
+	"time"
+)
+
+func TestRestoreRejected(t *testing.T) {
+	restore := &entry.Entry{CmdName: "restore", Argv: []string{"RESTORE", "k", "0", "payload"}}
+	cases := []struct {
+		reply    string
+		rejected bool
+		always   bool
+	}{
+		{"ERR unknown command 'restore', with args beginning with: 'k' ", true, true},
+		{"NOPERM this user has no permissions to run the 'restore' command", true, true},
+		{"ERR DUMP payload version or checksum are wrong", true, true},
+		{"ERR Bad data format", true, false},
+		{"ERR this command is disabled in this context", false, false},
+		{"OOM command not allowed when used memory > 'maxmemory'.", false, false},
+	}
+	for _, c := range cases {
+		rejected, always := restoreRejected(restore, errors.New(c.reply))
+		if rejected != c.rejected || always != c.always {
+			t.Errorf("reply=[%s], rejected=[%v], always=[%v]", c.reply, rejected, always)
+		}
+	}
+	set := &entry.Entry{CmdName: "set", Argv: []string{"SET", "k", "v"}}
+	if rejected, _ := restoreRejected(set, errors.New("ERR Bad data format")); rejected {
+		t.Error("error of SET handled as rejected RESTORE")
+	}
+}
+
+func TestKeyBarrier(t *testing.T) {
+	var b keyBarrier
+	restore := &entry.Entry{CmdName: "restore", Keys: []string{"a"}}
+	b.enter(restore)
+
+	entered := make(chan string, 3)
+	for _, e := range []*entry.Entry{
+		{CmdName: "set", Keys: []string{"a"}},
+		{CmdName: "ping"},
+	} {
+		go func(e *entry.Entry) {
+			b.enter(e)
+			entered <- e.CmdName
+		}(e)
+	}
+	// other keys and other dbs are not held back
+	b.enter(&entry.Entry{CmdName: "set", Keys: []string{"b"}})
+	b.enter(&entry.Entry{CmdName: "set", DbId: 1, Keys: []string{"a"}})
+	select {
+	case name := <-entered:
+		t.Fatalf("%s entered before the RESTORE on its key was answered", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.leave(restore)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-entered:
+		case <-time.After(time.Second):
+			t.Fatal("commands still held back after the RESTORE was answered")
+		}
+	}
+}
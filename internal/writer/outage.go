@@ -0,0 +1,57 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"time"
+)
+
+// reconnectEnabled tells whether a writer reconnects when its connection to
+// the target breaks, instead of stopping the sync.
+func reconnectEnabled() bool {
+	return config.Config.Advanced.SyncForever || config.Config.Advanced.TargetOutageMaxSeconds > 0
+}
+
+// checkOutage is called after a failed reconnect to address. It stops the
+// sync when the outage exceeds target_outage_max_seconds or the source
+// received since the start of the outage exceeds target_outage_max_bytes, a
+// restart starts a new full sync then.
+func checkOutage(address string, err error) {
+	cfg := &config.Config.Advanced
+	elapsed, buffered, _ := statistics.GetTargetOutage(address)
+	elapsed = elapsed.Truncate(time.Second)
+	if max := time.Duration(cfg.TargetOutageMaxSeconds) * time.Second; max > 0 && elapsed >= max {
+		log.Panicf("target unreachable for longer than target_outage_max_seconds, a restart starts a new full sync. address=[%s], elapsed=[%v], buffered=[%d]bytes, error=[%v]",
+			address, elapsed, buffered, err)
+	}
+	if cfg.TargetOutageMaxBytes > 0 && buffered > cfg.TargetOutageMaxBytes {
+		log.Panicf("source buffered during the target outage exceeds target_outage_max_bytes, a restart starts a new full sync. address=[%s], elapsed=[%v], buffered=[%d]bytes, error=[%v]",
+			address, elapsed, buffered, err)
+	}
+	log.Warnf("target unreachable, buffering the source until it is back. address=[%s], elapsed=[%v], buffered=[%d]bytes, error=[%v]",
+		address, elapsed, buffered, err)
+}
+
+// notReplaySafe are the commands whose effect changes when they are applied
+// twice, they count, append, pop or move, or fail the second time.
+var notReplaySafe = map[string]bool{
+	"incr": true, "incrby": true, "incrbyfloat": true, "decr": true, "decrby": true,
+	"append": true, "hincrby": true, "hincrbyfloat": true, "zincrby": true,
+	"lpush": true, "rpush": true, "lpushx": true, "rpushx": true, "linsert": true,
+	"lpop": true, "rpop": true, "lmpop": true, "blpop": true, "brpop": true, "blmpop": true,
+	"rpoplpush": true, "brpoplpush": true, "lmove": true, "blmove": true, "lrem": true, "ltrim": true,
+	"spop": true, "zpopmin": true, "zpopmax": true, "bzpopmin": true, "bzpopmax": true, "zmpop": true, "bzmpop": true,
+	"rename": true, "renamenx": true, "move": true, "bitfield": true,
+	"xadd": true, "xautoclaim": true, "xclaim": true, "xreadgroup": true, "xsetid": true,
+	"eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true, "fcall": true, "fcall_ro": true,
+}
+
+// replaySafe reports whether e can be sent again after the connection broke
+// while it was unanswered. The other commands set a value, a field or a
+// member, applying them twice in the order of the pipeline is harmless.
+func replaySafe(e *entry.Entry) bool {
+	return len(e.Argv) == 0 || !notReplaySafe[strings.ToLower(e.Argv[0])]
+}
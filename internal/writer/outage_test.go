@@ -0,0 +1,96 @@
+package writer
+
+import (
+	"errors"
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestReconnectResend checks that with sync_forever the writer dials the
+// target again and resends the commands left unanswered by a broken
+// connection.
+func TestReconnectResend(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.SyncForever = true
+
+	var mu sync.Mutex
+	hungUp := false
+	server := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if argv[1] == "k1" && !hungUp {
+			hungUp = true
+			return clienttest.Hangup
+		}
+		return clienttest.OK
+	}))
+	w := NewRedisWriter(server.Addr(), "", "", false)
+	var replied int
+	for _, key := range []string{"k1", "k2"} {
+		e := entry.NewEntry()
+		e.Argv = []string{"SET", key, "v"}
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		e.OnReply = func() { replied++ }
+		w.Write(e)
+	}
+	w.Close()
+
+	var sent []string
+	for _, cmd := range server.Commands() {
+		sent = append(sent, strings.Join(cmd, " "))
+	}
+	// k2 sent on the broken connection is not read by the server
+	if got, want := strings.Join(sent, "|"), "SET k1 v|SET k1 v|SET k2 v"; got != want {
+		t.Errorf("sent=[%s], want=[%s]", got, want)
+	}
+	if replied != 2 {
+		t.Errorf("replied=[%d], want 2", replied)
+	}
+}
+
+func TestReplaySafe(t *testing.T) {
+	for argv, safe := range map[string]bool{
+		"SET k v":       true,
+		"HSET h f v":    true,
+		"SADD s m":      true,
+		"INCR k":        false,
+		"RPUSH l a":     false,
+		"EVALSHA sha 0": false,
+	} {
+		if got := replaySafe(&entry.Entry{Argv: strings.Fields(argv)}); got != safe {
+			t.Errorf("argv=[%s], safe=[%v], want=[%v]", argv, got, safe)
+		}
+	}
+}
+
+// TestCheckOutage checks that the source is buffered during an outage until
+// target_outage_max_bytes is exceeded.
+func TestCheckOutage(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	defer func(offset uint64) { atomic.StoreUint64(&statistics.Metrics.AofReceivedOffset, offset) }(atomic.LoadUint64(&statistics.Metrics.AofReceivedOffset))
+	config.Config.Advanced.TargetOutageMaxSeconds = 60
+	config.Config.Advanced.TargetOutageMaxBytes = 1000
+
+	address := "outage-test:6379"
+	statistics.StartTargetOutage(address)
+	defer statistics.EndTargetOutage(address)
+	atomic.AddUint64(&statistics.Metrics.AofReceivedOffset, 1000)
+	checkOutage(address, errors.New("connection refused"))
+
+	atomic.AddUint64(&statistics.Metrics.AofReceivedOffset, 1)
+	defer func() {
+		if recover() == nil {
+			t.Error("the sync should stop when the buffered source exceeds target_outage_max_bytes")
+		}
+	}()
+	checkOutage(address, errors.New("connection refused"))
+}
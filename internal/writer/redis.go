@@ -8,36 +8,83 @@ import (
 	"github.com/alibaba/RedisShake/internal/entry"
 	"github.com/alibaba/RedisShake/internal/log"
 	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/utils"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 type redisWriter struct {
-	client *client.Redis
-	DbId   int
+	address  string
+	username string
+	password string
+	isTls    bool
+
+	client   *client.Redis
+	clientMu sync.Mutex // protects sending, client is replaced when reconnecting
+	DbId     int        // db of the last sent command
+	replyDb  int        // db of the last answered command, used when reconnecting
 
 	cmdBuffer   *bytes.Buffer
 	chWaitReply chan *entry.Entry
+	slots       chan struct{} // one per entry waiting for its reply, see send
 	chWg        sync.WaitGroup
 
-	UpdateUnansweredBytesCount uint64 // have sent in bytes
+	unanswered     uint64 // bytes sent and not answered yet
+	unansweredMu   sync.Mutex
+	unansweredCond *sync.Cond
+	stats          *statistics.ShardMetrics
+
+	barrier keyBarrier // see order.go
+
+	source   *client.Redis // dialed when a script is not known, see copyScriptKeys
+	sourceDb int
+	copied   copiedKeys
 }
 
 func NewRedisWriter(address string, username string, password string, isTls bool) Writer {
+	return newRedisWriter(address, address, username, password, isTls)
+}
+
+// newRedisWriter creates a writer whose metrics are labeled with shard.
+func newRedisWriter(address string, shard string, username string, password string, isTls bool) *redisWriter {
 	rw := new(redisWriter)
+	rw.address = address
+	rw.stats = statistics.RegisterShard(statistics.RoleWriter, shard)
+	rw.username = username
+	rw.password = password
+	rw.isTls = isTls
 	rw.client = client.NewRedisClient(address, username, password, isTls)
+	rw.client.InjectFaults(targetFaults())
 	log.Infof("redisWriter connected to redis successful. address=[%s]", address)
 	rw.cmdBuffer = new(bytes.Buffer)
 	rw.chWaitReply = make(chan *entry.Entry, config.Config.Advanced.PipelineCountLimit)
+	rw.slots = make(chan struct{}, config.Config.Advanced.PipelineCountLimit)
+	rw.unansweredCond = sync.NewCond(&rw.unansweredMu)
 	rw.chWg.Add(1)
 	go rw.flushInterval()
+	if config.Config.Advanced.TargetMemoryPauseRatio > 0 {
+		go watchMemory(address, username, password, isTls)
+	}
 	return rw
 }
 
 func (w *redisWriter) Write(e *entry.Entry) {
+	w.stats.AddInFlight()
+	rememberScript(e)
+	renameScript(e)
+	w.barrier.enter(e)
+	if w.copied.skip(e) {
+		w.barrier.leave(e)
+		statistics.AddDropCount("script_copied")
+		w.stats.AddApplied(0, e.Offset)
+		if e.OnDrop != nil {
+			e.OnDrop()
+		}
+		return
+	}
+
 	// switch db if we need
 	if w.DbId != e.DbId {
 		w.switchDbTo(e.DbId)
@@ -47,50 +94,239 @@ func (w *redisWriter) Write(e *entry.Entry) {
 	w.cmdBuffer.Reset()
 	client.EncodeArgv(e.Argv, w.cmdBuffer)
 	e.EncodedSize = uint64(w.cmdBuffer.Len())
-	for e.EncodedSize+atomic.LoadUint64(&w.UpdateUnansweredBytesCount) > config.Config.Advanced.TargetRedisClientMaxQuerybufLen {
-		time.Sleep(1 * time.Nanosecond)
+	w.reserveBytes(e.EncodedSize)
+	w.send(e, w.cmdBuffer.Bytes())
+}
+
+// reserveBytes blocks until size more bytes fit in
+// target_redis_client_max_querybuf_len. An entry larger than it is sent
+// once nothing else is unanswered.
+func (w *redisWriter) reserveBytes(size uint64) {
+	w.unansweredMu.Lock()
+	for w.unanswered > 0 && w.unanswered+size > config.Config.Advanced.TargetRedisClientMaxQuerybufLen {
+		w.unansweredCond.Wait()
 	}
+	w.unanswered += size
+	w.unansweredMu.Unlock()
+}
+
+// releaseBytes is called when an entry of size bytes is answered, it returns
+// the bytes still unanswered.
+func (w *redisWriter) releaseBytes(size uint64) uint64 {
+	w.unansweredMu.Lock()
+	w.unanswered -= size
+	left := w.unanswered
+	w.unansweredMu.Unlock()
+	w.unansweredCond.Broadcast()
+	return left
+}
+
+// send queues e for the reply and sends buf. It blocks while
+// pipeline_count_limit entries wait for replies. Queueing and sending are
+// done under clientMu so that reconnect sees exactly the entries already
+// sent, the slot is taken before so that reconnect never waits for send.
+func (w *redisWriter) send(e *entry.Entry, buf []byte) {
+	w.slots <- struct{}{}
+	w.clientMu.Lock()
 	w.chWaitReply <- e
-	atomic.AddUint64(&w.UpdateUnansweredBytesCount, e.EncodedSize)
-	w.client.SendBytes(w.cmdBuffer.Bytes())
+	err := w.client.TrySendBytes(buf)
+	w.clientMu.Unlock()
+	if err != nil && !reconnectEnabled() {
+		log.PanicError(err)
+	}
+	// on errors, flushInterval reconnects and sends e again
 }
 
 func (w *redisWriter) switchDbTo(newDbId int) {
-	w.client.Send("select", strconv.Itoa(newDbId))
-	w.DbId = newDbId
-	w.chWaitReply <- &entry.Entry{
+	e := &entry.Entry{
 		Argv:    []string{"select", strconv.Itoa(newDbId)},
 		CmdName: "select",
+		DbId:    newDbId,
 	}
+	buf := new(bytes.Buffer)
+	client.EncodeArgv(e.Argv, buf)
+	w.DbId = newDbId
+	w.send(e, buf.Bytes())
 }
 
 func (w *redisWriter) flushInterval() {
-	for e := range w.chWaitReply {
-		reply, err := w.client.Receive()
-		if err == proto.Nil {
-			log.Warnf("redisWriter receive nil reply. argv=%v", e.Argv)
-		} else if err != nil {
-			if err.Error() == "BUSYKEY Target key name already exists." {
-				if config.Config.Advanced.RDBRestoreCommandBehavior == "skip" {
-					log.Warnf("redisWriter received BUSYKEY reply. argv=%v", e.Argv)
-				} else if config.Config.Advanced.RDBRestoreCommandBehavior == "panic" {
-					log.Panicf("redisWriter received BUSYKEY reply. argv=%v", e.Argv)
-				}
-			} else {
-				log.Panicf("redisWriter received error. error=[%v], argv=%v, slots=%v, reply=[%v]", err, e.Argv, e.Slots, reply)
+	var replay []*entry.Entry // entries resent after reconnecting
+	for {
+		var e *entry.Entry
+		if len(replay) > 0 {
+			e, replay = replay[0], replay[1:]
+		} else {
+			var ok bool
+			if e, ok = <-w.chWaitReply; !ok {
+				break
 			}
 		}
-		if strings.EqualFold(e.CmdName, "select") { // skip select command
+		reply, err := w.client.Receive()
+		if connectionBroken(err) && reconnectEnabled() {
+			log.Warnf("redisWriter connection broken. address=[%s], error=[%v]", w.address, err)
+			replay = w.reconnect(append([]*entry.Entry{e}, replay...))
+			continue
+		}
+		if fallback := w.checkReply(e, reply, err); fallback != nil {
+			replay = w.runFallbacks(e, fallback, replay)
 			continue
 		}
-		atomic.AddUint64(&w.UpdateUnansweredBytesCount, ^(e.EncodedSize - 1))
-		statistics.UpdateAOFAppliedOffset(uint64(e.Offset))
-		statistics.UpdateUnansweredBytesCount(atomic.LoadUint64(&w.UpdateUnansweredBytesCount))
+		w.finish(e, reply, err)
 	}
 	w.chWg.Done()
 }
 
+// connectionBroken reports whether err is not an error reply of the target.
+func connectionBroken(err error) bool {
+	_, isRedisError := err.(proto.RedisError)
+	return err != nil && !isRedisError
+}
+
+// checkReply handles the error reply of e. It returns the fallback applying
+// e another way if there is one, see runFallbacks.
+func (w *redisWriter) checkReply(e *entry.Entry, reply interface{}, err error) (fallback func() error) {
+	if err == proto.Nil {
+		log.Warnf("redisWriter receive nil reply. argv=%v", e.Argv)
+		return nil
+	}
+	if err == nil {
+		return nil
+	}
+	if err.Error() == busyKeyReply {
+		busyKey(e)
+		return nil
+	}
+	if isOOM(err) && config.Config.Advanced.OOMPause {
+		return func() error { w.retryOOM(e, err); return nil }
+	}
+	if isNoScript(e, err) {
+		return func() error { w.reloadScript(e, err); return nil }
+	}
+	if rejected, always := restoreRejected(e, err); rejected {
+		return func() error { return w.rewriteRestore(e, err, always) }
+	}
+	if config.Config.Advanced.SkipErrorReplies {
+		log.Warnf("redisWriter received error, skipped. error=[%v], argv=%v", err, e.Argv)
+		statistics.AddDropCount("error:" + errorName(err))
+		return nil
+	}
+	log.Panicf("redisWriter received error. error=[%v], argv=%v, slots=%v, reply=[%v]", err, e.Argv, e.Slots, reply)
+	return nil
+}
+
+const busyKeyReply = "BUSYKEY Target key name already exists."
+
+// busyKey applies rdb_restore_command_behavior to a RESTORE without REPLACE
+// of a key that exists on the target: skip counts it, panic stops the sync.
+func busyKey(e *entry.Entry) {
+	if config.Config.Advanced.RDBRestoreCommandBehavior == "skip" {
+		log.Warnf("redisWriter received BUSYKEY reply. argv=%v", e.Argv)
+		statistics.AddDropCount("busykey")
+	} else if config.Config.Advanced.RDBRestoreCommandBehavior == "panic" {
+		log.Panicf("redisWriter received BUSYKEY reply. argv=%v", e.Argv)
+	}
+}
+
+// errorName returns the first word of an error reply, such as WRONGTYPE.
+func errorName(err error) string {
+	if fields := strings.Fields(err.Error()); len(fields) > 0 {
+		return fields[0]
+	}
+	return "ERR"
+}
+
+// finish is called once e is answered, or applied by its fallback.
+func (w *redisWriter) finish(e *entry.Entry, reply interface{}, err error) {
+	<-w.slots
+	w.barrier.leave(e)
+	if strings.EqualFold(e.CmdName, "select") { // skip select command
+		w.replyDb = e.DbId
+		return
+	}
+	if e.CmdName == "WAIT" && err == nil {
+		checkWaitReply(w.address, e, reply)
+	}
+	if sha, ok := reply.(string); ok && err == nil && isScriptLoad(e) {
+		statistics.AddLoadedScript(sha)
+	}
+	if e.OnReply != nil && err == nil {
+		e.OnReply()
+	} else if e.OnDrop != nil && err != nil {
+		e.OnDrop()
+	}
+	left := w.releaseBytes(e.EncodedSize)
+	w.stats.AddApplied(e.EncodedSize, e.Offset)
+	w.stats.SetUnansweredBytes(left)
+}
+
+// reconnect dials the target until success and sends the unanswered entries
+// again. The target may have executed them before the connection broke, so
+// the sync stops if one of them is not safe to apply twice, see replaySafe.
+// It returns the entries waiting for replies, in order. The source is
+// buffered meanwhile, see checkOutage.
+func (w *redisWriter) reconnect(pending []*entry.Entry) []*entry.Entry {
+	w.clientMu.Lock()
+	defer w.clientMu.Unlock()
+	return w.reconnectLocked(pending)
+}
+
+// reconnectLocked is reconnect with clientMu held.
+func (w *redisWriter) reconnectLocked(pending []*entry.Entry) []*entry.Entry {
+	w.client.Close()
+	statistics.StartTargetOutage(w.address)
+drain:
+	for {
+		select {
+		case e, ok := <-w.chWaitReply:
+			if !ok {
+				break drain
+			}
+			pending = append(pending, e)
+		default:
+			break drain
+		}
+	}
+	for _, e := range pending {
+		if !replaySafe(e) {
+			log.Panicf("redisWriter connection broken with an unanswered command that is not safe to send again, it may have been applied already, a restart starts a new full sync. address=[%s], argv=%v",
+				w.address, e.Argv)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, e := range pending {
+		client.EncodeArgv(e.Argv, buf)
+	}
+	backoff := utils.NewBackoff(time.Duration(config.Config.Advanced.ReconnectMaxBackoff) * time.Second)
+	for {
+		c, err := client.DialRedisClient(w.address, w.username, w.password, w.isTls)
+		if err == nil {
+			c.InjectFaults(targetFaults())
+			// a new connection starts at db 0, restore the db of the first pending entry
+			if w.replyDb != 0 {
+				_, err = c.Do("select", strconv.Itoa(w.replyDb))
+			}
+			if err == nil {
+				err = c.TrySendBytes(buf.Bytes())
+			}
+			if err == nil {
+				w.client = c
+				elapsed, buffered := statistics.EndTargetOutage(w.address)
+				log.Infof("redisWriter reconnected and resent unanswered commands. address=[%s], count=[%d], outage=[%v], buffered=[%d]bytes",
+					w.address, len(pending), elapsed.Truncate(time.Second), buffered)
+				return pending
+			}
+			c.Close()
+		}
+		checkOutage(w.address, err)
+		backoff.Wait()
+	}
+}
+
 func (w *redisWriter) Close() {
 	close(w.chWaitReply)
 	w.chWg.Wait()
+	if w.source != nil {
+		w.source.Close()
+	}
 }
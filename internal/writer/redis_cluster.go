@@ -3,10 +3,13 @@ package writer
 import (
 	"fmt"
 	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
 	"github.com/alibaba/RedisShake/internal/entry"
 	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const KeySlots = 16384
@@ -40,18 +43,7 @@ func (r *RedisClusterWriter) loadClusterNodes(address string, username string, p
 			log.Panicf("invalid cluster nodes line: %s", line)
 		}
 		log.Infof("redisClusterWriter load cluster nodes. line=%v", line)
-		// address
-		address := strings.Split(words[1], "@")[0]
-
-		// handle ipv6 address
-		tok := strings.Split(address, ":")
-		if len(tok) > 2 {
-			// ipv6 address
-			port := tok[len(tok)-1]
-
-			ipv6Addr := strings.Join(tok[:len(tok)-1], ":")
-			address = fmt.Sprintf("[%s]:%s", ipv6Addr, port)
-		}
+		address := nodeAddress(words[1])
 
 		r.addresses = append(r.addresses, address)
 		// writers
@@ -94,11 +86,26 @@ func (r *RedisClusterWriter) loadClusterNodes(address string, username string, p
 	}
 }
 
+// nodeAddress returns the address of a node in the CLUSTER NODES reply,
+// e.g. 127.0.0.1:30001@40001
+func nodeAddress(field string) string {
+	address := strings.Split(field, "@")[0]
+
+	// handle ipv6 address
+	tok := strings.Split(address, ":")
+	if len(tok) > 2 {
+		// ipv6 address
+		port := tok[len(tok)-1]
+
+		ipv6Addr := strings.Join(tok[:len(tok)-1], ":")
+		address = fmt.Sprintf("[%s]:%s", ipv6Addr, port)
+	}
+	return address
+}
+
 func (r *RedisClusterWriter) Write(entry *entry.Entry) {
 	if len(entry.Slots) == 0 {
-		for _, writer := range r.writers {
-			writer.Write(entry)
-		}
+		fanOut(entry, r.writers)
 		return
 	}
 
@@ -108,12 +115,57 @@ func (r *RedisClusterWriter) Write(entry *entry.Entry) {
 			lastSlot = slot
 		}
 		if slot != lastSlot {
-			log.Panicf("CROSSSLOT Keys in request don't hash to the same slot. argv=%v", entry.Argv)
+			dropCrossNode(entry, "CROSSSLOT Keys in request don't hash to the same slot")
+			return
 		}
 	}
 	r.router[lastSlot].Write(entry)
 }
 
+// fanOut writes a clone of e to every writer, the writers set fields of the
+// entry, such as EncodedSize, at the same time. The hooks of e run once, when
+// every writer is done with its clone: OnReply if all of them applied it,
+// OnDrop otherwise.
+func fanOut(e *entry.Entry, writers []Writer) {
+	remaining := int32(len(writers))
+	var dropped int32
+	done := func() {
+		if atomic.AddInt32(&remaining, -1) != 0 {
+			return
+		}
+		if atomic.LoadInt32(&dropped) != 0 {
+			if e.OnDrop != nil {
+				e.OnDrop()
+			}
+		} else if e.OnReply != nil {
+			e.OnReply()
+		}
+	}
+	for _, writer := range writers {
+		c := e.Clone()
+		c.OnReply = done
+		c.OnDrop = func() {
+			atomic.StoreInt32(&dropped, 1)
+			done()
+		}
+		writer.Write(c)
+	}
+}
+
+// dropCrossNode drops an entry whose keys are on different nodes of the
+// target if drop_unsupported is set, the sync stops otherwise.
+func dropCrossNode(e *entry.Entry, reason string) {
+	if !config.Config.Target.DropUnsupported {
+		log.Panicf("%s, set drop_unsupported to drop it. argv=%v", reason, e.Argv)
+	}
+	log.Warnf("%s, dropped. argv=%v", reason, e.Argv)
+	statistics.AddDropCount("crossslot")
+	statistics.AddDroppedOffset(e.Offset)
+	if e.OnDrop != nil {
+		e.OnDrop()
+	}
+}
+
 func (r *RedisClusterWriter) Close() {
 	for _, writer := range r.writers {
 		writer.Close()
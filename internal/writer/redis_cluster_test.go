@@ -0,0 +1,94 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClusterCrossSlotDropped(t *testing.T) {
+	defer func(drop bool) { config.Config.Target.DropUnsupported = drop }(config.Config.Target.DropUnsupported)
+	w := &RedisClusterWriter{}
+	e := entry.NewEntry()
+	e.Argv = []string{"MSET", "a", "1", "b", "2"}
+	e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+	e.Slots = commands.CalcSlots(e.Keys)
+	dropped := false
+	e.OnDrop = func() { dropped = true }
+
+	config.Config.Target.DropUnsupported = false
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("cross-slot entry written without drop_unsupported")
+			}
+		}()
+		w.Write(e)
+	}()
+
+	config.Config.Target.DropUnsupported = true
+	before := statistics.GetDropCounts()["crossslot"]
+	w.Write(e)
+	if !dropped || statistics.GetDropCounts()["crossslot"] != before+1 {
+		t.Errorf("cross-slot entry not dropped and counted. dropped=[%v]", dropped)
+	}
+}
+
+// concurrentWriter answers every entry in its own goroutine, like the reply
+// loop of a redis writer, and changes the entry the way redisWriter does.
+type concurrentWriter struct {
+	wg      *sync.WaitGroup
+	drop    bool
+	written []*entry.Entry
+}
+
+func (w *concurrentWriter) Write(e *entry.Entry) {
+	w.written = append(w.written, e)
+	renameScript(e)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		e.EncodedSize = uint64(len(e.Argv))
+		if w.drop {
+			e.OnDrop()
+		} else {
+			e.OnReply()
+		}
+	}()
+}
+
+func (w *concurrentWriter) Close() {}
+
+// run with -race, the writers change their entries at the same time
+func TestClusterFanOut(t *testing.T) {
+	defer func(name string) { config.Config.Advanced.ScriptCommand = name }(config.Config.Advanced.ScriptCommand)
+	config.Config.Advanced.ScriptCommand = "renamed-script"
+
+	for _, drop := range []bool{false, true} {
+		wg := new(sync.WaitGroup)
+		a, b := &concurrentWriter{wg: wg}, &concurrentWriter{wg: wg, drop: drop}
+		w := &RedisClusterWriter{writers: []Writer{a, b}}
+		e := entry.NewEntry()
+		e.Argv = []string{"SCRIPT", "LOAD", "return 1"}
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		var replied, dropped int32
+		e.OnReply = func() { atomic.AddInt32(&replied, 1) }
+		e.OnDrop = func() { atomic.AddInt32(&dropped, 1) }
+
+		w.Write(e)
+		wg.Wait()
+		if a.written[0] == e || b.written[0] == e || a.written[0] == b.written[0] {
+			t.Fatalf("every node should get its own clone of the entry")
+		}
+		if e.Argv[0] != "SCRIPT" || a.written[0].Argv[0] != "renamed-script" {
+			t.Errorf("the clones should be changed, not the entry. argv=%v, clone=%v", e.Argv, a.written[0].Argv)
+		}
+		if drop && (replied != 0 || dropped != 1) || !drop && (replied != 1 || dropped != 0) {
+			t.Errorf("hooks of the entry should run once. drop=[%v], replied=[%d], dropped=[%d]", drop, replied, dropped)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package writer
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/client"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// scriptCacheSize bounds the scripts kept by rememberScript, the least
+// recently used one is dropped first.
+const scriptCacheSize = 1024
+
+// scripts are the bodies of the scripts written to the target by SCRIPT LOAD
+// and EVAL, by sha1. Redis can not return a script by its sha1, so a script
+// missing on the target is loaded again from here. The lua aux fields of the
+// rdb are sent as SCRIPT LOAD.
+var scripts struct {
+	mu     sync.Mutex
+	bodies map[string]*list.Element // of *cachedScript in lru
+	lru    *list.List               // the most recently used first
+}
+
+type cachedScript struct {
+	sha  string
+	body string
+}
+
+// rememberScript keeps the body of the script in e, if any.
+func rememberScript(e *entry.Entry) {
+	var body string
+	switch {
+	case len(e.Argv) >= 3 && strings.EqualFold(e.Argv[0], "script") && strings.EqualFold(e.Argv[1], "load"):
+		body = e.Argv[2]
+	case len(e.Argv) >= 2 && (strings.EqualFold(e.Argv[0], "eval") || strings.EqualFold(e.Argv[0], "eval_ro")):
+		body = e.Argv[1]
+	default:
+		return
+	}
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+	scripts.mu.Lock()
+	defer scripts.mu.Unlock()
+	if scripts.bodies == nil {
+		scripts.bodies = make(map[string]*list.Element)
+		scripts.lru = list.New()
+	}
+	if elem, ok := scripts.bodies[sha]; ok {
+		scripts.lru.MoveToFront(elem)
+		return
+	}
+	scripts.bodies[sha] = scripts.lru.PushFront(&cachedScript{sha: sha, body: body})
+	if scripts.lru.Len() > scriptCacheSize {
+		oldest := scripts.lru.Remove(scripts.lru.Back()).(*cachedScript)
+		delete(scripts.bodies, oldest.sha)
+	}
+}
+
+// renameScript sends SCRIPT as script_command, for targets that renamed or
+// restricted SCRIPT.
+func renameScript(e *entry.Entry) {
+	name := config.Config.Advanced.ScriptCommand
+	if len(e.Argv) == 0 || !strings.EqualFold(e.Argv[0], "script") || strings.EqualFold(name, "script") {
+		return
+	}
+	e.Argv = append([]string{name}, e.Argv[1:]...)
+}
+
+// isScriptLoad reports whether e is a SCRIPT LOAD, the reply is the sha1.
+func isScriptLoad(e *entry.Entry) bool {
+	return len(e.Argv) >= 3 && strings.EqualFold(e.Argv[1], "load") &&
+		(strings.EqualFold(e.Argv[0], "script") || strings.EqualFold(e.Argv[0], config.Config.Advanced.ScriptCommand))
+}
+
+func scriptBody(sha string) (string, bool) {
+	scripts.mu.Lock()
+	defer scripts.mu.Unlock()
+	elem, ok := scripts.bodies[strings.ToLower(sha)]
+	if !ok {
+		return "", false
+	}
+	scripts.lru.MoveToFront(elem)
+	return elem.Value.(*cachedScript).body, true
+}
+
+// isNoScript reports whether e is an EVALSHA the target does not have the
+// script of, such as after the script cache of the target was flushed or
+// when the script was loaded before the full sync of an older source.
+func isNoScript(e *entry.Entry, err error) bool {
+	if len(e.Argv) < 2 || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		return false
+	}
+	return strings.EqualFold(e.Argv[0], "evalsha") || strings.EqualFold(e.Argv[0], "evalsha_ro")
+}
+
+// reloadScript loads the script of the EVALSHA on the target and runs it
+// again, see runFallbacks.
+func (w *redisWriter) reloadScript(e *entry.Entry, err error) {
+	sha := e.Argv[1]
+	body, ok := scriptBody(sha)
+	if !ok {
+		w.copyScriptKeys(e, sha)
+		return
+	}
+	if _, err := w.do(config.Config.Advanced.ScriptCommand, "load", body); err != nil {
+		log.Panicf("redisWriter SCRIPT LOAD after NOSCRIPT failed. error=[%v], sha1=[%s]", err, sha)
+	}
+	if _, err := w.do(e.Argv...); err != nil {
+		log.Panicf("redisWriter retry after NOSCRIPT failed. error=[%v], argv=%v", err, e.Argv)
+	}
+	statistics.AddScriptReloadCount()
+	statistics.AddLoadedScript(strings.ToLower(sha))
+	log.Infof("redisWriter script loaded on the target after NOSCRIPT. address=[%s], sha1=[%s], error=[%v]", w.address, sha, err)
+}
+
+// copiedKeys are the keys copied from the source by copyScriptKeys, with the
+// replication offset of the source the copy was taken at.
+type copiedKeys struct {
+	mu      sync.Mutex
+	offsets map[string]int64 // by barrierKey
+}
+
+func (c *copiedKeys) add(dbId int, key string, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offsets == nil {
+		c.offsets = make(map[string]int64)
+	}
+	c.offsets[barrierKey(dbId, key)] = offset
+}
+
+// skip reports whether e is already in the copy of its keys, that is e is a
+// command on copied keys up to the offset of the copy. Applying it again
+// would apply INCR or LPUSH twice. A key is forgotten once the stream passed
+// its offset.
+func (c *copiedKeys) skip(e *entry.Entry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.offsets) == 0 {
+		return false
+	}
+	copied := 0
+	for _, key := range e.Keys {
+		k := barrierKey(e.DbId, key)
+		offset, ok := c.offsets[k]
+		if !ok {
+			continue
+		}
+		if e.Offset > offset {
+			delete(c.offsets, k)
+			continue
+		}
+		copied++
+	}
+	if copied == 0 {
+		return false
+	}
+	if copied < len(e.Keys) {
+		log.Panicf("redisWriter a command touches keys copied after NOSCRIPT and other keys, it can not be applied in part. argv=%v", e.Argv)
+	}
+	return true
+}
+
+// copyScriptKeys applies an EVALSHA of a script whose body is not known, not
+// seen or dropped from the cache, by copying the keys of the EVALSHA from the
+// source as they are now, with script_copy_keys. The copy is at a later
+// offset than the EVALSHA, the commands on the keys up to it are skipped, see
+// copiedKeys.
+func (w *redisWriter) copyScriptKeys(e *entry.Entry, sha string) {
+	if config.Config.Type != "sync" {
+		log.Panicf("redisWriter received NOSCRIPT and the script was not seen in SCRIPT LOAD, EVAL or the rdb. sha1=[%s], argv=%v", sha, e.Argv)
+	}
+	if !config.Config.Advanced.ScriptCopyKeys {
+		log.Panicf("redisWriter received NOSCRIPT and the script was not seen in SCRIPT LOAD, EVAL or the rdb, set script_copy_keys to copy its keys from the source. sha1=[%s], argv=%v", sha, e.Argv)
+	}
+	if len(e.Keys) == 0 {
+		log.Warnf("redisWriter received NOSCRIPT for a script without keys, skipped. sha1=[%s], argv=%v", sha, e.Argv)
+		statistics.AddDropCount("noscript")
+		return
+	}
+	if w.source == nil {
+		source := &config.Config.Source
+		w.source = client.NewRedisClient(source.Address, source.Username, source.Password, source.IsTLS)
+		w.sourceDb = 0
+	}
+	sourceDbs := w.source.KeyspaceDbs()
+	for _, key := range e.Keys {
+		sourceKeys, ok := filter.SourceKeysOf(e.DbId, key, sourceDbs)
+		if !ok {
+			log.Panicf("redisWriter received NOSCRIPT and the key of the script does not come from the source. sha1=[%s], key=[%s]", sha, key)
+		}
+		var payload string
+		var pttl, offset int64
+		exists := false
+		// the first source key and db the key exists in, if several dbs are
+		// synced to e.DbId or renames collide
+	search:
+		for _, sk := range sourceKeys {
+			for _, dbId := range sk.Dbs {
+				if dbId != w.sourceDb {
+					if _, err := w.source.Do("select", strconv.Itoa(dbId)); err != nil {
+						log.Panicf("redisWriter select db of source failed. error=[%v], db=[%d]", err, dbId)
+					}
+					w.sourceDb = dbId
+				}
+				var err error
+				payload, pttl, exists, offset, err = w.source.DumpWithOffset(sk.Key)
+				if err != nil {
+					log.Panicf("redisWriter DUMP of source key failed. error=[%v], db=[%d], key=[%s]", err, dbId, sk.Key)
+				}
+				if exists {
+					if len(sourceKeys) > 1 || len(sk.Dbs) > 1 {
+						log.Warnf("redisWriter copies the key of the script from the first source key and db it exists in. sha1=[%s], key=[%s], source_key=[%s], db=[%d]", sha, key, sk.Key, dbId)
+					}
+					break search
+				}
+			}
+		}
+		w.copied.add(e.DbId, key, offset)
+		if !exists {
+			if _, err := w.do("del", key); err != nil {
+				log.Panicf("redisWriter DEL after NOSCRIPT failed. error=[%v], key=[%s]", err, key)
+			}
+			continue
+		}
+		if pttl < 0 {
+			pttl = 0
+		}
+		restore := &entry.Entry{CmdName: "restore", Argv: []string{"restore", key, strconv.FormatInt(pttl, 10), payload, "replace"}}
+		if !capability.Target.CanRestore() {
+			w.rewriteRestore(restore, errors.New("target can not RESTORE"), false)
+			continue
+		}
+		if _, err := w.do(restore.Argv...); err != nil {
+			rejected, always := restoreRejected(restore, err)
+			if !rejected {
+				log.Panicf("redisWriter RESTORE after NOSCRIPT failed. error=[%v], key=[%s]", err, key)
+			}
+			w.rewriteRestore(restore, err, always)
+		}
+	}
+	statistics.AddScriptKeysCopiedCount()
+	log.Warnf("redisWriter received NOSCRIPT for a script not seen, its keys are copied from the source. sha1=[%s], keys=%v", sha, e.Keys)
+}
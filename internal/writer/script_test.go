@@ -0,0 +1,100 @@
+package writer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"testing"
+)
+
+func TestScriptCache(t *testing.T) {
+	body := "return 1"
+	sha := "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" // sha1 of body
+	rememberScript(&entry.Entry{Argv: []string{"EVAL", body, "0"}})
+	if got, ok := scriptBody(sha); !ok || got != body {
+		t.Fatalf("script not remembered. body=[%s], ok=[%v]", got, ok)
+	}
+	if _, ok := scriptBody("0000000000000000000000000000000000000000"); ok {
+		t.Fatal("unknown script found")
+	}
+
+	noScript := errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	if !isNoScript(&entry.Entry{Argv: []string{"evalsha", sha, "0"}}, noScript) {
+		t.Error("NOSCRIPT of EVALSHA not detected")
+	}
+	if isNoScript(&entry.Entry{Argv: []string{"set", "k", "v"}}, noScript) {
+		t.Error("NOSCRIPT of other commands detected")
+	}
+}
+
+func TestRenameScript(t *testing.T) {
+	defer func(name string) { config.Config.Advanced.ScriptCommand = name }(config.Config.Advanced.ScriptCommand)
+	config.Config.Advanced.ScriptCommand = "xscript"
+	argv := []string{"SCRIPT", "LOAD", "return 1"}
+	e := &entry.Entry{Argv: argv}
+	renameScript(e)
+	if e.Argv[0] != "xscript" || argv[0] != "SCRIPT" {
+		t.Fatalf("SCRIPT not renamed into a new argv. argv=%v, original=%v", e.Argv, argv)
+	}
+	if !isScriptLoad(e) {
+		t.Error("renamed SCRIPT LOAD not detected")
+	}
+	e = &entry.Entry{Argv: []string{"set", "script", "v"}}
+	renameScript(e)
+	if e.Argv[0] != "set" {
+		t.Errorf("other command renamed. argv=%v", e.Argv)
+	}
+}
+
+func TestScriptCacheLRU(t *testing.T) {
+	first := "return 'first'"
+	rememberScript(&entry.Entry{Argv: []string{"EVAL", first, "0"}})
+	for i := 0; i < scriptCacheSize; i++ {
+		rememberScript(&entry.Entry{Argv: []string{"SCRIPT", "LOAD", fmt.Sprintf("return %d", i)}})
+		if i == scriptCacheSize/2 {
+			// used, so it is kept
+			rememberScript(&entry.Entry{Argv: []string{"EVAL", first, "0"}})
+		}
+	}
+	if scripts.lru.Len() != scriptCacheSize || len(scripts.bodies) != scriptCacheSize {
+		t.Fatalf("cache not bounded. len=[%d], size=[%d]", scripts.lru.Len(), scriptCacheSize)
+	}
+	sum := sha1.Sum([]byte(first))
+	if _, ok := scriptBody(hex.EncodeToString(sum[:])); !ok {
+		t.Error("recently used script dropped")
+	}
+	sum = sha1.Sum([]byte("return 0"))
+	if _, ok := scriptBody(hex.EncodeToString(sum[:])); ok {
+		t.Error("least recently used script kept")
+	}
+}
+
+func TestCopiedKeysSkip(t *testing.T) {
+	w := &redisWriter{stats: statistics.RegisterShard(statistics.RoleWriter, "copied-test")}
+	// the EVALSHA at offset 100 is applied by a copy of counter taken at offset 300
+	w.copied.add(0, "counter", 300)
+	dropped := statistics.GetDropCounts()["script_copied"]
+
+	// INCR at 200 is in the copy, sending it would increment twice. The writer
+	// has no connection, the test fails if it is sent.
+	incr := &entry.Entry{DbId: 0, CmdName: "INCR", Argv: []string{"INCR", "counter"}, Keys: []string{"counter"}, Offset: 200}
+	w.Write(incr)
+	if got := statistics.GetDropCounts()["script_copied"]; got != dropped+1 {
+		t.Errorf("INCR in the copy not skipped. script_copied=[%d]", got)
+	}
+	other := &entry.Entry{DbId: 1, CmdName: "INCR", Keys: []string{"counter"}, Offset: 250}
+	if w.copied.skip(other) {
+		t.Error("INCR of a key of another db skipped")
+	}
+	after := &entry.Entry{DbId: 0, CmdName: "INCR", Keys: []string{"counter"}, Offset: 400}
+	if w.copied.skip(after) {
+		t.Error("INCR after the copy skipped")
+	}
+	if len(w.copied.offsets) != 0 {
+		t.Errorf("copied key not forgotten after its offset. offsets=%v", w.copied.offsets)
+	}
+}
@@ -0,0 +1,124 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// ShardRing maps keys to shards by consistent hashing. The ring is built on
+// the names of the shards, not their addresses, so that a shard can move to
+// another address without moving keys, and adding a shard moves only the
+// keys it takes over. Keys with the same hash tag are on the same shard.
+type ShardRing struct {
+	points []uint32
+	names  []string // name of the shard of each point
+	shards map[string]string
+}
+
+// NewShardRing places virtualNodes points of every shard on the ring, shards
+// maps names to addresses.
+func NewShardRing(shards map[string]string, virtualNodes int) *ShardRing {
+	type point struct {
+		hash uint32
+		name string
+	}
+	points := make([]point, 0, len(shards)*virtualNodes)
+	for name := range shards {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, point{crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(i))), name})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].name < points[j].name
+	})
+	r := &ShardRing{shards: shards}
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.names = append(r.names, p.name)
+	}
+	return r
+}
+
+// Shard returns the name and the address of the shard of key.
+func (r *ShardRing) Shard(key string) (string, string) {
+	hash := crc32.ChecksumIEEE([]byte(commands.HashTag(key)))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	name := r.names[i]
+	return name, r.shards[name]
+}
+
+// TargetRing returns the ring of the sharded target in Config.
+func TargetRing() *ShardRing {
+	target := &config.Config.Target
+	return NewShardRing(target.Shards, target.ShardVirtualNodes)
+}
+
+// RedisShardedWriter writes to standalone redis shards without a proxy, the
+// shard of a key is chosen by TargetRing.
+type RedisShardedWriter struct {
+	ring    *ShardRing
+	writers map[string]Writer
+}
+
+func NewRedisShardedWriter(username string, password string, isTls bool) Writer {
+	w := &RedisShardedWriter{ring: TargetRing(), writers: make(map[string]Writer)}
+	for name, address := range config.Config.Target.Shards {
+		w.writers[name] = newRedisWriter(address, name, username, password, isTls)
+	}
+	log.Infof("redisShardedWriter connected to shards. shards=%v", config.Config.Target.Shards)
+	return w
+}
+
+func (w *RedisShardedWriter) Write(e *entry.Entry) {
+	if len(e.Keys) == 0 {
+		writers := make([]Writer, 0, len(w.writers))
+		for _, writer := range w.writers {
+			writers = append(writers, writer)
+		}
+		fanOut(e, writers)
+		return
+	}
+	shard, _ := w.ring.Shard(e.Keys[0])
+	for _, key := range e.Keys[1:] {
+		if name, _ := w.ring.Shard(key); name != shard {
+			dropCrossNode(e, "keys of the entry are on different shards, use hash tags to keep them together")
+			return
+		}
+	}
+	w.writers[shard].Write(e)
+}
+
+func (w *RedisShardedWriter) Close() {
+	for _, writer := range w.writers {
+		writer.Close()
+	}
+}
+
+// TargetAddresses returns the nodes of the target that hold keys: the
+// address, the masters of a cluster or the shards.
+func TargetAddresses() []string {
+	target := &config.Config.Target
+	switch target.Type {
+	case "cluster":
+		return ClusterMasters(target.Address, target.Username, target.Password, target.IsTLS)
+	case "sharded":
+		var addresses []string
+		for _, address := range target.Shards {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		return addresses
+	}
+	return []string{target.Address}
+}
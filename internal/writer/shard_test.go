@@ -0,0 +1,45 @@
+package writer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestShardRing(t *testing.T) {
+	shards := map[string]string{"a": "10.0.0.1:6379", "b": "10.0.0.2:6379", "c": "10.0.0.3:6379"}
+	ring := NewShardRing(shards, 160)
+
+	// the shard of a key only depends on the names
+	moved := NewShardRing(map[string]string{"a": "10.0.0.9:6379", "b": "10.0.0.2:6379", "c": "10.0.0.3:6379"}, 160)
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		name, _ := ring.Shard(key)
+		if movedName, _ := moved.Shard(key); movedName != name {
+			t.Fatalf("key %s moved from %s to %s", key, name, movedName)
+		}
+	}
+
+	name, _ := ring.Shard("{user1}:name")
+	if other, _ := ring.Shard("{user1}:age"); other != name {
+		t.Fatalf("keys of the same hash tag are on %s and %s", name, other)
+	}
+
+	// adding a shard only moves keys to the new shard
+	shards["d"] = "10.0.0.4:6379"
+	grown := NewShardRing(shards, 160)
+	movedKeys := 0
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		before, _ := ring.Shard(key)
+		after, _ := grown.Shard(key)
+		if before != after {
+			if after != "d" {
+				t.Fatalf("key %s moved from %s to %s", key, before, after)
+			}
+			movedKeys++
+		}
+	}
+	if movedKeys == 0 || movedKeys > 500 {
+		t.Fatalf("unexpected number of moved keys: %d", movedKeys)
+	}
+}
@@ -0,0 +1,155 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sink writes the entries to something other than redis, such as files,
+// databases or object storage. A sink is registered by name with
+// RegisterSink and chosen by target.type = "sink" and target.sink.
+type Sink interface {
+	Write(e *entry.Entry) error
+	// Flush makes the entries written so far durable, the source is acked
+	// up to them.
+	Flush() error
+	Close() error
+	// Stats are served in the metrics as sink_stats and logged on close.
+	Stats() map[string]uint64
+}
+
+// SinkFactory creates a sink from target.sink_options.
+type SinkFactory func(options map[string]string) (Sink, error)
+
+var sinkFactories = struct {
+	mu        sync.Mutex
+	factories map[string]SinkFactory
+}{factories: make(map[string]SinkFactory)}
+
+// RegisterSink makes the sink available as target.sink = name, it is called
+// from init of the package of the sink.
+func RegisterSink(name string, factory SinkFactory) {
+	sinkFactories.mu.Lock()
+	defer sinkFactories.mu.Unlock()
+	if _, ok := sinkFactories.factories[name]; ok {
+		log.Panicf("sink registered twice. name=[%s]", name)
+	}
+	sinkFactories.factories[name] = factory
+}
+
+// SinkNames returns the registered sinks.
+func SinkNames() []string {
+	sinkFactories.mu.Lock()
+	defer sinkFactories.mu.Unlock()
+	names := make([]string, 0, len(sinkFactories.factories))
+	for name := range sinkFactories.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sinkFlushInterval is how often the entries written to a sink are flushed
+// and acked.
+const sinkFlushInterval = time.Second
+
+// sinkWriter adapts a Sink to Writer, it flushes the sink every
+// sinkFlushInterval and on close.
+type sinkWriter struct {
+	name string
+	sink Sink
+
+	mu       sync.Mutex
+	offset   int64 // offset of the last written entry, acked after a flush
+	dirty    bool
+	pending  uint64 // entries written since the last flush
+	shard    *statistics.ShardMetrics
+	stop     chan struct{}
+	finished sync.WaitGroup
+}
+
+// NewSinkWriter creates the sink registered as name.
+func NewSinkWriter(name string, options map[string]string) Writer {
+	sinkFactories.mu.Lock()
+	factory, ok := sinkFactories.factories[name]
+	sinkFactories.mu.Unlock()
+	if !ok {
+		log.Panicf("unknown sink. name=[%s], registered=%v", name, SinkNames())
+	}
+	sink, err := factory(options)
+	if err != nil {
+		log.Panicf("create sink failed. name=[%s], error=[%v]", name, err)
+	}
+	w := &sinkWriter{name: name, sink: sink, stop: make(chan struct{})}
+	w.shard = statistics.RegisterShard(statistics.RoleWriter, "sink/"+name)
+	statistics.SetSinkStats(w.stats)
+	w.finished.Add(1)
+	go w.flushInterval()
+	log.Infof("sinkWriter created. name=[%s]", name)
+	return w
+}
+
+func (w *sinkWriter) Write(e *entry.Entry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.shard.AddInFlight()
+	if err := w.sink.Write(e); err != nil {
+		log.Panicf("sink write failed. name=[%s], error=[%v], argv=%v", w.name, err, e.Argv)
+	}
+	if e.OnReply != nil {
+		e.OnReply()
+	}
+	if e.Offset > w.offset {
+		w.offset = e.Offset
+	}
+	w.dirty = true
+	w.pending++
+}
+
+func (w *sinkWriter) flushInterval() {
+	defer w.finished.Done()
+	ticker := time.NewTicker(sinkFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.flush()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *sinkWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty {
+		return
+	}
+	if err := w.sink.Flush(); err != nil {
+		log.Panicf("sink flush failed. name=[%s], error=[%v]", w.name, err)
+	}
+	w.dirty = false
+	w.shard.AddAppliedEntries(w.pending, 0, w.offset)
+	w.pending = 0
+}
+
+func (w *sinkWriter) stats() map[string]uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sink.Stats()
+}
+
+func (w *sinkWriter) Close() {
+	close(w.stop)
+	w.finished.Wait()
+	w.flush()
+	if err := w.sink.Close(); err != nil {
+		log.Panicf("sink close failed. name=[%s], error=[%v]", w.name, err)
+	}
+	log.Infof("sinkWriter closed. name=[%s], stats=%v", w.name, w.sink.Stats())
+}
@@ -0,0 +1,39 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strconv"
+)
+
+// NewWaitEntry returns a WAIT command that blocks until the commands written
+// before are acknowledged by wait_replicas replicas of the target. It has no
+// key, so cluster writers send it to every master. offset is the offset of the
+// last written entry.
+func NewWaitEntry(offset int64) *entry.Entry {
+	cfg := &config.Config.Advanced
+	return &entry.Entry{
+		Argv:    []string{"WAIT", strconv.Itoa(cfg.WaitReplicas), strconv.Itoa(cfg.WaitTimeout)},
+		CmdName: "WAIT",
+		Group:   "GENERIC",
+		Offset:  offset,
+	}
+}
+
+// checkWaitReply records the result of WAIT, reply is the number of replicas
+// that acknowledged the writes.
+func checkWaitReply(address string, e *entry.Entry, reply interface{}) {
+	acked, ok := reply.(int64)
+	if !ok {
+		log.Panicf("redisWriter unexpected WAIT reply. address=[%s], reply=[%v]", address, reply)
+	}
+	if int(acked) < config.Config.Advanced.WaitReplicas {
+		log.Warnf("redisWriter WAIT timeout, writes are not on enough replicas yet. address=[%s], acked=[%d], wait_replicas=[%d]",
+			address, acked, config.Config.Advanced.WaitReplicas)
+		statistics.AddWaitTimeoutCount()
+		return
+	}
+	statistics.UpdateDurableOffset(uint64(e.Offset))
+}
@@ -0,0 +1,38 @@
+package writer
+
+import (
+	"github.com/alibaba/RedisShake/internal/client/clienttest"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWaitCheckpoint(t *testing.T) {
+	saved := config.Config.Advanced
+	defer func() { config.Config.Advanced = saved }()
+	config.Config.Advanced.WaitReplicas = 2
+	config.Config.Advanced.WaitTimeout = 500
+
+	for _, acked := range []int64{2, 1} {
+		server := clienttest.NewServer(t, clienttest.Handshake(func(argv []string) string {
+			return clienttest.Int(acked)
+		}))
+		offset := int64(atomic.LoadUint64(&statistics.Metrics.DurableOffset)) + 100
+		timeouts := statistics.GetWaitTimeoutCount()
+		w := NewRedisWriter(server.Addr(), "", "", false)
+		w.Write(NewWaitEntry(offset))
+		w.Close()
+
+		if cmds := server.Commands(); len(cmds) != 1 || strings.Join(cmds[0], " ") != "WAIT 2 500" {
+			t.Errorf("acked=[%d], commands=%q, want WAIT 2 500", acked, cmds)
+		}
+		durable := atomic.LoadUint64(&statistics.Metrics.DurableOffset) == uint64(offset)
+		timedOut := statistics.GetWaitTimeoutCount() == timeouts+1
+		// the durable offset moves only when enough replicas acknowledged
+		if durable != (acked == 2) || timedOut != (acked < 2) {
+			t.Errorf("acked=[%d], durable_offset moved=[%v], wait timeout counted=[%v]", acked, durable, timedOut)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package shake
+
+import (
+	"github.com/alibaba/RedisShake/internal/checker"
+	"github.com/alibaba/RedisShake/internal/commands"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/control"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/reader"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/writer"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Handler serves the metrics, the pause endpoints, the health probes and the
+// control API.
+func Handler() http.Handler {
+	statistics.SetAddress(config.Config.Source.Address)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", statistics.Handler)
+	mux.HandleFunc("/metrics", statistics.PrometheusHandler)
+	mux.HandleFunc("/pause", control.Authorized(control.PauseHandler))
+	mux.HandleFunc("/resume", control.Authorized(control.ResumeHandler))
+	mux.HandleFunc("/healthz", control.HealthzHandler)
+	mux.HandleFunc("/readyz", control.ReadyzHandler)
+	control.RegisterAPI(mux)
+	mux.HandleFunc("/api/v1/cutover", checker.CutoverHandler)
+	return mux
+}
+
+// ProbeHandler serves only the health probes, it can be reachable from other
+// hosts, such as container probes, while Handler stays on localhost.
+func ProbeHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", control.HealthzHandler)
+	mux.HandleFunc("/readyz", control.ReadyzHandler)
+	return mux
+}
+
+// started is set by the first Run.
+var started int32
+
+// Run reads the entries of r, filters them and writes them to w until r
+// ends, a stop condition in Config is met or stop is closed. It closes w,
+// waiting for the target to answer the sent commands, and returns the exit
+// code of the command. stop may be nil. It panics if called again, the
+// state of a run is global to the process.
+func Run(r Reader, w Writer, stop <-chan struct{}) int {
+	if !atomic.CompareAndSwapInt32(&started, 0, 1) {
+		log.Panicf("shake.Run can only be called once per process")
+	}
+	control.Start()
+	ch := r.StartRead()
+	control.RegisterQueue(ch)
+
+	checker.StartSampling()
+	checker.StartReadback()
+	checker.StartDedupe()
+	stopConditions := control.WatchStopConditions()
+	exitCode := 0
+	id := uint64(0)
+	startTime := time.Now()
+	waitEnabled := config.Config.Advanced.WaitReplicas > 0
+	lastOffset := int64(0)
+	sinceWait := 0
+loop:
+	for {
+		var e *Entry
+		var ok bool
+		// while writing is paused, in is nil and only resume or stop wakes up the loop
+		in := ch
+		resumed := control.WritePause.Resumed()
+		if resumed != nil {
+			in = nil
+		}
+		select {
+		case e, ok = <-in:
+			if !ok {
+				break loop
+			}
+		case <-resumed:
+			continue
+		case <-stop:
+			log.Infof("stopped, waiting for the target to answer sent commands")
+			break loop
+		case exitCode = <-stopConditions:
+			break loop
+		}
+		if control.ReachedStopOffset(e) {
+			log.Infof("stop_at_offset reached. offset=[%d], stop_at_offset=[%d]", e.Offset, config.Config.Advanced.StopAtOffset)
+			exitCode = control.ExitStopAtOffset
+			break loop
+		}
+		statistics.UpdateInQueueEntriesCount(uint64(len(ch)))
+		// calc arguments
+		e.Id = id
+		id++
+		e.CmdName, e.Group, e.Keys, e.KeyIndexes = commands.CalcKeysWithIndexes(e.Argv)
+		e.Slots = commands.CalcSlots(e.Keys)
+		if e.IsFlush {
+			w.Write(e)
+			continue
+		}
+
+		// the filter may rename keys, the checker compares source keys with target keys
+		var sourceDb int
+		var sourceKeys []string
+		if checker.Enabled() {
+			sourceDb = e.DbId
+			sourceKeys = append(sourceKeys, e.Keys...)
+		}
+
+		// filter, e may be split into several entries
+		entries, code, reason := filter.FilterEntries(e)
+		statistics.UpdateEntryId(e.Id)
+		if code == filter.Allow && checker.SkipMigrated(e) {
+			// restored by a previous run, see resume_dedupe
+			statistics.AddDroppedOffset(e.Offset)
+			if e.OnDrop != nil {
+				e.OnDrop()
+			}
+			continue
+		}
+		if code == filter.Allow {
+			for _, part := range entries {
+				if checker.ReadbackEnabled() {
+					checker.Readback(part)
+				}
+				control.WriteLimit.Wait()
+				w.Write(part)
+				statistics.AddCommandCount(part.CmdName)
+				if checker.Enabled() {
+					checker.Record(sourceDb, sourceKeys, part.DbId, part.Keys)
+				}
+			}
+			statistics.AddAllowEntriesCount()
+			lastOffset = e.Offset
+			sinceWait++
+			if waitEnabled && config.Config.Advanced.WaitEveryEntries > 0 && sinceWait >= config.Config.Advanced.WaitEveryEntries {
+				w.Write(writer.NewWaitEntry(lastOffset))
+				sinceWait = 0
+			}
+		} else if code == filter.Disallow {
+			statistics.AddDisallowEntriesCount()
+			statistics.AddDropCount("filter:" + reason)
+			statistics.AddDroppedOffset(e.Offset)
+			if e.OnDrop != nil {
+				e.OnDrop()
+			}
+		} else {
+			log.Panicf("error when run lua filter. entry: %s", e.ToString())
+		}
+	}
+	control.NotifyStopping()
+	// make sure the data is on the replicas of target before exit
+	var waitTimeouts uint64
+	if waitEnabled {
+		waitTimeouts = statistics.GetWaitTimeoutCount()
+		w.Write(writer.NewWaitEntry(lastOffset))
+	}
+	w.Close()
+	if c, ok := r.(reader.Closer); ok {
+		c.Close()
+	}
+	checker.SaveDedupe()
+	if waitEnabled && statistics.GetWaitTimeoutCount() > waitTimeouts {
+		log.Warnf("the last WAIT timed out, data may not be on the replicas of target")
+		exitCode = control.ExitWaitTimeout
+	}
+	elapsed := time.Since(startTime)
+	log.Infof("finished. entries=[%d], elapsed=[%v], entries_per_second=[%.2f], exit_code=[%d]",
+		id, elapsed, float64(id)/elapsed.Seconds(), exitCode)
+	logDropCounts()
+	return exitCode
+}
+
+// logDropCounts logs the entries and keys not written to target by reason.
+func logDropCounts() {
+	counts := statistics.GetDropCounts()
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		log.Infof("dropped. reason=[%s], count=[%d]", reason, counts[reason])
+	}
+}
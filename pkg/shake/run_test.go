@@ -0,0 +1,56 @@
+package shake
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+type sliceReader struct {
+	argvs [][]string
+}
+
+func (r *sliceReader) StartRead() chan *Entry {
+	ch := make(chan *Entry, len(r.argvs))
+	for _, argv := range r.argvs {
+		ch <- &Entry{Argv: argv}
+	}
+	close(ch)
+	return ch
+}
+
+type sliceWriter struct {
+	written [][]string
+	closed  bool
+}
+
+func (w *sliceWriter) Write(e *Entry) {
+	w.written = append(w.written, e.Argv)
+	if e.OnReply != nil {
+		e.OnReply()
+	}
+}
+
+func (w *sliceWriter) Close() {
+	w.closed = true
+}
+
+func TestRunOnce(t *testing.T) {
+	// go test -count runs the test again in the same process
+	atomic.StoreInt32(&started, 0)
+	r := &sliceReader{argvs: [][]string{{"SET", "a", "1"}, {"DEL", "a"}}}
+	w := &sliceWriter{}
+	if code := Run(r, w, nil); code != 0 {
+		t.Errorf("unexpected exit code. code=[%d]", code)
+	}
+	if len(w.written) != 2 || w.written[1][0] != "DEL" || !w.closed {
+		t.Errorf("entries not written or writer not closed. written=%v, closed=[%v]", w.written, w.closed)
+	}
+
+	// the state of a run is global, a second run would mix it with the first
+	defer func() {
+		if recover() == nil {
+			t.Errorf("second Run should panic")
+		}
+	}()
+	Run(&sliceReader{}, &sliceWriter{}, nil)
+}
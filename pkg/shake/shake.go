@@ -0,0 +1,160 @@
+// Package shake exposes the readers, the RDB loader, the filters and the
+// writers of redis-shake for embedding in other Go programs. The
+// redis-shake command is a thin wrapper around it.
+//
+// The configuration is global like in the command: set the fields of Config
+// or call LoadConfig, then call Init before anything else. The state of a run,
+// such as the statistics, the pauses and the control API, is global too, so a
+// process runs one migration: Run can be called only once. Start a
+// redis-shake process for each migration instead.
+//
+//	shake.LoadConfig("sync.toml")
+//	shake.Init()
+//	w := shake.NewWriter()
+//	r := shake.NewReader()
+//	code := shake.Run(r, w, nil)
+package shake
+
+import (
+	"github.com/alibaba/RedisShake/internal/capability"
+	"github.com/alibaba/RedisShake/internal/config"
+	"github.com/alibaba/RedisShake/internal/entry"
+	"github.com/alibaba/RedisShake/internal/filter"
+	"github.com/alibaba/RedisShake/internal/log"
+	"github.com/alibaba/RedisShake/internal/rdb"
+	"github.com/alibaba/RedisShake/internal/reader"
+	"github.com/alibaba/RedisShake/internal/statistics"
+	"github.com/alibaba/RedisShake/internal/writer"
+)
+
+type (
+	// Entry is a command read from the source, with its keys and db.
+	Entry = entry.Entry
+	// Reader sends the entries of a source to the returned channel and
+	// closes it when the source ends. Run calls Close of the readers that
+	// implement it when it returns.
+	Reader = reader.Reader
+	// Writer writes entries to a target, Close waits for the replies.
+	Writer = writer.Writer
+	// Sink writes entries to something other than redis, see RegisterSink.
+	Sink = writer.Sink
+	// SinkFactory creates a sink from target.sink_options.
+	SinkFactory = writer.SinkFactory
+	// Loader parses an rdb file into entries.
+	Loader = rdb.Loader
+	// Middleware inspects or transforms an entry and returns Allow,
+	// Disallow or Error.
+	Middleware = filter.Middleware
+)
+
+const (
+	Allow    = filter.Allow
+	Disallow = filter.Disallow
+	Error    = filter.Error
+)
+
+// Config is the global configuration, the same as the config file.
+var Config = &config.Config
+
+// LoadConfig loads the config file and changes the working dir to dir, it
+// panics if the config is invalid.
+func LoadConfig(path string) {
+	config.LoadFromFile(path)
+}
+
+// LoadFilter loads a lua filter file, it must be called before Init.
+func LoadFilter(path string) {
+	filter.LoadFromFile(path)
+}
+
+// Use adds a middleware to the filter chain, after the lua filter and before
+// the builtin filters. It must be called before Init.
+func Use(m Middleware) {
+	filter.Use(m)
+}
+
+// Init opens the log and builds the filter chain from Config.
+func Init() {
+	log.Init()
+	filter.Init()
+}
+
+// StartStatistics logs the statistics every log_interval seconds.
+func StartStatistics() {
+	statistics.Init()
+}
+
+// Filter runs the filter chain on e.
+func Filter(e *Entry) int {
+	return filter.Filter(e)
+}
+
+// NewReader creates the reader of the type in Config.
+func NewReader() Reader {
+	source := &config.Config.Source
+	switch config.Config.Type {
+	case "sync":
+		return reader.NewPSyncReader(source.Address, source.Username, source.Password, source.IsTLS, source.ElastiCachePSync)
+	case "restore":
+		return reader.NewRDBReader(source.RDBFilePath)
+	case "scan":
+		return reader.NewScanReader(source.Address, source.Username, source.Password, source.IsTLS)
+	case "keys":
+		return reader.NewKeyFileReader(source.KeyFile, source.Address, source.Username, source.Password, source.IsTLS)
+	case "replay":
+		return reader.NewReplayReader(source.ReplayDir)
+	case "bench":
+		return reader.NewBenchReader()
+	}
+	log.Panicf("unknown source type: %s", config.Config.Type)
+	return nil
+}
+
+// NewRDBReader reads an rdb file.
+func NewRDBReader(path string) Reader {
+	return reader.NewRDBReader(path)
+}
+
+// NewLoader parses the rdb file at path into ch, ParseRDB does the work. With
+// a nil ch, the entries are pulled one by one by Next.
+func NewLoader(path string, ch chan *Entry) *Loader {
+	return rdb.NewLoader(path, ch)
+}
+
+// NewWriter creates the writer of the target type in Config. The
+// capabilities of a redis target are probed first if probe_capabilities is
+// set, the limits left 0 in the config are discovered either way.
+func NewWriter() Writer {
+	target := &config.Config.Target
+	if target.Type == "sink" {
+		// values of the rdb are written as commands instead of RESTORE payloads
+		capability.Target.DisableRestore()
+		return writer.NewSinkWriter(target.Sink, target.SinkOptions)
+	}
+	if target.ProbeCapabilities {
+		capability.Probe(target.Address, target.Username, target.Password, target.IsTLS)
+	} else if config.DiscoverLimits.ProtoMaxBulkLen || config.DiscoverLimits.QuerybufLen {
+		capability.ProbeLimits(target.Address, target.Username, target.Password, target.IsTLS)
+	}
+	switch target.Type {
+	case "standalone":
+		return writer.NewRedisWriter(target.Address, target.Username, target.Password, target.IsTLS)
+	case "cluster":
+		return writer.NewRedisClusterWriter(target.Address, target.Username, target.Password, target.IsTLS)
+	case "sharded":
+		return writer.NewRedisShardedWriter(target.Username, target.Password, target.IsTLS)
+	}
+	log.Panicf("unknown target type: %s", target.Type)
+	return nil
+}
+
+// RegisterSink makes a sink available as target.sink = name with
+// target.type = "sink". Register it before NewWriter, such as in init.
+func RegisterSink(name string, factory SinkFactory) {
+	writer.RegisterSink(name, factory)
+}
+
+// NewDryRunWriter discards the entries, or writes them to path in RESP.
+func NewDryRunWriter(path string) Writer {
+	return writer.NewDryRunWriter(path)
+}
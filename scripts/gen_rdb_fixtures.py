@@ -0,0 +1,501 @@
+"""
+Generate RDB fixtures for internal/rdb/testdata.
+
+For every redis version a docker container is started and filled with every
+type/encoding supported by that version. Then SAVE is called and dump.rdb is
+copied to internal/rdb/testdata/redis-<version>.rdb.
+
+Without docker, --encode writes internal/rdb/testdata/encoded-<version>.rdb
+instead, encoded by this script the way that version saves the same commands:
+ziplist, intset, quicklist and listpack encodings, LZF compressed strings,
+the default size thresholds of the encodings and a valid checksum.
+HyperLogLogs and streams are not encoded, they are only in the fixtures saved
+by redis. The encoded fixtures are the ones committed, they are reproducible.
+
+usage:
+    python3 gen_rdb_fixtures.py              # all versions
+    python3 gen_rdb_fixtures.py 6.2 7.0      # selected versions
+    python3 gen_rdb_fixtures.py --encode     # ENCODED_VERSIONS without docker
+
+The values written here are checked by hand in TestRDBFixtureValues, keep the
+two in sync. After generating, update the golden files and review the diff:
+    go test ./internal/rdb/ -run TestRDBFixtures -update
+"""
+import os
+import struct
+import subprocess
+import sys
+import time
+
+VERSIONS = ["3.0", "3.2", "4.0", "5.0", "6.0", "6.2", "7.0", "7.2", "7.4"]
+# one version per rdb layout: ziplist lists, quicklist, binary zset scores,
+# listpacks, listpack sets
+ENCODED_VERSIONS = ["3.0", "3.2", "4.0", "7.0", "7.2"]
+TESTDATA_DIR = os.path.join(os.path.dirname(os.path.abspath(__file__)), "..", "internal", "rdb", "testdata")
+EXPIRE_AT_MS = 4102444800000  # 2100-01-01, keeps the golden files stable
+
+
+def version_tuple(version):
+    return tuple(int(x) for x in version.split("."))
+
+
+def commands_for(version):
+    v = version_tuple(version)
+    cmds = []
+
+    # string: int, embstr, raw, lzf compressed
+    cmds.append(["SET", "string_int", "12345"])
+    cmds.append(["SET", "string_neg_int", "-2147483649"])
+    cmds.append(["SET", "string_short", "hello"])
+    cmds.append(["SET", "string_long", "x" * 100])
+    cmds.append(["SET", "string_lzf", "abcdefgh" * 64])
+    cmds.append(["SET", "string_expire", "v"])
+    cmds.append(["PEXPIREAT", "string_expire", str(EXPIRE_AT_MS)])
+
+    # bitmap and HyperLogLog
+    cmds.append(["SETBIT", "bitmap", "1000", "1"])
+    cmds.append(["PFADD", "hll", "a", "b", "c"])
+
+    # list: ziplist/listpack and quicklist with multiple nodes
+    cmds.append(["RPUSH", "list_small", "a", "1", "b", "2"])
+    cmds.append(["RPUSH", "list_big"] + [f"element_{i}" for i in range(1000)])
+    cmds.append(["RPUSH", "list_large_element", "y" * 10000])
+
+    # set: intset 16/32/64 bits, listpack (7.2+), hashtable
+    cmds.append(["SADD", "set_int16", "1", "2", "3"])
+    cmds.append(["SADD", "set_int32", "1", "70000"])
+    cmds.append(["SADD", "set_int64", "1", "5000000000", "-5000000000"])
+    cmds.append(["SADD", "set_small", "a", "b", "c"])
+    cmds.append(["SADD", "set_big"] + [f"member_{i}" for i in range(1000)])
+
+    # zset: ziplist/listpack and skiplist
+    cmds.append(["ZADD", "zset_small", "1", "a", "2.5", "b", "-3", "c"])
+    zadd = ["ZADD", "zset_big"]
+    for i in range(1000):
+        zadd += [str(i * 1.5), f"member_{i}"]
+    cmds.append(zadd)
+
+    # hash: ziplist/listpack and hashtable
+    cmds.append(["HSET", "hash_small", "f1", "v1"])
+    cmds.append(["HSET", "hash_small", "f2", "2"])
+    for i in range(1000):
+        cmds.append(["HSET", "hash_big", f"field_{i}", f"value_{i}"])
+
+    # geo
+    if v >= (3, 2):
+        cmds.append(["GEOADD", "geo", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"])
+
+    # stream
+    if v >= (5, 0):
+        cmds.append(["XADD", "stream", "1-1", "f1", "v1"])
+        cmds.append(["XADD", "stream", "1-2", "f1", "v2"])
+        cmds.append(["XADD", "stream", "2-1", "f2", "v3"])
+        cmds.append(["XDEL", "stream", "1-2"])
+        cmds.append(["XGROUP", "CREATE", "stream", "group", "0"])
+        cmds.append(["XREADGROUP", "GROUP", "group", "consumer", "COUNT", "1", "STREAMS", "stream", ">"])
+        cmds.append(["XADD", "stream_empty", "MAXLEN", "0", "5-5", "f", "v"])
+
+    # other databases
+    cmds.append(["SELECT", "1"])
+    cmds.append(["SET", "db1_string", "value"])
+    return cmds
+
+
+def run(args, **kwargs):
+    return subprocess.run(args, check=True, **kwargs)
+
+
+def generate(version):
+    name = f"redis-shake-fixture-{version}"
+    subprocess.run(["docker", "rm", "-f", name], stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)
+    run(["docker", "run", "-d", "--name", name, f"redis:{version}", "redis-server", "--save", ""], stdout=subprocess.DEVNULL)
+    try:
+        for _ in range(50):
+            ret = subprocess.run(["docker", "exec", name, "redis-cli", "PING"], capture_output=True, text=True)
+            if ret.stdout.strip() == "PONG":
+                break
+            time.sleep(0.1)
+
+        # redis-cli reads one command per line, all values above are free of spaces
+        script = "\n".join(" ".join(cmd) for cmd in commands_for(version)) + "\nSAVE\n"
+        run(["docker", "exec", "-i", name, "redis-cli"], input=script, text=True, stdout=subprocess.DEVNULL)
+
+        os.makedirs(TESTDATA_DIR, exist_ok=True)
+        path = os.path.join(TESTDATA_DIR, f"redis-{version}.rdb")
+        run(["docker", "cp", f"{name}:/data/dump.rdb", path])
+        print(f"generated {path}")
+    finally:
+        subprocess.run(["docker", "rm", "-f", name], stdout=subprocess.DEVNULL)
+
+
+# --encode: the rdb encoder
+
+RDB_VERSIONS = {"3.0": 6, "3.2": 7, "4.0": 8, "5.0": 9, "6.0": 9, "6.2": 9, "7.0": 10, "7.2": 11}
+CTIME = 1700000000  # aux ctime, keeps the fixtures reproducible
+
+# default size thresholds of the encodings
+LIST_ZIPLIST_ENTRIES, LIST_ZIPLIST_VALUE = 512, 64  # redis < 3.2
+QUICKLIST_NODE_BYTES = 8192  # list-max-ziplist-size -2
+SET_INTSET_ENTRIES = 512
+SET_LISTPACK_ENTRIES, SET_LISTPACK_VALUE = 128, 64  # redis >= 7.2
+ZSET_ZIPLIST_ENTRIES, ZSET_ZIPLIST_VALUE = 128, 64
+HASH_ZIPLIST_ENTRIES, HASH_ZIPLIST_VALUE = 512, 64
+
+GEO_LAT_MAX, GEO_LONG_MAX, GEO_STEP = 85.05112878, 180.0, 26
+
+
+def crc64_table():
+    table = []
+    for i in range(256):
+        crc = i
+        for _ in range(8):
+            crc = (crc >> 1) ^ 0x95AC9329AC4BC9B5 if crc & 1 else crc >> 1
+        table.append(crc)
+    return table
+
+
+CRC64_TABLE = crc64_table()
+
+
+def crc64(data, crc=0):
+    for b in data:
+        crc = CRC64_TABLE[(crc ^ b) & 0xFF] ^ (crc >> 8)
+    return crc
+
+
+def lzf_compress(data):
+    """Greedy LZF, returns None if it does not save more than 4 bytes, like redis."""
+    out = bytearray()
+    literals = bytearray()
+    last = {}
+
+    def flush_literals():
+        for i in range(0, len(literals), 32):
+            chunk = literals[i:i + 32]
+            out.append(len(chunk) - 1)
+            out.extend(chunk)
+        literals.clear()
+
+    i = 0
+    while i < len(data):
+        ref = last.get(bytes(data[i:i + 3])) if i + 3 <= len(data) else None
+        if i + 3 <= len(data):
+            last[bytes(data[i:i + 3])] = i
+        if ref is not None and i - ref - 1 < 8192:
+            length = 3
+            while i + length < len(data) and length < 264 and data[ref + length] == data[i + length]:
+                length += 1
+            flush_literals()
+            off = i - ref - 1
+            if length - 2 < 7:
+                out.append(((length - 2) << 5) | (off >> 8))
+            else:
+                out.append((7 << 5) | (off >> 8))
+                out.append(length - 2 - 7)
+            out.append(off & 0xFF)
+            for j in range(i + 1, i + length):
+                if j + 3 <= len(data):
+                    last[bytes(data[j:j + 3])] = j
+            i += length
+        else:
+            literals.append(data[i])
+            i += 1
+    flush_literals()
+    if len(data) <= 4 or len(out) >= len(data) - 4:
+        return None
+    return bytes(out)
+
+
+def enc_length(n):
+    if n < 1 << 6:
+        return bytes([n])
+    if n < 1 << 14:
+        return bytes([0x40 | (n >> 8), n & 0xFF])
+    if n <= 0xFFFFFFFF:
+        return b"\x80" + struct.pack(">I", n)
+    return b"\x81" + struct.pack(">Q", n)
+
+
+def canonical_int(s, max_len):
+    """The integer value of s if redis encodes it as an integer."""
+    if len(s) == 0 or len(s) > max_len:
+        return None
+    try:
+        v = int(s)
+    except ValueError:
+        return None
+    if str(v) != s or not -(1 << 63) <= v < 1 << 63:
+        return None
+    return v
+
+
+def enc_string(s):
+    """rdbSaveRawString: integer, LZF or raw."""
+    data = s.encode() if isinstance(s, str) else bytes(s)
+    if len(data) <= 11:
+        v = canonical_int(data.decode("latin-1"), 11)
+        if v is not None:
+            if -(1 << 7) <= v < 1 << 7:
+                return b"\xc0" + struct.pack("<b", v)
+            if -(1 << 15) <= v < 1 << 15:
+                return b"\xc1" + struct.pack("<h", v)
+            if -(1 << 31) <= v < 1 << 31:
+                return b"\xc2" + struct.pack("<i", v)
+    if len(data) > 20:
+        compressed = lzf_compress(data)
+        if compressed is not None:
+            return b"\xc3" + enc_length(len(compressed)) + enc_length(len(data)) + compressed
+    return enc_length(len(data)) + data
+
+
+def ziplist(elements):
+    entries = []
+    prevlen = 0
+    for ele in elements:
+        header = bytes([prevlen]) if prevlen < 254 else b"\xfe" + struct.pack("<I", prevlen)
+        v = canonical_int(ele, 31)
+        if v is not None and 0 <= v <= 12:
+            body = bytes([0xF1 + v])
+        elif v is not None and -(1 << 7) <= v < 1 << 7:
+            body = b"\xfe" + struct.pack("<b", v)
+        elif v is not None and -(1 << 15) <= v < 1 << 15:
+            body = b"\xc0" + struct.pack("<h", v)
+        elif v is not None and -(1 << 23) <= v < 1 << 23:
+            body = b"\xf0" + struct.pack("<i", v)[:3]
+        elif v is not None and -(1 << 31) <= v < 1 << 31:
+            body = b"\xd0" + struct.pack("<i", v)
+        elif v is not None:
+            body = b"\xe0" + struct.pack("<q", v)
+        else:
+            data = ele.encode()
+            if len(data) < 1 << 6:
+                body = bytes([len(data)]) + data
+            elif len(data) < 1 << 14:
+                body = bytes([0x40 | (len(data) >> 8), len(data) & 0xFF]) + data
+            else:
+                body = b"\x80" + struct.pack(">I", len(data)) + data
+        entry = header + body
+        entries.append(entry)
+        prevlen = len(entry)
+    tail = 10 + sum(len(e) for e in entries[:-1]) if entries else 10
+    body = b"".join(entries)
+    return struct.pack("<IIH", 10 + len(body) + 1, tail, min(len(entries), 0xFFFF)) + body + b"\xff"
+
+
+def listpack_backlen(n):
+    if n <= 127:
+        return bytes([n])
+    if n < 16383:
+        return bytes([n >> 7, (n & 127) | 128])
+    if n < 2097151:
+        return bytes([n >> 14, ((n >> 7) & 127) | 128, (n & 127) | 128])
+    if n < 268435455:
+        return bytes([n >> 21, ((n >> 14) & 127) | 128, ((n >> 7) & 127) | 128, (n & 127) | 128])
+    return bytes([n >> 28, ((n >> 21) & 127) | 128, ((n >> 14) & 127) | 128, ((n >> 7) & 127) | 128, (n & 127) | 128])
+
+
+def listpack(elements):
+    entries = []
+    for ele in elements:
+        v = canonical_int(ele, 20)
+        if v is not None and 0 <= v <= 127:
+            body = bytes([v])
+        elif v is not None and -(1 << 12) <= v < 1 << 12:
+            u = v & 0x1FFF
+            body = bytes([0xC0 | (u >> 8), u & 0xFF])
+        elif v is not None and -(1 << 15) <= v < 1 << 15:
+            body = b"\xf1" + struct.pack("<h", v)
+        elif v is not None and -(1 << 23) <= v < 1 << 23:
+            body = b"\xf2" + struct.pack("<i", v)[:3]
+        elif v is not None and -(1 << 31) <= v < 1 << 31:
+            body = b"\xf3" + struct.pack("<i", v)
+        elif v is not None:
+            body = b"\xf4" + struct.pack("<q", v)
+        else:
+            data = ele.encode()
+            if len(data) < 1 << 6:
+                body = bytes([0x80 | len(data)]) + data
+            elif len(data) < 1 << 12:
+                body = bytes([0xE0 | (len(data) >> 8), len(data) & 0xFF]) + data
+            else:
+                body = b"\xf0" + struct.pack("<I", len(data)) + data
+        entries.append(body + listpack_backlen(len(body)))
+    body = b"".join(entries)
+    return struct.pack("<IH", 6 + len(body) + 1, min(len(entries), 0xFFFF)) + body + b"\xff"
+
+
+def intset(members):
+    values = sorted(int(m) for m in members)
+    if all(-(1 << 15) <= v < 1 << 15 for v in values):
+        width, fmt = 2, "<h"
+    elif all(-(1 << 31) <= v < 1 << 31 for v in values):
+        width, fmt = 4, "<i"
+    else:
+        width, fmt = 8, "<q"
+    return struct.pack("<II", width, len(values)) + b"".join(struct.pack(fmt, v) for v in values)
+
+
+def d2string(score):
+    """d2string of redis, integers without a fraction."""
+    if score == int(score) and abs(score) < 1 << 52:
+        return str(int(score))
+    return "%.17g" % score
+
+
+def geohash(longitude, latitude):
+    def spread(v):
+        result = 0
+        for bit in range(32):
+            result |= ((v >> bit) & 1) << (2 * bit)
+        return result
+
+    lat = int((latitude + GEO_LAT_MAX) / (2 * GEO_LAT_MAX) * (1 << GEO_STEP))
+    lon = int((longitude + GEO_LONG_MAX) / (2 * GEO_LONG_MAX) * (1 << GEO_STEP))
+    return float(spread(lat) | (spread(lon) << 1))
+
+
+def apply_commands(cmds):
+    """Runs the commands on a model of the keyspace: db -> key -> [type, value, expire]."""
+    dbs = {0: {}}
+    db = dbs[0]
+    skipped = set()
+    for cmd in cmds:
+        name, args = cmd[0].upper(), cmd[1:]
+        if name == "SELECT":
+            db = dbs.setdefault(int(args[0]), {})
+        elif name == "SET":
+            db[args[0]] = ["string", bytearray(args[1].encode()), 0]
+        elif name == "PEXPIREAT":
+            db[args[0]][2] = int(args[1])
+        elif name == "SETBIT":
+            offset = int(args[1])
+            value = db.setdefault(args[0], ["string", bytearray(), 0])[1]
+            if len(value) <= offset // 8:
+                value.extend(b"\x00" * (offset // 8 + 1 - len(value)))
+            value[offset // 8] |= 0x80 >> (offset % 8)
+        elif name == "RPUSH":
+            db.setdefault(args[0], ["list", [], 0])[1].extend(args[1:])
+        elif name == "SADD":
+            members = db.setdefault(args[0], ["set", [], 0])[1]
+            members.extend(m for m in args[1:] if m not in members)
+        elif name == "ZADD":
+            members = db.setdefault(args[0], ["zset", {}, 0])[1]
+            for i in range(1, len(args), 2):
+                members[args[i + 1]] = float(args[i])
+        elif name == "GEOADD":
+            members = db.setdefault(args[0], ["zset", {}, 0])[1]
+            for i in range(1, len(args), 3):
+                members[args[i + 2]] = geohash(float(args[i]), float(args[i + 1]))
+        elif name == "HSET":
+            fields = db.setdefault(args[0], ["hash", {}, 0])[1]
+            for i in range(1, len(args), 2):
+                fields[args[i]] = args[i + 1]
+        else:
+            skipped.add(name)
+    return dbs, skipped
+
+
+def split_nodes(elements, encode):
+    """Quicklist nodes of at most QUICKLIST_NODE_BYTES, a larger element gets a node of its own."""
+    nodes, node = [], []
+    for ele in elements:
+        if node and len(encode(node + [ele])) > QUICKLIST_NODE_BYTES:
+            nodes.append(node)
+            node = []
+        node.append(ele)
+    if node:
+        nodes.append(node)
+    return nodes
+
+
+def encode_value(kind, value, v):
+    """Returns the type byte and the encoded value as the version saves it."""
+    if kind == "string":
+        return 0, enc_string(value)
+    if kind == "list":
+        if v < (3, 2):
+            if len(value) <= LIST_ZIPLIST_ENTRIES and all(len(e) <= LIST_ZIPLIST_VALUE for e in value):
+                return 10, enc_string(ziplist(value))
+            return 1, enc_length(len(value)) + b"".join(enc_string(e) for e in value)
+        if v < (7, 0):
+            nodes = split_nodes(value, ziplist)
+            return 14, enc_length(len(nodes)) + b"".join(enc_string(ziplist(n)) for n in nodes)
+        nodes = split_nodes(value, listpack)
+        return 18, enc_length(len(nodes)) + b"".join(b"\x02" + enc_string(listpack(n)) for n in nodes)
+    if kind == "set":
+        if len(value) <= SET_INTSET_ENTRIES and all(canonical_int(m, 20) is not None for m in value):
+            return 11, enc_string(intset(value))
+        if v >= (7, 2) and len(value) <= SET_LISTPACK_ENTRIES and all(len(m) <= SET_LISTPACK_VALUE for m in value):
+            return 20, enc_string(listpack(value))
+        return 2, enc_length(len(value)) + b"".join(enc_string(m) for m in value)
+    if kind == "zset":
+        ordered = sorted(value.items(), key=lambda item: (item[1], item[0]))
+        if len(value) <= ZSET_ZIPLIST_ENTRIES and all(len(m) <= ZSET_ZIPLIST_VALUE for m in value):
+            elements = [x for member, score in ordered for x in (member, d2string(score))]
+            if v >= (7, 0):
+                return 17, enc_string(listpack(elements))
+            return 12, enc_string(ziplist(elements))
+        # the skiplist is saved from the greatest to the smallest
+        ordered.reverse()
+        if v >= (4, 0):
+            return 5, enc_length(len(ordered)) + b"".join(enc_string(m) + struct.pack("<d", s) for m, s in ordered)
+        out = enc_length(len(ordered))
+        for member, score in ordered:
+            text = ("%.17g" % score).encode()
+            out += enc_string(member) + bytes([len(text)]) + text
+        return 3, out
+    if kind == "hash":
+        if len(value) <= HASH_ZIPLIST_ENTRIES and all(len(f) <= HASH_ZIPLIST_VALUE and len(x) <= HASH_ZIPLIST_VALUE
+                                                      for f, x in value.items()):
+            elements = [x for field, val in value.items() for x in (field, val)]
+            if v >= (7, 0):
+                return 16, enc_string(listpack(elements))
+            return 13, enc_string(ziplist(elements))
+        return 4, enc_length(len(value)) + b"".join(enc_string(f) + enc_string(x) for f, x in value.items())
+    raise ValueError(kind)
+
+
+def encode(version):
+    v = version_tuple(version)
+    dbs, skipped = apply_commands(commands_for(version))
+    out = bytearray(b"REDIS%04d" % RDB_VERSIONS[version])
+    if v >= (3, 2):
+        for key, value in [("redis-ver", version + ".0"), ("redis-bits", "64"), ("ctime", str(CTIME)), ("used-mem", "1000000")]:
+            out += b"\xfa" + enc_string(key) + enc_string(value)
+    for db_id in sorted(dbs):
+        keys = dbs[db_id]
+        out += b"\xfe" + enc_length(db_id)
+        if v >= (3, 2):
+            out += b"\xfb" + enc_length(len(keys)) + enc_length(sum(1 for k in keys.values() if k[2]))
+        for key, (kind, value, expire) in keys.items():
+            if expire:
+                out += b"\xfc" + struct.pack("<Q", expire)
+            type_byte, data = encode_value(kind, value, v)
+            out += bytes([type_byte]) + enc_string(key) + data
+    out += b"\xff"
+    out += struct.pack("<Q", crc64(out))
+    return bytes(out), skipped
+
+
+def write_encoded(version):
+    data, skipped = encode(version)
+    os.makedirs(TESTDATA_DIR, exist_ok=True)
+    path = os.path.join(TESTDATA_DIR, f"encoded-{version}.rdb")
+    with open(path, "wb") as f:
+        f.write(data)
+    print(f"encoded {path}, not encoded: {' '.join(sorted(skipped))}")
+
+
+def main():
+    args = sys.argv[1:]
+    if args and args[0] == "--encode":
+        for version in args[1:] or ENCODED_VERSIONS:
+            write_encoded(version)
+        return
+    versions = args or VERSIONS
+    for version in versions:
+        generate(version)
+
+
+if __name__ == "__main__":
+    main()
@@ -2,6 +2,8 @@
 set -e
 
 go test ./... -v
+# the cluster writer fans entries out to the writers of its nodes
+go test -race -run TestCluster ./internal/writer/
 
 cd test
 python main.py
\ No newline at end of file